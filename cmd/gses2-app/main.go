@@ -6,9 +6,20 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
+
+	// The schedule and subscriber time zones don't depend on the tzdata of the system
+	_ "time/tzdata"
 
 	"gses2-app/internal/core/port"
+	"gses2-app/internal/core/service/alert"
+	"gses2-app/internal/core/service/broadcast"
+	"gses2-app/internal/core/service/history"
+	"gses2-app/internal/core/service/mailbox"
+	"gses2-app/internal/core/service/outbox"
 	"gses2-app/internal/core/service/rate"
+	"gses2-app/internal/core/service/rate/cache"
+	"gses2-app/internal/core/service/schedule"
 	"gses2-app/internal/core/service/sender"
 	"gses2-app/internal/core/service/subscription"
 	"gses2-app/internal/handler/httpcontroller"
@@ -51,25 +62,102 @@ func main() {
 
 	go consumer()
 
-	senderService, err := createSenderService(&config)
+	links, err := createSubscriptionLinks(logger, &config)
+	if err != nil {
+		logger.Errorf("Error, cannot create subscription links: %s", err)
+		os.Exit(1)
+	}
+
+	smtpPool, err := smtp.NewPool(
+		config.SMTP,
+		&smtp.ConnectionDialerImpl{},
+		&smtp.SMTPClientFactoryImpl{},
+	)
+	if err != nil {
+		logger.Errorf("Error, cannot configure SMTP: %s", err)
+		os.Exit(1)
+	}
+	go smtpPool.Run(ctx)
+
+	historyStorage := storage.NewHistoryFileStorage(
+		logger,
+		config.Storage.HistoryPath,
+		config.Storage.HistoryRetention,
+	)
+
+	senderService, err := createSenderService(&config, smtpPool, links, historyStorage)
+	if err != nil {
+		logger.Errorf("Error, cannot create sender service: %s", err)
+		os.Exit(1)
+	}
+
+	subscriptionService, err := createSubscriptionService(
+		ctx, logger, &config, senderService, links,
+	)
 	if err != nil {
-		logger.Errorf("Connection error: %s", err)
+		logger.Errorf("Error, cannot create subscription service: %s", err)
 		os.Exit(1)
 	}
+	go subscriptionService.Purge(ctx)
 
 	defer conn.Close()
 	defer ch.Close()
 
-	rateService := createRateService(logger, &config)
-	subscriptionService := createSubscriptionService(&config)
+	outboxService := createOutboxService(logger, &config, senderService)
+	go outboxService.Run(ctx)
+
+	alertService := alert.NewService(
+		logger,
+		config.Alert,
+		storage.NewAlertFileStorage(config.Storage.AlertsPath),
+		historyStorage,
+		subscriptionService,
+		senderService,
+	)
+
+	historyService := history.NewService(historyStorage)
+	rateService, err := createRateService(
+		logger,
+		&config,
+		rate.Recorders{historyService, alertService},
+	)
+	if err != nil {
+		logger.Errorf("Error, cannot create rate service: %s", err)
+		os.Exit(1)
+	}
+	go rateService.Refresh(ctx)
+	go alertService.Run(ctx, rateService)
+
+	broadcastService := broadcast.NewService(
+		logger,
+		rateService,
+		subscriptionService,
+		outboxService,
+	)
+
+	scheduleService, err := schedule.NewService(
+		logger,
+		config.Schedule,
+		storage.NewScheduleFileStorage(config.Storage.SchedulePath),
+		broadcastService,
+	)
+	if err != nil {
+		logger.Errorf("Error, cannot create schedule service: %s", err)
+		os.Exit(1)
+	}
+	go scheduleService.Run(ctx)
 
 	appController := httpcontroller.NewAppController(
 		rateService,
+		historyService,
 		subscriptionService,
-		senderService,
+		outboxService,
+		broadcastService,
+		scheduleService,
+		alertService,
 	)
 
-	mux := registerRoutes(appController)
+	mux := registerRoutes(appController, config.HTTP.Timeout)
 	startServer(logger, config.HTTP.Port, mux)
 
 	<-loging
@@ -78,7 +166,11 @@ func main() {
 func createRateService(
 	logger port.Logger,
 	config *config.Config,
-) *rate.Service {
+	recorder rate.HistoryPort,
+) (*cache.Service, error) {
+	if err := config.Rate.Strategy.Validate(); err != nil {
+		return nil, err
+	}
 
 	httpClient := &http.Client{Timeout: config.HTTP.Timeout}
 
@@ -94,24 +186,33 @@ func createRateService(
 		logger, config.CoingeckoAPI, httpClient,
 	)
 
-	return rate.NewService(
+	rateService := rate.NewService(
 		logger,
+		config.Rate,
+		recorder,
 		BinanceRateProvider,
 		CoingeckoRateProvider,
 		KunaRateProvider,
 	)
+
+	return cache.NewService(logger, config.Cache, rateService), nil
 }
 
 func createSenderService(
 	config *config.Config,
+	connections email.Connections,
+	linker email.Linker,
+	rateHistory email.RateHistory,
 ) (*sender.Service, error) {
 	emailSenderProvider, err := email.NewProvider(
 		&email.EmailSenderConfig{
 			SMTP:  config.SMTP,
 			Email: config.Email,
+			DKIM:  config.DKIM,
 		},
-		&smtp.TLSConnectionDialerImpl{},
-		&smtp.SMTPClientFactoryImpl{},
+		connections,
+		linker,
+		rateHistory,
 	)
 
 	if err != nil {
@@ -121,15 +222,71 @@ func createSenderService(
 	return sender.NewService(emailSenderProvider), nil
 }
 
-func createSubscriptionService(config *config.Config) *subscription.Service {
-	storageCSV := storage.NewCSVStorage(config.Storage.Path)
-	userRepository := port.NewUserRepository(storageCSV)
+func createOutboxService(
+	logger port.Logger,
+	config *config.Config,
+	senderService *sender.Service,
+) *outbox.Service {
+	outboxStorage := storage.NewOutboxFileStorage(
+		logger,
+		config.Storage.OutboxPath,
+		config.Outbox.Retention,
+	)
 
-	return subscription.NewService(userRepository)
+	return outbox.NewService(logger, config.Outbox, outboxStorage, senderService)
+}
+
+func createSubscriptionLinks(
+	logger port.Logger,
+	config *config.Config,
+) (*subscription.Links, error) {
+	if config.Subscription.Secret == "" {
+		secret, err := subscription.RandomSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Info("The subscription secret isn't set, " +
+			"the links sent by email won't work after restart")
+		config.Subscription.Secret = secret
+	}
+
+	return subscription.NewLinks(config.Subscription), nil
+}
+
+func createSubscriptionService(
+	ctx context.Context,
+	logger port.Logger,
+	config *config.Config,
+	senderService *sender.Service,
+	links *subscription.Links,
+) (*subscription.Service, error) {
+	validator, err := mailbox.NewValidator(config.EmailValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	userStorage, err := storage.NewUserStorage(ctx, logger, config.Storage)
+	if err != nil {
+		return nil, err
+	}
+	userRepository := port.NewUserRepository(userStorage)
+
+	return subscription.NewService(
+		logger,
+		config.Subscription,
+		userRepository,
+		validator,
+		senderService,
+		links,
+	), nil
 }
 
-func registerRoutes(appController *httpcontroller.AppController) *http.ServeMux {
-	router := router.NewHTTPRouter(appController)
+func registerRoutes(
+	appController *httpcontroller.AppController,
+	timeout time.Duration,
+) *http.ServeMux {
+	router := router.NewHTTPRouter(appController, timeout)
 
 	mux := http.NewServeMux()
 	router.RegisterRoutes(mux)
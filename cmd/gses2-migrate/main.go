@@ -0,0 +1,158 @@
+// Command gses2-migrate moves the subscribers between the storages:
+//
+//	gses2-migrate -from csv:./storage/storage.csv -to sqlite:./storage/storage.db
+//
+// The storage is given as the driver and the path. -dry-run prints the
+// changes without writing them, the storages are only read then and
+// the missing destination isn't created, -overwrite replaces the destination
+// subscribers having other values with the source ones
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"gses2-app/internal/core/port"
+	"gses2-app/internal/core/service/migration"
+	"gses2-app/internal/repository/storage"
+)
+
+var ErrInvalidStorage = errors.New("invalid storage, expected driver:path")
+
+func main() {
+	from := flag.String("from", "", "source storage, e.g. csv:./storage/storage.csv")
+	to := flag.String("to", "", "destination storage, e.g. sqlite:./storage/storage.db")
+	dryRun := flag.Bool("dry-run", false, "print the changes without writing them")
+	overwrite := flag.Bool("overwrite", false, "overwrite the destination subscribers with other values")
+	flag.Parse()
+
+	err := run(context.Background(), *from, *to, migration.Options{
+		DryRun:    *dryRun,
+		Overwrite: *overwrite,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error, migration failed: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, from, to string, options migration.Options) error {
+	logger := logrus.New()
+
+	source, err := openStorage(ctx, logger, from, options.DryRun)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(source)
+
+	destination, err := openDestination(ctx, logger, to, options.DryRun)
+	if err != nil {
+		return err
+	}
+	if destination != nil {
+		defer closeStorage(destination)
+	}
+
+	service := migration.NewService(logger, port.UserSchema, port.UserKey)
+	report, err := service.Migrate(ctx, source, destination, options)
+
+	printReport(os.Stdout, report, options)
+
+	return err
+}
+
+// openStorage opens the storage given as the driver and the path,
+// the read-only storage doesn't change or create any files
+func openStorage(
+	ctx context.Context,
+	logger port.Logger,
+	location string,
+	readOnly bool,
+) (port.Storage, error) {
+	config, err := parseStorage(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if readOnly {
+		return storage.NewReadOnlyUserStorage(ctx, logger, config)
+	}
+
+	return storage.NewUserStorage(ctx, logger, config)
+}
+
+// openDestination opens the destination storage, on the dry run it's
+// opened read-only and the missing one is nil, so nothing is created
+func openDestination(
+	ctx context.Context,
+	logger port.Logger,
+	location string,
+	dryRun bool,
+) (port.Storage, error) {
+	if dryRun {
+		config, err := parseStorage(location)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = os.Stat(config.Path); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+
+	return openStorage(ctx, logger, location, dryRun)
+}
+
+// parseStorage returns the config of the storage given as the driver and the path
+func parseStorage(location string) (storage.StorageConfig, error) {
+	driver, path, ok := strings.Cut(location, ":")
+	if !ok || path == "" {
+		return storage.StorageConfig{}, fmt.Errorf("%w: %q", ErrInvalidStorage, location)
+	}
+
+	return storage.StorageConfig{Driver: driver, Path: path, SQLitePath: path}, nil
+}
+
+func closeStorage(s port.Storage) {
+	if closer, ok := s.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func printReport(w io.Writer, report migration.Report, options migration.Options) {
+	for _, change := range report.Changes {
+		switch change.Kind {
+		case migration.ChangeAdd:
+			fmt.Fprintf(w, "+ %s\n", change.Key)
+		case migration.ChangeUpdate:
+			fmt.Fprintf(w, "~ %s\n", change.Key)
+		case migration.ChangeConflict:
+			fmt.Fprintf(w, "! %s\n", change.Key)
+		}
+
+		for _, field := range change.Fields {
+			fmt.Fprintf(w, "    %s: %q -> %q\n", field.Name, field.From, field.To)
+		}
+	}
+
+	fmt.Fprintf(w, "read %d, duplicates %d, unchanged %d, changed %d, conflicts %d\n",
+		report.Read, report.Duplicates, report.Unchanged,
+		len(report.Changes)-report.Conflicts(), report.Conflicts())
+
+	if options.DryRun {
+		fmt.Fprintf(w, "dry run, source %d records, checksum %s\n",
+			report.SourceCount, report.SourceChecksum)
+		return
+	}
+
+	fmt.Fprintf(w, "source %d records, checksum %s\n", report.SourceCount, report.SourceChecksum)
+	fmt.Fprintf(w, "destination %d records, checksum %s\n",
+		report.DestinationCount, report.DestinationChecksum)
+}
@@ -0,0 +1,230 @@
+package port
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultAlertHysteresis is the hysteresis of the alert in percent
+	// when it isn't specified
+	DefaultAlertHysteresis = 0.5
+
+	// DefaultAlertWindow is the window of the change alert
+	// when it isn't specified
+	DefaultAlertWindow = 24 * time.Hour
+
+	_maxHysteresis = 100
+)
+
+var (
+	ErrInvalidAlert      = errors.New("invalid alert")
+	ErrAlertNotFound     = errors.New("alert not found")
+	ErrInvalidAlertKind  = errors.New("invalid alert kind, expected above, below or change")
+	ErrInvalidThreshold  = errors.New("invalid threshold, expected positive number")
+	ErrInvalidWindow     = errors.New("invalid window, expected positive duration e.g. 1h")
+	ErrInvalidHysteresis = errors.New("invalid hysteresis, expected percent below the threshold")
+)
+
+// AlertKind is the condition the alert fires on
+type AlertKind string
+
+const (
+	// AlertAbove fires when the rate rises to the threshold or above
+	AlertAbove AlertKind = "above"
+
+	// AlertBelow fires when the rate falls to the threshold or below
+	AlertBelow AlertKind = "below"
+
+	// AlertChange fires when the rate moves by the threshold percent
+	// or more within the window in either direction
+	AlertChange AlertKind = "change"
+)
+
+// ParseAlertKind returns the kind of the alert
+func ParseAlertKind(kind string) (AlertKind, error) {
+	switch k := AlertKind(strings.ToLower(strings.TrimSpace(kind))); k {
+	case AlertAbove, AlertBelow, AlertChange:
+		return k, nil
+	default:
+		return "", ErrInvalidAlertKind
+	}
+}
+
+// Duration is the duration written as text, e.g. "1h30m"
+type Duration time.Duration
+
+func (d Duration) String() string {
+	s := time.Duration(d).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+
+	return nil
+}
+
+// Alert is the rule notifying the subscriber about the rate of the pair.
+// Threshold is the level of the rate for the above and below alerts and
+// the percent of the move within the window for the change alerts.
+// Hysteresis is how far back in percent the rate has to go to arm the
+// fired alert again, so the rate hovering at the threshold doesn't fire
+// it over and over. Triggered is set while the alert is fired
+type Alert struct {
+	ID          string       `json:"id"`
+	Email       string       `json:"email"`
+	Pair        CurrencyPair `json:"pair"`
+	Kind        AlertKind    `json:"kind"`
+	Threshold   Decimal      `json:"threshold"`
+	Window      Duration     `json:"window,omitempty"`
+	Hysteresis  float64      `json:"hysteresis"`
+	Triggered   bool         `json:"triggered"`
+	TriggeredAt time.Time    `json:"triggeredAt"`
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+// ParseAlert parses the alert given as text, such as "BTC/UAH" pair,
+// "above" kind, "1500000" threshold, "1h" window and "0.5" hysteresis.
+// The empty pair is the default pair, the empty window and hysteresis
+// are the default ones. The window is only kept for the change alerts
+func ParseAlert(pair, kind, threshold, window, hysteresis string) (Alert, error) {
+	alert, err := parseAlert(pair, kind, threshold, window, hysteresis)
+	if err != nil {
+		return Alert{}, errors.Join(err, ErrInvalidAlert)
+	}
+
+	return alert, nil
+}
+
+func parseAlert(pair, kind, threshold, window, hysteresis string) (Alert, error) {
+	var (
+		alert Alert
+		err   error
+	)
+
+	if alert.Pair, err = ParseCurrencyPair(pair); err != nil {
+		return Alert{}, err
+	}
+
+	if alert.Kind, err = ParseAlertKind(kind); err != nil {
+		return Alert{}, err
+	}
+
+	alert.Threshold, err = ParseDecimal(threshold)
+	if err != nil || alert.Threshold.Cmp(Decimal{}) <= 0 {
+		return Alert{}, ErrInvalidThreshold
+	}
+
+	if alert.Window, err = parseAlertWindow(alert.Kind, window); err != nil {
+		return Alert{}, err
+	}
+
+	if alert.Hysteresis, err = parseHysteresis(hysteresis); err != nil {
+		return Alert{}, err
+	}
+
+	if alert.Kind == AlertChange && alert.Hysteresis >= alert.Threshold.Float64() {
+		return Alert{}, ErrInvalidHysteresis
+	}
+
+	return alert, nil
+}
+
+func parseAlertWindow(kind AlertKind, window string) (Duration, error) {
+	window = strings.TrimSpace(window)
+	if kind != AlertChange {
+		return 0, nil
+	}
+
+	if window == "" {
+		return Duration(DefaultAlertWindow), nil
+	}
+
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		return 0, ErrInvalidWindow
+	}
+
+	return Duration(duration), nil
+}
+
+func parseHysteresis(hysteresis string) (float64, error) {
+	hysteresis = strings.TrimSpace(hysteresis)
+	if hysteresis == "" {
+		return DefaultAlertHysteresis, nil
+	}
+
+	value, err := ParseDecimal(hysteresis)
+	if err != nil {
+		return 0, ErrInvalidHysteresis
+	}
+
+	percent := value.Float64()
+	if percent < 0 || percent >= _maxHysteresis {
+		return 0, ErrInvalidHysteresis
+	}
+
+	return percent, nil
+}
+
+// IsMet reports whether the value fires the alert, the value is the rate
+// for the above and below alerts and the absolute percent of the move
+// within the window for the change alerts
+func (a Alert) IsMet(value float64) bool {
+	threshold := a.Threshold.Float64()
+
+	switch a.Kind {
+	case AlertAbove, AlertChange:
+		return value >= threshold
+	case AlertBelow:
+		return value <= threshold
+	default:
+		return false
+	}
+}
+
+// IsCleared reports whether the value is far enough from the threshold
+// to arm the fired alert again. The rate has to go back by the hysteresis
+// percent of the level, the move has to drop by the hysteresis points
+func (a Alert) IsCleared(value float64) bool {
+	threshold := a.Threshold.Float64()
+
+	switch a.Kind {
+	case AlertAbove:
+		return value < threshold*(1-a.Hysteresis/100)
+	case AlertBelow:
+		return value > threshold*(1+a.Hysteresis/100)
+	case AlertChange:
+		return value < threshold-a.Hysteresis
+	default:
+		return false
+	}
+}
+
+// AlertEvent is the alert fired by the rate. Reference is the rate within
+// the window the rate moved from and Change is the move in percent,
+// they are only set for the change alerts
+type AlertEvent struct {
+	Alert     Alert
+	Rate      Rate
+	Reference *Rate
+	Change    float64
+}
@@ -0,0 +1,211 @@
+package port
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAlert(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		pair               string
+		kind               string
+		threshold          string
+		window             string
+		hysteresis         string
+		expectedPair       CurrencyPair
+		expectedKind       AlertKind
+		expectedWindow     Duration
+		expectedHysteresis float64
+		expectedErr        error
+	}{
+		{
+			name:               "Level alert with defaults",
+			kind:               "Above",
+			threshold:          "1500000",
+			window:             "1h",
+			expectedPair:       DefaultCurrencyPair,
+			expectedKind:       AlertAbove,
+			expectedHysteresis: DefaultAlertHysteresis,
+		},
+		{
+			name:               "Change alert",
+			pair:               "eth/usd",
+			kind:               "change",
+			threshold:          "5",
+			window:             "30m",
+			hysteresis:         "1",
+			expectedPair:       CurrencyPair{Base: "ETH", Quote: "USD"},
+			expectedKind:       AlertChange,
+			expectedWindow:     Duration(30 * time.Minute),
+			expectedHysteresis: 1,
+		},
+		{
+			name:               "Change alert with default window",
+			kind:               "change",
+			threshold:          "5",
+			hysteresis:         "0",
+			expectedPair:       DefaultCurrencyPair,
+			expectedKind:       AlertChange,
+			expectedWindow:     Duration(DefaultAlertWindow),
+			expectedHysteresis: 0,
+		},
+		{
+			name:        "Invalid pair",
+			pair:        "BTC-UAH",
+			kind:        "above",
+			threshold:   "1",
+			expectedErr: ErrInvalidCurrencyCode,
+		},
+		{
+			name:        "Invalid kind",
+			kind:        "cross",
+			threshold:   "1",
+			expectedErr: ErrInvalidAlertKind,
+		},
+		{
+			name:        "Negative threshold",
+			kind:        "below",
+			threshold:   "-1",
+			expectedErr: ErrInvalidThreshold,
+		},
+		{
+			name:        "Invalid window",
+			kind:        "change",
+			threshold:   "5",
+			window:      "-1h",
+			expectedErr: ErrInvalidWindow,
+		},
+		{
+			name:        "Hysteresis above the change",
+			kind:        "change",
+			threshold:   "5",
+			hysteresis:  "5",
+			expectedErr: ErrInvalidHysteresis,
+		},
+		{
+			name:        "Invalid hysteresis",
+			kind:        "above",
+			threshold:   "1",
+			hysteresis:  "100",
+			expectedErr: ErrInvalidHysteresis,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			alert, err := ParseAlert(tt.pair, tt.kind, tt.threshold, tt.window, tt.hysteresis)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				require.ErrorIs(t, err, ErrInvalidAlert)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedPair, alert.Pair)
+			require.Equal(t, tt.expectedKind, alert.Kind)
+			require.Equal(t, 0, alert.Threshold.Cmp(MustParseDecimal(tt.threshold)))
+			require.Equal(t, tt.expectedWindow, alert.Window)
+			require.Equal(t, tt.expectedHysteresis, alert.Hysteresis)
+		})
+	}
+}
+
+func TestAlertHysteresis(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		alert           Alert
+		value           float64
+		expectedMet     bool
+		expectedCleared bool
+	}{
+		{
+			name:        "Above at the level",
+			alert:       Alert{Kind: AlertAbove, Threshold: MustParseDecimal("100"), Hysteresis: 1},
+			value:       100,
+			expectedMet: true,
+		},
+		{
+			name:  "Above within the hysteresis",
+			alert: Alert{Kind: AlertAbove, Threshold: MustParseDecimal("100"), Hysteresis: 1},
+			value: 99.5,
+		},
+		{
+			name:            "Above past the hysteresis",
+			alert:           Alert{Kind: AlertAbove, Threshold: MustParseDecimal("100"), Hysteresis: 1},
+			value:           98.9,
+			expectedCleared: true,
+		},
+		{
+			name:        "Below at the level",
+			alert:       Alert{Kind: AlertBelow, Threshold: MustParseDecimal("100"), Hysteresis: 1},
+			value:       100,
+			expectedMet: true,
+		},
+		{
+			name:  "Below within the hysteresis",
+			alert: Alert{Kind: AlertBelow, Threshold: MustParseDecimal("100"), Hysteresis: 1},
+			value: 100.5,
+		},
+		{
+			name:            "Below past the hysteresis",
+			alert:           Alert{Kind: AlertBelow, Threshold: MustParseDecimal("100"), Hysteresis: 1},
+			value:           101.1,
+			expectedCleared: true,
+		},
+		{
+			name:        "Change by the percent",
+			alert:       Alert{Kind: AlertChange, Threshold: MustParseDecimal("5"), Hysteresis: 1},
+			value:       5.2,
+			expectedMet: true,
+		},
+		{
+			name:  "Change within the hysteresis",
+			alert: Alert{Kind: AlertChange, Threshold: MustParseDecimal("5"), Hysteresis: 1},
+			value: 4.5,
+		},
+		{
+			name:            "Change past the hysteresis",
+			alert:           Alert{Kind: AlertChange, Threshold: MustParseDecimal("5"), Hysteresis: 1},
+			value:           3.9,
+			expectedCleared: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expectedMet, tt.alert.IsMet(tt.value))
+			require.Equal(t, tt.expectedCleared, tt.alert.IsCleared(tt.value))
+		})
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	t.Parallel()
+
+	durations := []string{"45s", "30m", "1h", "1h30m", "168h"}
+	for _, duration := range durations {
+		data, err := json.Marshal(duration)
+		require.NoError(t, err)
+
+		var d Duration
+		require.NoError(t, json.Unmarshal(data, &d))
+
+		marshaled, err := json.Marshal(d)
+		require.NoError(t, err)
+		require.JSONEq(t, string(data), string(marshaled))
+	}
+}
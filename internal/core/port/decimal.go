@@ -0,0 +1,177 @@
+package port
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const _maxDecimalPlaces = 18
+
+var ErrInvalidDecimal = errors.New("invalid decimal number")
+
+var (
+	// _plainDecimal is an optional sign, the digits and an optional fraction
+	_plainDecimal = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+
+	// _jsonNumber is a number of the JSON grammar, it may have an exponent
+	_jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+)
+
+// Decimal is an exact decimal number. Unlike the floating point types
+// it keeps every digit received from the rate provider.
+// The zero value of a Decimal is 0
+type Decimal struct {
+	rat *big.Rat
+}
+
+// ParseDecimal parses a plain decimal number, e.g. "1227057.12",
+// the exponents and the other bases aren't accepted
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if !_plainDecimal.MatchString(s) {
+		return Decimal{}, ErrInvalidDecimal
+	}
+
+	return parseRat(s)
+}
+
+// ParseJSONNumber parses a JSON number, unlike ParseDecimal it
+// accepts the exponent the rate providers may send, e.g. "1.5e-05"
+func ParseJSONNumber(n json.Number) (Decimal, error) {
+	if !_jsonNumber.MatchString(n.String()) {
+		return Decimal{}, ErrInvalidDecimal
+	}
+
+	return parseRat(n.String())
+}
+
+func parseRat(s string) (Decimal, error) {
+	rat, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, ErrInvalidDecimal
+	}
+
+	return Decimal{rat: rat}, nil
+}
+
+// MustParseDecimal is like ParseDecimal but panics if the number
+// cannot be parsed, it's intended for constants and tests
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+// NewDecimalFromFloat converts a float into the shortest decimal that
+// represents it, so 0.1 becomes exactly 0.1
+func NewDecimalFromFloat(f float64) Decimal {
+	return MustParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// String returns the value with up to 18 decimal places
+// and without trailing zeros
+func (d Decimal) String() string {
+	s := d.StringFixed(_maxDecimalPlaces)
+	if !strings.Contains(s, ".") {
+		return s
+	}
+
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// StringFixed returns the value rounded to the given number of decimal
+// places, halves are rounded away from zero
+func (d Decimal) StringFixed(places int) string {
+	return d.value().FloatString(places)
+}
+
+// Float64 returns the nearest float64 value, it may lose precision
+func (d Decimal) Float64() float64 {
+	f, _ := d.value().Float64()
+	return f
+}
+
+// Cmp compares d and other and returns -1, 0 or +1
+func (d Decimal) Cmp(other Decimal) int {
+	return d.value().Cmp(other.value())
+}
+
+func (d Decimal) Add(other Decimal) Decimal {
+	return Decimal{rat: new(big.Rat).Add(d.value(), other.value())}
+}
+
+func (d Decimal) Sub(other Decimal) Decimal {
+	return Decimal{rat: new(big.Rat).Sub(d.value(), other.value())}
+}
+
+func (d Decimal) Abs() Decimal {
+	return Decimal{rat: new(big.Rat).Abs(d.value())}
+}
+
+// Quo returns d / other, dividing by zero returns zero
+func (d Decimal) Quo(other Decimal) Decimal {
+	if other.IsZero() {
+		return Decimal{}
+	}
+
+	return Decimal{rat: new(big.Rat).Quo(d.value(), other.value())}
+}
+
+// QuoInt returns d / n, dividing by zero returns zero
+func (d Decimal) QuoInt(n int) Decimal {
+	return d.Quo(Decimal{rat: new(big.Rat).SetInt64(int64(n))})
+}
+
+func (d Decimal) IsZero() bool {
+	return d.value().Sign() == 0
+}
+
+// MarshalJSON encodes the decimal as a JSON string so the clients
+// don't lose precision when decoding it into a float
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts both JSON strings with a plain decimal number
+// and JSON numbers, null leaves the decimal unchanged
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	parsed, err := ParseJSONNumber(json.Number(data))
+	if strings.HasPrefix(string(data), `"`) {
+		parsed, err = unmarshalString(data)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	*d = parsed
+	return nil
+}
+
+func unmarshalString(data []byte) (Decimal, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Decimal{}, errors.Join(err, ErrInvalidDecimal)
+	}
+
+	return ParseDecimal(s)
+}
+
+func (d Decimal) value() *big.Rat {
+	if d.rat == nil {
+		return new(big.Rat)
+	}
+
+	return d.rat
+}
@@ -0,0 +1,194 @@
+package port
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDecimal(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		input          string
+		expectedString string
+		expectedErr    error
+	}{
+		{
+			name:           "Integer",
+			input:          "1227057",
+			expectedString: "1227057",
+		},
+		{
+			name:           "Keeps every digit of a large rate",
+			input:          "1227057.123456789",
+			expectedString: "1227057.123456789",
+		},
+		{
+			name:           "Trailing zeros",
+			input:          "1227057.1200",
+			expectedString: "1227057.12",
+		},
+		{
+			name:           "Sign",
+			input:          " -1.5 ",
+			expectedString: "-1.5",
+		},
+		{
+			name:        "Exponent notation",
+			input:       "1.5e-05",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Hexadecimal",
+			input:       "0x10",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Binary",
+			input:       "0b1",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Octal",
+			input:       "0o7",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Missing fraction digits",
+			input:       "1.",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Empty string",
+			input:       "",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Fraction",
+			input:       "1/3",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Not a number",
+			input:       "rate",
+			expectedErr: ErrInvalidDecimal,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			decimal, err := ParseDecimal(tt.input)
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			if err == nil {
+				require.Equal(t, tt.expectedString, decimal.String())
+			}
+		})
+	}
+}
+
+func TestParseJSONNumber(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		input          json.Number
+		expectedString string
+		expectedErr    error
+	}{
+		{
+			name:           "Decimal",
+			input:          "1227057.12",
+			expectedString: "1227057.12",
+		},
+		{
+			name:           "Exponent notation",
+			input:          "1.5e-05",
+			expectedString: "0.000015",
+		},
+		{
+			name:        "Hexadecimal",
+			input:       "0x10",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Plus sign",
+			input:       "+1",
+			expectedErr: ErrInvalidDecimal,
+		},
+		{
+			name:        "Empty number",
+			input:       "",
+			expectedErr: ErrInvalidDecimal,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			decimal, err := ParseJSONNumber(tt.input)
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			if err == nil {
+				require.Equal(t, tt.expectedString, decimal.String())
+			}
+		})
+	}
+}
+
+func TestDecimalStringFixed(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "1227057.13", MustParseDecimal("1227057.125").StringFixed(2))
+	require.Equal(t, "1227057.00", MustParseDecimal("1227057").StringFixed(2))
+	require.Equal(t, "0.00", Decimal{}.StringFixed(2))
+}
+
+func TestDecimalJSON(t *testing.T) {
+	t.Parallel()
+
+	data, err := json.Marshal(MustParseDecimal("1227057.12"))
+	require.NoError(t, err)
+	require.Equal(t, `"1227057.12"`, string(data))
+
+	var fromString, fromNumber Decimal
+	require.NoError(t, json.Unmarshal([]byte(`"1227057.12"`), &fromString))
+	require.NoError(t, json.Unmarshal([]byte(`1227057.12`), &fromNumber))
+	require.Equal(t, 0, fromString.Cmp(fromNumber))
+
+	var fromExponent Decimal
+	require.NoError(t, json.Unmarshal([]byte(`1.5e-05`), &fromExponent))
+	require.Equal(t, "0.000015", fromExponent.String())
+
+	fromNull := MustParseDecimal("1.5")
+	require.NoError(t, json.Unmarshal([]byte(`null`), &fromNull))
+	require.Equal(t, "1.5", fromNull.String())
+
+	var invalid Decimal
+	require.ErrorIs(t, json.Unmarshal([]byte(`"0x10"`), &invalid), ErrInvalidDecimal)
+	require.ErrorIs(t, json.Unmarshal([]byte(`"1.5e-05"`), &invalid), ErrInvalidDecimal)
+	require.ErrorIs(t, invalid.UnmarshalJSON([]byte(`"1.5`)), ErrInvalidDecimal)
+	require.ErrorIs(t, invalid.UnmarshalJSON([]byte(`1.5"`)), ErrInvalidDecimal)
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	t.Parallel()
+
+	a := MustParseDecimal("1227057.10")
+	b := MustParseDecimal("1227056.90")
+
+	require.Equal(t, "2454114", a.Add(b).String())
+	require.Equal(t, "0.2", a.Sub(b).String())
+	require.Equal(t, "0.2", b.Sub(a).Abs().String())
+	require.Equal(t, "1227057", a.Add(b).QuoInt(2).String())
+	require.Equal(t, "0.5", MustParseDecimal("1").Quo(MustParseDecimal("2")).String())
+	require.True(t, a.QuoInt(0).IsZero())
+}
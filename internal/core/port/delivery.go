@@ -0,0 +1,61 @@
+package port
+
+// DeliveryStatus is the outcome of sending an email to a recipient
+type DeliveryStatus string
+
+const (
+	// DeliveryAccepted is an email accepted by the mail server
+	DeliveryAccepted DeliveryStatus = "accepted"
+
+	// DeliveryRejected is an email permanently rejected by the mail server,
+	// sending it again won't help
+	DeliveryRejected DeliveryStatus = "rejected"
+
+	// DeliveryDeferred is an email that wasn't sent because of
+	// a temporary failure, it can be sent again later
+	DeliveryDeferred DeliveryStatus = "deferred"
+)
+
+// Delivery is the outcome of sending an email to the recipient. Code and
+// Message are the reply of the mail server or the error of the failure
+type Delivery struct {
+	Email   string         `json:"email"`
+	Status  DeliveryStatus `json:"status"`
+	Code    int            `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+}
+
+// DeliverySummary counts the deliveries by their status
+type DeliverySummary struct {
+	Total    int `json:"total"`
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+	Deferred int `json:"deferred"`
+}
+
+// DeliveryReport is the outcome of sending an email to several recipients
+type DeliveryReport struct {
+	Summary    DeliverySummary `json:"summary"`
+	Deliveries []Delivery      `json:"deliveries"`
+}
+
+func NewDeliveryReport() *DeliveryReport {
+	return &DeliveryReport{Deliveries: []Delivery{}}
+}
+
+// Add records the deliveries and counts them in the summary
+func (r *DeliveryReport) Add(deliveries ...Delivery) {
+	for _, delivery := range deliveries {
+		r.Deliveries = append(r.Deliveries, delivery)
+		r.Summary.Total++
+
+		switch delivery.Status {
+		case DeliveryAccepted:
+			r.Summary.Accepted++
+		case DeliveryRejected:
+			r.Summary.Rejected++
+		case DeliveryDeferred:
+			r.Summary.Deferred++
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package port
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliveryReport(t *testing.T) {
+	t.Parallel()
+
+	report := NewDeliveryReport()
+	report.Add(
+		Delivery{Email: "first@example.com", Status: DeliveryAccepted},
+		Delivery{Email: "second@example.com", Status: DeliveryRejected, Code: 550},
+		Delivery{Email: "third@example.com", Status: DeliveryDeferred, Code: 450},
+		Delivery{Email: "fourth@example.com", Status: DeliveryAccepted},
+	)
+
+	require.Equal(t, DeliverySummary{Total: 4, Accepted: 2, Rejected: 1, Deferred: 1}, report.Summary)
+	require.Len(t, report.Deliveries, 4)
+}
@@ -0,0 +1,16 @@
+package port
+
+import "time"
+
+// OHLC represents the open, high, low and close rates of a currency pair
+// within the time interval that begins at Start. Count is the number of
+// rates fetched within the interval
+type OHLC struct {
+	Pair  CurrencyPair `json:"pair"`
+	Start time.Time    `json:"start"`
+	Open  Decimal      `json:"open"`
+	High  Decimal      `json:"high"`
+	Low   Decimal      `json:"low"`
+	Close Decimal      `json:"close"`
+	Count int          `json:"count"`
+}
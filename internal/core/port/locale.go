@@ -0,0 +1,140 @@
+package port
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	_minLanguageLength = 2
+	_maxLanguageLength = 3
+	_scriptLength      = 4
+	_regionLength      = 2
+	_regionCodeLength  = 3
+)
+
+var ErrInvalidLocale = errors.New("invalid locale")
+
+// ParseLocale returns the canonical language tag of the locale with the
+// language, script and region subtags, e.g. "uk-UA" for "uk_ua". The
+// other subtags, such as variants and extensions, are dropped
+func ParseLocale(locale string) (string, error) {
+	subtags := strings.FieldsFunc(strings.TrimSpace(locale), func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+
+	if len(subtags) == 0 || !isLanguage(subtags[0]) {
+		return "", ErrInvalidLocale
+	}
+
+	tag := []string{strings.ToLower(subtags[0])}
+	rest := subtags[1:]
+
+	if len(rest) > 0 && len(rest[0]) == _scriptLength && isLetters(rest[0]) {
+		tag = append(tag, strings.ToUpper(rest[0][:1])+strings.ToLower(rest[0][1:]))
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 && isRegion(rest[0]) {
+		tag = append(tag, strings.ToUpper(rest[0]))
+	}
+
+	return strings.Join(tag, "-"), nil
+}
+
+// LocaleLanguage returns the language of the canonical locale, e.g. "uk"
+func LocaleLanguage(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+	return language
+}
+
+// PreferredLocale returns the canonical locale the Accept-Language
+// header prefers, it's empty when the header has no valid locale
+func PreferredLocale(acceptLanguage string) string {
+	type weighted struct {
+		locale  string
+		quality float64
+	}
+
+	var locales []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+
+		locale, err := ParseLocale(tag)
+		if err != nil {
+			continue
+		}
+
+		quality := parseQuality(params)
+		if quality > 0 {
+			locales = append(locales, weighted{locale: locale, quality: quality})
+		}
+	}
+
+	if len(locales) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(locales, func(i, j int) bool {
+		return locales[i].quality > locales[j].quality
+	})
+
+	return locales[0].locale
+}
+
+// parseQuality returns the q parameter of the Accept-Language
+// entry, the entry without it has the highest quality
+func parseQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(key, "q") {
+			continue
+		}
+
+		quality, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0
+		}
+
+		return quality
+	}
+
+	return 1
+}
+
+func isLanguage(subtag string) bool {
+	return len(subtag) >= _minLanguageLength &&
+		len(subtag) <= _maxLanguageLength &&
+		isLetters(subtag)
+}
+
+func isRegion(subtag string) bool {
+	if len(subtag) == _regionLength {
+		return isLetters(subtag)
+	}
+
+	return len(subtag) == _regionCodeLength && isDigits(subtag)
+}
+
+func isDigits(subtag string) bool {
+	for _, r := range subtag {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLetters(subtag string) bool {
+	for _, r := range subtag {
+		isLetter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		if !isLetter {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,111 @@
+package port
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLocale(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		locale         string
+		expectedLocale string
+		expectedErr    error
+	}{
+		{
+			name:           "Language",
+			locale:         "UK",
+			expectedLocale: "uk",
+		},
+		{
+			name:           "Language and region with underscore",
+			locale:         " uk_ua ",
+			expectedLocale: "uk-UA",
+		},
+		{
+			name:           "Script and numeric region",
+			locale:         "sr-latn-419",
+			expectedLocale: "sr-Latn-419",
+		},
+		{
+			name:           "Variants dropped",
+			locale:         "de-DE-1996",
+			expectedLocale: "de-DE",
+		},
+		{
+			name:        "Empty locale",
+			locale:      "",
+			expectedErr: ErrInvalidLocale,
+		},
+		{
+			name:        "Wildcard",
+			locale:      "*",
+			expectedErr: ErrInvalidLocale,
+		},
+		{
+			name:        "Invalid language",
+			locale:      "ukrainian",
+			expectedErr: ErrInvalidLocale,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			locale, err := ParseLocale(tt.locale)
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Equal(t, tt.expectedLocale, locale)
+		})
+	}
+}
+
+func TestPreferredLocale(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		expectedLocale string
+	}{
+		{
+			name:           "Single locale",
+			acceptLanguage: "uk-UA",
+			expectedLocale: "uk-UA",
+		},
+		{
+			name:           "Highest quality",
+			acceptLanguage: "en;q=0.8, uk;q=0.9, de;q=0.1",
+			expectedLocale: "uk",
+		},
+		{
+			name:           "First of equal quality",
+			acceptLanguage: "en-GB,en;q=0.9,uk",
+			expectedLocale: "en-GB",
+		},
+		{
+			name:           "Wildcard and refused locales skipped",
+			acceptLanguage: "*, fr;q=0, pl;q=0.5",
+			expectedLocale: "pl",
+		},
+		{
+			name:           "No header",
+			acceptLanguage: "",
+			expectedLocale: "",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expectedLocale, PreferredLocale(tt.acceptLanguage))
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package port
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrBatchNotFound = errors.New("batch not found")
+
+// JobStatus is the state of the email waiting in the outbox
+type JobStatus string
+
+const (
+	// JobQueued is a job waiting for the first or the next attempt
+	JobQueued JobStatus = "queued"
+
+	// JobDelivered is a job whose email was accepted by the mail server
+	JobDelivered JobStatus = "delivered"
+
+	// JobDead is a job whose email was rejected or failed too many times,
+	// it won't be attempted again
+	JobDead JobStatus = "dead"
+)
+
+// Recipient is the subscriber getting the rate of a followed pair
+type Recipient struct {
+	User User
+	Rate Rate
+}
+
+// Job is the email with the rate to a single recipient. Key is the
+// idempotency key of the job, it's unique within the outbox, and
+// LastDelivery is the outcome of the last attempt
+type Job struct {
+	Key           string    `json:"key"`
+	BatchID       string    `json:"batchId"`
+	Email         string    `json:"email"`
+	Locale        string    `json:"locale,omitempty"`
+	Rate          Rate      `json:"rate"`
+	Status        JobStatus `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	LastDelivery  *Delivery `json:"lastDelivery,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// IsFinished reports whether the job won't be attempted again
+func (j Job) IsFinished() bool {
+	return j.Status == JobDelivered || j.Status == JobDead
+}
+
+// BatchSummary counts the jobs of the batch by their status
+type BatchSummary struct {
+	Total     int `json:"total"`
+	Queued    int `json:"queued"`
+	Delivered int `json:"delivered"`
+	Dead      int `json:"dead"`
+}
+
+// Batch is the jobs enqueued by a single send request
+type Batch struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Summary   BatchSummary `json:"summary"`
+	Jobs      []Job        `json:"jobs"`
+}
+
+// IsFinished reports whether all the jobs of the batch are finished
+func (b Batch) IsFinished() bool {
+	for _, job := range b.Jobs {
+		if !job.IsFinished() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Summarize counts the jobs of the batch in the summary
+func (b *Batch) Summarize() {
+	b.Summary = BatchSummary{Total: len(b.Jobs)}
+
+	for _, job := range b.Jobs {
+		switch job.Status {
+		case JobQueued:
+			b.Summary.Queued++
+		case JobDelivered:
+			b.Summary.Delivered++
+		case JobDead:
+			b.Summary.Dead++
+		}
+	}
+}
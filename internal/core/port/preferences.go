@@ -0,0 +1,291 @@
+package port
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	_pairsSeparator      = ","
+	_pairSeparator       = "/"
+	_quietHoursSeparator = "-"
+	_clockLayout         = "15:04"
+	_minutesInDay        = 24 * 60
+)
+
+var (
+	ErrInvalidPreferences = errors.New("invalid preferences")
+	ErrInvalidFrequency   = errors.New("invalid frequency, expected hourly, daily or weekly")
+	ErrInvalidTimezone    = errors.New("invalid timezone, expected IANA time zone e.g. Europe/Kyiv")
+	ErrInvalidQuietHours  = errors.New("invalid quiet hours, expected e.g. 22:00-07:00")
+)
+
+// Frequency is how often the subscriber gets the rate
+type Frequency string
+
+const (
+	// FrequencyEveryBroadcast is the subscriber getting every broadcast
+	FrequencyEveryBroadcast Frequency = ""
+
+	// FrequencyHourly is the subscriber getting at most one email an hour
+	FrequencyHourly Frequency = "hourly"
+
+	// FrequencyDaily is the subscriber getting at most one email a day
+	FrequencyDaily Frequency = "daily"
+
+	// FrequencyWeekly is the subscriber getting at most one email a week
+	FrequencyWeekly Frequency = "weekly"
+)
+
+// ParseFrequency returns the frequency, the empty one means every broadcast
+func ParseFrequency(frequency string) (Frequency, error) {
+	switch f := Frequency(strings.ToLower(strings.TrimSpace(frequency))); f {
+	case FrequencyEveryBroadcast, FrequencyHourly, FrequencyDaily, FrequencyWeekly:
+		return f, nil
+	default:
+		return "", ErrInvalidFrequency
+	}
+}
+
+// period returns the calendar period of the local time the subscriber
+// gets a single email in, the broadcasts in the same period are skipped
+func (f Frequency) period(local time.Time) string {
+	switch f {
+	case FrequencyHourly:
+		return local.Format("2006-01-02T15")
+	case FrequencyDaily:
+		return local.Format("2006-01-02")
+	case FrequencyWeekly:
+		year, week := local.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	default:
+		return local.String()
+	}
+}
+
+// QuietHours is the local time of the day the subscriber gets no emails
+// in, from Start up to End minutes after midnight. The period may span
+// midnight, the zero value means no quiet hours
+type QuietHours struct {
+	Start int
+	End   int
+}
+
+// ParseQuietHours parses the quiet hours such as "22:00-07:00",
+// the empty string means no quiet hours
+func ParseQuietHours(quietHours string) (QuietHours, error) {
+	quietHours = strings.TrimSpace(quietHours)
+	if quietHours == "" {
+		return QuietHours{}, nil
+	}
+
+	start, end, ok := strings.Cut(quietHours, _quietHoursSeparator)
+	if !ok {
+		return QuietHours{}, ErrInvalidQuietHours
+	}
+
+	startMinute, startErr := parseClock(start)
+	endMinute, endErr := parseClock(end)
+	if startErr != nil || endErr != nil {
+		return QuietHours{}, ErrInvalidQuietHours
+	}
+
+	return QuietHours{Start: startMinute, End: endMinute}, nil
+}
+
+// IsZero reports whether there are no quiet hours
+func (q QuietHours) IsZero() bool {
+	return q.Start == q.End
+}
+
+// Contains reports whether the local time is within the quiet hours
+func (q QuietHours) Contains(local time.Time) bool {
+	if q.IsZero() {
+		return false
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	if q.Start < q.End {
+		return minute >= q.Start && minute < q.End
+	}
+
+	return minute >= q.Start || minute < q.End
+}
+
+func (q QuietHours) String() string {
+	if q.IsZero() {
+		return ""
+	}
+
+	return formatClock(q.Start) + _quietHoursSeparator + formatClock(q.End)
+}
+
+func (q QuietHours) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+func (q *QuietHours) UnmarshalText(text []byte) error {
+	quietHours, err := ParseQuietHours(string(text))
+	if err != nil {
+		return err
+	}
+
+	*q = quietHours
+
+	return nil
+}
+
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse(_clockLayout, strings.TrimSpace(clock))
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatClock(minute int) string {
+	minute %= _minutesInDay
+	return fmt.Sprintf("%02d:%02d", minute/60, minute%60)
+}
+
+// Preferences are the delivery preferences of the subscriber. The empty
+// pairs mean the default pair, the empty timezone means UTC
+type Preferences struct {
+	Pairs      []CurrencyPair `json:"pairs"`
+	Frequency  Frequency      `json:"frequency"`
+	Timezone   string         `json:"timezone"`
+	QuietHours QuietHours     `json:"quietHours"`
+}
+
+// ParsePreferences parses the preferences given as text, such as
+// "BTC/UAH,ETH/USD" pairs, "daily" frequency, "Europe/Kyiv" timezone and
+// "22:00-07:00" quiet hours, every empty value is the default one
+func ParsePreferences(pairs, frequency, timezone, quietHours string) (Preferences, error) {
+	var (
+		preferences Preferences
+		err         error
+	)
+
+	if preferences.Pairs, err = ParseCurrencyPairs(pairs); err != nil {
+		return Preferences{}, errors.Join(err, ErrInvalidPreferences)
+	}
+
+	if preferences.Frequency, err = ParseFrequency(frequency); err != nil {
+		return Preferences{}, errors.Join(err, ErrInvalidPreferences)
+	}
+
+	if preferences.Timezone, err = ParseTimezone(timezone); err != nil {
+		return Preferences{}, errors.Join(err, ErrInvalidPreferences)
+	}
+
+	if preferences.QuietHours, err = ParseQuietHours(quietHours); err != nil {
+		return Preferences{}, errors.Join(err, ErrInvalidPreferences)
+	}
+
+	return preferences, nil
+}
+
+// ParseCurrencyPairs parses the comma separated pairs such as
+// "BTC/UAH,ETH/USD", the repeated pairs are dropped
+func ParseCurrencyPairs(pairs string) ([]CurrencyPair, error) {
+	var parsed []CurrencyPair
+
+	seen := make(map[CurrencyPair]bool)
+	for _, value := range strings.Split(pairs, _pairsSeparator) {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		pair, err := ParseCurrencyPair(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[pair] {
+			seen[pair] = true
+			parsed = append(parsed, pair)
+		}
+	}
+
+	return parsed, nil
+}
+
+// ParseCurrencyPair parses the pair such as "BTC/UAH",
+// the empty pair is the default one
+func ParseCurrencyPair(pair string) (CurrencyPair, error) {
+	if strings.TrimSpace(pair) == "" {
+		return DefaultCurrencyPair, nil
+	}
+
+	base, quote, ok := strings.Cut(pair, _pairSeparator)
+	if !ok {
+		return CurrencyPair{}, ErrInvalidCurrencyCode
+	}
+
+	return NewCurrencyPair(base, quote)
+}
+
+// ParseTimezone returns the name of the IANA time zone,
+// the empty name means UTC
+func ParseTimezone(timezone string) (string, error) {
+	timezone = strings.TrimSpace(timezone)
+	if timezone == "" {
+		return "", nil
+	}
+
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return "", ErrInvalidTimezone
+	}
+
+	return timezone, nil
+}
+
+// FollowedPairs returns the pairs the subscriber gets the rates of
+func (p Preferences) FollowedPairs() []CurrencyPair {
+	if len(p.Pairs) == 0 {
+		return []CurrencyPair{DefaultCurrencyPair}
+	}
+
+	return p.Pairs
+}
+
+// Location returns the time zone of the subscriber,
+// the unknown time zone is UTC
+func (p Preferences) Location() *time.Location {
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return location
+}
+
+// IsDue reports whether the subscriber last notified at the time gets
+// the broadcast at now. The subscriber gets nothing within the quiet
+// hours and a single email within the period of the frequency
+func (p Preferences) IsDue(notifiedAt, now time.Time) bool {
+	location := p.Location()
+
+	local := now.In(location)
+	if p.QuietHours.Contains(local) {
+		return false
+	}
+
+	if p.Frequency == FrequencyEveryBroadcast || notifiedAt.IsZero() {
+		return true
+	}
+
+	return p.Frequency.period(notifiedAt.In(location)) != p.Frequency.period(local)
+}
+
+func formatCurrencyPairs(pairs []CurrencyPair) string {
+	values := make([]string, len(pairs))
+	for i, pair := range pairs {
+		values[i] = pair.String()
+	}
+
+	return strings.Join(values, _pairsSeparator)
+}
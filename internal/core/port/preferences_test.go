@@ -0,0 +1,200 @@
+package port
+
+import (
+	"testing"
+	"time"
+
+	// The tests of the time zones don't depend on the tzdata of the system
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePreferences(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                string
+		pairs               string
+		frequency           string
+		timezone            string
+		quietHours          string
+		expectedPreferences Preferences
+		expectedErr         error
+	}{
+		{
+			name:                "Defaults",
+			expectedPreferences: Preferences{},
+		},
+		{
+			name:       "All preferences",
+			pairs:      "btc/uah, ETH/USD,BTC/UAH",
+			frequency:  "Daily",
+			timezone:   "Europe/Kyiv",
+			quietHours: "22:00-07:30",
+			expectedPreferences: Preferences{
+				Pairs:      []CurrencyPair{{Base: "BTC", Quote: "UAH"}, {Base: "ETH", Quote: "USD"}},
+				Frequency:  FrequencyDaily,
+				Timezone:   "Europe/Kyiv",
+				QuietHours: QuietHours{Start: 22 * 60, End: 7*60 + 30},
+			},
+		},
+		{
+			name:        "Invalid pair",
+			pairs:       "BTC-UAH",
+			expectedErr: ErrInvalidCurrencyCode,
+		},
+		{
+			name:        "Invalid frequency",
+			frequency:   "yearly",
+			expectedErr: ErrInvalidFrequency,
+		},
+		{
+			name:        "Invalid timezone",
+			timezone:    "Mars/Olympus",
+			expectedErr: ErrInvalidTimezone,
+		},
+		{
+			name:        "Invalid quiet hours",
+			quietHours:  "22:00-25:00",
+			expectedErr: ErrInvalidQuietHours,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			preferences, err := ParsePreferences(tt.pairs, tt.frequency, tt.timezone, tt.quietHours)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				require.ErrorIs(t, err, ErrInvalidPreferences)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedPreferences, preferences)
+		})
+	}
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		quietHours string
+		clock      string
+		expected   bool
+	}{
+		{name: "No quiet hours", quietHours: "", clock: "03:00", expected: false},
+		{name: "Within the day", quietHours: "12:00-14:00", clock: "13:59", expected: true},
+		{name: "End of the day period", quietHours: "12:00-14:00", clock: "14:00", expected: false},
+		{name: "Before midnight", quietHours: "22:00-07:00", clock: "23:30", expected: true},
+		{name: "After midnight", quietHours: "22:00-07:00", clock: "06:59", expected: true},
+		{name: "Outside the night", quietHours: "22:00-07:00", clock: "07:00", expected: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			quietHours, err := ParseQuietHours(tt.quietHours)
+			require.NoError(t, err)
+			require.Equal(t, tt.quietHours, quietHours.String())
+
+			local, err := time.Parse(_clockLayout, tt.clock)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, quietHours.Contains(local))
+		})
+	}
+}
+
+func TestPreferencesIsDue(t *testing.T) {
+	t.Parallel()
+
+	// Monday 2023-07-03 21:30 UTC is Tuesday 00:30 in Kyiv
+	now := time.Date(2023, time.July, 3, 21, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		preferences Preferences
+		notifiedAt  time.Time
+		expected    bool
+	}{
+		{
+			name:       "Every broadcast",
+			notifiedAt: now.Add(-time.Minute),
+			expected:   true,
+		},
+		{
+			name:        "Never notified",
+			preferences: Preferences{Frequency: FrequencyWeekly},
+			expected:    true,
+		},
+		{
+			name:        "Hourly within the hour",
+			preferences: Preferences{Frequency: FrequencyHourly},
+			notifiedAt:  now.Add(-20 * time.Minute),
+			expected:    false,
+		},
+		{
+			name:        "Hourly in the next hour",
+			preferences: Preferences{Frequency: FrequencyHourly},
+			notifiedAt:  now.Add(-40 * time.Minute),
+			expected:    true,
+		},
+		{
+			name:        "Daily in the time zone of the subscriber",
+			preferences: Preferences{Frequency: FrequencyDaily, Timezone: "Europe/Kyiv"},
+			notifiedAt:  now.Add(-time.Hour),
+			expected:    true,
+		},
+		{
+			name:        "Daily within the day",
+			preferences: Preferences{Frequency: FrequencyDaily},
+			notifiedAt:  now.Add(-time.Hour),
+			expected:    false,
+		},
+		{
+			name:        "Weekly within the week",
+			preferences: Preferences{Frequency: FrequencyWeekly},
+			notifiedAt:  now.Add(-time.Hour),
+			expected:    false,
+		},
+		{
+			name:        "Weekly in the next week",
+			preferences: Preferences{Frequency: FrequencyWeekly},
+			notifiedAt:  now.Add(-48 * time.Hour),
+			expected:    true,
+		},
+		{
+			name: "Quiet hours in the time zone of the subscriber",
+			preferences: Preferences{
+				Timezone:   "Europe/Kyiv",
+				QuietHours: QuietHours{Start: 23 * 60, End: 7 * 60},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, tt.preferences.IsDue(tt.notifiedAt, now))
+		})
+	}
+}
+
+func TestFollowedPairs(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, []CurrencyPair{DefaultCurrencyPair}, Preferences{}.FollowedPairs())
+
+	pairs := []CurrencyPair{{Base: "ETH", Quote: "USD"}}
+	require.Equal(t, pairs, Preferences{Pairs: pairs}.FollowedPairs())
+}
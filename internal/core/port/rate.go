@@ -1,5 +1,75 @@
 package port
 
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	_minCurrencyCodeLength = 2
+	_maxCurrencyCodeLength = 10
+)
+
+var ErrInvalidCurrencyCode = errors.New("invalid currency code")
+
+// DefaultCurrencyPair is used whenever a currency pair isn't specified
+var DefaultCurrencyPair = CurrencyPair{Base: "BTC", Quote: "UAH"}
+
 // Rate represents the exchange rate between two currencies.
-// It is expressed as a float32 value.
-type Rate float32
+// Amount is the price of one unit of the base currency expressed in the
+// quote currency, Provider and FetchedAt tell where the amount came from
+// and how fresh it is. When the amount is agreed between several
+// providers, Sources lists the providers that agreed on it. Stale is set
+// when an outdated rate is served because the providers are unavailable
+type Rate struct {
+	Amount    Decimal      `json:"amount"`
+	Pair      CurrencyPair `json:"pair"`
+	Provider  string       `json:"provider"`
+	FetchedAt time.Time    `json:"fetchedAt"`
+	Sources   []string     `json:"sources,omitempty"`
+	Stale     bool         `json:"stale,omitempty"`
+}
+
+// CurrencyPair represents the pair of currencies an exchange rate is
+// requested for. Base is the currency being priced and Quote is the
+// currency the price is expressed in, e.g. BTC/UAH
+type CurrencyPair struct {
+	Base  string `json:"base"`
+	Quote string `json:"quote"`
+}
+
+// NewCurrencyPair creates a currency pair with upper-cased currency codes.
+// Codes must be alphanumeric and between 2 and 10 characters long
+func NewCurrencyPair(base, quote string) (CurrencyPair, error) {
+	pair := CurrencyPair{
+		Base:  strings.ToUpper(strings.TrimSpace(base)),
+		Quote: strings.ToUpper(strings.TrimSpace(quote)),
+	}
+
+	if !isValidCurrencyCode(pair.Base) || !isValidCurrencyCode(pair.Quote) {
+		return CurrencyPair{}, ErrInvalidCurrencyCode
+	}
+
+	return pair, nil
+}
+
+func (p CurrencyPair) String() string {
+	return p.Base + "/" + p.Quote
+}
+
+func isValidCurrencyCode(code string) bool {
+	if len(code) < _minCurrencyCodeLength || len(code) > _maxCurrencyCodeLength {
+		return false
+	}
+
+	for _, r := range code {
+		isLetter := r >= 'A' && r <= 'Z'
+		isDigit := r >= '0' && r <= '9'
+		if !isLetter && !isDigit {
+			return false
+		}
+	}
+
+	return true
+}
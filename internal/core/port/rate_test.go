@@ -0,0 +1,62 @@
+package port
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCurrencyPair(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		base         string
+		quote        string
+		expectedPair CurrencyPair
+		expectedErr  error
+	}{
+		{
+			name:         "Upper-case pair",
+			base:         "BTC",
+			quote:        "UAH",
+			expectedPair: CurrencyPair{Base: "BTC", Quote: "UAH"},
+		},
+		{
+			name:         "Lower-case pair with spaces",
+			base:         " eth",
+			quote:        "usd ",
+			expectedPair: CurrencyPair{Base: "ETH", Quote: "USD"},
+		},
+		{
+			name:        "Empty base",
+			base:        "",
+			quote:       "UAH",
+			expectedErr: ErrInvalidCurrencyCode,
+		},
+		{
+			name:        "Invalid characters",
+			base:        "BTC",
+			quote:       "U/AH",
+			expectedErr: ErrInvalidCurrencyCode,
+		},
+		{
+			name:        "Too long code",
+			base:        "BITCOINBITCOIN",
+			quote:       "UAH",
+			expectedErr: ErrInvalidCurrencyCode,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pair, err := NewCurrencyPair(tt.base, tt.quote)
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Equal(t, tt.expectedPair, pair)
+		})
+	}
+}
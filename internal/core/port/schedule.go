@@ -0,0 +1,29 @@
+package port
+
+import "time"
+
+// ScheduleRun is the broadcast of the rate for the slot of the schedule.
+// Attempts counts the failed attempts to run the slot again, BatchID is
+// the outbox batch of the emails and Error is why the last attempt failed
+type ScheduleRun struct {
+	Slot      time.Time `json:"slot"`
+	StartedAt time.Time `json:"startedAt"`
+	Attempts  int       `json:"attempts"`
+	BatchID   string    `json:"batchId,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Succeeded reports whether the emails of the run were enqueued
+func (r ScheduleRun) Succeeded() bool {
+	return r.Error == ""
+}
+
+// Schedule is the state of the scheduled broadcasts,
+// the previous runs are the latest first
+type Schedule struct {
+	Enabled      bool          `json:"enabled"`
+	Cron         string        `json:"cron,omitempty"`
+	Timezone     string        `json:"timezone,omitempty"`
+	NextRuns     []time.Time   `json:"nextRuns,omitempty"`
+	PreviousRuns []ScheduleRun `json:"previousRuns,omitempty"`
+}
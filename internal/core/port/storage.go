@@ -0,0 +1,152 @@
+package port
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrRecordNotFound = errors.New("record not found")
+	ErrRecordExists   = errors.New("record already exists")
+	ErrUnknownColumn  = errors.New("unknown column")
+	ErrInvalidValue   = errors.New("invalid column value")
+)
+
+// ColumnType is the type of the values stored in the column
+type ColumnType string
+
+const (
+	// ColumnString holds any text
+	ColumnString ColumnType = "string"
+
+	// ColumnTime holds the time in RFC 3339 format
+	ColumnTime ColumnType = "time"
+)
+
+// Column is the named and typed field of the records, the records
+// are looked up by the value of the indexed column without a scan
+type Column struct {
+	Name  string
+	Type  ColumnType
+	Index bool
+}
+
+// Validate reports whether the value can be stored in the column,
+// the empty value is the missing one and is always valid
+func (c Column) Validate(value string) error {
+	if value == "" || c.Type != ColumnTime {
+		return nil
+	}
+
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		return fmt.Errorf("%w: %s %q", ErrInvalidValue, c.Name, value)
+	}
+
+	return nil
+}
+
+// Schema is the ordered columns of the records
+type Schema []Column
+
+// Names returns the names of the columns in order
+func (s Schema) Names() []string {
+	names := make([]string, len(s))
+	for i, column := range s {
+		names[i] = column.Name
+	}
+
+	return names
+}
+
+// Column returns the column with the name
+func (s Schema) Column(name string) (Column, bool) {
+	for _, column := range s {
+		if column.Name == name {
+			return column, true
+		}
+	}
+
+	return Column{}, false
+}
+
+// Validate reports whether every field of the record
+// is a column of the schema with the valid value
+func (s Schema) Validate(record map[string]string) error {
+	var errs []error
+	for name, value := range record {
+		column, ok := s.Column(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrUnknownColumn, name))
+			continue
+		}
+
+		if err := column.Validate(value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RecordFilter reports whether the record is kept by the scan
+type RecordFilter func(record map[string]string) bool
+
+// Match keeps the records with the value under the key
+func Match(key, value string) RecordFilter {
+	return func(record map[string]string) bool {
+		return record[key] == value
+	}
+}
+
+type Storage interface {
+	Append(ctx context.Context, record map[string]string) error
+	AllRecords(ctx context.Context) (records []map[string]string, err error)
+
+	// Insert appends the record unless there is one with the same value
+	// under the key, ErrRecordExists is returned then. The check and
+	// the append are done at once, so the record is never added twice
+	Insert(ctx context.Context, key string, record map[string]string) error
+
+	// Find returns the first record with the value under the key,
+	// ErrRecordNotFound is returned when there is no such record
+	Find(ctx context.Context, key, value string) (record map[string]string, err error)
+
+	// Select returns the records kept by the filter
+	Select(ctx context.Context, filter RecordFilter) (records []map[string]string, err error)
+
+	// Page returns up to the limit records following the offset
+	// in the order they were added, so the records can be scanned
+	// without holding all of them
+	Page(ctx context.Context, offset, limit int) (records []map[string]string, err error)
+
+	// Update sets the fields of the record in the records
+	// with the value under the key
+	Update(
+		ctx context.Context,
+		key, value string,
+		record map[string]string,
+	) (updated int, err error)
+
+	// UpdateAll sets the fields of the record in the records with any
+	// of the values under the key, the records are updated at once
+	UpdateAll(
+		ctx context.Context,
+		key string,
+		values []string,
+		record map[string]string,
+	) (updated int, err error)
+
+	// Remove deletes the records with the value under the key
+	Remove(ctx context.Context, key, value string) (removed int, err error)
+
+	// RemoveMatching deletes the records with the value under the key
+	// kept by the filter. The check and the removal are done at once,
+	// so a record changed meanwhile is checked again
+	RemoveMatching(
+		ctx context.Context,
+		key, value string,
+		filter RecordFilter,
+	) (removed int, err error)
+}
@@ -0,0 +1,51 @@
+package port
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		record      map[string]string
+		expectedErr error
+	}{
+		{
+			name: "Valid record",
+			record: map[string]string{
+				"email":        "user@example.com",
+				"subscribedAt": "2023-07-01T12:00:00Z",
+				"notifiedAt":   "",
+			},
+		},
+		{
+			name:        "Unknown column",
+			record:      map[string]string{"email": "user@example.com", "phone": "+380"},
+			expectedErr: ErrUnknownColumn,
+		},
+		{
+			name:        "Invalid time",
+			record:      map[string]string{"subscribedAt": "2023-07-01"},
+			expectedErr: ErrInvalidValue,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := UserSchema.Validate(tt.record)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
@@ -1,10 +1,22 @@
 package port
 
 import (
+	"context"
 	"errors"
+	"time"
 )
 
-const _emailKey = "email"
+const (
+	_emailKey        = "email"
+	_statusKey       = "status"
+	_subscribedAtKey = "subscribedAt"
+	_localeKey       = "locale"
+	_pairsKey        = "pairs"
+	_frequencyKey    = "frequency"
+	_timezoneKey     = "timezone"
+	_quietHoursKey   = "quietHours"
+	_notifiedAtKey   = "notifiedAt"
+)
 
 var (
 	ErrAlreadyAdded      = errors.New("user is already added")
@@ -12,14 +24,50 @@ var (
 	ErrCannotLoadUsers   = errors.New("cannot load users")
 )
 
-// Represents a User entity
+// UserStatus is the state of the user subscription
+type UserStatus string
+
+const (
+	// UserPending is a user who hasn't confirmed the email yet
+	UserPending UserStatus = "pending"
+
+	// UserConfirmed is a user who has confirmed the email
+	UserConfirmed UserStatus = "confirmed"
+)
+
+// Represents a User entity, Locale is the canonical locale the emails
+// are sent in, the default one is used when it's empty. NotifiedAt is
+// the time of the last broadcast the user got
 type User struct {
-	Email string
+	Email        string
+	Status       UserStatus
+	SubscribedAt time.Time
+	Locale       string
+	Preferences  Preferences
+	NotifiedAt   time.Time
 }
 
-type Storage interface {
-	Append(record map[string]string) error
-	AllRecords() (records []map[string]string, err error)
+// IsConfirmed reports whether the user has confirmed the email
+func (u User) IsConfirmed() bool {
+	return u.Status == UserConfirmed
+}
+
+// UserKey is the column identifying the user records
+const UserKey = _emailKey
+
+// UserSchema is the columns of the user records, the new columns are
+// added to the end as the rows of the files written without the header
+// are read in this order
+var UserSchema = Schema{
+	{Name: _emailKey, Type: ColumnString, Index: true},
+	{Name: _statusKey, Type: ColumnString},
+	{Name: _subscribedAtKey, Type: ColumnTime},
+	{Name: _localeKey, Type: ColumnString},
+	{Name: _pairsKey, Type: ColumnString},
+	{Name: _frequencyKey, Type: ColumnString},
+	{Name: _timezoneKey, Type: ColumnString},
+	{Name: _quietHoursKey, Type: ColumnString},
+	{Name: _notifiedAtKey, Type: ColumnTime},
 }
 
 type UserRepository struct {
@@ -32,46 +80,191 @@ func NewUserRepository(storage Storage) *UserRepository {
 	}
 }
 
-func (ur *UserRepository) Add(user *User) error {
-	_, err := ur.FindByEmail(user.Email)
-
-	isUserFound := !errors.Is(err, ErrCannotFindByEmail)
-	if isUserFound {
+func (ur *UserRepository) Add(ctx context.Context, user *User) error {
+	err := ur.storage.Insert(ctx, _emailKey, userToRecord(user))
+	if errors.Is(err, ErrRecordExists) {
 		return ErrAlreadyAdded
 	}
 
-	if err != nil && isUserFound {
+	return err
+}
+
+func (ur *UserRepository) FindByEmail(
+	ctx context.Context,
+	email string,
+) (*User, error) {
+	record, err := ur.storage.Find(ctx, _emailKey, email)
+	if errors.Is(err, ErrRecordNotFound) {
+		return &User{}, ErrCannotFindByEmail
+	}
+
+	if err != nil {
+		return &User{}, err
+	}
+
+	user := userFromRecord(record)
+
+	return &user, nil
+}
+
+// Update stores the status, the subscription time, the preferences and the notification time
+// of the user, ErrCannotFindByEmail is returned when there is no such user
+func (ur *UserRepository) Update(ctx context.Context, user *User) error {
+	record := userToRecord(user)
+	delete(record, _emailKey)
+
+	updated, err := ur.storage.Update(ctx, _emailKey, user.Email, record)
+	if err != nil {
 		return err
 	}
 
-	return ur.storage.Append(map[string]string{_emailKey: user.Email})
+	if updated == 0 {
+		return ErrCannotFindByEmail
+	}
+
+	return nil
 }
 
-func (ur *UserRepository) FindByEmail(email string) (*User, error) {
-	records, err := ur.storage.AllRecords()
+// MarkNotified stores the notification time of the users with the emails,
+// the other fields aren't changed and the users are updated at once
+func (ur *UserRepository) MarkNotified(ctx context.Context, emails []string, at time.Time) error {
+	_, err := ur.storage.UpdateAll(ctx, _emailKey, emails, map[string]string{
+		_notifiedAtKey: at.UTC().Format(time.RFC3339),
+	})
+
+	return err
+}
+
+// Remove deletes the user, ErrCannotFindByEmail
+// is returned when there is no such user
+func (ur *UserRepository) Remove(ctx context.Context, user *User) error {
+	removed, err := ur.storage.Remove(ctx, _emailKey, user.Email)
 	if err != nil {
-		return &User{}, err
+		return err
+	}
+
+	if removed == 0 {
+		return ErrCannotFindByEmail
+	}
+
+	return nil
+}
+
+// RemoveUnconfirmed deletes the user while it's still pending and subscribed
+// before the time, the user is checked at once with the removal, so the one
+// who has confirmed or subscribed again meanwhile is kept. ErrCannotFindByEmail
+// is returned when there is no such user
+func (ur *UserRepository) RemoveUnconfirmed(
+	ctx context.Context,
+	email string,
+	subscribedBefore time.Time,
+) error {
+	removed, err := ur.storage.RemoveMatching(
+		ctx,
+		_emailKey,
+		email,
+		func(record map[string]string) bool {
+			user := userFromRecord(record)
+			return !user.IsConfirmed() && user.SubscribedAt.Before(subscribedBefore)
+		},
+	)
+	if err != nil {
+		return err
 	}
 
-	for _, e := range records {
-		if e[_emailKey] == email {
-			return &User{Email: email}, nil
-		}
+	if removed == 0 {
+		return ErrCannotFindByEmail
 	}
 
-	return &User{}, ErrCannotFindByEmail
+	return nil
 }
 
-func (ur *UserRepository) All() ([]User, error) {
-	records, err := ur.storage.AllRecords()
+func (ur *UserRepository) All(ctx context.Context) ([]User, error) {
+	records, err := ur.storage.AllRecords(ctx)
 	if err != nil {
 		return nil, errors.Join(err, ErrCannotLoadUsers)
 	}
 
 	users := make([]User, len(records))
 	for i, record := range records {
-		users[i].Email = record[_emailKey]
+		users[i] = userFromRecord(record)
 	}
 
 	return users, nil
 }
+
+// userToRecord returns the record of the user, the preferences are always
+// set, so updating the record with them clears the removed ones
+func userToRecord(user *User) map[string]string {
+	record := map[string]string{
+		_emailKey:      user.Email,
+		_statusKey:     string(user.Status),
+		_pairsKey:      formatCurrencyPairs(user.Preferences.Pairs),
+		_frequencyKey:  string(user.Preferences.Frequency),
+		_timezoneKey:   user.Preferences.Timezone,
+		_quietHoursKey: user.Preferences.QuietHours.String(),
+	}
+
+	if !user.SubscribedAt.IsZero() {
+		record[_subscribedAtKey] = user.SubscribedAt.UTC().Format(time.RFC3339)
+	}
+
+	if user.Locale != "" {
+		record[_localeKey] = user.Locale
+	}
+
+	if !user.NotifiedAt.IsZero() {
+		record[_notifiedAtKey] = user.NotifiedAt.UTC().Format(time.RFC3339)
+	}
+
+	return record
+}
+
+// userFromRecord restores the user, the records stored before
+// the confirmation was introduced have no status and are confirmed
+func userFromRecord(record map[string]string) User {
+	user := User{
+		Email:       record[_emailKey],
+		Status:      UserStatus(record[_statusKey]),
+		Locale:      record[_localeKey],
+		Preferences: preferencesFromRecord(record),
+	}
+
+	if user.Status == "" {
+		user.Status = UserConfirmed
+	}
+
+	if subscribedAt, err := time.Parse(time.RFC3339, record[_subscribedAtKey]); err == nil {
+		user.SubscribedAt = subscribedAt
+	}
+
+	if notifiedAt, err := time.Parse(time.RFC3339, record[_notifiedAtKey]); err == nil {
+		user.NotifiedAt = notifiedAt
+	}
+
+	return user
+}
+
+// preferencesFromRecord restores the preferences, the invalid
+// values are dropped and the default ones are used instead
+func preferencesFromRecord(record map[string]string) Preferences {
+	var preferences Preferences
+
+	if pairs, err := ParseCurrencyPairs(record[_pairsKey]); err == nil {
+		preferences.Pairs = pairs
+	}
+
+	if frequency, err := ParseFrequency(record[_frequencyKey]); err == nil {
+		preferences.Frequency = frequency
+	}
+
+	if timezone, err := ParseTimezone(record[_timezoneKey]); err == nil {
+		preferences.Timezone = timezone
+	}
+
+	if quietHours, err := ParseQuietHours(record[_quietHoursKey]); err == nil {
+		preferences.QuietHours = quietHours
+	}
+
+	return preferences
+}
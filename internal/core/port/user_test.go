@@ -1,7 +1,10 @@
 package port
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -11,7 +14,7 @@ type StubStorage struct {
 	err  error
 }
 
-func (s *StubStorage) Append(record map[string]string) error {
+func (s *StubStorage) Append(ctx context.Context, record map[string]string) error {
 	if s.err != nil {
 		return s.err
 	}
@@ -19,13 +22,152 @@ func (s *StubStorage) Append(record map[string]string) error {
 	return nil
 }
 
-func (s *StubStorage) AllRecords() ([]map[string]string, error) {
+func (s *StubStorage) Insert(
+	ctx context.Context,
+	key string,
+	record map[string]string,
+) error {
+	if _, err := s.Find(ctx, key, record[key]); !errors.Is(err, ErrRecordNotFound) {
+		if err == nil {
+			return ErrRecordExists
+		}
+
+		return err
+	}
+
+	return s.Append(ctx, record)
+}
+
+func (s *StubStorage) AllRecords(ctx context.Context) ([]map[string]string, error) {
 	if s.err != nil {
 		return nil, s.err
 	}
 	return s.data, nil
 }
 
+func (s *StubStorage) Find(
+	ctx context.Context,
+	key, value string,
+) (map[string]string, error) {
+	records, err := s.Select(ctx, Match(key, value))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return records[0], nil
+}
+
+func (s *StubStorage) Select(
+	ctx context.Context,
+	filter RecordFilter,
+) ([]map[string]string, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var records []map[string]string
+	for _, record := range s.data {
+		if filter(record) {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+func (s *StubStorage) Page(ctx context.Context, offset, limit int) ([]map[string]string, error) {
+	return nil, nil
+}
+
+func (s *StubStorage) Update(
+	ctx context.Context,
+	key, value string,
+	record map[string]string,
+) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	updated := 0
+	for _, stored := range s.data {
+		if stored[key] != value {
+			continue
+		}
+
+		for k, v := range record {
+			stored[k] = v
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+func (s *StubStorage) UpdateAll(
+	ctx context.Context,
+	key string,
+	values []string,
+	record map[string]string,
+) (int, error) {
+	updated := 0
+	for _, value := range values {
+		valueUpdated, err := s.Update(ctx, key, value, record)
+		if err != nil {
+			return 0, err
+		}
+		updated += valueUpdated
+	}
+
+	return updated, nil
+}
+
+func (s *StubStorage) Remove(
+	ctx context.Context,
+	key, value string,
+) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	kept := make([]map[string]string, 0, len(s.data))
+	for _, record := range s.data {
+		if record[key] != value {
+			kept = append(kept, record)
+		}
+	}
+
+	removed := len(s.data) - len(kept)
+	s.data = kept
+
+	return removed, nil
+}
+
+func (s *StubStorage) RemoveMatching(
+	ctx context.Context,
+	key, value string,
+	filter RecordFilter,
+) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	kept := make([]map[string]string, 0, len(s.data))
+	for _, record := range s.data {
+		if record[key] != value || !filter(record) {
+			kept = append(kept, record)
+		}
+	}
+
+	removed := len(s.data) - len(kept)
+	s.data = kept
+
+	return removed, nil
+}
+
 func TestAdd(t *testing.T) {
 	t.Parallel()
 
@@ -57,7 +199,7 @@ func TestAdd(t *testing.T) {
 			stubStorage := &StubStorage{data: tt.existingData}
 			userRepository := NewUserRepository(stubStorage)
 
-			err := userRepository.Add(&User{Email: tt.emailToAdd})
+			err := userRepository.Add(context.Background(), &User{Email: tt.emailToAdd})
 
 			require.Equal(t, tt.expectedErr, err)
 		})
@@ -95,7 +237,7 @@ func TestFindByEmail(t *testing.T) {
 			stubStorage := &StubStorage{data: tt.existingData}
 			userRepository := NewUserRepository(stubStorage)
 
-			_, err := userRepository.FindByEmail(tt.emailToFind)
+			_, err := userRepository.FindByEmail(context.Background(), tt.emailToFind)
 
 			require.Equal(t, tt.expectedErr, err)
 		})
@@ -138,7 +280,7 @@ func TestAll(t *testing.T) {
 			stubStorage := &StubStorage{data: tt.existingData, err: tt.storageError}
 			userRepository := NewUserRepository(stubStorage)
 
-			users, err := userRepository.All()
+			users, err := userRepository.All(context.Background())
 
 			if tt.expectedErr != nil {
 				require.ErrorIs(t, err, tt.expectedErr)
@@ -149,3 +291,236 @@ func TestAll(t *testing.T) {
 		})
 	}
 }
+
+func TestRemove(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		existingData  []map[string]string
+		emailToRemove string
+		expectedErr   error
+		expectedCount int
+	}{
+		{
+			name: "Remove user successfully",
+			existingData: []map[string]string{
+				{"email": "user1"}, {"email": "user2"},
+			},
+			emailToRemove: "user1",
+			expectedErr:   nil,
+			expectedCount: 1,
+		},
+		{
+			name:          "User not found",
+			existingData:  []map[string]string{{"email": "user1"}},
+			emailToRemove: "user2",
+			expectedErr:   ErrCannotFindByEmail,
+			expectedCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stubStorage := &StubStorage{data: tt.existingData}
+			userRepository := NewUserRepository(stubStorage)
+
+			err := userRepository.Remove(context.Background(), &User{Email: tt.emailToRemove})
+
+			require.Equal(t, tt.expectedErr, err)
+			require.Equal(t, tt.expectedCount, len(stubStorage.data))
+		})
+	}
+}
+
+func TestRemoveUnconfirmed(t *testing.T) {
+	t.Parallel()
+
+	expiredBefore := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		existingData  []map[string]string
+		expectedErr   error
+		expectedCount int
+	}{
+		{
+			name: "Expired pending user is removed",
+			existingData: []map[string]string{
+				{"email": "user1", "status": "pending", "subscribedAt": "2023-07-01T11:00:00Z"},
+			},
+			expectedCount: 0,
+		},
+		{
+			name: "Confirmed user is kept",
+			existingData: []map[string]string{
+				{"email": "user1", "status": "confirmed", "subscribedAt": "2023-07-01T11:00:00Z"},
+			},
+			expectedErr:   ErrCannotFindByEmail,
+			expectedCount: 1,
+		},
+		{
+			name: "User subscribed again is kept",
+			existingData: []map[string]string{
+				{"email": "user1", "status": "pending", "subscribedAt": "2023-07-01T12:30:00Z"},
+			},
+			expectedErr:   ErrCannotFindByEmail,
+			expectedCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stubStorage := &StubStorage{data: tt.existingData}
+			userRepository := NewUserRepository(stubStorage)
+
+			err := userRepository.RemoveUnconfirmed(context.Background(), "user1", expiredBefore)
+
+			require.Equal(t, tt.expectedErr, err)
+			require.Equal(t, tt.expectedCount, len(stubStorage.data))
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	subscribedAt := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		existingData  []map[string]string
+		user          User
+		expectedErr   error
+		expectedFound bool
+	}{
+		{
+			name: "Confirm user successfully",
+			existingData: []map[string]string{
+				{"email": "user1", "status": "pending"},
+			},
+			user: User{
+				Email:        "user1",
+				Status:       UserConfirmed,
+				SubscribedAt: subscribedAt,
+				Locale:       "uk-UA",
+			},
+			expectedErr:   nil,
+			expectedFound: true,
+		},
+		{
+			name: "Update preferences successfully",
+			existingData: []map[string]string{
+				{"email": "user1", "status": "confirmed", "pairs": "ETH/USD"},
+			},
+			user: User{
+				Email:  "user1",
+				Status: UserConfirmed,
+				Preferences: Preferences{
+					Pairs:      []CurrencyPair{{Base: "BTC", Quote: "UAH"}, {Base: "ETH", Quote: "UAH"}},
+					Frequency:  FrequencyDaily,
+					Timezone:   "Europe/Kyiv",
+					QuietHours: QuietHours{Start: 22 * 60, End: 7 * 60},
+				},
+				NotifiedAt: subscribedAt,
+			},
+			expectedErr:   nil,
+			expectedFound: true,
+		},
+		{
+			name: "Clear preferences successfully",
+			existingData: []map[string]string{
+				{"email": "user1", "status": "confirmed", "pairs": "ETH/USD", "frequency": "weekly"},
+			},
+			user:          User{Email: "user1", Status: UserConfirmed},
+			expectedErr:   nil,
+			expectedFound: true,
+		},
+		{
+			name:          "User not found",
+			existingData:  []map[string]string{{"email": "user1"}},
+			user:          User{Email: "user2", Status: UserConfirmed},
+			expectedErr:   ErrCannotFindByEmail,
+			expectedFound: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stubStorage := &StubStorage{data: tt.existingData}
+			userRepository := NewUserRepository(stubStorage)
+			ctx := context.Background()
+
+			err := userRepository.Update(ctx, &tt.user)
+			require.Equal(t, tt.expectedErr, err)
+
+			user, err := userRepository.FindByEmail(ctx, tt.user.Email)
+			if !tt.expectedFound {
+				require.ErrorIs(t, err, ErrCannotFindByEmail)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.user, *user)
+		})
+	}
+}
+
+func TestMarkNotified(t *testing.T) {
+	t.Parallel()
+
+	notifiedAt := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	stubStorage := &StubStorage{data: []map[string]string{
+		{"email": "user1", "status": "confirmed", "frequency": "daily"},
+		{"email": "user2", "status": "confirmed", "frequency": "weekly"},
+		{"email": "user3", "status": "confirmed"},
+	}}
+	userRepository := NewUserRepository(stubStorage)
+
+	err := userRepository.MarkNotified(context.Background(), []string{"user1", "user2", "gone"}, notifiedAt)
+	require.NoError(t, err)
+
+	require.Equal(t, []map[string]string{
+		{"email": "user1", "status": "confirmed", "frequency": "daily", "notifiedAt": "2023-07-01T12:00:00Z"},
+		{"email": "user2", "status": "confirmed", "frequency": "weekly", "notifiedAt": "2023-07-01T12:00:00Z"},
+		{"email": "user3", "status": "confirmed"},
+	}, stubStorage.data)
+}
+
+func TestUserWithInvalidPreferences(t *testing.T) {
+	t.Parallel()
+
+	stubStorage := &StubStorage{data: []map[string]string{{
+		"email":      "user1",
+		"pairs":      "BTC",
+		"frequency":  "yearly",
+		"timezone":   "Mars/Olympus",
+		"quietHours": "late",
+	}}}
+	userRepository := NewUserRepository(stubStorage)
+
+	user, err := userRepository.FindByEmail(context.Background(), "user1")
+	require.NoError(t, err)
+	require.Equal(t, Preferences{}, user.Preferences)
+}
+
+func TestUserWithoutStatusIsConfirmed(t *testing.T) {
+	t.Parallel()
+
+	stubStorage := &StubStorage{data: []map[string]string{{"email": "user1"}}}
+	userRepository := NewUserRepository(stubStorage)
+
+	users, err := userRepository.All(context.Background())
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.True(t, users[0].IsConfirmed())
+}
@@ -0,0 +1,494 @@
+package alert
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+const _alertIDSize = 8
+
+var (
+	ErrNotSubscribed   = errors.New("email is not subscribed")
+	ErrTooManyAlerts   = errors.New("too many alerts for the email")
+	ErrAlertRepository = errors.New("alert repository error")
+	ErrQueueFull       = errors.New("alert queue is full")
+)
+
+type AlertConfig struct {
+	// CheckInterval is how often the rates of the pairs with the alerts are
+	// fetched, so the alerts are checked even when no one asks for the rates.
+	// Zero checks the alerts only against the rates fetched on request
+	CheckInterval time.Duration `default:"1m"`
+
+	// MaxPerEmail is the number of the alerts a subscriber can have
+	MaxPerEmail int `default:"10"`
+
+	// QueueSize is the number of the fetched rates waiting to be checked,
+	// the rates fetched while the queue is full are skipped
+	QueueSize int `default:"100"`
+}
+
+type AlertRepository interface {
+	Alerts(ctx context.Context) ([]port.Alert, error)
+	SaveAlerts(ctx context.Context, alerts []port.Alert) error
+}
+
+type RateService interface {
+	ExchangeRate(ctx context.Context, pair port.CurrencyPair) (port.Rate, error)
+}
+
+// RateHistory provides the earlier rates the change alerts compare to
+type RateHistory interface {
+	Range(pair port.CurrencyPair, from, to time.Time) ([]port.Rate, error)
+}
+
+// SubscriptionService finds the confirmed subscribers,
+// port.ErrCannotFindByEmail is returned for the others
+type SubscriptionService interface {
+	FindByEmail(ctx context.Context, email string) (*port.User, error)
+}
+
+type SenderService interface {
+	SendAlert(ctx context.Context, user port.User, event port.AlertEvent) error
+}
+
+// Service keeps the alerts of the subscribers and checks them against
+// every fetched rate. The fired alert notifies the subscriber once and
+// stays quiet until the rate goes back past the hysteresis
+type Service struct {
+	logger        port.Logger
+	config        AlertConfig
+	repository    AlertRepository
+	history       RateHistory
+	subscriptions SubscriptionService
+	sender        SenderService
+	queue         chan port.Rate
+	now           func() time.Time
+
+	mu sync.Mutex
+}
+
+func NewService(
+	logger port.Logger,
+	config AlertConfig,
+	repository AlertRepository,
+	history RateHistory,
+	subscriptions SubscriptionService,
+	sender SenderService,
+) *Service {
+	return &Service{
+		logger:        logger,
+		config:        config,
+		repository:    repository,
+		history:       history,
+		subscriptions: subscriptions,
+		sender:        sender,
+		queue:         make(chan port.Rate, config.QueueSize),
+		now:           time.Now,
+	}
+}
+
+// Create adds the alert of the confirmed subscriber
+// and returns it with its ID
+func (s *Service) Create(ctx context.Context, alert port.Alert) (*port.Alert, error) {
+	subscriber, err := s.subscriber(ctx, alert.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts, err := s.repository.Alerts(ctx)
+	if err != nil {
+		return nil, errors.Join(err, ErrAlertRepository)
+	}
+
+	if len(alertsOf(alerts, subscriber.Email)) >= s.config.MaxPerEmail {
+		return nil, ErrTooManyAlerts
+	}
+
+	if alert.ID, err = alertID(); err != nil {
+		return nil, err
+	}
+	alert.Email = subscriber.Email
+	alert.Triggered = false
+	alert.TriggeredAt = time.Time{}
+	alert.CreatedAt = s.now()
+
+	if err = s.repository.SaveAlerts(ctx, append(alerts, alert)); err != nil {
+		return nil, errors.Join(err, ErrAlertRepository)
+	}
+
+	return &alert, nil
+}
+
+// Alerts returns the alerts of the email
+func (s *Service) Alerts(ctx context.Context, email string) ([]port.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts, err := s.repository.Alerts(ctx)
+	if err != nil {
+		return nil, errors.Join(err, ErrAlertRepository)
+	}
+
+	return alertsOf(alerts, email), nil
+}
+
+// Delete removes the alert with the ID of the email, ErrAlertNotFound
+// is returned for the alerts of the other emails as for the missing ones
+func (s *Service) Delete(ctx context.Context, email, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts, err := s.repository.Alerts(ctx)
+	if err != nil {
+		return errors.Join(err, ErrAlertRepository)
+	}
+
+	kept := make([]port.Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		if alert.ID != id || !strings.EqualFold(alert.Email, email) {
+			kept = append(kept, alert)
+		}
+	}
+
+	if len(kept) == len(alerts) {
+		return port.ErrAlertNotFound
+	}
+
+	if err = s.repository.SaveAlerts(ctx, kept); err != nil {
+		return errors.Join(err, ErrAlertRepository)
+	}
+
+	return nil
+}
+
+// Record queues the fetched rate to check the alerts against it, so the
+// rate service doesn't wait for the notifications
+func (s *Service) Record(rate port.Rate) error {
+	select {
+	case s.queue <- rate:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run checks the alerts against the queued rates until the context is
+// done, the rates of the pairs with the alerts are fetched through the
+// rates every check interval and get queued by the rate service
+func (s *Service) Run(ctx context.Context, rates RateService) {
+	var poll <-chan time.Time
+	if s.config.CheckInterval > 0 {
+		ticker := time.NewTicker(s.config.CheckInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rate := <-s.queue:
+			if err := s.Check(ctx, rate); err != nil {
+				s.logger.Errorf("Error, cannot check the alerts of %v: %v", rate.Pair, err)
+			}
+		case <-poll:
+			s.poll(ctx, rates)
+		}
+	}
+}
+
+// Check fires the alerts of the pair met by the rate and arms again the
+// cleared ones. The subscribers are notified of the fired alerts, the
+// alert that wasn't sent is armed again to fire on the next rate
+func (s *Service) Check(ctx context.Context, rate port.Rate) error {
+	events, err := s.update(ctx, rate)
+	if err != nil {
+		return err
+	}
+
+	if failed := s.notify(ctx, events); len(failed) > 0 {
+		s.rearm(ctx, failed)
+	}
+
+	return nil
+}
+
+func (s *Service) update(ctx context.Context, rate port.Rate) ([]port.AlertEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts, err := s.repository.Alerts(ctx)
+	if err != nil {
+		return nil, errors.Join(err, ErrAlertRepository)
+	}
+
+	var (
+		events  []port.AlertEvent
+		changed bool
+		history = s.window(alerts, rate)
+	)
+
+	for i := range alerts {
+		if alerts[i].Pair != rate.Pair {
+			continue
+		}
+
+		event, updated := s.evaluate(&alerts[i], rate, history)
+		if event != nil {
+			events = append(events, *event)
+		}
+		changed = changed || updated
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	if err = s.repository.SaveAlerts(ctx, alerts); err != nil {
+		return nil, errors.Join(err, ErrAlertRepository)
+	}
+
+	return events, nil
+}
+
+// evaluate updates the state of the alert by the rate and returns the
+// event when the alert fires and whether the state has changed, the
+// change alert compares the rate to the earlier ones of the history
+func (s *Service) evaluate(
+	alert *port.Alert,
+	rate port.Rate,
+	history []port.Rate,
+) (*port.AlertEvent, bool) {
+	event := port.AlertEvent{Rate: rate}
+	value := rate.Amount.Float64()
+
+	if alert.Kind == port.AlertChange {
+		reference, ok := reference(*alert, rate, history)
+		if !ok {
+			return nil, false
+		}
+
+		event.Reference = &reference
+		event.Change = percentChange(reference, rate)
+		value = math.Abs(event.Change)
+	}
+
+	switch {
+	case !alert.Triggered && alert.IsMet(value):
+		alert.Triggered = true
+		alert.TriggeredAt = s.now()
+		event.Alert = *alert
+
+		return &event, true
+	case alert.Triggered && alert.IsCleared(value):
+		alert.Triggered = false
+
+		return nil, true
+	default:
+		return nil, false
+	}
+}
+
+// window returns the history of the pair of the rate within the longest
+// window of its change alerts, so the history is read once per check
+func (s *Service) window(alerts []port.Alert, rate port.Rate) []port.Rate {
+	var longest time.Duration
+	for _, alert := range alerts {
+		if alert.Pair == rate.Pair && alert.Kind == port.AlertChange {
+			longest = maxDuration(longest, time.Duration(alert.Window))
+		}
+	}
+
+	if s.history == nil || longest <= 0 {
+		return nil
+	}
+
+	rates, err := s.history.Range(rate.Pair, rate.FetchedAt.Add(-longest), rate.FetchedAt)
+	if err != nil {
+		s.logger.Errorf("Error, cannot read the history of %v: %v", rate.Pair, err)
+		return nil
+	}
+
+	return rates
+}
+
+// reference returns the rate of the history within the window of the change
+// alert the rate moved the most from, there's none when the window is empty
+func reference(alert port.Alert, rate port.Rate, history []port.Rate) (port.Rate, bool) {
+	from := rate.FetchedAt.Add(-time.Duration(alert.Window))
+
+	var (
+		reference port.Rate
+		found     bool
+	)
+
+	for _, earlier := range history {
+		if earlier.FetchedAt.Before(from) || earlier.Amount.IsZero() {
+			continue
+		}
+
+		move := math.Abs(percentChange(earlier, rate))
+		if !found || move > math.Abs(percentChange(reference, rate)) {
+			reference, found = earlier, true
+		}
+	}
+
+	return reference, found
+}
+
+// notify sends the events to the subscribers and returns the IDs of the
+// alerts that weren't sent. The alerts of the emails that are no longer
+// subscribed are skipped
+func (s *Service) notify(ctx context.Context, events []port.AlertEvent) []string {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var failed []string
+
+	for _, event := range events {
+		subscriber, err := s.subscriber(ctx, event.Alert.Email)
+		if errors.Is(err, ErrNotSubscribed) {
+			s.logger.Debugf("Alert %s skipped, %s is not subscribed", event.Alert.ID, event.Alert.Email)
+			continue
+		}
+
+		if err != nil {
+			s.logger.Errorf("Error, cannot get the subscriber of alert %s: %v", event.Alert.ID, err)
+			failed = append(failed, event.Alert.ID)
+			continue
+		}
+
+		if err = s.sender.SendAlert(ctx, subscriber, event); err != nil {
+			s.logger.Errorf("Error, cannot send alert %s: %v", event.Alert.ID, err)
+			failed = append(failed, event.Alert.ID)
+		}
+	}
+
+	return failed
+}
+
+// rearm arms again the alerts with the IDs
+func (s *Service) rearm(ctx context.Context, ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts, err := s.repository.Alerts(ctx)
+	if err == nil {
+		for i := range alerts {
+			if containsID(ids, alerts[i].ID) {
+				alerts[i].Triggered = false
+				alerts[i].TriggeredAt = time.Time{}
+			}
+		}
+
+		err = s.repository.SaveAlerts(ctx, alerts)
+	}
+
+	if err != nil {
+		s.logger.Errorf("Error, cannot arm the unsent alerts again: %v", err)
+	}
+}
+
+// poll fetches the rates of the pairs with the alerts
+func (s *Service) poll(ctx context.Context, rates RateService) {
+	s.mu.Lock()
+	alerts, err := s.repository.Alerts(ctx)
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Errorf("Error, cannot read the alerts: %v", err)
+		return
+	}
+
+	for _, pair := range alertPairs(alerts) {
+		if _, err = rates.ExchangeRate(ctx, pair); err != nil {
+			s.logger.Errorf("Error, cannot get the %v rate for the alerts: %v", pair, err)
+		}
+	}
+}
+
+func (s *Service) subscriber(ctx context.Context, email string) (port.User, error) {
+	subscriber, err := s.subscriptions.FindByEmail(ctx, strings.TrimSpace(email))
+	if errors.Is(err, port.ErrCannotFindByEmail) {
+		return port.User{}, ErrNotSubscribed
+	}
+
+	if err != nil {
+		return port.User{}, err
+	}
+
+	return *subscriber, nil
+}
+
+func alertsOf(alerts []port.Alert, email string) []port.Alert {
+	email = strings.TrimSpace(email)
+
+	of := make([]port.Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		if strings.EqualFold(alert.Email, email) {
+			of = append(of, alert)
+		}
+	}
+
+	return of
+}
+
+// alertPairs returns the pairs of the alerts in the order they first appear in
+func alertPairs(alerts []port.Alert) []port.CurrencyPair {
+	var pairs []port.CurrencyPair
+
+	seen := make(map[port.CurrencyPair]bool)
+	for _, alert := range alerts {
+		if !seen[alert.Pair] {
+			seen[alert.Pair] = true
+			pairs = append(pairs, alert.Pair)
+		}
+	}
+
+	return pairs
+}
+
+func containsID(ids []string, id string) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// percentChange returns the change from the reference to the rate in percent
+func percentChange(reference, rate port.Rate) float64 {
+	return (rate.Amount.Float64()/reference.Amount.Float64() - 1) * 100
+}
+
+func alertID() (string, error) {
+	id := make([]byte, _alertIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
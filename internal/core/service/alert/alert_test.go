@@ -0,0 +1,518 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+var (
+	errRepository    = errors.New("repository error")
+	errSubscriptions = errors.New("subscriptions error")
+	errSender        = errors.New("sender error")
+)
+
+var (
+	_testNow = time.Date(2023, time.July, 3, 12, 0, 0, 0, time.UTC)
+	_ethUSD  = port.CurrencyPair{Base: "ETH", Quote: "USD"}
+)
+
+type StubLogger struct{}
+
+func (s *StubLogger) Info(...interface{})           {}
+func (s *StubLogger) Infof(string, ...interface{})  {}
+func (s *StubLogger) Debug(...interface{})          {}
+func (s *StubLogger) Debugf(string, ...interface{}) {}
+func (s *StubLogger) Error(...interface{})          {}
+func (s *StubLogger) Errorf(string, ...interface{}) {}
+
+type StubAlertRepository struct {
+	alerts  []port.Alert
+	err     error
+	saveErr error
+}
+
+func (r *StubAlertRepository) Alerts(ctx context.Context) ([]port.Alert, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return append([]port.Alert(nil), r.alerts...), nil
+}
+
+func (r *StubAlertRepository) SaveAlerts(ctx context.Context, alerts []port.Alert) error {
+	if r.saveErr != nil {
+		return r.saveErr
+	}
+
+	r.alerts = alerts
+
+	return nil
+}
+
+type StubRateService struct {
+	pairs []port.CurrencyPair
+}
+
+func (s *StubRateService) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	s.pairs = append(s.pairs, pair)
+	return port.Rate{Pair: pair}, nil
+}
+
+type StubRateHistory struct {
+	rates []port.Rate
+	reads int
+}
+
+func (h *StubRateHistory) Range(
+	pair port.CurrencyPair,
+	from, to time.Time,
+) ([]port.Rate, error) {
+	h.reads++
+
+	var rates []port.Rate
+	for _, rate := range h.rates {
+		isInRange := !rate.FetchedAt.Before(from) && rate.FetchedAt.Before(to)
+		if rate.Pair == pair && isInRange {
+			rates = append(rates, rate)
+		}
+	}
+
+	return rates, nil
+}
+
+type StubSubscriptionService struct {
+	subscribers []port.User
+	err         error
+}
+
+func (s *StubSubscriptionService) FindByEmail(
+	ctx context.Context,
+	email string,
+) (*port.User, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	for _, subscriber := range s.subscribers {
+		if subscriber.Email == email {
+			return &subscriber, nil
+		}
+	}
+
+	return nil, port.ErrCannotFindByEmail
+}
+
+type StubSenderService struct {
+	events []port.AlertEvent
+	err    error
+}
+
+func (s *StubSenderService) SendAlert(
+	ctx context.Context,
+	user port.User,
+	event port.AlertEvent,
+) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.events = append(s.events, event)
+
+	return nil
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	subscriptions := &StubSubscriptionService{
+		subscribers: []port.User{{Email: "test@example.com"}},
+	}
+
+	tests := []struct {
+		name          string
+		email         string
+		repository    *StubAlertRepository
+		subscriptions *StubSubscriptionService
+		expectedErr   error
+	}{
+		{
+			name:          "Alert of the subscriber",
+			email:         " test@example.com",
+			repository:    &StubAlertRepository{},
+			subscriptions: subscriptions,
+		},
+		{
+			name:          "Not subscribed",
+			email:         "other@example.com",
+			repository:    &StubAlertRepository{},
+			subscriptions: subscriptions,
+			expectedErr:   ErrNotSubscribed,
+		},
+		{
+			name:  "Too many alerts",
+			email: "test@example.com",
+			repository: &StubAlertRepository{alerts: []port.Alert{
+				{ID: "first", Email: "test@example.com"},
+				{ID: "second", Email: "test@example.com"},
+			}},
+			subscriptions: subscriptions,
+			expectedErr:   ErrTooManyAlerts,
+		},
+		{
+			name:          "Subscriptions error",
+			email:         "test@example.com",
+			repository:    &StubAlertRepository{},
+			subscriptions: &StubSubscriptionService{err: errSubscriptions},
+			expectedErr:   errSubscriptions,
+		},
+		{
+			name:          "Repository error",
+			email:         "test@example.com",
+			repository:    &StubAlertRepository{saveErr: errRepository},
+			subscriptions: subscriptions,
+			expectedErr:   ErrAlertRepository,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			service := newTestService(tt.repository, nil, tt.subscriptions, &StubSenderService{})
+
+			alert, err := service.Create(context.Background(), port.Alert{
+				Email:     tt.email,
+				Pair:      port.DefaultCurrencyPair,
+				Kind:      port.AlertAbove,
+				Threshold: port.MustParseDecimal("100"),
+				Triggered: true,
+			})
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotEmpty(t, alert.ID)
+			require.Equal(t, "test@example.com", alert.Email)
+			require.Equal(t, _testNow, alert.CreatedAt)
+			require.False(t, alert.Triggered)
+			require.Equal(t, []port.Alert{*alert}, tt.repository.alerts)
+		})
+	}
+}
+
+func TestAlertsAndDelete(t *testing.T) {
+	t.Parallel()
+
+	repository := &StubAlertRepository{alerts: []port.Alert{
+		{ID: "first", Email: "test@example.com"},
+		{ID: "other", Email: "other@example.com"},
+		{ID: "second", Email: "test@example.com"},
+	}}
+	service := newTestService(repository, nil, &StubSubscriptionService{}, &StubSenderService{})
+	ctx := context.Background()
+
+	alerts, err := service.Alerts(ctx, "TEST@example.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, alertIDs(alerts))
+
+	require.ErrorIs(t, service.Delete(ctx, "test@example.com", "other"), port.ErrAlertNotFound)
+	require.NoError(t, service.Delete(ctx, "test@example.com", "first"))
+	require.ErrorIs(t, service.Delete(ctx, "test@example.com", "first"), port.ErrAlertNotFound)
+
+	alerts, err = service.Alerts(ctx, "test@example.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"second"}, alertIDs(alerts))
+
+	repository.err = errRepository
+	_, err = service.Alerts(ctx, "test@example.com")
+	require.ErrorIs(t, err, ErrAlertRepository)
+	require.ErrorIs(t, service.Delete(ctx, "test@example.com", "second"), ErrAlertRepository)
+}
+
+func TestCheckHysteresis(t *testing.T) {
+	t.Parallel()
+
+	repository := &StubAlertRepository{alerts: []port.Alert{
+		{
+			ID:         "above",
+			Email:      "test@example.com",
+			Pair:       port.DefaultCurrencyPair,
+			Kind:       port.AlertAbove,
+			Threshold:  port.MustParseDecimal("100"),
+			Hysteresis: 1,
+		},
+		{
+			ID:        "other pair",
+			Email:     "test@example.com",
+			Pair:      _ethUSD,
+			Kind:      port.AlertAbove,
+			Threshold: port.MustParseDecimal("1"),
+		},
+	}}
+	sender := &StubSenderService{}
+	service := newTestService(repository, nil, subscribed("test@example.com"), sender)
+
+	// The rate hovering at the level fires the alert once, and it fires
+	// again only after the rate goes back past the hysteresis
+	amounts := []string{"99", "100", "100.5", "99.5", "100.2", "98.9", "99.5", "101"}
+	for _, amount := range amounts {
+		require.NoError(t, service.Check(context.Background(), rateOf(port.DefaultCurrencyPair, amount, _testNow)))
+	}
+
+	require.Len(t, sender.events, 2)
+	require.Equal(t, "100", sender.events[0].Rate.Amount.String())
+	require.Equal(t, "101", sender.events[1].Rate.Amount.String())
+	require.Equal(t, "above", sender.events[1].Alert.ID)
+	require.True(t, repository.alerts[0].Triggered)
+	require.Equal(t, _testNow, repository.alerts[0].TriggeredAt)
+	require.False(t, repository.alerts[1].Triggered)
+}
+
+func TestCheckChange(t *testing.T) {
+	t.Parallel()
+
+	history := &StubRateHistory{rates: []port.Rate{
+		rateOf(port.DefaultCurrencyPair, "90", _testNow.Add(-2*time.Hour)),
+		rateOf(port.DefaultCurrencyPair, "100", _testNow.Add(-50*time.Minute)),
+		rateOf(port.DefaultCurrencyPair, "103", _testNow.Add(-20*time.Minute)),
+		rateOf(_ethUSD, "50", _testNow.Add(-10*time.Minute)),
+	}}
+
+	tests := []struct {
+		name              string
+		amount            string
+		expectedReference string
+		expectedChange    float64
+	}{
+		{
+			name:              "Rate went up",
+			amount:            "105",
+			expectedReference: "100",
+			expectedChange:    5,
+		},
+		{
+			name:              "Rate went down",
+			amount:            "97.85",
+			expectedReference: "103",
+			expectedChange:    -5,
+		},
+		{
+			name:   "Rate moved too little",
+			amount: "104",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repository := &StubAlertRepository{alerts: []port.Alert{{
+				ID:        "change",
+				Email:     "test@example.com",
+				Pair:      port.DefaultCurrencyPair,
+				Kind:      port.AlertChange,
+				Threshold: port.MustParseDecimal("5"),
+				Window:    port.Duration(time.Hour),
+			}}}
+			sender := &StubSenderService{}
+			service := newTestService(repository, history, subscribed("test@example.com"), sender)
+
+			rate := rateOf(port.DefaultCurrencyPair, tt.amount, _testNow)
+			require.NoError(t, service.Check(context.Background(), rate))
+
+			if tt.expectedReference == "" {
+				require.Empty(t, sender.events)
+				return
+			}
+
+			require.Len(t, sender.events, 1)
+			require.Equal(t, tt.expectedReference, sender.events[0].Reference.Amount.String())
+			require.InDelta(t, tt.expectedChange, sender.events[0].Change, 0.001)
+		})
+	}
+}
+
+func TestCheckChangeReadsHistoryOnce(t *testing.T) {
+	t.Parallel()
+
+	history := &StubRateHistory{rates: []port.Rate{
+		rateOf(port.DefaultCurrencyPair, "90", _testNow.Add(-50*time.Minute)),
+		rateOf(port.DefaultCurrencyPair, "100", _testNow.Add(-20*time.Minute)),
+	}}
+	changeAlert := func(id string, window time.Duration) port.Alert {
+		return port.Alert{
+			ID:        id,
+			Email:     "test@example.com",
+			Pair:      port.DefaultCurrencyPair,
+			Kind:      port.AlertChange,
+			Threshold: port.MustParseDecimal("10"),
+			Window:    port.Duration(window),
+		}
+	}
+	repository := &StubAlertRepository{alerts: []port.Alert{
+		changeAlert("hour", time.Hour),
+		changeAlert("half an hour", 30*time.Minute),
+	}}
+	sender := &StubSenderService{}
+	service := newTestService(repository, history, subscribed("test@example.com"), sender)
+
+	rate := rateOf(port.DefaultCurrencyPair, "105", _testNow)
+	require.NoError(t, service.Check(context.Background(), rate))
+
+	// Only the longer window reaches the rate the change is big enough from
+	require.Equal(t, 1, history.reads)
+	require.Len(t, sender.events, 1)
+	require.Equal(t, "hour", sender.events[0].Alert.ID)
+	require.Equal(t, "90", sender.events[0].Reference.Amount.String())
+}
+
+func TestCheckNotSent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		subscriptions     *StubSubscriptionService
+		sender            *StubSenderService
+		expectedTriggered bool
+	}{
+		{
+			name:          "Send error",
+			subscriptions: subscribed("test@example.com"),
+			sender:        &StubSenderService{err: errSender},
+		},
+		{
+			name:          "Subscriptions error",
+			subscriptions: &StubSubscriptionService{err: errSubscriptions},
+			sender:        &StubSenderService{},
+		},
+		{
+			name:              "Unsubscribed email",
+			subscriptions:     subscribed("other@example.com"),
+			sender:            &StubSenderService{},
+			expectedTriggered: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repository := &StubAlertRepository{alerts: []port.Alert{{
+				ID:        "above",
+				Email:     "test@example.com",
+				Pair:      port.DefaultCurrencyPair,
+				Kind:      port.AlertAbove,
+				Threshold: port.MustParseDecimal("100"),
+			}}}
+			service := newTestService(repository, nil, tt.subscriptions, tt.sender)
+
+			rate := rateOf(port.DefaultCurrencyPair, "101", _testNow)
+			require.NoError(t, service.Check(context.Background(), rate))
+
+			require.Empty(t, tt.sender.events)
+			require.Equal(t, tt.expectedTriggered, repository.alerts[0].Triggered)
+		})
+	}
+}
+
+func TestCheckRepositoryError(t *testing.T) {
+	t.Parallel()
+
+	repository := &StubAlertRepository{err: errRepository}
+	service := newTestService(repository, nil, &StubSubscriptionService{}, &StubSenderService{})
+
+	err := service.Check(context.Background(), rateOf(port.DefaultCurrencyPair, "1", _testNow))
+	require.ErrorIs(t, err, ErrAlertRepository)
+}
+
+func TestRecord(t *testing.T) {
+	t.Parallel()
+
+	service := NewService(
+		&StubLogger{},
+		AlertConfig{QueueSize: 1},
+		&StubAlertRepository{},
+		nil,
+		&StubSubscriptionService{},
+		&StubSenderService{},
+	)
+
+	rate := rateOf(port.DefaultCurrencyPair, "1", _testNow)
+	require.NoError(t, service.Record(rate))
+	require.ErrorIs(t, service.Record(rate), ErrQueueFull)
+}
+
+func TestPoll(t *testing.T) {
+	t.Parallel()
+
+	repository := &StubAlertRepository{alerts: []port.Alert{
+		{ID: "first", Pair: port.DefaultCurrencyPair},
+		{ID: "second", Pair: _ethUSD},
+		{ID: "third", Pair: port.DefaultCurrencyPair},
+	}}
+	service := newTestService(repository, nil, &StubSubscriptionService{}, &StubSenderService{})
+
+	rates := &StubRateService{}
+	service.poll(context.Background(), rates)
+
+	require.Equal(t, []port.CurrencyPair{port.DefaultCurrencyPair, _ethUSD}, rates.pairs)
+}
+
+func newTestService(
+	repository AlertRepository,
+	history RateHistory,
+	subscriptions SubscriptionService,
+	sender SenderService,
+) *Service {
+	service := NewService(
+		&StubLogger{},
+		AlertConfig{MaxPerEmail: 2, QueueSize: 1},
+		repository,
+		history,
+		subscriptions,
+		sender,
+	)
+	service.now = func() time.Time { return _testNow }
+
+	return service
+}
+
+func subscribed(emails ...string) *StubSubscriptionService {
+	subscriptions := &StubSubscriptionService{}
+	for _, email := range emails {
+		subscriptions.subscribers = append(subscriptions.subscribers, port.User{Email: email})
+	}
+
+	return subscriptions
+}
+
+func rateOf(pair port.CurrencyPair, amount string, fetchedAt time.Time) port.Rate {
+	return port.Rate{
+		Amount:    port.MustParseDecimal(amount),
+		Pair:      pair,
+		FetchedAt: fetchedAt,
+	}
+}
+
+func alertIDs(alerts []port.Alert) []string {
+	ids := make([]string, len(alerts))
+	for i, alert := range alerts {
+		ids[i] = alert.ID
+	}
+
+	return ids
+}
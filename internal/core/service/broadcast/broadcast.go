@@ -0,0 +1,164 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+var (
+	ErrExchangeRate  = errors.New("cannot get exchange rate")
+	ErrSubscriptions = errors.New("cannot get subscriptions")
+)
+
+type RateService interface {
+	ExchangeRate(ctx context.Context, pair port.CurrencyPair) (port.Rate, error)
+}
+
+type SubscriptionService interface {
+	Subscriptions(ctx context.Context) ([]port.User, error)
+	MarkNotified(ctx context.Context, subscribers []port.User, at time.Time) error
+}
+
+type OutboxService interface {
+	Enqueue(
+		ctx context.Context,
+		idempotencyKey string,
+		recipients ...port.Recipient,
+	) (batchID string, added bool, err error)
+}
+
+// Service broadcasts the rates to the subscribers by their delivery
+// preferences, both the send request and the schedule use it
+type Service struct {
+	logger        port.Logger
+	rates         RateService
+	subscriptions SubscriptionService
+	outbox        OutboxService
+	now           func() time.Time
+}
+
+func NewService(
+	logger port.Logger,
+	rates RateService,
+	subscriptions SubscriptionService,
+	outbox OutboxService,
+) *Service {
+	return &Service{
+		logger:        logger,
+		rates:         rates,
+		subscriptions: subscriptions,
+		outbox:        outbox,
+		now:           time.Now,
+	}
+}
+
+// Broadcast enqueues the rates of the followed pairs to the due
+// subscribers in a single batch and returns its ID. The subscribers
+// within their quiet hours or already notified within the period of
+// their frequency are skipped until a later broadcast. Replaying the
+// idempotency key returns the existing batch and marks nobody notified
+func (s *Service) Broadcast(ctx context.Context, idempotencyKey string) (string, error) {
+	subscribers, err := s.subscriptions.Subscriptions(ctx)
+	if err != nil {
+		return "", errors.Join(err, ErrSubscriptions)
+	}
+
+	now := s.now()
+
+	recipients, notified, err := s.recipients(ctx, dueSubscribers(subscribers, now))
+	if err != nil {
+		return "", err
+	}
+
+	batchID, added, err := s.outbox.Enqueue(ctx, idempotencyKey, recipients...)
+	if err != nil {
+		return "", err
+	}
+
+	if !added {
+		return batchID, nil
+	}
+
+	// The emails are enqueued, so the failure only lets
+	// the subscribers get the next broadcast too soon
+	if err = s.subscriptions.MarkNotified(ctx, notified, now); err != nil {
+		s.logger.Errorf("Error, cannot mark the subscribers notified: %v", err)
+	}
+
+	return batchID, nil
+}
+
+// recipients groups the subscribers by the followed pairs and returns
+// the recipient of every pair with its rate and the notified subscribers.
+// The subscribers of the pair without the rate are skipped, the error is
+// returned only when none of the rates is available
+func (s *Service) recipients(
+	ctx context.Context,
+	subscribers []port.User,
+) ([]port.Recipient, []port.User, error) {
+	pairs, byPair := groupByPair(subscribers)
+
+	var (
+		recipients []port.Recipient
+		notified   []port.User
+		errs       []error
+	)
+
+	isNotified := make(map[string]bool, len(subscribers))
+	for _, pair := range pairs {
+		rate, err := s.rates.ExchangeRate(ctx, pair)
+		if err != nil {
+			s.logger.Errorf("Error, cannot get the %v rate for the broadcast: %v", pair, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, subscriber := range byPair[pair] {
+			recipients = append(recipients, port.Recipient{User: subscriber, Rate: rate})
+
+			if !isNotified[subscriber.Email] {
+				isNotified[subscriber.Email] = true
+				notified = append(notified, subscriber)
+			}
+		}
+	}
+
+	if len(pairs) > 0 && len(errs) == len(pairs) {
+		return nil, nil, errors.Join(append(errs, ErrExchangeRate)...)
+	}
+
+	return recipients, notified, nil
+}
+
+// dueSubscribers returns the subscribers getting the broadcast at now
+func dueSubscribers(subscribers []port.User, now time.Time) []port.User {
+	due := make([]port.User, 0, len(subscribers))
+	for _, subscriber := range subscribers {
+		if subscriber.Preferences.IsDue(subscriber.NotifiedAt, now) {
+			due = append(due, subscriber)
+		}
+	}
+
+	return due
+}
+
+// groupByPair returns the followed pairs in the order they're
+// first followed in and the subscribers following each of them
+func groupByPair(subscribers []port.User) ([]port.CurrencyPair, map[port.CurrencyPair][]port.User) {
+	var pairs []port.CurrencyPair
+
+	byPair := make(map[port.CurrencyPair][]port.User)
+	for _, subscriber := range subscribers {
+		for _, pair := range subscriber.Preferences.FollowedPairs() {
+			if _, ok := byPair[pair]; !ok {
+				pairs = append(pairs, pair)
+			}
+			byPair[pair] = append(byPair[pair], subscriber)
+		}
+	}
+
+	return pairs, byPair
+}
@@ -0,0 +1,290 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+var (
+	errRate          = errors.New("rate error")
+	errSubscriptions = errors.New("subscriptions error")
+	errOutbox        = errors.New("outbox error")
+	errMarkNotified  = errors.New("mark notified error")
+)
+
+var (
+	_testNow = time.Date(2023, time.July, 3, 12, 0, 0, 0, time.UTC)
+	_ethUSD  = port.CurrencyPair{Base: "ETH", Quote: "USD"}
+)
+
+type StubLogger struct{}
+
+func (s *StubLogger) Info(...interface{})           {}
+func (s *StubLogger) Infof(string, ...interface{})  {}
+func (s *StubLogger) Debug(...interface{})          {}
+func (s *StubLogger) Debugf(string, ...interface{}) {}
+func (s *StubLogger) Error(...interface{})          {}
+func (s *StubLogger) Errorf(string, ...interface{}) {}
+
+type StubRateService struct {
+	errs  map[port.CurrencyPair]error
+	pairs []port.CurrencyPair
+}
+
+func (s *StubRateService) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	s.pairs = append(s.pairs, pair)
+	if err := s.errs[pair]; err != nil {
+		return port.Rate{}, err
+	}
+
+	return port.Rate{Pair: pair, Amount: port.NewDecimalFromFloat(1000)}, nil
+}
+
+type StubSubscriptionService struct {
+	subscribers      []port.User
+	subscriptionsErr error
+	markErr          error
+	notified         []string
+	notifiedAt       time.Time
+}
+
+func (s *StubSubscriptionService) Subscriptions(ctx context.Context) ([]port.User, error) {
+	return s.subscribers, s.subscriptionsErr
+}
+
+func (s *StubSubscriptionService) MarkNotified(
+	ctx context.Context,
+	subscribers []port.User,
+	at time.Time,
+) error {
+	for _, subscriber := range subscribers {
+		s.notified = append(s.notified, subscriber.Email)
+	}
+	s.notifiedAt = at
+
+	return s.markErr
+}
+
+type StubOutboxService struct {
+	idempotencyKey string
+	recipients     []port.Recipient
+	enqueued       map[string]bool
+	err            error
+}
+
+func (s *StubOutboxService) Enqueue(
+	ctx context.Context,
+	idempotencyKey string,
+	recipients ...port.Recipient,
+) (string, bool, error) {
+	if s.err != nil {
+		return "", false, s.err
+	}
+
+	if s.enqueued[idempotencyKey] {
+		return "batch-id", false, nil
+	}
+
+	if s.enqueued == nil {
+		s.enqueued = make(map[string]bool)
+	}
+	s.enqueued[idempotencyKey] = true
+
+	s.idempotencyKey = idempotencyKey
+	s.recipients = recipients
+
+	return "batch-id", true, nil
+}
+
+func TestBroadcast(t *testing.T) {
+	t.Parallel()
+
+	subscribers := []port.User{
+		{Email: "default@example.com"},
+		{
+			Email:       "pairs@example.com",
+			Preferences: port.Preferences{Pairs: []port.CurrencyPair{_ethUSD, port.DefaultCurrencyPair}},
+		},
+		{
+			Email:       "notified@example.com",
+			Preferences: port.Preferences{Frequency: port.FrequencyDaily},
+			NotifiedAt:  _testNow.Add(-time.Hour),
+		},
+		{
+			Email:       "quiet@example.com",
+			Preferences: port.Preferences{QuietHours: port.QuietHours{Start: 11 * 60, End: 13 * 60}},
+		},
+	}
+
+	tests := []struct {
+		name               string
+		rateErrs           map[port.CurrencyPair]error
+		expectedRecipients []string
+		expectedNotified   []string
+	}{
+		{
+			name: "Grouped by pair",
+			expectedRecipients: []string{
+				"BTC/UAH default@example.com",
+				"BTC/UAH pairs@example.com",
+				"ETH/USD pairs@example.com",
+			},
+			expectedNotified: []string{"default@example.com", "pairs@example.com"},
+		},
+		{
+			name:               "Pair without rate",
+			rateErrs:           map[port.CurrencyPair]error{port.DefaultCurrencyPair: errRate},
+			expectedRecipients: []string{"ETH/USD pairs@example.com"},
+			expectedNotified:   []string{"pairs@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rates := &StubRateService{errs: tt.rateErrs}
+			subscriptions := &StubSubscriptionService{subscribers: subscribers}
+			outbox := &StubOutboxService{}
+			service := newTestService(rates, subscriptions, outbox)
+
+			batchID, err := service.Broadcast(context.Background(), "key")
+			require.NoError(t, err)
+			require.Equal(t, "batch-id", batchID)
+			require.Equal(t, "key", outbox.idempotencyKey)
+
+			recipients := make([]string, len(outbox.recipients))
+			for i, recipient := range outbox.recipients {
+				recipients[i] = recipient.Rate.Pair.String() + " " + recipient.User.Email
+			}
+			require.ElementsMatch(t, tt.expectedRecipients, recipients)
+			require.Len(t, rates.pairs, 2, "expected a single request per pair")
+
+			require.Equal(t, tt.expectedNotified, subscriptions.notified)
+			require.Equal(t, _testNow, subscriptions.notifiedAt)
+		})
+	}
+}
+
+func TestBroadcastReplay(t *testing.T) {
+	t.Parallel()
+
+	subscriptions := &StubSubscriptionService{
+		subscribers: []port.User{{Email: "default@example.com"}},
+	}
+	outbox := &StubOutboxService{}
+	service := newTestService(&StubRateService{}, subscriptions, outbox)
+
+	_, err := service.Broadcast(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, []string{"default@example.com"}, subscriptions.notified)
+
+	subscriptions.notified = nil
+	subscriptions.subscribers = append(
+		subscriptions.subscribers,
+		port.User{Email: "new@example.com"},
+	)
+
+	batchID, err := service.Broadcast(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, "batch-id", batchID)
+	require.Empty(t, subscriptions.notified, "the replayed batch must not mark anybody notified")
+}
+
+func TestBroadcastErrors(t *testing.T) {
+	t.Parallel()
+
+	subscribers := []port.User{{Email: "default@example.com"}}
+
+	tests := []struct {
+		name          string
+		rates         *StubRateService
+		subscriptions *StubSubscriptionService
+		outbox        *StubOutboxService
+		expectedErr   error
+	}{
+		{
+			name: "No rate",
+			rates: &StubRateService{
+				errs: map[port.CurrencyPair]error{port.DefaultCurrencyPair: errRate},
+			},
+			subscriptions: &StubSubscriptionService{subscribers: subscribers},
+			outbox:        &StubOutboxService{},
+			expectedErr:   ErrExchangeRate,
+		},
+		{
+			name:          "Subscriptions error",
+			rates:         &StubRateService{},
+			subscriptions: &StubSubscriptionService{subscriptionsErr: errSubscriptions},
+			outbox:        &StubOutboxService{},
+			expectedErr:   ErrSubscriptions,
+		},
+		{
+			name:          "Outbox error",
+			rates:         &StubRateService{},
+			subscriptions: &StubSubscriptionService{subscribers: subscribers},
+			outbox:        &StubOutboxService{err: errOutbox},
+			expectedErr:   errOutbox,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			service := newTestService(tt.rates, tt.subscriptions, tt.outbox)
+
+			_, err := service.Broadcast(context.Background(), "key")
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Empty(t, tt.subscriptions.notified)
+		})
+	}
+}
+
+func TestBroadcastMarkNotifiedError(t *testing.T) {
+	t.Parallel()
+
+	subscriptions := &StubSubscriptionService{
+		subscribers: []port.User{{Email: "default@example.com"}},
+		markErr:     errMarkNotified,
+	}
+	service := newTestService(&StubRateService{}, subscriptions, &StubOutboxService{})
+
+	batchID, err := service.Broadcast(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, "batch-id", batchID)
+}
+
+func TestBroadcastNoSubscribers(t *testing.T) {
+	t.Parallel()
+
+	outbox := &StubOutboxService{}
+	service := newTestService(&StubRateService{}, &StubSubscriptionService{}, outbox)
+
+	batchID, err := service.Broadcast(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, "batch-id", batchID)
+	require.Empty(t, outbox.recipients)
+}
+
+func newTestService(
+	rates RateService,
+	subscriptions SubscriptionService,
+	outbox OutboxService,
+) *Service {
+	service := NewService(&StubLogger{}, rates, subscriptions, outbox)
+	service.now = func() time.Time { return _testNow }
+
+	return service
+}
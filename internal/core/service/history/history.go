@@ -0,0 +1,111 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+const _maxBuckets = 1000
+
+var (
+	ErrInvalidInterval  = errors.New("interval must be positive")
+	ErrInvalidTimeRange = errors.New("from must be before to")
+	ErrTooManyBuckets   = errors.New("too many intervals within the time range")
+	ErrHistoryStorage   = errors.New("history storage error")
+)
+
+type HistoryRepository interface {
+	Add(rate port.Rate) error
+	Range(pair port.CurrencyPair, from, to time.Time) ([]port.Rate, error)
+}
+
+type Service struct {
+	repository HistoryRepository
+}
+
+func NewService(repository HistoryRepository) *Service {
+	return &Service{repository: repository}
+}
+
+// Record stores the fetched rate in the history
+func (s *Service) Record(rate port.Rate) error {
+	return s.repository.Add(rate)
+}
+
+// OHLC returns the rates of the pair fetched within [from, to) grouped
+// into the intervals aligned to the interval duration. The intervals
+// without any fetched rates are omitted
+func (s *Service) OHLC(
+	ctx context.Context,
+	pair port.CurrencyPair,
+	from, to time.Time,
+	interval time.Duration,
+) ([]port.OHLC, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidInterval
+	}
+
+	if !from.Before(to) {
+		return nil, ErrInvalidTimeRange
+	}
+
+	if to.Sub(from)/interval > _maxBuckets {
+		return nil, ErrTooManyBuckets
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rates, err := s.repository.Range(pair, from, to)
+	if err != nil {
+		return nil, errors.Join(err, ErrHistoryStorage)
+	}
+
+	return aggregate(pair, rates, interval), nil
+}
+
+func aggregate(
+	pair port.CurrencyPair,
+	rates []port.Rate,
+	interval time.Duration,
+) []port.OHLC {
+	sort.SliceStable(rates, func(i, j int) bool {
+		return rates[i].FetchedAt.Before(rates[j].FetchedAt)
+	})
+
+	buckets := make([]port.OHLC, 0)
+	for _, rate := range rates {
+		start := rate.FetchedAt.Truncate(interval).UTC()
+
+		last := len(buckets) - 1
+		if last < 0 || !buckets[last].Start.Equal(start) {
+			buckets = append(buckets, port.OHLC{
+				Pair:  pair,
+				Start: start,
+				Open:  rate.Amount,
+				High:  rate.Amount,
+				Low:   rate.Amount,
+				Close: rate.Amount,
+				Count: 1,
+			})
+			continue
+		}
+
+		bucket := &buckets[last]
+		if rate.Amount.Cmp(bucket.High) > 0 {
+			bucket.High = rate.Amount
+		}
+		if rate.Amount.Cmp(bucket.Low) < 0 {
+			bucket.Low = rate.Amount
+		}
+		bucket.Close = rate.Amount
+		bucket.Count++
+	}
+
+	return buckets
+}
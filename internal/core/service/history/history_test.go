@@ -0,0 +1,162 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+var errStorage = errors.New("storage error")
+
+type StubHistoryRepository struct {
+	Rates []port.Rate
+	Err   error
+}
+
+func (s *StubHistoryRepository) Add(rate port.Rate) error {
+	s.Rates = append(s.Rates, rate)
+	return s.Err
+}
+
+func (s *StubHistoryRepository) Range(
+	pair port.CurrencyPair,
+	from, to time.Time,
+) ([]port.Rate, error) {
+	return s.Rates, s.Err
+}
+
+func rateAt(amount string, hour, minute int) port.Rate {
+	return port.Rate{
+		Amount:    port.MustParseDecimal(amount),
+		Pair:      port.DefaultCurrencyPair,
+		FetchedAt: time.Date(2023, time.July, 1, hour, minute, 0, 0, time.UTC),
+	}
+}
+
+func TestOHLC(t *testing.T) {
+	t.Parallel()
+
+	from := time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	tests := []struct {
+		name         string
+		repository   *StubHistoryRepository
+		from         time.Time
+		to           time.Time
+		interval     time.Duration
+		expectedOHLC []port.OHLC
+		expectedErr  error
+	}{
+		{
+			name: "Rates are grouped by interval",
+			repository: &StubHistoryRepository{
+				Rates: []port.Rate{
+					rateAt("102", 10, 30),
+					rateAt("100", 10, 0),
+					rateAt("105", 10, 15),
+					rateAt("99", 10, 45),
+					rateAt("110", 12, 5),
+				},
+			},
+			from:     from,
+			to:       to,
+			interval: time.Hour,
+			expectedOHLC: []port.OHLC{
+				{
+					Pair:  port.DefaultCurrencyPair,
+					Start: time.Date(2023, time.July, 1, 10, 0, 0, 0, time.UTC),
+					Open:  port.MustParseDecimal("100"),
+					High:  port.MustParseDecimal("105"),
+					Low:   port.MustParseDecimal("99"),
+					Close: port.MustParseDecimal("99"),
+					Count: 4,
+				},
+				{
+					Pair:  port.DefaultCurrencyPair,
+					Start: time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC),
+					Open:  port.MustParseDecimal("110"),
+					High:  port.MustParseDecimal("110"),
+					Low:   port.MustParseDecimal("110"),
+					Close: port.MustParseDecimal("110"),
+					Count: 1,
+				},
+			},
+		},
+		{
+			name:         "No rates",
+			repository:   &StubHistoryRepository{},
+			from:         from,
+			to:           to,
+			interval:     time.Hour,
+			expectedOHLC: []port.OHLC{},
+		},
+		{
+			name:        "Invalid interval",
+			repository:  &StubHistoryRepository{},
+			from:        from,
+			to:          to,
+			interval:    0,
+			expectedErr: ErrInvalidInterval,
+		},
+		{
+			name:        "Invalid time range",
+			repository:  &StubHistoryRepository{},
+			from:        to,
+			to:          from,
+			interval:    time.Hour,
+			expectedErr: ErrInvalidTimeRange,
+		},
+		{
+			name:        "Too many intervals",
+			repository:  &StubHistoryRepository{},
+			from:        from,
+			to:          to,
+			interval:    time.Second,
+			expectedErr: ErrTooManyBuckets,
+		},
+		{
+			name:        "Storage error",
+			repository:  &StubHistoryRepository{Err: errStorage},
+			from:        from,
+			to:          to,
+			interval:    time.Hour,
+			expectedErr: ErrHistoryStorage,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			service := NewService(tt.repository)
+			ohlc, err := service.OHLC(
+				context.Background(),
+				port.DefaultCurrencyPair,
+				tt.from, tt.to,
+				tt.interval,
+			)
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			if tt.expectedErr != nil {
+				return
+			}
+
+			require.Equal(t, len(tt.expectedOHLC), len(ohlc))
+			for i, expected := range tt.expectedOHLC {
+				require.Equal(t, expected.Start, ohlc[i].Start)
+				require.Equal(t, expected.Count, ohlc[i].Count)
+				require.Equal(t, expected.Open.String(), ohlc[i].Open.String())
+				require.Equal(t, expected.High.String(), ohlc[i].High.String())
+				require.Equal(t, expected.Low.String(), ohlc[i].Low.String())
+				require.Equal(t, expected.Close.String(), ohlc[i].Close.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package mailbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	_maxAddressLength = 254
+	_maxLocalLength   = 64
+	_maxDomainLength  = 253
+	_maxLabelLength   = 63
+	_atext            = "!#$%&'*+-/=?^_`{|}~"
+)
+
+// The reasons the email is rejected for
+const (
+	ReasonEmpty          = "email is empty"
+	ReasonSyntax         = "email doesn't match the RFC 5322 address syntax"
+	ReasonDisplayName    = "email must not contain a display name"
+	ReasonTooLong        = "email is too long"
+	ReasonLocalPart      = "local part must be an unquoted ASCII dot-atom"
+	ReasonDomainLiteral  = "domain literals aren't supported"
+	ReasonDomain         = "domain isn't a valid host name"
+	ReasonDeniedDomain   = "domain is denied"
+	ReasonEmptyLocalPart = "local part is empty after folding"
+)
+
+var (
+	ErrInvalidEmail = errors.New("invalid email")
+	ErrDenylist     = errors.New("cannot read the domain denylist")
+)
+
+// ValidationError describes why the email was rejected
+type ValidationError struct {
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid email %q: %s", e.Email, e.Reason)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidEmail
+}
+
+type ValidationConfig struct {
+	// PlusTagDomains are the domains where "user+tag"
+	// is the same mailbox as "user"
+	PlusTagDomains []string
+
+	// DotFoldDomains are the domains where the dots
+	// in the local part are ignored
+	DotFoldDomains []string
+
+	// DenylistPath is the file with the denied domains, one per line,
+	// the subdomains of the denied domains are denied as well
+	DenylistPath string
+}
+
+type Validator struct {
+	plusTagDomains map[string]bool
+	dotFoldDomains map[string]bool
+	deniedDomains  map[string]bool
+}
+
+func NewValidator(config ValidationConfig) (*Validator, error) {
+	deniedDomains, err := readDenylist(config.DenylistPath)
+	if err != nil {
+		return nil, errors.Join(err, ErrDenylist)
+	}
+
+	return &Validator{
+		plusTagDomains: domainSet(config.PlusTagDomains),
+		dotFoldDomains: domainSet(config.DotFoldDomains),
+		deniedDomains:  deniedDomains,
+	}, nil
+}
+
+// Canonicalize validates the email and returns its canonical form: the
+// domain is lowercased and converted to Punycode, and the local part is
+// folded according to the rules of the domain. *ValidationError is
+// returned for the rejected emails
+func (v *Validator) Canonicalize(email string) (string, error) {
+	local, domain, reason := parse(email)
+	if reason != "" {
+		return "", &ValidationError{Email: email, Reason: reason}
+	}
+
+	if v.isDenied(domain) {
+		return "", &ValidationError{Email: email, Reason: ReasonDeniedDomain}
+	}
+
+	local = v.fold(local, domain)
+	if local == "" {
+		return "", &ValidationError{Email: email, Reason: ReasonEmptyLocalPart}
+	}
+
+	return local + "@" + domain, nil
+}
+
+func (v *Validator) fold(local, domain string) string {
+	if v.plusTagDomains[domain] {
+		local, _, _ = strings.Cut(local, "+")
+	}
+
+	if v.dotFoldDomains[domain] {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+
+	return local
+}
+
+func (v *Validator) isDenied(domain string) bool {
+	for {
+		if v.deniedDomains[domain] {
+			return true
+		}
+
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			return false
+		}
+		domain = parent
+	}
+}
+
+// parse splits the email into the local part and the normalized domain,
+// the reason is returned when the email is rejected
+func parse(email string) (local, domain, reason string) {
+	trimmed := strings.TrimSpace(email)
+	if trimmed == "" {
+		return "", "", ReasonEmpty
+	}
+
+	if strings.ContainsAny(trimmed, "<>") {
+		return "", "", ReasonDisplayName
+	}
+
+	address, err := mail.ParseAddress(trimmed)
+	if err != nil {
+		return "", "", ReasonSyntax
+	}
+
+	if address.Name != "" {
+		return "", "", ReasonDisplayName
+	}
+
+	at := strings.LastIndex(trimmed, "@")
+	local, domain = trimmed[:at], trimmed[at+1:]
+
+	if !isDotAtom(local) {
+		return "", "", ReasonLocalPart
+	}
+
+	if strings.HasPrefix(domain, "[") {
+		return "", "", ReasonDomainLiteral
+	}
+
+	domain, ok := normalizeDomain(domain)
+	if !ok {
+		return "", "", ReasonDomain
+	}
+
+	if len(local) > _maxLocalLength || len(local)+1+len(domain) > _maxAddressLength {
+		return "", "", ReasonTooLong
+	}
+
+	return local, domain, ""
+}
+
+func isDotAtom(local string) bool {
+	if local == "" || !isASCII(local) {
+		return false
+	}
+
+	for _, atom := range strings.Split(local, ".") {
+		if !isAtom(atom) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isAtom(atom string) bool {
+	if atom == "" {
+		return false
+	}
+
+	for _, r := range atom {
+		if !isAtext(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isAtext(r rune) bool {
+	return isAlphanumeric(r) || strings.ContainsRune(_atext, r)
+}
+
+// normalizeDomain lowercases the domain and converts it to Punycode,
+// the domain must be a host name with at least two labels
+func normalizeDomain(domain string) (string, bool) {
+	domain, err := idna.Lookup.ToASCII(strings.ToLower(strings.TrimSuffix(domain, ".")))
+	if err != nil || len(domain) > _maxDomainLength {
+		return "", false
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", false
+	}
+
+	for _, label := range labels {
+		if !isHostLabel(label) {
+			return "", false
+		}
+	}
+
+	if isNumeric(labels[len(labels)-1]) {
+		return "", false
+	}
+
+	return domain, true
+}
+
+func isHostLabel(label string) bool {
+	if label == "" || len(label) > _maxLabelLength {
+		return false
+	}
+
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+
+	for _, r := range label {
+		if !isAlphanumeric(r) && r != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isAlphanumeric(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isNumeric(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func domainSet(domains []string) map[string]bool {
+	set := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		if normalized, ok := normalizeDomain(strings.TrimSpace(domain)); ok {
+			set[normalized] = true
+		}
+	}
+
+	return set
+}
+
+// readDenylist reads the denied domains, the empty lines
+// and the lines starting with "#" are skipped
+func readDenylist(path string) (map[string]bool, error) {
+	if path == "" {
+		return map[string]bool{}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var domains []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return domainSet(domains), nil
+}
@@ -0,0 +1,217 @@
+package mailbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	validator, err := NewValidator(ValidationConfig{
+		PlusTagDomains: []string{"gmail.com", "Example.ORG"},
+		DotFoldDomains: []string{"gmail.com"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		email          string
+		expected       string
+		expectedReason string
+	}{
+		{
+			name:     "Valid email",
+			email:    "user@example.com",
+			expected: "user@example.com",
+		},
+		{
+			name:     "Surrounding whitespace",
+			email:    "  user@example.com\t",
+			expected: "user@example.com",
+		},
+		{
+			name:     "Domain is lowercased",
+			email:    "User@EXAMPLE.Com",
+			expected: "User@example.com",
+		},
+		{
+			name:     "Internationalized domain",
+			email:    "user@Bücher.example",
+			expected: "user@xn--bcher-kva.example",
+		},
+		{
+			name:     "Plus tag folding",
+			email:    "user+news@example.org",
+			expected: "user@example.org",
+		},
+		{
+			name:     "Plus tag is kept for other domains",
+			email:    "user+news@example.com",
+			expected: "user+news@example.com",
+		},
+		{
+			name:     "Plus tag and dot folding",
+			email:    "first.last+news@GMAIL.com",
+			expected: "firstlast@gmail.com",
+		},
+		{
+			name:           "Empty email",
+			email:          " ",
+			expectedReason: ReasonEmpty,
+		},
+		{
+			name:           "Missing domain",
+			email:          "user@",
+			expectedReason: ReasonSyntax,
+		},
+		{
+			name:           "Missing at sign",
+			email:          "user.example.com",
+			expectedReason: ReasonSyntax,
+		},
+		{
+			name:           "Display name",
+			email:          "User <user@example.com>",
+			expectedReason: ReasonDisplayName,
+		},
+		{
+			name:           "Quoted local part",
+			email:          `"user name"@example.com`,
+			expectedReason: ReasonLocalPart,
+		},
+		{
+			name:           "Domain literal",
+			email:          "user@[127.0.0.1]",
+			expectedReason: ReasonDomainLiteral,
+		},
+		{
+			name:           "Single label domain",
+			email:          "user@localhost",
+			expectedReason: ReasonDomain,
+		},
+		{
+			name:           "Numeric top-level domain",
+			email:          "user@127.0.0.1",
+			expectedReason: ReasonDomain,
+		},
+		{
+			name:           "Hyphen at the label edge",
+			email:          "user@-example.com",
+			expectedReason: ReasonDomain,
+		},
+		{
+			name:           "Long local part",
+			email:          strings.Repeat("a", 65) + "@example.com",
+			expectedReason: ReasonTooLong,
+		},
+		{
+			name:           "Empty local part after folding",
+			email:          "+news@example.org",
+			expectedReason: ReasonEmptyLocalPart,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			email, err := validator.Canonicalize(tt.email)
+			if tt.expectedReason == "" {
+				require.NoError(t, err)
+				require.Equal(t, tt.expected, email)
+				return
+			}
+
+			require.ErrorIs(t, err, ErrInvalidEmail)
+
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			require.Equal(t, tt.email, validationErr.Email)
+			require.Equal(t, tt.expectedReason, validationErr.Reason)
+		})
+	}
+}
+
+func TestDenylist(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "denylist")
+	denylist := "# disposable domains\n\nmailinator.com\n  Spam.Example  \nbücher.example\n"
+	require.NoError(t, os.WriteFile(path, []byte(denylist), 0o600))
+
+	validator, err := NewValidator(ValidationConfig{DenylistPath: path})
+	require.NoError(t, err)
+
+	tests := []struct {
+		email  string
+		denied bool
+	}{
+		{email: "user@mailinator.com", denied: true},
+		{email: "user@MAILINATOR.com", denied: true},
+		{email: "user@eu.mailinator.com", denied: true},
+		{email: "user@spam.example", denied: true},
+		{email: "user@xn--bcher-kva.example", denied: true},
+		{email: "user@notmailinator.com", denied: false},
+		{email: "user@example.com", denied: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.email, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := validator.Canonicalize(tt.email)
+			if !tt.denied {
+				require.NoError(t, err)
+				return
+			}
+
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			require.Equal(t, ReasonDeniedDomain, validationErr.Reason)
+		})
+	}
+}
+
+func TestMissingDenylist(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "missing")
+
+	_, err := NewValidator(ValidationConfig{DenylistPath: path})
+	require.ErrorIs(t, err, ErrDenylist)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestPunycode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		domain   string
+		expected string
+	}{
+		{domain: "example.com", expected: "example.com"},
+		{domain: "bücher.example", expected: "xn--bcher-kva.example"},
+		{domain: "münchen.de", expected: "xn--mnchen-3ya.de"},
+		{domain: "пример.испытание", expected: "xn--e1afmkfd.xn--80akhbyknj4f"},
+		{domain: "例え.jp", expected: "xn--r8jz45g.jp"},
+		{domain: "Bücher.Example", expected: "xn--bcher-kva.example"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.domain, func(t *testing.T) {
+			t.Parallel()
+
+			domain, ok := normalizeDomain(tt.domain)
+			require.True(t, ok)
+			require.Equal(t, tt.expected, domain)
+		})
+	}
+}
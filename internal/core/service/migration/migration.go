@@ -0,0 +1,370 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+
+	"gses2-app/internal/core/port"
+)
+
+// _pageSize is the number of the records read at once,
+// the progress is logged after every page
+const _pageSize = 1000
+
+var (
+	ErrSourceStorage      = errors.New("cannot read source storage")
+	ErrDestinationStorage = errors.New("cannot write destination storage")
+	ErrVerification       = errors.New("destination records don't match source records")
+)
+
+// ChangeKind is what the migration does with the source record
+type ChangeKind string
+
+const (
+	// ChangeAdd adds the record missing in the destination
+	ChangeAdd ChangeKind = "add"
+
+	// ChangeUpdate overwrites the destination record with other values
+	ChangeUpdate ChangeKind = "update"
+
+	// ChangeConflict keeps the destination record with other values
+	ChangeConflict ChangeKind = "conflict"
+)
+
+// FieldChange is the field of the record with other values
+// in the source and the destination
+type FieldChange struct {
+	Name string
+	From string
+	To   string
+}
+
+// Change is the difference of the source record from the destination,
+// Fields are set for the records the destination has with other values
+type Change struct {
+	Kind   ChangeKind
+	Key    string
+	Fields []FieldChange
+}
+
+// Options are how the records are migrated. DryRun only reports the
+// changes without writing them, the destination may be nil then, as if
+// it had no records. Overwrite updates the destination records with
+// other values, which are kept as conflicts otherwise
+type Options struct {
+	DryRun    bool
+	Overwrite bool
+}
+
+// Report is the result of the migration. Read is the number of the
+// source records, Duplicates are the records with the key read before,
+// which are skipped, and Unchanged are the records the destination
+// already has. Count and the checksums are of the migrated records
+// in the source and the destination, they match after the migration
+type Report struct {
+	Read       int
+	Duplicates int
+	Unchanged  int
+	Changes    []Change
+
+	SourceCount         int
+	DestinationCount    int
+	SourceChecksum      string
+	DestinationChecksum string
+}
+
+// Conflicts returns the number of the destination records kept with other values
+func (r Report) Conflicts() int {
+	conflicts := 0
+	for _, change := range r.Changes {
+		if change.Kind == ChangeConflict {
+			conflicts++
+		}
+	}
+
+	return conflicts
+}
+
+// Service moves the records between the storages, the records are
+// identified by the value under the key and compared by the columns
+// of the schema, the columns missing in the record are empty
+type Service struct {
+	logger port.Logger
+	schema port.Schema
+	key    string
+}
+
+func NewService(logger port.Logger, schema port.Schema, key string) *Service {
+	return &Service{logger: logger, schema: schema, key: key}
+}
+
+// Migrate writes the source records missing in the destination one by
+// one, the first record with the key is kept of the duplicates. The
+// source is read page by page and only the keys of the migrated records
+// are kept, so the memory doesn't grow with the records. The migrated
+// records are then read back from the destination and verified by their
+// count and checksum, ErrVerification is returned when they don't match
+// the source records, as with the conflicts that are kept. The dry run
+// only reports the changes
+func (s *Service) Migrate(
+	ctx context.Context,
+	from, to port.Storage,
+	options Options,
+) (Report, error) {
+	if to == nil && !options.DryRun {
+		return Report{}, ErrDestinationStorage
+	}
+
+	p := progress{migrated: make(map[string]struct{})}
+
+	err := scan(ctx, from, ErrSourceStorage, func(record map[string]string) error {
+		if p.report.Read%_pageSize == 0 && p.report.Read > 0 {
+			s.logger.Infof("Migrated %d records", p.report.Read)
+		}
+
+		return s.migrateRecord(ctx, to, record, options, &p)
+	})
+	if err != nil {
+		return p.report, err
+	}
+
+	s.logger.Infof("Migrated %d records", p.report.Read)
+
+	p.report.SourceCount = p.source.count
+	p.report.SourceChecksum = p.source.String()
+
+	if options.DryRun {
+		return p.report, nil
+	}
+
+	return p.report, s.verify(ctx, to, p.migrated, &p.report)
+}
+
+// progress is the state of the migration, the keys of the
+// migrated records and the checksum of their source records
+type progress struct {
+	report   Report
+	migrated map[string]struct{}
+	source   checksum
+}
+
+// migrateRecord migrates the source record unless its key was migrated
+// before, the migrated record is added to the source checksum
+func (s *Service) migrateRecord(
+	ctx context.Context,
+	to port.Storage,
+	record map[string]string,
+	options Options,
+	p *progress,
+) error {
+	p.report.Read++
+
+	record = s.normalize(record)
+	if _, ok := p.migrated[record[s.key]]; ok {
+		p.report.Duplicates++
+		return nil
+	}
+	p.migrated[record[s.key]] = struct{}{}
+	p.source.add(s.row(record))
+
+	return s.migrate(ctx, to, record, options, &p.report)
+}
+
+// migrate compares the record with the destination one
+// and writes the change unless it's the dry run
+func (s *Service) migrate(
+	ctx context.Context,
+	to port.Storage,
+	record map[string]string,
+	options Options,
+	report *Report,
+) error {
+	existing, err := s.find(ctx, to, record[s.key])
+	if errors.Is(err, port.ErrRecordNotFound) {
+		report.Changes = append(report.Changes, Change{Kind: ChangeAdd, Key: record[s.key]})
+		return s.write(options.DryRun, func() error {
+			return to.Insert(ctx, s.key, record)
+		})
+	}
+
+	if err != nil {
+		return errors.Join(err, ErrDestinationStorage)
+	}
+
+	fields := s.diff(record, s.normalize(existing))
+	if len(fields) == 0 {
+		report.Unchanged++
+		return nil
+	}
+
+	change := Change{Kind: ChangeConflict, Key: record[s.key], Fields: fields}
+	if !options.Overwrite {
+		report.Changes = append(report.Changes, change)
+		return nil
+	}
+
+	change.Kind = ChangeUpdate
+	report.Changes = append(report.Changes, change)
+
+	return s.write(options.DryRun, func() error {
+		_, updateErr := to.Update(ctx, s.key, record[s.key], record)
+		return updateErr
+	})
+}
+
+// find returns the destination record with the value under the key,
+// the missing destination has no records
+func (s *Service) find(
+	ctx context.Context,
+	to port.Storage,
+	value string,
+) (map[string]string, error) {
+	if to == nil {
+		return nil, port.ErrRecordNotFound
+	}
+
+	return to.Find(ctx, s.key, value)
+}
+
+func (s *Service) write(dryRun bool, write func() error) error {
+	if dryRun {
+		return nil
+	}
+
+	if err := write(); err != nil {
+		return errors.Join(err, ErrDestinationStorage)
+	}
+
+	return nil
+}
+
+// verify reads the migrated records from the destination page by page
+// and compares their count and checksum with the source ones
+func (s *Service) verify(
+	ctx context.Context,
+	to port.Storage,
+	migrated map[string]struct{},
+	report *Report,
+) error {
+	var destination checksum
+	err := scan(ctx, to, ErrDestinationStorage, func(record map[string]string) error {
+		if _, ok := migrated[record[s.key]]; ok {
+			destination.add(s.row(s.normalize(record)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report.DestinationCount = destination.count
+	report.DestinationChecksum = destination.String()
+
+	if report.DestinationCount != report.SourceCount ||
+		report.DestinationChecksum != report.SourceChecksum {
+		return ErrVerification
+	}
+
+	return nil
+}
+
+// scan calls the function with the records of the storage read page
+// by page until it fails, the failure to read a page is joined with
+// the error of the storage
+func scan(
+	ctx context.Context,
+	storage port.Storage,
+	storageErr error,
+	fn func(record map[string]string) error,
+) error {
+	for offset := 0; ; offset += _pageSize {
+		records, err := storage.Page(ctx, offset, _pageSize)
+		if err != nil {
+			return errors.Join(err, storageErr)
+		}
+
+		for _, record := range records {
+			if err = fn(record); err != nil {
+				return err
+			}
+		}
+
+		if len(records) < _pageSize {
+			return nil
+		}
+	}
+}
+
+// normalize returns the record with the columns of the schema
+func (s *Service) normalize(record map[string]string) map[string]string {
+	normalized := make(map[string]string, len(s.schema))
+	for _, column := range s.schema {
+		normalized[column.Name] = record[column.Name]
+	}
+
+	return normalized
+}
+
+// diff returns the fields of the record with other values in the existing one
+func (s *Service) diff(record, existing map[string]string) []FieldChange {
+	var fields []FieldChange
+	for _, column := range s.schema {
+		if record[column.Name] != existing[column.Name] {
+			fields = append(fields, FieldChange{
+				Name: column.Name,
+				From: existing[column.Name],
+				To:   record[column.Name],
+			})
+		}
+	}
+
+	return fields
+}
+
+// row returns the values of the schema columns of the record
+func (s *Service) row(record map[string]string) []string {
+	row := make([]string, 0, len(s.schema))
+	for _, column := range s.schema {
+		row = append(row, record[column.Name])
+	}
+
+	return row
+}
+
+// checksum is the number of the records and the sum of the SHA-256 of
+// the records written as CSV rows, so it doesn't depend on their order
+// and is computed without holding the records
+type checksum struct {
+	count int
+	sum   [sha256.Size]byte
+}
+
+// add adds the SHA-256 of the row to the sum, the carry
+// out of the most significant byte is dropped
+func (c *checksum) add(row []string) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	// Writing to the buffer never fails
+	_ = w.Write(row)
+	w.Flush()
+
+	hash := sha256.Sum256(buf.Bytes())
+
+	carry := 0
+	for i := len(c.sum) - 1; i >= 0; i-- {
+		total := int(c.sum[i]) + int(hash[i]) + carry
+		c.sum[i] = byte(total)
+		carry = total >> 8
+	}
+
+	c.count++
+}
+
+func (c checksum) String() string {
+	return hex.EncodeToString(c.sum[:])
+}
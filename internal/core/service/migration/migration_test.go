@@ -0,0 +1,332 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+var errStorage = errors.New("storage error")
+
+type StubLogger struct{}
+
+func (s *StubLogger) Info(...interface{})           {}
+func (s *StubLogger) Infof(string, ...interface{})  {}
+func (s *StubLogger) Debug(...interface{})          {}
+func (s *StubLogger) Debugf(string, ...interface{}) {}
+func (s *StubLogger) Error(...interface{})          {}
+func (s *StubLogger) Errorf(string, ...interface{}) {}
+
+type StubStorage struct {
+	records []map[string]string
+	err     error
+}
+
+func (s *StubStorage) Append(ctx context.Context, record map[string]string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.records = append(s.records, record)
+
+	return nil
+}
+
+func (s *StubStorage) AllRecords(ctx context.Context) ([]map[string]string, error) {
+	return s.Select(ctx, func(map[string]string) bool { return true })
+}
+
+func (s *StubStorage) Insert(ctx context.Context, key string, record map[string]string) error {
+	if _, err := s.Find(ctx, key, record[key]); err == nil {
+		return port.ErrRecordExists
+	}
+
+	return s.Append(ctx, record)
+}
+
+func (s *StubStorage) Find(ctx context.Context, key, value string) (map[string]string, error) {
+	records, err := s.Select(ctx, port.Match(key, value))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, port.ErrRecordNotFound
+	}
+
+	return records[0], nil
+}
+
+func (s *StubStorage) Select(
+	ctx context.Context,
+	filter port.RecordFilter,
+) ([]map[string]string, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var records []map[string]string
+	for _, record := range s.records {
+		if filter(record) {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+func (s *StubStorage) Page(ctx context.Context, offset, limit int) ([]map[string]string, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if offset >= len(s.records) {
+		return nil, nil
+	}
+
+	end := offset + limit
+	if end > len(s.records) {
+		end = len(s.records)
+	}
+
+	return s.records[offset:end], nil
+}
+
+func (s *StubStorage) Update(
+	ctx context.Context,
+	key, value string,
+	record map[string]string,
+) (int, error) {
+	updated := 0
+	for _, stored := range s.records {
+		if stored[key] == value {
+			for field, fieldValue := range record {
+				stored[field] = fieldValue
+			}
+			updated++
+		}
+	}
+
+	return updated, nil
+}
+
+func (s *StubStorage) UpdateAll(
+	ctx context.Context,
+	key string,
+	values []string,
+	record map[string]string,
+) (int, error) {
+	return 0, nil
+}
+
+func (s *StubStorage) Remove(ctx context.Context, key, value string) (int, error) {
+	return 0, nil
+}
+
+func (s *StubStorage) RemoveMatching(
+	ctx context.Context,
+	key, value string,
+	filter port.RecordFilter,
+) (int, error) {
+	return 0, nil
+}
+
+var _schema = port.Schema{{Name: "email"}, {Name: "status"}}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		source          []map[string]string
+		destination     []map[string]string
+		options         Options
+		expectedChanges []Change
+		expectedRecords []map[string]string
+		expectedReport  Report
+		expectedErr     error
+	}{
+		{
+			name: "Migrate to empty storage",
+			source: []map[string]string{
+				{"email": "first@test.com", "status": "confirmed"},
+				{"email": "second@test.com"},
+				{"email": "first@test.com", "status": "pending"},
+			},
+			expectedChanges: []Change{
+				{Kind: ChangeAdd, Key: "first@test.com"},
+				{Kind: ChangeAdd, Key: "second@test.com"},
+			},
+			expectedRecords: []map[string]string{
+				{"email": "first@test.com", "status": "confirmed"},
+				{"email": "second@test.com", "status": ""},
+			},
+			expectedReport: Report{Read: 3, Duplicates: 1, SourceCount: 2, DestinationCount: 2},
+		},
+		{
+			name:   "Skip unchanged records",
+			source: []map[string]string{{"email": "first@test.com"}},
+			destination: []map[string]string{
+				{"email": "first@test.com", "status": ""},
+				{"email": "other@test.com", "status": ""},
+			},
+			expectedRecords: []map[string]string{
+				{"email": "first@test.com", "status": ""},
+				{"email": "other@test.com", "status": ""},
+			},
+			expectedReport: Report{Read: 1, Unchanged: 1, SourceCount: 1, DestinationCount: 1},
+		},
+		{
+			name:        "Keep conflicting records",
+			source:      []map[string]string{{"email": "first@test.com", "status": "confirmed"}},
+			destination: []map[string]string{{"email": "first@test.com", "status": "pending"}},
+			expectedChanges: []Change{{
+				Kind:   ChangeConflict,
+				Key:    "first@test.com",
+				Fields: []FieldChange{{Name: "status", From: "pending", To: "confirmed"}},
+			}},
+			expectedRecords: []map[string]string{{"email": "first@test.com", "status": "pending"}},
+			expectedReport:  Report{Read: 1, SourceCount: 1, DestinationCount: 1},
+			expectedErr:     ErrVerification,
+		},
+		{
+			name:        "Overwrite conflicting records",
+			source:      []map[string]string{{"email": "first@test.com", "status": "confirmed"}},
+			destination: []map[string]string{{"email": "first@test.com", "status": "pending"}},
+			options:     Options{Overwrite: true},
+			expectedChanges: []Change{{
+				Kind:   ChangeUpdate,
+				Key:    "first@test.com",
+				Fields: []FieldChange{{Name: "status", From: "pending", To: "confirmed"}},
+			}},
+			expectedRecords: []map[string]string{{"email": "first@test.com", "status": "confirmed"}},
+			expectedReport:  Report{Read: 1, SourceCount: 1, DestinationCount: 1},
+		},
+		{
+			name:   "Dry run",
+			source: []map[string]string{{"email": "first@test.com", "status": "confirmed"}},
+			destination: []map[string]string{
+				{"email": "other@test.com", "status": ""},
+			},
+			options:         Options{DryRun: true, Overwrite: true},
+			expectedChanges: []Change{{Kind: ChangeAdd, Key: "first@test.com"}},
+			expectedRecords: []map[string]string{{"email": "other@test.com", "status": ""}},
+			expectedReport:  Report{Read: 1, SourceCount: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			source := &StubStorage{records: tt.source}
+			destination := &StubStorage{records: tt.destination}
+			service := NewService(&StubLogger{}, _schema, "email")
+
+			report, err := service.Migrate(context.Background(), source, destination, tt.options)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if tt.expectedErr == nil && !tt.options.DryRun {
+				require.Equal(t, report.SourceChecksum, report.DestinationChecksum)
+			}
+
+			require.Equal(t, tt.expectedChanges, report.Changes)
+			require.Equal(t, tt.expectedRecords, destination.records)
+			require.Equal(t, tt.expectedReport.Read, report.Read)
+			require.Equal(t, tt.expectedReport.Duplicates, report.Duplicates)
+			require.Equal(t, tt.expectedReport.Unchanged, report.Unchanged)
+			require.Equal(t, tt.expectedReport.SourceCount, report.SourceCount)
+			require.Equal(t, tt.expectedReport.DestinationCount, report.DestinationCount)
+		})
+	}
+}
+
+func TestMigratePages(t *testing.T) {
+	t.Parallel()
+
+	source := &StubStorage{}
+	for i := 0; i < 2*_pageSize+1; i++ {
+		source.records = append(source.records, map[string]string{
+			"email":  fmt.Sprintf("user%d@test.com", i%(2*_pageSize)),
+			"status": "confirmed",
+		})
+	}
+	destination := &StubStorage{}
+	service := NewService(&StubLogger{}, _schema, "email")
+
+	report, err := service.Migrate(context.Background(), source, destination, Options{})
+	require.NoError(t, err)
+	require.Equal(t, 2*_pageSize+1, report.Read)
+	require.Equal(t, 1, report.Duplicates)
+	require.Equal(t, 2*_pageSize, report.SourceCount)
+	require.Equal(t, 2*_pageSize, report.DestinationCount)
+	require.Equal(t, report.SourceChecksum, report.DestinationChecksum)
+	require.Len(t, destination.records, 2*_pageSize)
+}
+
+func TestMigrateWithoutDestination(t *testing.T) {
+	t.Parallel()
+
+	service := NewService(&StubLogger{}, _schema, "email")
+	source := &StubStorage{records: []map[string]string{{"email": "first@test.com"}}}
+
+	report, err := service.Migrate(context.Background(), source, nil, Options{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Kind: ChangeAdd, Key: "first@test.com"}}, report.Changes)
+
+	_, err = service.Migrate(context.Background(), source, nil, Options{})
+	require.ErrorIs(t, err, ErrDestinationStorage)
+}
+
+func TestMigrateStorageErrors(t *testing.T) {
+	t.Parallel()
+
+	service := NewService(&StubLogger{}, _schema, "email")
+	records := []map[string]string{{"email": "first@test.com"}}
+
+	_, err := service.Migrate(
+		context.Background(),
+		&StubStorage{err: errStorage},
+		&StubStorage{},
+		Options{},
+	)
+	require.ErrorIs(t, err, ErrSourceStorage)
+
+	_, err = service.Migrate(
+		context.Background(),
+		&StubStorage{records: records},
+		&StubStorage{err: errStorage},
+		Options{},
+	)
+	require.ErrorIs(t, err, ErrDestinationStorage)
+}
+
+func TestChecksumIgnoresOrder(t *testing.T) {
+	t.Parallel()
+
+	service := NewService(&StubLogger{}, _schema, "email")
+	first := service.row(map[string]string{"email": "first@test.com", "status": "confirmed"})
+	second := service.row(map[string]string{"email": "second@test.com"})
+
+	checksumOf := func(rows ...[]string) checksum {
+		var c checksum
+		for _, row := range rows {
+			c.add(row)
+		}
+		return c
+	}
+
+	require.Equal(t, checksumOf(first, second), checksumOf(second, first))
+	require.NotEqual(t, checksumOf(first).String(), checksumOf(second).String())
+	require.Equal(t, 2, checksumOf(first, second).count)
+}
@@ -0,0 +1,302 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+const (
+	_batchIDSize     = 16
+	_keySeparator    = "/"
+	_backoffExponent = 2
+)
+
+var ErrOutboxRepository = errors.New("outbox repository error")
+
+type OutboxConfig struct {
+	// Workers is the number of the jobs delivered at once
+	Workers int `default:"1"`
+
+	// PollInterval is the interval between the checks for the due jobs
+	PollInterval time.Duration `default:"1s"`
+
+	// MaxAttempts is the number of the attempts after which
+	// the deferred job is dead
+	MaxAttempts int `default:"5"`
+
+	// InitialBackoff is the delay after the first deferred attempt,
+	// it doubles after every next one up to MaxBackoff
+	InitialBackoff time.Duration `default:"30s"`
+	MaxBackoff     time.Duration `default:"1h"`
+
+	// Retention is the time the finished batches are kept for polling
+	Retention time.Duration `default:"168h"`
+}
+
+type JobRepository interface {
+	// AddBatch stores the batch unless the batch with its ID is already
+	// stored, the stored batch is returned with whether it was added
+	AddBatch(ctx context.Context, batch port.Batch) (stored port.Batch, added bool, err error)
+	Batch(ctx context.Context, id string) (port.Batch, error)
+	Due(ctx context.Context, now time.Time) ([]port.Job, error)
+	UpdateJob(ctx context.Context, job port.Job) error
+}
+
+type Sender interface {
+	SendExchangeRate(
+		ctx context.Context,
+		rate port.Rate,
+		subscribers ...port.User,
+	) (*port.DeliveryReport, error)
+}
+
+// Service delivers the emails in the background, every email is a job
+// attempted until it's accepted, rejected or runs out of attempts
+type Service struct {
+	logger     port.Logger
+	config     OutboxConfig
+	repository JobRepository
+	sender     Sender
+	wake       chan struct{}
+	now        func() time.Time
+}
+
+func NewService(
+	logger port.Logger,
+	config OutboxConfig,
+	repository JobRepository,
+	sender Sender,
+) *Service {
+	return &Service{
+		logger:     logger,
+		config:     config,
+		repository: repository,
+		sender:     sender,
+		wake:       make(chan struct{}, 1),
+		now:        time.Now,
+	}
+}
+
+// Enqueue adds a job for every recipient and returns the ID of their
+// batch and whether it was added. Enqueueing again with the same
+// idempotency key returns the same batch and adds no jobs, the empty
+// key always adds a new batch
+func (s *Service) Enqueue(
+	ctx context.Context,
+	idempotencyKey string,
+	recipients ...port.Recipient,
+) (string, bool, error) {
+	id, err := batchID(idempotencyKey)
+	if err != nil {
+		return "", false, err
+	}
+
+	now := s.now().UTC()
+	batch := port.Batch{
+		ID:        id,
+		CreatedAt: now,
+		Jobs:      make([]port.Job, 0, len(recipients)),
+	}
+
+	enqueued := make(map[string]bool, len(recipients))
+	for _, recipient := range recipients {
+		key := jobKey(id, recipient)
+		if enqueued[key] {
+			continue
+		}
+		enqueued[key] = true
+
+		batch.Jobs = append(batch.Jobs, port.Job{
+			Key:           key,
+			BatchID:       id,
+			Email:         recipient.User.Email,
+			Locale:        recipient.User.Locale,
+			Rate:          recipient.Rate,
+			Status:        port.JobQueued,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	}
+
+	batch, added, err := s.repository.AddBatch(ctx, batch)
+	if err != nil {
+		return "", false, errors.Join(err, ErrOutboxRepository)
+	}
+
+	s.notify()
+
+	return batch.ID, added, nil
+}
+
+// Batch returns the batch with the summary of its jobs
+func (s *Service) Batch(ctx context.Context, id string) (*port.Batch, error) {
+	batch, err := s.repository.Batch(ctx, id)
+	if errors.Is(err, port.ErrBatchNotFound) {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, errors.Join(err, ErrOutboxRepository)
+	}
+
+	batch.Summarize()
+
+	return &batch, nil
+}
+
+// Run delivers the due jobs every poll interval and right after
+// the jobs are enqueued until the context is done
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.DeliverDue(ctx); err != nil {
+			s.logger.Errorf("Error, cannot deliver outbox jobs: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// DeliverDue attempts the jobs due by now with up to the configured
+// number of workers at once and returns the number of the attempted jobs
+func (s *Service) DeliverDue(ctx context.Context) (int, error) {
+	due, err := s.repository.Due(ctx, s.now())
+	if err != nil {
+		return 0, errors.Join(err, ErrOutboxRepository)
+	}
+
+	workers := make(chan struct{}, s.workers())
+
+	var wg sync.WaitGroup
+	for _, job := range due {
+		workers <- struct{}{}
+		wg.Add(1)
+
+		go func(job port.Job) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			s.deliver(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+
+	return len(due), nil
+}
+
+// deliver attempts the job, the deferred job is scheduled for the next
+// attempt with the exponential backoff until it runs out of attempts
+func (s *Service) deliver(ctx context.Context, job port.Job) {
+	report, err := s.sender.SendExchangeRate(
+		ctx,
+		job.Rate,
+		port.User{Email: job.Email, Locale: job.Locale},
+	)
+	delivery := deliveryOf(job.Email, report, err)
+
+	now := s.now().UTC()
+	job.Attempts++
+	job.LastDelivery = &delivery
+	job.UpdatedAt = now
+
+	switch {
+	case delivery.Status == port.DeliveryAccepted:
+		job.Status = port.JobDelivered
+	case delivery.Status == port.DeliveryRejected, job.Attempts >= s.config.MaxAttempts:
+		job.Status = port.JobDead
+	default:
+		job.NextAttemptAt = now.Add(s.backoff(job.Attempts))
+	}
+
+	if err = s.repository.UpdateJob(ctx, job); err != nil {
+		s.logger.Errorf("Error, cannot update outbox job %v: %v", job.Key, err)
+	}
+}
+
+// backoff returns the delay after the attempt, the delay
+// doubles after every attempt up to the maximum backoff
+func (s *Service) backoff(attempts int) time.Duration {
+	delay := s.config.InitialBackoff
+	for i := 1; i < attempts && delay < s.config.MaxBackoff; i++ {
+		delay *= _backoffExponent
+	}
+
+	if delay > s.config.MaxBackoff {
+		return s.config.MaxBackoff
+	}
+
+	return delay
+}
+
+func (s *Service) workers() int {
+	if s.config.Workers < 1 {
+		return 1
+	}
+
+	return s.config.Workers
+}
+
+func (s *Service) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliveryOf returns the delivery of the email from the report,
+// the email is deferred when it wasn't reported
+func deliveryOf(email string, report *port.DeliveryReport, err error) port.Delivery {
+	if err != nil {
+		return port.Delivery{Email: email, Status: port.DeliveryDeferred, Message: err.Error()}
+	}
+
+	for _, delivery := range report.Deliveries {
+		if delivery.Email == email {
+			return delivery
+		}
+	}
+
+	return port.Delivery{Email: email, Status: port.DeliveryDeferred}
+}
+
+// batchID derives the ID from the idempotency key,
+// the ID is random when there's no key
+func batchID(idempotencyKey string) (string, error) {
+	if idempotencyKey != "" {
+		sum := sha256.Sum256([]byte(idempotencyKey))
+		return hex.EncodeToString(sum[:_batchIDSize]), nil
+	}
+
+	id := make([]byte, _batchIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// jobKey returns the key of the recipient's job in the batch,
+// the recipient gets a job for every followed pair
+func jobKey(batchID string, recipient port.Recipient) string {
+	return strings.Join([]string{
+		batchID,
+		recipient.Rate.Pair.String(),
+		recipient.User.Email,
+	}, _keySeparator)
+}
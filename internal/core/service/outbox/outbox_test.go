@@ -0,0 +1,346 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+type StubLogger struct{}
+
+func (s *StubLogger) Info(...interface{})           {}
+func (s *StubLogger) Infof(string, ...interface{})  {}
+func (s *StubLogger) Debug(...interface{})          {}
+func (s *StubLogger) Debugf(string, ...interface{}) {}
+func (s *StubLogger) Error(...interface{})          {}
+func (s *StubLogger) Errorf(string, ...interface{}) {}
+
+type StubJobRepository struct {
+	mu      sync.Mutex
+	batches map[string]port.Batch
+	err     error
+}
+
+func (r *StubJobRepository) AddBatch(
+	ctx context.Context,
+	batch port.Batch,
+) (port.Batch, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return port.Batch{}, false, r.err
+	}
+
+	if r.batches == nil {
+		r.batches = make(map[string]port.Batch)
+	}
+
+	if stored, ok := r.batches[batch.ID]; ok {
+		return stored, false, nil
+	}
+	r.batches[batch.ID] = batch
+
+	return batch, true, nil
+}
+
+func (r *StubJobRepository) Batch(ctx context.Context, id string) (port.Batch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return port.Batch{}, r.err
+	}
+
+	batch, ok := r.batches[id]
+	if !ok {
+		return port.Batch{}, port.ErrBatchNotFound
+	}
+
+	return batch, nil
+}
+
+func (r *StubJobRepository) Due(ctx context.Context, now time.Time) ([]port.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []port.Job
+	for _, batch := range r.batches {
+		for _, job := range batch.Jobs {
+			if job.Status == port.JobQueued && !job.NextAttemptAt.After(now) {
+				due = append(due, job)
+			}
+		}
+	}
+
+	return due, nil
+}
+
+func (r *StubJobRepository) UpdateJob(ctx context.Context, job port.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	batch := r.batches[job.BatchID]
+	for i := range batch.Jobs {
+		if batch.Jobs[i].Key == job.Key {
+			batch.Jobs[i] = job
+		}
+	}
+
+	return nil
+}
+
+type StubSender struct {
+	statuses map[string]port.DeliveryStatus
+	err      error
+
+	mu      sync.Mutex
+	locales []string
+}
+
+func (s *StubSender) SendExchangeRate(
+	ctx context.Context,
+	rate port.Rate,
+	subscribers ...port.User,
+) (*port.DeliveryReport, error) {
+	s.mu.Lock()
+	for _, subscriber := range subscribers {
+		s.locales = append(s.locales, subscriber.Locale)
+	}
+	s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	report := port.NewDeliveryReport()
+	for _, subscriber := range subscribers {
+		status, ok := s.statuses[subscriber.Email]
+		if !ok {
+			status = port.DeliveryAccepted
+		}
+		report.Add(port.Delivery{Email: subscriber.Email, Status: status})
+	}
+
+	return report, nil
+}
+
+var (
+	errRepository = errors.New("repository error")
+	errSender     = errors.New("sender error")
+)
+
+var _testConfig = OutboxConfig{
+	Workers:        2,
+	PollInterval:   time.Second,
+	MaxAttempts:    3,
+	InitialBackoff: time.Minute,
+	MaxBackoff:     3 * time.Minute,
+}
+
+var _testRate = port.Rate{Pair: port.DefaultCurrencyPair, Amount: port.NewDecimalFromFloat(1000)}
+
+func recipients(rate port.Rate, emails ...string) []port.Recipient {
+	recipients := make([]port.Recipient, len(emails))
+	for i, email := range emails {
+		recipients[i] = port.Recipient{User: port.User{Email: email}, Rate: rate}
+	}
+
+	return recipients
+}
+
+func TestEnqueue(t *testing.T) {
+	ctx := context.Background()
+	repository := &StubJobRepository{}
+	service := NewService(&StubLogger{}, _testConfig, repository, &StubSender{})
+
+	id, added, err := service.Enqueue(ctx, "key", recipients(_testRate, "a@example.com", "a@example.com")...)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	batch, err := service.Batch(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, batch.Jobs, 1)
+	require.Equal(t, id+"/BTC/UAH/a@example.com", batch.Jobs[0].Key)
+	require.Equal(t, port.BatchSummary{Total: 1, Queued: 1}, batch.Summary)
+
+	t.Run("Same idempotency key", func(t *testing.T) {
+		sameID, sameAdded, enqueueErr := service.Enqueue(ctx, "key", recipients(_testRate, "b@example.com")...)
+		require.NoError(t, enqueueErr)
+		require.Equal(t, id, sameID)
+		require.False(t, sameAdded)
+
+		sameBatch, batchErr := service.Batch(ctx, id)
+		require.NoError(t, batchErr)
+		require.Len(t, sameBatch.Jobs, 1)
+	})
+
+	t.Run("Several pairs", func(t *testing.T) {
+		ethRate := port.Rate{Pair: port.CurrencyPair{Base: "ETH", Quote: "UAH"}}
+		pairs := append(
+			recipients(_testRate, "a@example.com"),
+			recipients(ethRate, "a@example.com")...,
+		)
+
+		pairsID, _, enqueueErr := service.Enqueue(ctx, "pairs", pairs...)
+		require.NoError(t, enqueueErr)
+
+		pairsBatch, batchErr := service.Batch(ctx, pairsID)
+		require.NoError(t, batchErr)
+		require.Len(t, pairsBatch.Jobs, 2)
+		require.Equal(t, ethRate, pairsBatch.Jobs[1].Rate)
+	})
+
+	t.Run("No idempotency key", func(t *testing.T) {
+		first, _, firstErr := service.Enqueue(ctx, "", recipients(_testRate, "a@example.com")...)
+		require.NoError(t, firstErr)
+
+		second, _, secondErr := service.Enqueue(ctx, "", recipients(_testRate, "a@example.com")...)
+		require.NoError(t, secondErr)
+		require.NotEqual(t, first, second)
+	})
+
+	t.Run("Missing batch", func(t *testing.T) {
+		_, missingErr := service.Batch(ctx, "missing")
+		require.ErrorIs(t, missingErr, port.ErrBatchNotFound)
+	})
+
+	t.Run("Repository error", func(t *testing.T) {
+		failing := NewService(
+			&StubLogger{},
+			_testConfig,
+			&StubJobRepository{err: errRepository},
+			&StubSender{},
+		)
+
+		_, _, enqueueErr := failing.Enqueue(ctx, "key", recipients(_testRate, "a@example.com")...)
+		require.ErrorIs(t, enqueueErr, ErrOutboxRepository)
+
+		_, batchErr := failing.Batch(ctx, "key")
+		require.ErrorIs(t, batchErr, ErrOutboxRepository)
+	})
+}
+
+func TestDeliverDue(t *testing.T) {
+	tests := []struct {
+		name             string
+		sender           *StubSender
+		attempts         int
+		expectedStatus   port.JobStatus
+		expectedDelivery port.DeliveryStatus
+		expectedBackoff  time.Duration
+	}{
+		{
+			name:             "Accepted",
+			sender:           &StubSender{},
+			expectedStatus:   port.JobDelivered,
+			expectedDelivery: port.DeliveryAccepted,
+		},
+		{
+			name: "Rejected",
+			sender: &StubSender{
+				statuses: map[string]port.DeliveryStatus{"a@example.com": port.DeliveryRejected},
+			},
+			expectedStatus:   port.JobDead,
+			expectedDelivery: port.DeliveryRejected,
+		},
+		{
+			name: "Deferred",
+			sender: &StubSender{
+				statuses: map[string]port.DeliveryStatus{"a@example.com": port.DeliveryDeferred},
+			},
+			expectedStatus:   port.JobQueued,
+			expectedDelivery: port.DeliveryDeferred,
+			expectedBackoff:  time.Minute,
+		},
+		{
+			name:             "Sender error",
+			sender:           &StubSender{err: errSender},
+			attempts:         1,
+			expectedStatus:   port.JobQueued,
+			expectedDelivery: port.DeliveryDeferred,
+			expectedBackoff:  2 * time.Minute,
+		},
+		{
+			name:             "Out of attempts",
+			sender:           &StubSender{err: errSender},
+			attempts:         2,
+			expectedStatus:   port.JobDead,
+			expectedDelivery: port.DeliveryDeferred,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			now := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+
+			repository := &StubJobRepository{}
+			service := NewService(&StubLogger{}, _testConfig, repository, tt.sender)
+			service.now = func() time.Time { return now }
+
+			id, _, err := service.Enqueue(ctx, "key", recipients(_testRate, "a@example.com")...)
+			require.NoError(t, err)
+			repository.batches[id].Jobs[0].Attempts = tt.attempts
+
+			delivered, err := service.DeliverDue(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, delivered)
+
+			batch, err := service.Batch(ctx, id)
+			require.NoError(t, err)
+
+			job := batch.Jobs[0]
+			require.Equal(t, tt.expectedStatus, job.Status)
+			require.Equal(t, tt.attempts+1, job.Attempts)
+			require.Equal(t, tt.expectedDelivery, job.LastDelivery.Status)
+
+			if tt.expectedBackoff > 0 {
+				require.Equal(t, now.Add(tt.expectedBackoff), job.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestDeliverInLocale(t *testing.T) {
+	ctx := context.Background()
+	sender := &StubSender{}
+	service := NewService(&StubLogger{}, _testConfig, &StubJobRepository{}, sender)
+
+	_, _, err := service.Enqueue(ctx, "key", port.Recipient{
+		User: port.User{Email: "a@example.com", Locale: "uk-UA"},
+		Rate: _testRate,
+	})
+	require.NoError(t, err)
+
+	_, err = service.DeliverDue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"uk-UA"}, sender.locales)
+}
+
+func TestBackoff(t *testing.T) {
+	service := NewService(&StubLogger{}, _testConfig, &StubJobRepository{}, &StubSender{})
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: time.Minute},
+		{attempts: 2, expected: 2 * time.Minute},
+		{attempts: 3, expected: 3 * time.Minute},
+		{attempts: 10, expected: 3 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, service.backoff(tt.attempts))
+	}
+}
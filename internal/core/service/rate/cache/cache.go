@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+type RateService interface {
+	ExchangeRate(ctx context.Context, pair port.CurrencyPair) (port.Rate, error)
+}
+
+type CacheConfig struct {
+	// TTL is how long a fetched rate is served without asking the providers
+	TTL time.Duration `default:"30s"`
+
+	// MaxStale is how long an expired rate can still be served,
+	// flagged as stale, when all the providers fail
+	MaxStale time.Duration `default:"1h"`
+
+	// RefreshInterval is how often the rates requested since their last
+	// fetch are refreshed in the background, zero disables the refresh
+	RefreshInterval time.Duration `default:"20s"`
+
+	// Coalesce makes concurrent requests for the same currency
+	// pair share a single call to the providers
+	Coalesce bool `default:"true"`
+
+	// FetchTimeout limits the shared call to the providers, which
+	// doesn't depend on the context of any of the waiting requests
+	FetchTimeout time.Duration `default:"10s"`
+}
+
+type entry struct {
+	rate       port.Rate
+	storedAt   time.Time
+	accessedAt time.Time
+}
+
+// call is a request to the providers shared by the coalesced requests
+type call struct {
+	done chan struct{}
+	rate port.Rate
+	err  error
+}
+
+// Service is a caching decorator around the rate service
+type Service struct {
+	rateService RateService
+	logger      port.Logger
+	config      CacheConfig
+	now         func() time.Time
+
+	mu       sync.Mutex
+	entries  map[port.CurrencyPair]*entry
+	inflight map[port.CurrencyPair]*call
+}
+
+func NewService(
+	logger port.Logger,
+	config CacheConfig,
+	rateService RateService,
+) *Service {
+	return &Service{
+		rateService: rateService,
+		logger:      logger,
+		config:      config,
+		now:         time.Now,
+		entries:     make(map[port.CurrencyPair]*entry),
+		inflight:    make(map[port.CurrencyPair]*call),
+	}
+}
+
+// ExchangeRate returns the cached rate while it's fresh. An expired rate is
+// fetched again, and if it cannot be fetched the expired rate is returned
+// flagged as stale until it gets older than MaxStale
+func (s *Service) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	cached, found := s.lookup(pair)
+	if found && s.age(cached) < s.config.TTL {
+		return cached.rate, nil
+	}
+
+	rate, err := s.fetch(ctx, pair)
+	if err == nil {
+		return rate, nil
+	}
+
+	if found && s.age(cached) < s.config.TTL+s.config.MaxStale {
+		s.logger.Errorf("Error, serving stale rate %v: %v", pair, err)
+
+		stale := cached.rate
+		stale.Stale = true
+		return stale, nil
+	}
+
+	return port.Rate{}, err
+}
+
+// Refresh fetches again the rates requested since their last fetch
+// every RefreshInterval until the context is cancelled
+func (s *Service) Refresh(ctx context.Context) {
+	if s.config.RefreshInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshRequested(ctx)
+		}
+	}
+}
+
+func (s *Service) refreshRequested(ctx context.Context) {
+	for _, pair := range s.requestedPairs() {
+		if _, err := s.fetch(ctx, pair); err != nil {
+			s.logger.Errorf("Error, cannot refresh rate %v: %v", pair, err)
+		}
+	}
+}
+
+func (s *Service) requestedPairs() []port.CurrencyPair {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pairs []port.CurrencyPair
+	for pair, e := range s.entries {
+		if e.accessedAt.After(e.storedAt) {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return pairs
+}
+
+func (s *Service) lookup(pair port.CurrencyPair) (entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[pair]
+	if !ok {
+		return entry{}, false
+	}
+
+	e.accessedAt = s.now()
+	return *e, true
+}
+
+func (s *Service) age(e entry) time.Duration {
+	return s.now().Sub(e.storedAt)
+}
+
+func (s *Service) fetch(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	if !s.config.Coalesce {
+		return s.fetchAndStore(ctx, pair)
+	}
+
+	s.mu.Lock()
+	c, ok := s.inflight[pair]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		s.inflight[pair] = c
+		go s.fetchShared(detach(ctx), pair, c)
+	}
+	s.mu.Unlock()
+
+	return c.wait(ctx)
+}
+
+// fetchShared runs the call shared by the coalesced requests, so it's
+// limited by FetchTimeout instead of the context of the request started it
+func (s *Service) fetchShared(
+	ctx context.Context,
+	pair port.CurrencyPair,
+	c *call,
+) {
+	if s.config.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.FetchTimeout)
+		defer cancel()
+	}
+
+	c.rate, c.err = s.fetchAndStore(ctx, pair)
+	close(c.done)
+
+	s.mu.Lock()
+	delete(s.inflight, pair)
+	s.mu.Unlock()
+}
+
+func (s *Service) fetchAndStore(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	rate, err := s.rateService.ExchangeRate(ctx, pair)
+	if err != nil {
+		return port.Rate{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	storedAt := s.now()
+	s.entries[pair] = &entry{
+		rate:       rate,
+		storedAt:   storedAt,
+		accessedAt: storedAt,
+	}
+
+	return rate, nil
+}
+
+// wait returns the result of the shared call, the waiting is abandoned
+// when the context is done while the call itself goes on for the others
+func (c *call) wait(ctx context.Context) (port.Rate, error) {
+	select {
+	case <-ctx.Done():
+		return port.Rate{}, ctx.Err()
+	case <-c.done:
+		return c.rate, c.err
+	}
+}
+
+// detachedContext keeps the values of its parent
+// but is never cancelled together with it
+type detachedContext struct {
+	parent context.Context
+}
+
+func detach(parent context.Context) context.Context {
+	return detachedContext{parent: parent}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,301 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+var errProviders = errors.New("all providers failed")
+
+type StubLogger struct{}
+
+func (s *StubLogger) Info(...interface{})           {}
+func (s *StubLogger) Infof(string, ...interface{})  {}
+func (s *StubLogger) Debug(...interface{})          {}
+func (s *StubLogger) Debugf(string, ...interface{}) {}
+func (s *StubLogger) Error(...interface{})          {}
+func (s *StubLogger) Errorf(string, ...interface{}) {}
+
+type StubRateService struct {
+	mu     sync.Mutex
+	amount string
+	err    error
+	delay  time.Duration
+	calls  atomic.Int32
+}
+
+func (s *StubRateService) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	s.calls.Add(1)
+
+	select {
+	case <-ctx.Done():
+		return port.Rate{}, ctx.Err()
+	case <-time.After(s.delay):
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return port.Rate{}, s.err
+	}
+
+	return port.Rate{Amount: port.MustParseDecimal(s.amount), Pair: pair}, nil
+}
+
+func (s *StubRateService) set(amount string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.amount = amount
+	s.err = err
+}
+
+type StubClock struct {
+	current time.Time
+}
+
+func (c *StubClock) now() time.Time {
+	return c.current
+}
+
+func (c *StubClock) advance(d time.Duration) {
+	c.current = c.current.Add(d)
+}
+
+func newTestService(
+	config CacheConfig,
+	rateService RateService,
+) (*Service, *StubClock) {
+	clock := &StubClock{current: time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)}
+
+	service := NewService(&StubLogger{}, config, rateService)
+	service.now = clock.now
+
+	return service, clock
+}
+
+func TestExchangeRate(t *testing.T) {
+	t.Parallel()
+
+	config := CacheConfig{TTL: time.Minute, MaxStale: time.Hour}
+	ctx := context.Background()
+
+	t.Run("Fresh rate is served from cache", func(t *testing.T) {
+		t.Parallel()
+
+		rateService := &StubRateService{amount: "100"}
+		service, clock := newTestService(config, rateService)
+
+		_, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.NoError(t, err)
+
+		clock.advance(30 * time.Second)
+		rateService.set("200", nil)
+
+		rate, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.NoError(t, err)
+		require.Equal(t, "100", rate.Amount.String())
+		require.EqualValues(t, 1, rateService.calls.Load())
+	})
+
+	t.Run("Expired rate is fetched again", func(t *testing.T) {
+		t.Parallel()
+
+		rateService := &StubRateService{amount: "100"}
+		service, clock := newTestService(config, rateService)
+
+		_, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.NoError(t, err)
+
+		clock.advance(2 * time.Minute)
+		rateService.set("200", nil)
+
+		rate, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.NoError(t, err)
+		require.Equal(t, "200", rate.Amount.String())
+		require.False(t, rate.Stale)
+	})
+
+	t.Run("Stale rate is served when providers fail", func(t *testing.T) {
+		t.Parallel()
+
+		rateService := &StubRateService{amount: "100"}
+		service, clock := newTestService(config, rateService)
+
+		_, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.NoError(t, err)
+
+		clock.advance(2 * time.Minute)
+		rateService.set("", errProviders)
+
+		rate, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.NoError(t, err)
+		require.Equal(t, "100", rate.Amount.String())
+		require.True(t, rate.Stale)
+	})
+
+	t.Run("Too old rate isn't served", func(t *testing.T) {
+		t.Parallel()
+
+		rateService := &StubRateService{amount: "100"}
+		service, clock := newTestService(config, rateService)
+
+		_, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.NoError(t, err)
+
+		clock.advance(2 * time.Hour)
+		rateService.set("", errProviders)
+
+		_, err = service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.ErrorIs(t, err, errProviders)
+	})
+
+	t.Run("Currency pairs are cached separately", func(t *testing.T) {
+		t.Parallel()
+
+		rateService := &StubRateService{amount: "100"}
+		service, _ := newTestService(config, rateService)
+
+		_, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		require.NoError(t, err)
+
+		_, err = service.ExchangeRate(ctx, port.CurrencyPair{Base: "ETH", Quote: "USD"})
+		require.NoError(t, err)
+		require.EqualValues(t, 2, rateService.calls.Load())
+	})
+}
+
+func TestCoalesce(t *testing.T) {
+	t.Parallel()
+
+	const concurrentRequests = 5
+
+	tests := []struct {
+		name          string
+		coalesce      bool
+		expectedCalls int32
+	}{
+		{
+			name:          "Concurrent requests are coalesced",
+			coalesce:      true,
+			expectedCalls: 1,
+		},
+		{
+			name:          "Concurrent requests aren't coalesced",
+			coalesce:      false,
+			expectedCalls: concurrentRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rateService := &StubRateService{amount: "100", delay: 100 * time.Millisecond}
+			service, _ := newTestService(
+				CacheConfig{TTL: time.Minute, Coalesce: tt.coalesce},
+				rateService,
+			)
+
+			var wg sync.WaitGroup
+			for i := 0; i < concurrentRequests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := service.ExchangeRate(
+						context.Background(),
+						port.DefaultCurrencyPair,
+					)
+					require.NoError(t, err)
+				}()
+			}
+			wg.Wait()
+
+			require.Equal(t, tt.expectedCalls, rateService.calls.Load())
+		})
+	}
+}
+
+func TestSharedFetchOutlivesCancelledRequest(t *testing.T) {
+	t.Parallel()
+
+	rateService := &StubRateService{amount: "100", delay: 100 * time.Millisecond}
+	service, _ := newTestService(
+		CacheConfig{TTL: time.Minute, Coalesce: true, FetchTimeout: time.Second},
+		rateService,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	go func() {
+		close(started)
+		_, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+		cancelled <- err
+	}()
+
+	<-started
+	time.Sleep(10 * time.Millisecond)
+
+	rate, err := service.ExchangeRate(context.Background(), port.DefaultCurrencyPair)
+	require.NoError(t, err)
+	require.Equal(t, "100", rate.Amount.String())
+
+	require.ErrorIs(t, <-cancelled, context.DeadlineExceeded)
+	require.EqualValues(t, 1, rateService.calls.Load())
+}
+
+func TestSharedFetchTimeout(t *testing.T) {
+	t.Parallel()
+
+	rateService := &StubRateService{amount: "100", delay: time.Second}
+	service, _ := newTestService(
+		CacheConfig{TTL: time.Minute, Coalesce: true, FetchTimeout: 20 * time.Millisecond},
+		rateService,
+	)
+
+	_, err := service.ExchangeRate(context.Background(), port.DefaultCurrencyPair)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRefreshRequested(t *testing.T) {
+	t.Parallel()
+
+	rateService := &StubRateService{amount: "100"}
+	service, clock := newTestService(CacheConfig{TTL: time.Minute}, rateService)
+	ctx := context.Background()
+
+	_, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+	require.NoError(t, err)
+
+	// Nothing was requested since the fetch
+	service.refreshRequested(ctx)
+	require.EqualValues(t, 1, rateService.calls.Load())
+
+	clock.advance(time.Second)
+	_, err = service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+	require.NoError(t, err)
+
+	rateService.set("200", nil)
+	service.refreshRequested(ctx)
+	require.EqualValues(t, 2, rateService.calls.Load())
+
+	rate, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+	require.NoError(t, err)
+	require.Equal(t, "200", rate.Amount.String())
+}
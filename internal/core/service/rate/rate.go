@@ -1,35 +1,172 @@
 package rate
 
 import (
+	"context"
+	"errors"
+
 	"gses2-app/internal/core/port"
 )
 
+var ErrUnknownStrategy = errors.New("unknown rate strategy")
+
 type RatePort interface {
-	ExchangeRate() (port.Rate, error)
+	ExchangeRate(ctx context.Context, pair port.CurrencyPair) (port.Rate, error)
 	Name() string
 }
 
+type HistoryPort interface {
+	Record(rate port.Rate) error
+}
+
+// Recorders records the rate in every recorder, a failed
+// recorder doesn't stop the rate from reaching the others
+type Recorders []HistoryPort
+
+func (r Recorders) Record(rate port.Rate) error {
+	var errs []error
+	for _, recorder := range r {
+		if err := recorder.Record(rate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Strategy defines how the service combines the rate providers
+type Strategy string
+
+const (
+	// StrategyFailover asks the providers one after another
+	// and returns the first successful rate
+	StrategyFailover Strategy = "failover"
+
+	// StrategyRace asks all the providers at once
+	// and returns the first successful rate
+	StrategyRace Strategy = "race"
+
+	// StrategyMedian asks all the providers at once and returns
+	// the median of the rates that agree with each other
+	StrategyMedian Strategy = "median"
+
+	// StrategyMean asks all the providers at once and returns
+	// the mean of the rates that agree with each other
+	StrategyMean Strategy = "mean"
+)
+
+// Validate returns ErrUnknownStrategy for the strategy
+// that isn't one of the above, empty is failover
+func (s Strategy) Validate() error {
+	switch s {
+	case StrategyFailover, StrategyRace, StrategyMedian, StrategyMean, "":
+		return nil
+	default:
+		return ErrUnknownStrategy
+	}
+}
+
+type ServiceConfig struct {
+	Strategy Strategy `default:"failover"`
+
+	// MaxDeviation is the maximum relative deviation from the median
+	// rate, providers whose rates deviate more are rejected as outliers
+	MaxDeviation float64 `default:"0.05"`
+
+	// MinAgreement is the minimum number of providers
+	// that must agree on the rate for a consensus
+	MinAgreement int `default:"1"`
+}
+
 type Service struct {
 	providers []RatePort
 	logger    port.Logger
+	config    ServiceConfig
+	history   HistoryPort
 }
 
-func NewService(logger port.Logger, providers ...RatePort) *Service {
+// NewService creates the rate service, every successfully fetched
+// rate is recorded in the history unless it's nil
+func NewService(
+	logger port.Logger,
+	config ServiceConfig,
+	history HistoryPort,
+	providers ...RatePort,
+) *Service {
 	return &Service{
 		logger:    logger,
+		config:    config,
+		history:   history,
 		providers: providers,
 	}
 }
 
-func (s *Service) ExchangeRate() (rate port.Rate, err error) {
+func (s *Service) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	rate, err := s.exchangeRate(ctx, pair)
+	if err != nil {
+		return rate, err
+	}
+
+	s.record(rate)
+	return rate, nil
+}
+
+func (s *Service) exchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	switch s.config.Strategy {
+	case StrategyFailover, "":
+		return s.failover(ctx, pair)
+	case StrategyRace:
+		return s.race(ctx, pair)
+	case StrategyMedian:
+		return s.consensus(ctx, pair, median)
+	case StrategyMean:
+		return s.consensus(ctx, pair, mean)
+	default:
+		return port.Rate{}, ErrUnknownStrategy
+	}
+}
+
+func (s *Service) failover(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (rate port.Rate, err error) {
+	if len(s.providers) == 0 {
+		return port.Rate{}, ErrNoProviders
+	}
+
 	for _, provider := range s.providers {
-		rate, err = provider.ExchangeRate()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return rate, ctxErr
+		}
+
+		rate, err = provider.ExchangeRate(ctx, pair)
 		if err == nil {
 			return rate, nil
 		}
 
-		s.logger.Errorf("Error, %v: %v", provider.Name(), err)
+		s.logError(provider, pair, err)
 	}
 
 	return rate, err
 }
+
+// record stores the rate in the history, a failure
+// is only logged so it doesn't affect the response
+func (s *Service) record(rate port.Rate) {
+	if s.history == nil {
+		return
+	}
+
+	if err := s.history.Record(rate); err != nil {
+		s.logger.Errorf("Error, cannot record rate %v: %v", rate.Pair, err)
+	}
+}
+
+func (s *Service) logError(provider RatePort, pair port.CurrencyPair, err error) {
+	s.logger.Errorf("Error, %v %v: %v", provider.Name(), pair, err)
+}
@@ -1,8 +1,10 @@
 package rate
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -22,10 +24,19 @@ type StubProvider struct {
 	Rate         port.Rate
 	Error        error
 	ProviderName string
+	Delay        time.Duration
 }
 
-func (m *StubProvider) ExchangeRate() (port.Rate, error) {
-	return m.Rate, m.Error
+func (m *StubProvider) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	select {
+	case <-ctx.Done():
+		return port.Rate{}, ctx.Err()
+	case <-time.After(m.Delay):
+		return m.Rate, m.Error
+	}
 }
 
 func (m *StubProvider) Name() string {
@@ -42,19 +53,19 @@ func TestExchangeRate(t *testing.T) {
 		{
 			name: "Success",
 			stubProvider: &StubProvider{
-				Rate:  1.23,
+				Rate:  port.Rate{Amount: port.MustParseDecimal("1.23")},
 				Error: nil,
 			},
-			expectedRate:   1.23,
+			expectedRate:   port.Rate{Amount: port.MustParseDecimal("1.23")},
 			expectingError: false,
 		},
 		{
 			name: "Failure",
 			stubProvider: &StubProvider{
-				Rate:  0,
+				Rate:  port.Rate{},
 				Error: errors.New("error fetching rate"),
 			},
-			expectedRate:   0,
+			expectedRate:   port.Rate{},
 			expectingError: true,
 		},
 	}
@@ -63,8 +74,11 @@ func TestExchangeRate(t *testing.T) {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			service := NewService(&StubLogger{}, tt.stubProvider)
-			rate, err := service.ExchangeRate()
+			service := NewService(&StubLogger{}, ServiceConfig{}, nil, tt.stubProvider)
+			rate, err := service.ExchangeRate(
+				context.Background(),
+				port.DefaultCurrencyPair,
+			)
 
 			require.Equal(
 				t, tt.expectedRate, rate,
@@ -76,3 +90,91 @@ func TestExchangeRate(t *testing.T) {
 	}
 
 }
+
+func TestExchangeRateCancelledContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	service := NewService(&StubLogger{}, ServiceConfig{}, nil, &StubProvider{
+		Rate: port.Rate{Amount: port.MustParseDecimal("1.23")},
+	})
+	_, err := service.ExchangeRate(ctx, port.DefaultCurrencyPair)
+
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+type StubHistory struct {
+	Rates []port.Rate
+	Err   error
+}
+
+func (h *StubHistory) Record(rate port.Rate) error {
+	h.Rates = append(h.Rates, rate)
+	return h.Err
+}
+
+func TestExchangeRateIsRecorded(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		stubProvider  *StubProvider
+		history       *StubHistory
+		expectedCount int
+		expectingErr  bool
+	}{
+		{
+			name: "Successful fetch is recorded",
+			stubProvider: &StubProvider{
+				Rate: port.Rate{Amount: port.MustParseDecimal("1.23")},
+			},
+			history:       &StubHistory{},
+			expectedCount: 1,
+		},
+		{
+			name:          "Failed fetch isn't recorded",
+			stubProvider:  &StubProvider{Error: errors.New("error fetching rate")},
+			history:       &StubHistory{},
+			expectedCount: 0,
+			expectingErr:  true,
+		},
+		{
+			name: "History error doesn't fail the fetch",
+			stubProvider: &StubProvider{
+				Rate: port.Rate{Amount: port.MustParseDecimal("1.23")},
+			},
+			history:       &StubHistory{Err: errors.New("history error")},
+			expectedCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			service := NewService(&StubLogger{}, ServiceConfig{}, tt.history, tt.stubProvider)
+			_, err := service.ExchangeRate(context.Background(), port.DefaultCurrencyPair)
+
+			require.Equal(t, tt.expectingErr, err != nil)
+			require.Len(t, tt.history.Rates, tt.expectedCount)
+		})
+	}
+}
+
+func TestRecorders(t *testing.T) {
+	t.Parallel()
+
+	errHistory := errors.New("history error")
+	failing := &StubHistory{Err: errHistory}
+	other := &StubHistory{}
+
+	rate := port.Rate{Amount: port.MustParseDecimal("1.23")}
+	err := Recorders{failing, other}.Record(rate)
+
+	require.ErrorIs(t, err, errHistory)
+	require.Len(t, failing.Rates, 1)
+	require.Len(t, other.Rates, 1, "expected the rate after the failed recorder")
+}
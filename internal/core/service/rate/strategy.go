@@ -0,0 +1,207 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+const _consensusProviderName = "Consensus"
+
+var (
+	ErrNoProviders = errors.New("no rate providers")
+	ErrNoConsensus = errors.New("not enough providers agree on the rate")
+)
+
+type providerResult struct {
+	provider RatePort
+	rate     port.Rate
+	err      error
+}
+
+// aggregateFunc calculates a single amount from the rates sorted by amount
+type aggregateFunc func(rates []port.Rate) port.Decimal
+
+// race returns the first successful rate, the requests
+// to the rest of the providers are cancelled
+func (s *Service) race(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	if len(s.providers) == 0 {
+		return port.Rate{}, ErrNoProviders
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := s.askAll(ctx, pair)
+
+	var errs []error
+	for range s.providers {
+		select {
+		case <-ctx.Done():
+			return port.Rate{}, ctx.Err()
+		case result := <-results:
+			if result.err == nil {
+				return result.rate, nil
+			}
+
+			s.logError(result.provider, pair, result.err)
+			errs = append(errs, result.err)
+		}
+	}
+
+	return port.Rate{}, errors.Join(errs...)
+}
+
+// consensus waits for all the providers, rejects the rates that deviate
+// from the median more than allowed and aggregates the rest of them
+func (s *Service) consensus(
+	ctx context.Context,
+	pair port.CurrencyPair,
+	aggregate aggregateFunc,
+) (port.Rate, error) {
+	rates, err := s.collect(ctx, pair)
+	if err != nil {
+		return port.Rate{}, err
+	}
+
+	agreed := s.rejectOutliers(rates)
+	if len(agreed) < s.config.MinAgreement || len(agreed) == 0 {
+		return port.Rate{}, ErrNoConsensus
+	}
+
+	return port.Rate{
+		Amount:    aggregate(agreed),
+		Pair:      pair,
+		Provider:  _consensusProviderName,
+		FetchedAt: oldestFetchTime(agreed),
+		Sources:   providerNames(agreed),
+	}, nil
+}
+
+// collect returns the successful rates sorted by amount
+func (s *Service) collect(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) ([]port.Rate, error) {
+	if len(s.providers) == 0 {
+		return nil, ErrNoProviders
+	}
+
+	results := s.askAll(ctx, pair)
+
+	var (
+		rates []port.Rate
+		errs  []error
+	)
+
+	for range s.providers {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result := <-results:
+			if result.err != nil {
+				s.logError(result.provider, pair, result.err)
+				errs = append(errs, result.err)
+				continue
+			}
+
+			result.rate.Provider = result.provider.Name()
+			rates = append(rates, result.rate)
+		}
+	}
+
+	if len(rates) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].Amount.Cmp(rates[j].Amount) < 0
+	})
+
+	return rates, nil
+}
+
+// askAll requests the rate from every provider concurrently, the channel is
+// buffered so the providers never block when nobody reads their results
+func (s *Service) askAll(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) <-chan providerResult {
+	results := make(chan providerResult, len(s.providers))
+
+	for _, provider := range s.providers {
+		go func(provider RatePort) {
+			rate, err := provider.ExchangeRate(ctx, pair)
+			results <- providerResult{provider: provider, rate: rate, err: err}
+		}(provider)
+	}
+
+	return results
+}
+
+func (s *Service) rejectOutliers(rates []port.Rate) []port.Rate {
+	middle := median(rates)
+	maxDeviation := port.MustParseDecimal(
+		strconv.FormatFloat(s.config.MaxDeviation, 'f', -1, 64),
+	)
+
+	agreed := make([]port.Rate, 0, len(rates))
+	for _, rate := range rates {
+		deviation := rate.Amount.Sub(middle).Abs().Quo(middle)
+		if middle.IsZero() || deviation.Cmp(maxDeviation) > 0 {
+			s.logger.Errorf(
+				"Error, %v %v: rate %v deviates from the median %v",
+				rate.Provider, rate.Pair, rate.Amount, middle,
+			)
+			continue
+		}
+
+		agreed = append(agreed, rate)
+	}
+
+	return agreed
+}
+
+func median(rates []port.Rate) port.Decimal {
+	middle := len(rates) / 2
+	if len(rates)%2 == 1 {
+		return rates[middle].Amount
+	}
+
+	return rates[middle-1].Amount.Add(rates[middle].Amount).QuoInt(2)
+}
+
+func mean(rates []port.Rate) port.Decimal {
+	var sum port.Decimal
+	for _, rate := range rates {
+		sum = sum.Add(rate.Amount)
+	}
+
+	return sum.QuoInt(len(rates))
+}
+
+func oldestFetchTime(rates []port.Rate) (oldest time.Time) {
+	for i, rate := range rates {
+		if i == 0 || rate.FetchedAt.Before(oldest) {
+			oldest = rate.FetchedAt
+		}
+	}
+
+	return oldest
+}
+
+func providerNames(rates []port.Rate) []string {
+	names := make([]string, len(rates))
+	for i, rate := range rates {
+		names[i] = rate.Provider
+	}
+
+	return names
+}
@@ -0,0 +1,195 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+var errProvider = errors.New("provider error")
+
+func stubProvider(name, amount string) *StubProvider {
+	return &StubProvider{
+		ProviderName: name,
+		Rate: port.Rate{
+			Amount:   port.MustParseDecimal(amount),
+			Pair:     port.DefaultCurrencyPair,
+			Provider: name,
+		},
+	}
+}
+
+func TestRaceStrategy(t *testing.T) {
+	t.Parallel()
+
+	slow := stubProvider("Slow", "100")
+	slow.Delay = time.Second
+	failing := &StubProvider{ProviderName: "Failing", Error: errProvider}
+	fast := stubProvider("Fast", "101")
+
+	service := NewService(
+		&StubLogger{},
+		ServiceConfig{Strategy: StrategyRace},
+		nil,
+		slow, failing, fast,
+	)
+
+	rate, err := service.ExchangeRate(context.Background(), port.DefaultCurrencyPair)
+
+	require.NoError(t, err)
+	require.Equal(t, "Fast", rate.Provider)
+}
+
+func TestRaceStrategyAllProvidersFail(t *testing.T) {
+	t.Parallel()
+
+	service := NewService(
+		&StubLogger{},
+		ServiceConfig{Strategy: StrategyRace},
+		nil,
+		&StubProvider{ProviderName: "First", Error: errProvider},
+		&StubProvider{ProviderName: "Second", Error: errProvider},
+	)
+
+	_, err := service.ExchangeRate(context.Background(), port.DefaultCurrencyPair)
+
+	require.ErrorIs(t, err, errProvider)
+}
+
+func TestStrategyValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, strategy := range []Strategy{
+		StrategyFailover,
+		StrategyRace,
+		StrategyMedian,
+		StrategyMean,
+		"",
+	} {
+		require.NoError(t, strategy.Validate())
+	}
+
+	require.ErrorIs(t, Strategy("unknown").Validate(), ErrUnknownStrategy)
+	require.ErrorIs(t, Strategy("Median").Validate(), ErrUnknownStrategy)
+}
+
+func TestNoProviders(t *testing.T) {
+	t.Parallel()
+
+	for _, strategy := range []Strategy{StrategyFailover, StrategyRace, StrategyMedian} {
+		service := NewService(&StubLogger{}, ServiceConfig{Strategy: strategy}, nil)
+
+		_, err := service.ExchangeRate(context.Background(), port.DefaultCurrencyPair)
+		require.ErrorIs(t, err, ErrNoProviders, strategy)
+	}
+}
+
+func TestConsensusStrategies(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		config          ServiceConfig
+		providers       []RatePort
+		expectedAmount  string
+		expectedSources []string
+		expectedErr     error
+	}{
+		{
+			name:   "Median of agreeing providers",
+			config: ServiceConfig{Strategy: StrategyMedian, MaxDeviation: 0.05},
+			providers: []RatePort{
+				stubProvider("Binance", "100"),
+				stubProvider("Coingecko", "102"),
+				stubProvider("Kuna", "101"),
+			},
+			expectedAmount:  "101",
+			expectedSources: []string{"Binance", "Kuna", "Coingecko"},
+		},
+		{
+			name:   "Mean of agreeing providers",
+			config: ServiceConfig{Strategy: StrategyMean, MaxDeviation: 0.05},
+			providers: []RatePort{
+				stubProvider("Binance", "100"),
+				stubProvider("Coingecko", "103"),
+			},
+			expectedAmount:  "101.5",
+			expectedSources: []string{"Binance", "Coingecko"},
+		},
+		{
+			name:   "Outlier is rejected",
+			config: ServiceConfig{Strategy: StrategyMean, MaxDeviation: 0.05},
+			providers: []RatePort{
+				stubProvider("Binance", "100"),
+				stubProvider("Coingecko", "102"),
+				stubProvider("Kuna", "150"),
+			},
+			expectedAmount:  "101",
+			expectedSources: []string{"Binance", "Coingecko"},
+		},
+		{
+			name:   "Failing provider is skipped",
+			config: ServiceConfig{Strategy: StrategyMedian, MaxDeviation: 0.05},
+			providers: []RatePort{
+				stubProvider("Binance", "100"),
+				&StubProvider{ProviderName: "Kuna", Error: errProvider},
+			},
+			expectedAmount:  "100",
+			expectedSources: []string{"Binance"},
+		},
+		{
+			name: "Not enough providers agree",
+			config: ServiceConfig{
+				Strategy:     StrategyMedian,
+				MaxDeviation: 0.05,
+				MinAgreement: 2,
+			},
+			providers: []RatePort{
+				stubProvider("Binance", "100"),
+				&StubProvider{ProviderName: "Kuna", Error: errProvider},
+			},
+			expectedErr: ErrNoConsensus,
+		},
+		{
+			name:   "All providers fail",
+			config: ServiceConfig{Strategy: StrategyMedian},
+			providers: []RatePort{
+				&StubProvider{ProviderName: "Kuna", Error: errProvider},
+			},
+			expectedErr: errProvider,
+		},
+		{
+			name:        "Unknown strategy",
+			config:      ServiceConfig{Strategy: "unknown"},
+			providers:   []RatePort{stubProvider("Binance", "100")},
+			expectedErr: ErrUnknownStrategy,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			service := NewService(&StubLogger{}, tt.config, nil, tt.providers...)
+			rate, err := service.ExchangeRate(
+				context.Background(),
+				port.DefaultCurrencyPair,
+			)
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			if tt.expectedErr != nil {
+				return
+			}
+
+			require.Equal(t, tt.expectedAmount, rate.Amount.String())
+			require.Equal(t, tt.expectedSources, rate.Sources)
+			require.Equal(t, port.DefaultCurrencyPair, rate.Pair)
+		})
+	}
+}
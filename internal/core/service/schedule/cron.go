@@ -0,0 +1,230 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_cronFields = 5
+
+	// _searchYears bounds the search of the next time,
+	// e.g. "0 0 31 2 *" never matches
+	_searchYears = 5
+)
+
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+// _macros are the shortcuts for the common expressions
+var _macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField is the range of the values of the field
+// and the names some of them can be written with
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	_minuteField = cronField{name: "minute", min: 0, max: 59}
+	_hourField   = cronField{name: "hour", min: 0, max: 23}
+	_dayField    = cronField{name: "day of month", min: 1, max: 31}
+	_monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	// Sunday is both 0 and 7
+	_weekdayField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+// Cron is the parsed cron expression evaluated in the location,
+// every field is the set of the matching values
+type Cron struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	// The time matches either the day of month or the day of week
+	// when both of them are restricted
+	anyDay     bool
+	anyWeekday bool
+
+	location *time.Location
+}
+
+// ParseCron parses the standard five field expression, "minute hour
+// day-of-month month day-of-week", with the lists, ranges, steps and
+// names, e.g. "*/15 9-17 * * MON-FRI", or one of the macros, e.g. "@daily"
+func ParseCron(expression string, location *time.Location) (*Cron, error) {
+	spec := strings.TrimSpace(expression)
+	if macro, ok := _macros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != _cronFields {
+		return nil, fmt.Errorf("%w %q: expected %d fields", ErrInvalidCron, expression, _cronFields)
+	}
+
+	cron := &Cron{
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+		location:   location,
+	}
+
+	sets := []*uint64{&cron.minutes, &cron.hours, &cron.days, &cron.months, &cron.weekdays}
+	for i, field := range []cronField{_minuteField, _hourField, _dayField, _monthField, _weekdayField} {
+		set, err := field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidCron, expression, err)
+		}
+		*sets[i] = set
+	}
+
+	if has(cron.weekdays, 7) {
+		cron.weekdays |= 1
+	}
+
+	return cron, nil
+}
+
+// Location returns the location the expression is evaluated in
+func (c *Cron) Location() *time.Location {
+	return c.location
+}
+
+// Next returns the first minute matching the expression after the time,
+// it's zero when no time within the next years matches
+func (c *Cron) Next(after time.Time) time.Time {
+	t := after.In(c.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(_searchYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !has(c.months, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.location)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.location)
+		case !has(c.hours, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.location)
+		case !has(c.minutes, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	day := has(c.days, t.Day())
+	weekday := has(c.weekdays, int(t.Weekday()))
+
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// parse returns the set of the values of the comma separated list
+func (f cronField) parse(spec string) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(spec, ",") {
+		values, err := f.parseRange(part)
+		if err != nil {
+			return 0, err
+		}
+		set |= values
+	}
+
+	return set, nil
+}
+
+// parseRange returns the set of the values of "*", "a" or "a-b" with
+// the optional step, e.g. "*/15", "a/step" is the range from a to the max
+func (f cronField) parseRange(part string) (uint64, error) {
+	spec, stepSpec, hasStep := strings.Cut(part, "/")
+
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepSpec); err != nil || step < 1 {
+			return 0, fmt.Errorf("invalid %s step %q", f.name, stepSpec)
+		}
+	}
+
+	low, high, err := f.bounds(spec, hasStep)
+	if err != nil {
+		return 0, err
+	}
+
+	var set uint64
+	for value := low; value <= high; value += step {
+		set |= 1 << value
+	}
+
+	return set, nil
+}
+
+func (f cronField) bounds(spec string, hasStep bool) (low, high int, err error) {
+	if spec == "*" {
+		return f.min, f.max, nil
+	}
+
+	from, to, isRange := strings.Cut(spec, "-")
+
+	if low, err = f.value(from); err != nil {
+		return 0, 0, err
+	}
+
+	switch {
+	case isRange:
+		high, err = f.value(to)
+	case hasStep:
+		high = f.max
+	default:
+		high = low
+	}
+
+	if err == nil && low > high {
+		err = fmt.Errorf("invalid %s range %q", f.name, spec)
+	}
+
+	return low, high, err
+}
+
+func (f cronField) value(spec string) (int, error) {
+	if value, ok := f.names[strings.ToUpper(spec)]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(spec)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf("%s %q isn't within %d-%d", f.name, spec, f.min, f.max)
+	}
+
+	return value, nil
+}
+
+func has(set uint64, value int) bool {
+	return set&(1<<value) != 0
+}
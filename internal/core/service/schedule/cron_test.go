@@ -0,0 +1,145 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronNext(t *testing.T) {
+	t.Parallel()
+
+	kyiv := time.FixedZone("EEST", 3*60*60)
+
+	tests := []struct {
+		name       string
+		expression string
+		location   *time.Location
+		after      time.Time
+		expected   time.Time
+	}{
+		{
+			name:       "Every minute",
+			expression: "* * * * *",
+			after:      date(2023, 7, 1, 12, 0, 30),
+			expected:   date(2023, 7, 1, 12, 1, 0),
+		},
+		{
+			name:       "Daily later today",
+			expression: "0 9 * * *",
+			after:      date(2023, 7, 1, 8, 59, 0),
+			expected:   date(2023, 7, 1, 9, 0, 0),
+		},
+		{
+			name:       "Daily tomorrow",
+			expression: "0 9 * * *",
+			after:      date(2023, 7, 1, 9, 0, 0),
+			expected:   date(2023, 7, 2, 9, 0, 0),
+		},
+		{
+			name:       "Steps and ranges on weekdays",
+			expression: "*/15 9-17 * * MON-FRI",
+			after:      date(2023, 7, 1, 10, 0, 0),
+			expected:   date(2023, 7, 3, 9, 0, 0),
+		},
+		{
+			name:       "List",
+			expression: "5,35 * * * *",
+			after:      date(2023, 7, 1, 10, 5, 0),
+			expected:   date(2023, 7, 1, 10, 35, 0),
+		},
+		{
+			name:       "Day of month or day of week",
+			expression: "0 0 15 * 1",
+			after:      date(2023, 7, 11, 0, 0, 0),
+			expected:   date(2023, 7, 15, 0, 0, 0),
+		},
+		{
+			name:       "Sunday as 7",
+			expression: "0 12 * * 7",
+			after:      date(2023, 7, 1, 0, 0, 0),
+			expected:   date(2023, 7, 2, 12, 0, 0),
+		},
+		{
+			name:       "Month name and next year",
+			expression: "0 0 1 jan *",
+			after:      date(2023, 7, 1, 0, 0, 0),
+			expected:   date(2024, 1, 1, 0, 0, 0),
+		},
+		{
+			name:       "Leap day",
+			expression: "0 0 29 2 *",
+			after:      date(2023, 3, 1, 0, 0, 0),
+			expected:   date(2024, 2, 29, 0, 0, 0),
+		},
+		{
+			name:       "Macro",
+			expression: "@hourly",
+			after:      date(2023, 7, 1, 10, 30, 0),
+			expected:   date(2023, 7, 1, 11, 0, 0),
+		},
+		{
+			name:       "Time zone",
+			expression: "0 9 * * *",
+			location:   kyiv,
+			after:      date(2023, 7, 1, 6, 0, 0),
+			expected:   date(2023, 7, 2, 6, 0, 0),
+		},
+		{
+			name:       "Never",
+			expression: "0 0 31 2 *",
+			after:      date(2023, 7, 1, 0, 0, 0),
+			expected:   time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			location := tt.location
+			if location == nil {
+				location = time.UTC
+			}
+
+			cron, err := ParseCron(tt.expression, location)
+			require.NoError(t, err)
+
+			next := cron.Next(tt.after)
+			require.True(t, tt.expected.Equal(next), "got %v, want %v", next, tt.expected)
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"a * * * *",
+		"@often",
+	} {
+		expression := expression
+		t.Run(expression, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseCron(expression, time.UTC)
+			require.ErrorIs(t, err, ErrInvalidCron)
+		})
+	}
+}
+
+func date(year int, month time.Month, day, hour, minute, second int) time.Time {
+	return time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+}
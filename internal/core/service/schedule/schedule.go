@@ -0,0 +1,293 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+// IdempotencyKeyPrefix starts the idempotency keys of the scheduled
+// broadcasts, the keys of the send requests must not start with it
+const IdempotencyKeyPrefix = "schedule/"
+
+const (
+	_keptRuns = 20
+	_nextRuns = 5
+
+	// _idleWait is the wait when the expression matches no time soon
+	_idleWait = 24 * time.Hour
+)
+
+var (
+	ErrScheduleRepository = errors.New("schedule repository error")
+	ErrNeverRuns          = errors.New("cron expression never matches")
+)
+
+type ScheduleConfig struct {
+	// Cron is the cron expression of the broadcasts, e.g. "0 9 * * *",
+	// the rate isn't broadcast on schedule when it's empty
+	Cron string
+
+	// Timezone is the IANA time zone the expression is evaluated in
+	Timezone string `default:"UTC"`
+
+	// RetryInterval is the delay before the failed broadcast is retried
+	RetryInterval time.Duration `default:"1m"`
+}
+
+// RunRepository keeps the latest runs, the oldest first
+type RunRepository interface {
+	Runs(ctx context.Context) ([]port.ScheduleRun, error)
+	SaveRuns(ctx context.Context, runs []port.ScheduleRun) error
+}
+
+type BroadcastService interface {
+	Broadcast(ctx context.Context, idempotencyKey string) (batchID string, err error)
+}
+
+// Service broadcasts the rate to the subscribers on the cron schedule,
+// the same way as the send request. Every slot of the schedule enqueues
+// the emails with its own idempotency key, so the slot interrupted by
+// a restart is run again without sending the emails twice
+type Service struct {
+	logger      port.Logger
+	config      ScheduleConfig
+	cron        *Cron
+	repository  RunRepository
+	broadcaster BroadcastService
+	startedAt   time.Time
+	now         func() time.Time
+}
+
+// NewService parses the cron expression, the service
+// runs nothing when the expression is empty
+func NewService(
+	logger port.Logger,
+	config ScheduleConfig,
+	repository RunRepository,
+	broadcaster BroadcastService,
+) (*Service, error) {
+	service := &Service{
+		logger:      logger,
+		config:      config,
+		repository:  repository,
+		broadcaster: broadcaster,
+		startedAt:   time.Now(),
+		now:         time.Now,
+	}
+
+	if config.Cron == "" {
+		return service, nil
+	}
+
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("schedule timezone: %w", err)
+	}
+
+	cron, err := ParseCron(config.Cron, location)
+	if err != nil {
+		return nil, err
+	}
+
+	if cron.Next(service.startedAt).IsZero() {
+		return nil, fmt.Errorf("%w: %q", ErrNeverRuns, config.Cron)
+	}
+
+	service.cron = cron
+
+	return service, nil
+}
+
+// Run broadcasts the rate on every slot until the context is done.
+// The slots missed while the application was down are caught up with
+// a single broadcast, the failed broadcast is retried after the retry
+// interval until the next slot
+func (s *Service) Run(ctx context.Context) {
+	if s.cron == nil {
+		return
+	}
+
+	for {
+		failed := s.runDue(ctx)
+
+		if !sleep(ctx, s.wait(failed)) {
+			return
+		}
+	}
+}
+
+// runDue runs the due slot, the missed ones included, and logs
+// its failure, it reports whether the slot has to be retried
+func (s *Service) runDue(ctx context.Context) bool {
+	run, err := s.RunDue(ctx)
+	if err != nil {
+		s.logger.Errorf("Error, cannot run the scheduled broadcast: %v", err)
+	}
+
+	if run != nil && !run.Succeeded() {
+		s.logger.Errorf("Error, the scheduled broadcast for %v failed: %v", run.Slot, run.Error)
+		return true
+	}
+
+	return err != nil
+}
+
+// sleep waits for the duration, it reports false
+// when the context is done before that
+func sleep(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// RunDue broadcasts the rate when a slot is due and returns the run,
+// the run is nil when no slot is due
+func (s *Service) RunDue(ctx context.Context) (*port.ScheduleRun, error) {
+	if s.cron == nil {
+		return nil, nil
+	}
+
+	runs, err := s.repository.Runs(ctx)
+	if err != nil {
+		return nil, errors.Join(err, ErrScheduleRepository)
+	}
+
+	slot, ok := s.dueSlot(runs)
+	if !ok {
+		return nil, nil
+	}
+
+	run := s.broadcast(ctx, slot)
+
+	// The retried slot replaces its failed run
+	if len(runs) > 0 && runs[len(runs)-1].Slot.Equal(slot) {
+		run.Attempts += runs[len(runs)-1].Attempts
+		runs = runs[:len(runs)-1]
+	}
+
+	runs = append(runs, run)
+	if len(runs) > _keptRuns {
+		runs = runs[len(runs)-_keptRuns:]
+	}
+
+	if err = s.repository.SaveRuns(ctx, runs); err != nil {
+		return &run, errors.Join(err, ErrScheduleRepository)
+	}
+
+	return &run, nil
+}
+
+// Schedule returns the next slots and the previous runs
+func (s *Service) Schedule(ctx context.Context) (*port.Schedule, error) {
+	if s.cron == nil {
+		return &port.Schedule{}, nil
+	}
+
+	runs, err := s.repository.Runs(ctx)
+	if err != nil {
+		return nil, errors.Join(err, ErrScheduleRepository)
+	}
+
+	schedule := &port.Schedule{
+		Enabled:      true,
+		Cron:         s.config.Cron,
+		Timezone:     s.cron.Location().String(),
+		PreviousRuns: make([]port.ScheduleRun, 0, len(runs)),
+	}
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		schedule.PreviousRuns = append(schedule.PreviousRuns, runs[i])
+	}
+
+	next := s.now()
+	for i := 0; i < _nextRuns; i++ {
+		if next = s.cron.Next(next); next.IsZero() {
+			break
+		}
+		schedule.NextRuns = append(schedule.NextRuns, next)
+	}
+
+	return schedule, nil
+}
+
+// dueSlot returns the latest slot due by now after the last run or,
+// when nothing has run yet, after the start. The failed slot stays due
+// until it succeeds or the next slot is due
+func (s *Service) dueSlot(runs []port.ScheduleRun) (time.Time, bool) {
+	after := s.startedAt
+	if len(runs) > 0 {
+		last := runs[len(runs)-1]
+
+		after = last.Slot
+		if !last.Succeeded() {
+			after = last.Slot.Add(-time.Minute)
+		}
+	}
+
+	now := s.now()
+
+	slot := s.cron.Next(after)
+	if slot.IsZero() || slot.After(now) {
+		return time.Time{}, false
+	}
+
+	for {
+		next := s.cron.Next(slot)
+		if next.IsZero() || next.After(now) {
+			return slot, true
+		}
+		slot = next
+	}
+}
+
+// broadcast enqueues the emails with the current rates to the subscribers
+func (s *Service) broadcast(ctx context.Context, slot time.Time) port.ScheduleRun {
+	run := port.ScheduleRun{
+		Slot:      slot,
+		StartedAt: s.now().In(slot.Location()),
+		Attempts:  1,
+	}
+
+	batchID, err := s.broadcaster.Broadcast(ctx, idempotencyKey(slot))
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+
+	run.BatchID = batchID
+
+	return run
+}
+
+// wait returns the time until the next slot,
+// the failed broadcast is retried sooner
+func (s *Service) wait(failed bool) time.Duration {
+	now := s.now()
+
+	next := s.cron.Next(now)
+	if next.IsZero() {
+		return _idleWait
+	}
+
+	wait := next.Sub(now)
+
+	if failed && s.config.RetryInterval > 0 && s.config.RetryInterval < wait {
+		return s.config.RetryInterval
+	}
+
+	return wait
+}
+
+func idempotencyKey(slot time.Time) string {
+	return IdempotencyKeyPrefix + slot.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,334 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+var (
+	errBroadcast  = errors.New("broadcast error")
+	errRepository = errors.New("repository error")
+)
+
+type StubLogger struct{}
+
+func (s *StubLogger) Info(...interface{})           {}
+func (s *StubLogger) Infof(string, ...interface{})  {}
+func (s *StubLogger) Debug(...interface{})          {}
+func (s *StubLogger) Debugf(string, ...interface{}) {}
+func (s *StubLogger) Error(...interface{})          {}
+func (s *StubLogger) Errorf(string, ...interface{}) {}
+
+type StubRunRepository struct {
+	runs    []port.ScheduleRun
+	err     error
+	saveErr error
+}
+
+func (r *StubRunRepository) Runs(ctx context.Context) ([]port.ScheduleRun, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return append([]port.ScheduleRun(nil), r.runs...), nil
+}
+
+func (r *StubRunRepository) SaveRuns(ctx context.Context, runs []port.ScheduleRun) error {
+	if r.saveErr != nil {
+		return r.saveErr
+	}
+
+	r.runs = runs
+
+	return nil
+}
+
+// StubBroadcastService enqueues the batch once per idempotency key
+type StubBroadcastService struct {
+	err     error
+	keys    []string
+	batches map[string]bool
+}
+
+func (s *StubBroadcastService) Broadcast(
+	ctx context.Context,
+	idempotencyKey string,
+) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+
+	if s.batches == nil {
+		s.batches = make(map[string]bool)
+	}
+
+	if !s.batches[idempotencyKey] {
+		s.keys = append(s.keys, idempotencyKey)
+		s.batches[idempotencyKey] = true
+	}
+
+	return "batch-" + idempotencyKey, nil
+}
+
+func TestRunDue(t *testing.T) {
+	t.Parallel()
+
+	startedAt := date(2023, 7, 1, 9, 30, 0)
+
+	tests := []struct {
+		name            string
+		cron            string
+		runs            []port.ScheduleRun
+		now             time.Time
+		broadcastErr    error
+		expectedRun     *port.ScheduleRun
+		expectedKeys    []string
+		expectedRunsLen int
+	}{
+		{
+			name: "No slot since the start",
+			cron: "0 * * * *",
+			now:  date(2023, 7, 1, 9, 59, 0),
+		},
+		{
+			name: "First slot since the start",
+			cron: "0 * * * *",
+			now:  date(2023, 7, 1, 10, 0, 5),
+			expectedRun: &port.ScheduleRun{
+				Slot:     date(2023, 7, 1, 10, 0, 0),
+				Attempts: 1,
+				BatchID:  "batch-schedule/2023-07-01T10:00:00Z",
+			},
+			expectedKeys:    []string{"schedule/2023-07-01T10:00:00Z"},
+			expectedRunsLen: 1,
+		},
+		{
+			name: "Slot already run",
+			cron: "0 * * * *",
+			runs: []port.ScheduleRun{
+				{Slot: date(2023, 7, 1, 10, 0, 0), Attempts: 1, BatchID: "batch"},
+			},
+			now:             date(2023, 7, 1, 10, 30, 0),
+			expectedRunsLen: 1,
+		},
+		{
+			name: "Missed slots are caught up once",
+			cron: "0 9 * * *",
+			runs: []port.ScheduleRun{
+				{Slot: date(2023, 6, 28, 9, 0, 0), Attempts: 1, BatchID: "batch"},
+			},
+			now: date(2023, 7, 1, 9, 45, 0),
+			expectedRun: &port.ScheduleRun{
+				Slot:     date(2023, 7, 1, 9, 0, 0),
+				Attempts: 1,
+				BatchID:  "batch-schedule/2023-07-01T09:00:00Z",
+			},
+			expectedKeys:    []string{"schedule/2023-07-01T09:00:00Z"},
+			expectedRunsLen: 2,
+		},
+		{
+			name: "Failed slot is retried",
+			cron: "0 * * * *",
+			runs: []port.ScheduleRun{
+				{Slot: date(2023, 7, 1, 10, 0, 0), Attempts: 2, Error: "broadcast error"},
+			},
+			now: date(2023, 7, 1, 10, 5, 0),
+			expectedRun: &port.ScheduleRun{
+				Slot:     date(2023, 7, 1, 10, 0, 0),
+				Attempts: 3,
+				BatchID:  "batch-schedule/2023-07-01T10:00:00Z",
+			},
+			expectedKeys:    []string{"schedule/2023-07-01T10:00:00Z"},
+			expectedRunsLen: 1,
+		},
+		{
+			name:         "Failed broadcast",
+			cron:         "0 * * * *",
+			now:          date(2023, 7, 1, 10, 0, 5),
+			broadcastErr: errBroadcast,
+			expectedRun: &port.ScheduleRun{
+				Slot:     date(2023, 7, 1, 10, 0, 0),
+				Attempts: 1,
+				Error:    errBroadcast.Error(),
+			},
+			expectedRunsLen: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repository := &StubRunRepository{runs: tt.runs}
+			broadcaster := &StubBroadcastService{err: tt.broadcastErr}
+
+			service := newTestService(t, tt.cron, repository, broadcaster)
+			service.startedAt = startedAt
+			service.now = func() time.Time { return tt.now }
+
+			run, err := service.RunDue(context.Background())
+			require.NoError(t, err)
+
+			if tt.expectedRun == nil {
+				require.Nil(t, run)
+			} else {
+				require.NotNil(t, run)
+				require.True(t, tt.expectedRun.Slot.Equal(run.Slot))
+				require.Equal(t, tt.expectedRun.Attempts, run.Attempts)
+				require.Equal(t, tt.expectedRun.BatchID, run.BatchID)
+				require.Equal(t, tt.expectedRun.Error, run.Error)
+			}
+
+			require.Equal(t, tt.expectedKeys, broadcaster.keys)
+			require.Len(t, repository.runs, tt.expectedRunsLen)
+		})
+	}
+}
+
+func TestRunDueAfterCrash(t *testing.T) {
+	t.Parallel()
+
+	repository := &StubRunRepository{saveErr: errRepository}
+	broadcaster := &StubBroadcastService{}
+
+	service := newTestService(t, "0 * * * *", repository, broadcaster)
+	service.startedAt = date(2023, 7, 1, 9, 30, 0)
+	service.now = func() time.Time { return date(2023, 7, 1, 10, 0, 5) }
+
+	// The run isn't saved as if the application crashed right after
+	// the emails were enqueued
+	_, err := service.RunDue(context.Background())
+	require.ErrorIs(t, err, ErrScheduleRepository)
+
+	repository.saveErr = nil
+
+	run, err := service.RunDue(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	require.Equal(t, []string{"schedule/2023-07-01T10:00:00Z"}, broadcaster.keys)
+
+	run, err = service.RunDue(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, run)
+}
+
+func TestRunDueRepositoryError(t *testing.T) {
+	t.Parallel()
+
+	repository := &StubRunRepository{err: errRepository}
+	service := newTestService(t, "@hourly", repository, &StubBroadcastService{})
+
+	_, err := service.RunDue(context.Background())
+	require.ErrorIs(t, err, ErrScheduleRepository)
+	require.ErrorIs(t, err, errRepository)
+}
+
+func TestSchedule(t *testing.T) {
+	t.Parallel()
+
+	repository := &StubRunRepository{runs: []port.ScheduleRun{
+		{Slot: date(2023, 7, 1, 8, 0, 0), Attempts: 1, BatchID: "first"},
+		{Slot: date(2023, 7, 1, 9, 0, 0), Attempts: 1, BatchID: "second"},
+	}}
+
+	service := newTestService(t, "0 */6 * * *", repository, &StubBroadcastService{})
+	service.now = func() time.Time { return date(2023, 7, 1, 9, 30, 0) }
+
+	schedule, err := service.Schedule(context.Background())
+	require.NoError(t, err)
+
+	require.True(t, schedule.Enabled)
+	require.Equal(t, "0 */6 * * *", schedule.Cron)
+	require.Equal(t, "UTC", schedule.Timezone)
+	require.Equal(t, []time.Time{
+		date(2023, 7, 1, 12, 0, 0),
+		date(2023, 7, 1, 18, 0, 0),
+		date(2023, 7, 2, 0, 0, 0),
+		date(2023, 7, 2, 6, 0, 0),
+		date(2023, 7, 2, 12, 0, 0),
+	}, schedule.NextRuns)
+	require.Equal(t, "second", schedule.PreviousRuns[0].BatchID)
+	require.Equal(t, "first", schedule.PreviousRuns[1].BatchID)
+}
+
+func TestDisabledSchedule(t *testing.T) {
+	t.Parallel()
+
+	service := newTestService(t, "", &StubRunRepository{}, &StubBroadcastService{})
+
+	schedule, err := service.Schedule(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &port.Schedule{}, schedule)
+
+	run, err := service.RunDue(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, run)
+}
+
+func TestNewServiceInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		config      ScheduleConfig
+		expectedErr error
+	}{
+		{
+			name:        "Invalid expression",
+			config:      ScheduleConfig{Cron: "0 25 * * *"},
+			expectedErr: ErrInvalidCron,
+		},
+		{
+			name:        "Never matching expression",
+			config:      ScheduleConfig{Cron: "0 0 30 2 *"},
+			expectedErr: ErrNeverRuns,
+		},
+		{
+			name:   "Unknown time zone",
+			config: ScheduleConfig{Cron: "@daily", Timezone: "Mars/Olympus"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewService(
+				&StubLogger{},
+				tt.config,
+				&StubRunRepository{},
+				&StubBroadcastService{},
+			)
+			require.Error(t, err)
+
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func newTestService(
+	t *testing.T,
+	cron string,
+	repository RunRepository,
+	broadcaster BroadcastService,
+) *Service {
+	service, err := NewService(
+		&StubLogger{},
+		ScheduleConfig{Cron: cron, RetryInterval: time.Minute},
+		repository,
+		broadcaster,
+	)
+	require.NoError(t, err)
+
+	return service
+}
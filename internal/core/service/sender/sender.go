@@ -1,11 +1,19 @@
 package sender
 
 import (
+	"context"
+
 	"gses2-app/internal/core/port"
 )
 
 type SenderPort interface {
-	SendExchangeRate(rate port.Rate, subscribers []port.User) error
+	SendExchangeRate(
+		ctx context.Context,
+		rate port.Rate,
+		subscribers []port.User,
+	) (*port.DeliveryReport, error)
+	SendConfirmation(ctx context.Context, user port.User) error
+	SendAlert(ctx context.Context, user port.User, event port.AlertEvent) error
 }
 
 type Service struct {
@@ -16,9 +24,22 @@ func NewService(provider SenderPort) *Service {
 	return &Service{senderPort: provider}
 }
 
+// SendExchangeRate sends the rate to the users and reports
+// the delivery to each of them
 func (s *Service) SendExchangeRate(
+	ctx context.Context,
 	rate port.Rate,
 	users ...port.User,
-) error {
-	return s.senderPort.SendExchangeRate(rate, users)
+) (*port.DeliveryReport, error) {
+	return s.senderPort.SendExchangeRate(ctx, rate, users)
+}
+
+// SendConfirmation asks the user to confirm the subscription
+func (s *Service) SendConfirmation(ctx context.Context, user port.User) error {
+	return s.senderPort.SendConfirmation(ctx, user)
+}
+
+// SendAlert notifies the user of the fired alert
+func (s *Service) SendAlert(ctx context.Context, user port.User, event port.AlertEvent) error {
+	return s.senderPort.SendAlert(ctx, user, event)
 }
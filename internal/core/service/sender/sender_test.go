@@ -1,6 +1,7 @@
 package sender
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -14,8 +15,30 @@ type StubProvider struct {
 }
 
 func (tp *StubProvider) SendExchangeRate(
+	ctx context.Context,
 	rate port.Rate,
 	subscribers []port.User,
+) (*port.DeliveryReport, error) {
+	if tp.Err != nil {
+		return nil, tp.Err
+	}
+
+	report := port.NewDeliveryReport()
+	for _, subscriber := range subscribers {
+		report.Add(port.Delivery{Email: subscriber.Email, Status: port.DeliveryAccepted})
+	}
+
+	return report, nil
+}
+
+func (tp *StubProvider) SendConfirmation(ctx context.Context, user port.User) error {
+	return tp.Err
+}
+
+func (tp *StubProvider) SendAlert(
+	ctx context.Context,
+	user port.User,
+	event port.AlertEvent,
 ) error {
 	return tp.Err
 }
@@ -50,7 +73,88 @@ func TestSendExchangeRate(t *testing.T) {
 			provider := &StubProvider{Err: tt.providerErr}
 			service := NewService(provider)
 
-			err := service.SendExchangeRate(1.23, port.User{Email: "subscriber"})
+			report, err := service.SendExchangeRate(
+				context.Background(),
+				port.Rate{Amount: port.MustParseDecimal("1.23")},
+				port.User{Email: "subscriber"},
+			)
+
+			require.Equal(t, tt.expectedErr, err)
+
+			if tt.expectedErr == nil {
+				require.Equal(t, 1, report.Summary.Accepted)
+			}
+		})
+	}
+}
+
+func TestSendConfirmation(t *testing.T) {
+	tests := []struct {
+		name        string
+		providerErr error
+		expectedErr error
+	}{
+		{
+			name:        "No error from provider",
+			providerErr: nil,
+			expectedErr: nil,
+		},
+		{
+			name:        "Error from provider",
+			providerErr: errProvider,
+			expectedErr: errProvider,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			provider := &StubProvider{Err: tt.providerErr}
+			service := NewService(provider)
+
+			err := service.SendConfirmation(
+				context.Background(),
+				port.User{Email: "subscriber"},
+			)
+
+			require.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
+
+func TestSendAlert(t *testing.T) {
+	tests := []struct {
+		name        string
+		providerErr error
+		expectedErr error
+	}{
+		{
+			name:        "No error from provider",
+			providerErr: nil,
+			expectedErr: nil,
+		},
+		{
+			name:        "Error from provider",
+			providerErr: errProvider,
+			expectedErr: errProvider,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			provider := &StubProvider{Err: tt.providerErr}
+			service := NewService(provider)
+
+			err := service.SendAlert(
+				context.Background(),
+				port.User{Email: "subscriber"},
+				port.AlertEvent{Rate: port.Rate{Amount: port.MustParseDecimal("1.23")}},
+			)
 
 			require.Equal(t, tt.expectedErr, err)
 		})
@@ -0,0 +1,72 @@
+package subscription
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+const (
+	_unsubscribePath = "/api/unsubscribe"
+	_confirmPath     = "/api/confirm"
+	_preferencesPath = "/api/preferences"
+)
+
+// Links issues and verifies the signed links sent to the subscribers
+type Links struct {
+	signer          *tokenSigner
+	baseURL         string
+	confirmationTTL time.Duration
+	now             func() time.Time
+}
+
+func NewLinks(config SubscriptionConfig) *Links {
+	return &Links{
+		signer:          newTokenSigner(config.Secret),
+		baseURL:         strings.TrimSuffix(config.BaseURL, "/"),
+		confirmationTTL: config.ConfirmationTTL,
+		now:             time.Now,
+	}
+}
+
+// UnsubscribeURL returns the one-click unsubscribe link of the user,
+// the link doesn't expire
+func (l *Links) UnsubscribeURL(user port.User) string {
+	token := l.signer.sign(_purposeUnsubscribe, user.Email, time.Time{})
+	return l.link(_unsubscribePath, token)
+}
+
+// ConfirmURL returns the link confirming the subscription of the user,
+// the link expires after the confirmation TTL
+func (l *Links) ConfirmURL(user port.User) string {
+	expiresAt := l.now().Add(l.confirmationTTL)
+	token := l.signer.sign(_purposeConfirm, user.Email, expiresAt)
+
+	return l.link(_confirmPath, token)
+}
+
+// PreferencesURL returns the link managing the delivery
+// preferences of the user, the link doesn't expire
+func (l *Links) PreferencesURL(user port.User) string {
+	token := l.signer.sign(_purposePreferences, user.Email, time.Time{})
+	return l.link(_preferencesPath, token)
+}
+
+func (l *Links) unsubscribeEmail(token string) (string, error) {
+	return l.signer.verify(_purposeUnsubscribe, token, l.now())
+}
+
+func (l *Links) confirmEmail(token string) (string, error) {
+	return l.signer.verify(_purposeConfirm, token, l.now())
+}
+
+func (l *Links) preferencesEmail(token string) (string, error) {
+	return l.signer.verify(_purposePreferences, token, l.now())
+}
+
+func (l *Links) link(path, token string) string {
+	query := url.Values{"token": {token}}
+	return l.baseURL + path + "?" + query.Encode()
+}
@@ -0,0 +1,82 @@
+package subscription
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+func tokenFromURL(t *testing.T, link string) string {
+	t.Helper()
+
+	parsed, err := url.Parse(link)
+	require.NoError(t, err)
+
+	return parsed.Query().Get("token")
+}
+
+func TestLinks(t *testing.T) {
+	t.Parallel()
+
+	user := port.User{Email: "test@example.com"}
+
+	tests := []struct {
+		name         string
+		link         func(links *Links) string
+		verify       func(links *Links, token string) (string, error)
+		expectedPath string
+	}{
+		{
+			name:         "Unsubscribe link",
+			link:         func(links *Links) string { return links.UnsubscribeURL(user) },
+			verify:       (*Links).unsubscribeEmail,
+			expectedPath: "https://example.com/api/unsubscribe",
+		},
+		{
+			name:         "Confirmation link",
+			link:         func(links *Links) string { return links.ConfirmURL(user) },
+			verify:       (*Links).confirmEmail,
+			expectedPath: "https://example.com/api/confirm",
+		},
+		{
+			name:         "Preferences link",
+			link:         func(links *Links) string { return links.PreferencesURL(user) },
+			verify:       (*Links).preferencesEmail,
+			expectedPath: "https://example.com/api/preferences",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			links := NewLinks(_testConfig)
+			link := tt.link(links)
+
+			parsed, err := url.Parse(link)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedPath, parsed.Scheme+"://"+parsed.Host+parsed.Path)
+
+			email, err := tt.verify(links, parsed.Query().Get("token"))
+			require.NoError(t, err)
+			require.Equal(t, user.Email, email)
+		})
+	}
+}
+
+func TestConfirmationLinkExpires(t *testing.T) {
+	t.Parallel()
+
+	links := NewLinks(_testConfig)
+	links.now = func() time.Time { return _testNow }
+	token := tokenFromURL(t, links.ConfirmURL(port.User{Email: "test@example.com"}))
+
+	links.now = func() time.Time { return _testNow.Add(_testConfig.ConfirmationTTL) }
+	_, err := links.confirmEmail(token)
+	require.ErrorIs(t, err, ErrExpiredToken)
+}
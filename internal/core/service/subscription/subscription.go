@@ -1,41 +1,376 @@
 package subscription
 
 import (
+	"context"
 	"errors"
+	"time"
+
 	"gses2-app/internal/core/port"
 )
 
 var (
-	ErrAlreadySubscribed = errors.New("email is already subscribed")
-	ErrUserRepository    = errors.New("user repository error")
+	ErrAlreadySubscribed   = errors.New("email is already subscribed")
+	ErrNotSubscribed       = errors.New("email is not subscribed")
+	ErrUserRepository      = errors.New("user repository error")
+	ErrConfirmationNotSent = errors.New("confirmation email wasn't sent")
+	ErrConfirmationTooSoon = errors.New("confirmation email was sent recently, try again later")
 )
 
+type SubscriptionConfig struct {
+	// Secret signs the unsubscribe, confirmation and preferences tokens
+	Secret string
+
+	// BaseURL is the public URL of the API used in the links
+	BaseURL string `default:"http://localhost:8080"`
+
+	// ConfirmationTTL is the time to confirm the subscription,
+	// the unconfirmed subscriptions are purged after it
+	ConfirmationTTL time.Duration `default:"24h"`
+
+	// ResendInterval is the minimum time between the confirmations
+	// sent to the pending subscriber who subscribes again
+	ResendInterval time.Duration `default:"5m"`
+
+	// PurgeInterval is the interval between the purges
+	// of the unconfirmed subscriptions, 0 disables them
+	PurgeInterval time.Duration `default:"1h"`
+}
+
 type UserRepository interface {
-	Add(user *port.User) error
-	All() ([]port.User, error)
+	Add(ctx context.Context, user *port.User) error
+	FindByEmail(ctx context.Context, email string) (*port.User, error)
+	Update(ctx context.Context, user *port.User) error
+	MarkNotified(ctx context.Context, emails []string, at time.Time) error
+	Remove(ctx context.Context, user *port.User) error
+	RemoveUnconfirmed(ctx context.Context, email string, subscribedBefore time.Time) error
+	All(ctx context.Context) ([]port.User, error)
+}
+
+type EmailValidator interface {
+	Canonicalize(email string) (string, error)
+}
+
+type ConfirmationSender interface {
+	SendConfirmation(ctx context.Context, user port.User) error
 }
 
 type Service struct {
+	logger         port.Logger
+	config         SubscriptionConfig
 	userRepository UserRepository
+	validator      EmailValidator
+	sender         ConfirmationSender
+	links          *Links
+	now            func() time.Time
 }
 
-func NewService(userRepository UserRepository) *Service {
-	return &Service{userRepository: userRepository}
+func NewService(
+	logger port.Logger,
+	config SubscriptionConfig,
+	userRepository UserRepository,
+	validator EmailValidator,
+	sender ConfirmationSender,
+	links *Links,
+) *Service {
+	return &Service{
+		logger:         logger,
+		config:         config,
+		userRepository: userRepository,
+		validator:      validator,
+		sender:         sender,
+		links:          links,
+		now:            time.Now,
+	}
 }
 
-func (s *Service) Subscribe(user *port.User) error {
-	err := s.userRepository.Add(user)
+// Subscribe canonicalizes the email, adds the user as pending with their
+// preferences and sends the confirmation email, the user is removed again
+// if the email can't be sent. The pending user subscribing again gets
+// the confirmation again
+func (s *Service) Subscribe(ctx context.Context, user *port.User) error {
+	email, err := s.validator.Canonicalize(user.Email)
+	if err != nil {
+		return err
+	}
+
+	user.Email = email
+	user.Status = port.UserPending
+	user.SubscribedAt = s.now().UTC()
+
+	err = s.userRepository.Add(ctx, user)
 	if errors.Is(err, port.ErrAlreadyAdded) {
+		return s.resendConfirmation(ctx, user)
+	}
+
+	if err != nil {
+		return errors.Join(err, ErrUserRepository)
+	}
+
+	err = s.sender.SendConfirmation(ctx, *user)
+	if err == nil {
+		return nil
+	}
+
+	if removeErr := s.userRepository.Remove(ctx, user); removeErr != nil {
+		s.logger.Errorf("Error, cannot remove unconfirmed %v: %v", user.Email, removeErr)
+	}
+
+	return errors.Join(err, ErrConfirmationNotSent)
+}
+
+// resendConfirmation replaces the pending user with the new subscription
+// and sends the confirmation again, at most once per resend interval. The
+// subscription time moves to now, so the user isn't purged before the new
+// link expires, and the user is restored if the email can't be sent
+func (s *Service) resendConfirmation(ctx context.Context, user *port.User) error {
+	existing, err := s.userRepository.FindByEmail(ctx, user.Email)
+	if err != nil {
+		return errors.Join(err, ErrUserRepository)
+	}
+
+	if existing.IsConfirmed() {
 		return ErrAlreadySubscribed
 	}
 
+	if user.SubscribedAt.Sub(existing.SubscribedAt) < s.config.ResendInterval {
+		return ErrConfirmationTooSoon
+	}
+
+	if err = s.userRepository.Update(ctx, user); err != nil {
+		return errors.Join(err, ErrUserRepository)
+	}
+
+	err = s.sender.SendConfirmation(ctx, *user)
+	if err == nil {
+		return nil
+	}
+
+	if restoreErr := s.userRepository.Update(ctx, existing); restoreErr != nil {
+		s.logger.Errorf("Error, cannot restore pending %v: %v", user.Email, restoreErr)
+	}
+
+	return errors.Join(err, ErrConfirmationNotSent)
+}
+
+// Confirm activates the subscription of the user the token was issued for,
+// confirming the subscription again has no effect
+func (s *Service) Confirm(ctx context.Context, token string) error {
+	email, err := s.links.confirmEmail(token)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if errors.Is(err, port.ErrCannotFindByEmail) {
+		return ErrNotSubscribed
+	}
+
+	if err != nil {
+		return errors.Join(err, ErrUserRepository)
+	}
+
+	if user.IsConfirmed() {
+		return nil
+	}
+
+	user.Status = port.UserConfirmed
+	if err = s.userRepository.Update(ctx, user); err != nil {
+		return errors.Join(err, ErrUserRepository)
+	}
+
+	return nil
+}
+
+// Unsubscribe removes the user the token was issued for
+func (s *Service) Unsubscribe(ctx context.Context, token string) error {
+	email, err := s.links.unsubscribeEmail(token)
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepository.Remove(ctx, &port.User{Email: email})
+	if errors.Is(err, port.ErrCannotFindByEmail) {
+		return ErrNotSubscribed
+	}
+
+	if err != nil {
+		return errors.Join(err, ErrUserRepository)
+	}
+
+	return nil
+}
+
+// Preferences returns the delivery preferences of the user the token was
+// issued for, the pairs are the followed ones even when they're the default
+func (s *Service) Preferences(ctx context.Context, token string) (*port.Preferences, error) {
+	user, err := s.userByToken(ctx, token)
 	if err != nil {
+		return nil, err
+	}
+
+	preferences := user.Preferences
+	preferences.Pairs = preferences.FollowedPairs()
+
+	return &preferences, nil
+}
+
+// UpdatePreferences replaces the delivery preferences
+// of the user the token was issued for
+func (s *Service) UpdatePreferences(
+	ctx context.Context,
+	token string,
+	preferences port.Preferences,
+) error {
+	user, err := s.userByToken(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	user.Preferences = preferences
+	if err = s.userRepository.Update(ctx, user); err != nil {
 		return errors.Join(err, ErrUserRepository)
 	}
 
 	return nil
 }
 
-func (s *Service) Subscriptions() ([]port.User, error) {
-	return s.userRepository.All()
+// MarkNotified records the time the users got the broadcast at, only
+// the users with the frequency need it to skip the next broadcasts. Only
+// the time is stored, so the preferences changed meanwhile are kept
+func (s *Service) MarkNotified(ctx context.Context, users []port.User, at time.Time) error {
+	emails := make([]string, 0, len(users))
+	for _, user := range users {
+		if user.Preferences.Frequency != port.FrequencyEveryBroadcast {
+			emails = append(emails, user.Email)
+		}
+	}
+
+	if len(emails) == 0 {
+		return nil
+	}
+
+	if err := s.userRepository.MarkNotified(ctx, emails, at); err != nil {
+		return errors.Join(err, ErrUserRepository)
+	}
+
+	return nil
+}
+
+// Subscriptions returns the confirmed users
+func (s *Service) Subscriptions(ctx context.Context) ([]port.User, error) {
+	users, err := s.userRepository.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed := make([]port.User, 0, len(users))
+	for _, user := range users {
+		if user.IsConfirmed() {
+			confirmed = append(confirmed, user)
+		}
+	}
+
+	return confirmed, nil
+}
+
+// FindByEmail returns the confirmed user with the email, the pending
+// user isn't subscribed yet, so port.ErrCannotFindByEmail is returned
+// for it as for the missing one
+func (s *Service) FindByEmail(ctx context.Context, email string) (*port.User, error) {
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsConfirmed() {
+		return nil, port.ErrCannotFindByEmail
+	}
+
+	return user, nil
+}
+
+// PreferencesEmail returns the email of the user the preferences token was
+// issued for, the token authorizes managing the user's alerts as well
+func (s *Service) PreferencesEmail(token string) (string, error) {
+	return s.links.preferencesEmail(token)
+}
+
+// userByToken returns the user the preferences token was issued for
+func (s *Service) userByToken(ctx context.Context, token string) (*port.User, error) {
+	email, err := s.links.preferencesEmail(token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if errors.Is(err, port.ErrCannotFindByEmail) {
+		return nil, ErrNotSubscribed
+	}
+
+	if err != nil {
+		return nil, errors.Join(err, ErrUserRepository)
+	}
+
+	return user, nil
+}
+
+// Purge removes the expired unconfirmed subscriptions every purge
+// interval until the context is done
+func (s *Service) Purge(ctx context.Context) {
+	if s.config.PurgeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeUnconfirmed(ctx)
+			if err != nil {
+				s.logger.Errorf("Error, cannot purge unconfirmed subscriptions: %v", err)
+				continue
+			}
+
+			if purged > 0 {
+				s.logger.Infof("Purged %d unconfirmed subscriptions", purged)
+			}
+		}
+	}
+}
+
+// PurgeUnconfirmed removes the users who haven't confirmed the subscription
+// within the confirmation TTL, each user is checked again when removed, so
+// the one who confirms or subscribes again meanwhile is kept
+func (s *Service) PurgeUnconfirmed(ctx context.Context) (int, error) {
+	users, err := s.userRepository.All(ctx)
+	if err != nil {
+		return 0, errors.Join(err, ErrUserRepository)
+	}
+
+	expiredBefore := s.now().Add(-s.config.ConfirmationTTL)
+
+	purged := 0
+	for i := range users {
+		user := &users[i]
+		if user.IsConfirmed() || !user.SubscribedAt.Before(expiredBefore) {
+			continue
+		}
+
+		err = s.userRepository.RemoveUnconfirmed(ctx, user.Email, expiredBefore)
+		if errors.Is(err, port.ErrCannotFindByEmail) {
+			continue
+		}
+
+		if err != nil {
+			return purged, errors.Join(err, ErrUserRepository)
+		}
+
+		purged++
+	}
+
+	return purged, nil
 }
@@ -1,70 +1,669 @@
 package subscription
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
 
 	"gses2-app/internal/core/port"
 )
 
+var (
+	errSendConfirmation = errors.New("send confirmation error")
+	errInvalidEmail     = errors.New("invalid email")
+	errUserStorage      = errors.New("user storage error")
+)
+
+type StubLogger struct{}
+
+func (s *StubLogger) Info(...interface{})           {}
+func (s *StubLogger) Infof(string, ...interface{})  {}
+func (s *StubLogger) Debug(...interface{})          {}
+func (s *StubLogger) Debugf(string, ...interface{}) {}
+func (s *StubLogger) Error(...interface{})          {}
+func (s *StubLogger) Errorf(string, ...interface{}) {}
+
 type StubUserRepository struct {
 	Users []port.User
 	Err   error
 }
 
-func (s *StubUserRepository) Add(user *port.User) error {
+func (s *StubUserRepository) Add(ctx context.Context, user *port.User) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	for _, u := range s.Users {
+		if u.Email == user.Email {
+			return port.ErrAlreadyAdded
+		}
+	}
+
 	s.Users = append(s.Users, *user)
-	return s.Err
+	return nil
+}
+
+func (s *StubUserRepository) Update(ctx context.Context, user *port.User) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	for i := range s.Users {
+		if s.Users[i].Email == user.Email {
+			s.Users[i] = *user
+			return nil
+		}
+	}
+
+	return port.ErrCannotFindByEmail
+}
+
+func (s *StubUserRepository) MarkNotified(
+	ctx context.Context,
+	emails []string,
+	at time.Time,
+) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	for i := range s.Users {
+		if slices.Contains(emails, s.Users[i].Email) {
+			s.Users[i].NotifiedAt = at
+		}
+	}
+
+	return nil
+}
+
+func (s *StubUserRepository) Remove(ctx context.Context, user *port.User) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	for i, u := range s.Users {
+		if u.Email == user.Email {
+			s.Users = append(s.Users[:i], s.Users[i+1:]...)
+			return nil
+		}
+	}
+
+	return port.ErrCannotFindByEmail
 }
 
-func (s *StubUserRepository) FindByEmail(email string) (*port.User, error) {
-	return &s.Users[0], s.Err
+func (s *StubUserRepository) RemoveUnconfirmed(
+	ctx context.Context,
+	email string,
+	subscribedBefore time.Time,
+) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	for i, u := range s.Users {
+		if u.Email == email && !u.IsConfirmed() && u.SubscribedAt.Before(subscribedBefore) {
+			s.Users = append(s.Users[:i], s.Users[i+1:]...)
+			return nil
+		}
+	}
+
+	return port.ErrCannotFindByEmail
 }
 
-func (s *StubUserRepository) All() ([]port.User, error) {
+func (s *StubUserRepository) FindByEmail(
+	ctx context.Context,
+	email string,
+) (*port.User, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	for _, user := range s.Users {
+		if user.Email == email {
+			found := user
+			return &found, nil
+		}
+	}
+
+	return nil, port.ErrCannotFindByEmail
+}
+
+func (s *StubUserRepository) All(ctx context.Context) ([]port.User, error) {
 	return s.Users, s.Err
 }
 
-func TestSubscription(t *testing.T) {
+type StubEmailValidator struct {
+	Err error
+}
+
+func (s *StubEmailValidator) Canonicalize(email string) (string, error) {
+	if s.Err != nil {
+		return "", s.Err
+	}
+
+	return strings.ToLower(strings.TrimSpace(email)), nil
+}
+
+type StubConfirmationSender struct {
+	Sent []port.User
+	Err  error
+}
+
+func (s *StubConfirmationSender) SendConfirmation(
+	ctx context.Context,
+	user port.User,
+) error {
+	s.Sent = append(s.Sent, user)
+	return s.Err
+}
+
+var (
+	_testNow    = time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	_testConfig = SubscriptionConfig{
+		Secret:          "secret",
+		BaseURL:         "https://example.com/",
+		ConfirmationTTL: time.Hour,
+		ResendInterval:  5 * time.Minute,
+	}
+)
+
+func newTestService(
+	userRepository UserRepository,
+	sender ConfirmationSender,
+) *Service {
+	links := NewLinks(_testConfig)
+	links.now = func() time.Time { return _testNow }
+
+	service := NewService(
+		&StubLogger{},
+		_testConfig,
+		userRepository,
+		&StubEmailValidator{},
+		sender,
+		links,
+	)
+	service.now = func() time.Time { return _testNow }
+
+	return service
+}
+
+func TestSubscribe(t *testing.T) {
 	t.Run("Subscribe", func(t *testing.T) {
 		t.Parallel()
 
 		subscriber := &port.User{Email: "test@example.com"}
 		userRepository := &StubUserRepository{}
-		service := NewService(userRepository)
+		sender := &StubConfirmationSender{}
+		service := newTestService(userRepository, sender)
 
-		err := service.Subscribe(subscriber)
+		err := service.Subscribe(context.Background(), subscriber)
 		require.NoError(t, err)
 
-		subscribers, err := service.Subscriptions()
+		expected := port.User{
+			Email:        "test@example.com",
+			Status:       port.UserPending,
+			SubscribedAt: _testNow,
+		}
+		require.Equal(t, []port.User{expected}, userRepository.Users)
+		require.Equal(t, []port.User{expected}, sender.Sent)
+
+		subscribers, err := service.Subscriptions(context.Background())
 		require.NoError(t, err)
+		require.Empty(t, subscribers, "expected pending subscriber to be omitted")
+	})
 
-		require.Equal(
-			t, 1, len(subscribers),
-			"expected subscribers list to contain one subscriber",
-		)
+	t.Run("Subscribe with preferences", func(t *testing.T) {
+		t.Parallel()
 
-		require.Equal(
-			t, *subscriber, subscribers[0],
-			"expected subscribers list to contain the subscriber",
+		preferences := port.Preferences{Frequency: port.FrequencyWeekly, Timezone: "UTC"}
+		userRepository := &StubUserRepository{}
+		service := newTestService(userRepository, &StubConfirmationSender{})
+
+		err := service.Subscribe(
+			context.Background(),
+			&port.User{Email: "test@example.com", Preferences: preferences},
 		)
+		require.NoError(t, err)
+		require.Equal(t, preferences, userRepository.Users[0].Preferences)
+	})
+
+	t.Run("Canonical email", func(t *testing.T) {
+		t.Parallel()
+
+		userRepository := &StubUserRepository{}
+		service := newTestService(userRepository, &StubConfirmationSender{})
+		subscriber := &port.User{Email: " Test@Example.com "}
+
+		err := service.Subscribe(context.Background(), subscriber)
+		require.NoError(t, err)
+		require.Equal(t, "test@example.com", subscriber.Email)
+		require.Equal(t, "test@example.com", userRepository.Users[0].Email)
+	})
+
+	t.Run("Invalid email", func(t *testing.T) {
+		t.Parallel()
+
+		userRepository := &StubUserRepository{}
+		sender := &StubConfirmationSender{}
+		service := newTestService(userRepository, sender)
+		service.validator = &StubEmailValidator{Err: errInvalidEmail}
+		subscriber := &port.User{Email: "invalid"}
+
+		err := service.Subscribe(context.Background(), subscriber)
+		require.ErrorIs(t, err, errInvalidEmail)
+		require.Empty(t, userRepository.Users, "expected invalid email not to be stored")
+		require.Empty(t, sender.Sent, "expected no confirmation for invalid email")
 	})
 
 	t.Run("Already subscribed", func(t *testing.T) {
 		t.Parallel()
 
 		userRepository := &StubUserRepository{
-			Users: []port.User{},
-			Err:   port.ErrAlreadyAdded,
+			Users: []port.User{{Email: "test@example.com", Status: port.UserConfirmed}},
 		}
-		service := NewService(userRepository)
+		sender := &StubConfirmationSender{}
+		service := newTestService(userRepository, sender)
 		subscriber := &port.User{Email: "test@example.com"}
 
-		err := service.Subscribe(subscriber)
+		err := service.Subscribe(context.Background(), subscriber)
 		require.ErrorIs(
 			t, err, ErrAlreadySubscribed,
 			"expected error due to duplicate subscription",
 		)
+		require.Empty(t, sender.Sent, "expected no confirmation for confirmed subscriber")
+	})
+
+	t.Run("Pending subscriber gets confirmation again", func(t *testing.T) {
+		t.Parallel()
+
+		userRepository := &StubUserRepository{Users: []port.User{{
+			Email:        "test@example.com",
+			Status:       port.UserPending,
+			SubscribedAt: _testNow.Add(-10 * time.Minute),
+		}}}
+		sender := &StubConfirmationSender{}
+		service := newTestService(userRepository, sender)
+		preferences := port.Preferences{Frequency: port.FrequencyWeekly, Timezone: "UTC"}
+
+		err := service.Subscribe(
+			context.Background(),
+			&port.User{Email: "test@example.com", Preferences: preferences},
+		)
+		require.NoError(t, err)
+
+		expected := port.User{
+			Email:        "test@example.com",
+			Status:       port.UserPending,
+			SubscribedAt: _testNow,
+			Preferences:  preferences,
+		}
+		require.Equal(t, []port.User{expected}, userRepository.Users)
+		require.Equal(t, []port.User{expected}, sender.Sent)
+	})
+
+	t.Run("Confirmation sent recently", func(t *testing.T) {
+		t.Parallel()
+
+		pending := port.User{
+			Email:        "test@example.com",
+			Status:       port.UserPending,
+			SubscribedAt: _testNow.Add(-time.Minute),
+		}
+		userRepository := &StubUserRepository{Users: []port.User{pending}}
+		sender := &StubConfirmationSender{}
+		service := newTestService(userRepository, sender)
+
+		err := service.Subscribe(context.Background(), &port.User{Email: "test@example.com"})
+		require.ErrorIs(t, err, ErrConfirmationTooSoon)
+		require.Equal(t, []port.User{pending}, userRepository.Users)
+		require.Empty(t, sender.Sent, "expected no confirmation within resend interval")
 	})
+
+	t.Run("Confirmation isn't sent again", func(t *testing.T) {
+		t.Parallel()
+
+		pending := port.User{
+			Email:        "test@example.com",
+			Status:       port.UserPending,
+			SubscribedAt: _testNow.Add(-10 * time.Minute),
+		}
+		userRepository := &StubUserRepository{Users: []port.User{pending}}
+		sender := &StubConfirmationSender{Err: errSendConfirmation}
+		service := newTestService(userRepository, sender)
+
+		err := service.Subscribe(context.Background(), &port.User{Email: "test@example.com"})
+		require.ErrorIs(t, err, ErrConfirmationNotSent)
+		require.Equal(t, []port.User{pending}, userRepository.Users, "expected pending subscriber to be restored")
+	})
+
+	t.Run("Confirmation isn't sent", func(t *testing.T) {
+		t.Parallel()
+
+		userRepository := &StubUserRepository{}
+		sender := &StubConfirmationSender{Err: errSendConfirmation}
+		service := newTestService(userRepository, sender)
+		subscriber := &port.User{Email: "test@example.com"}
+
+		err := service.Subscribe(context.Background(), subscriber)
+		require.ErrorIs(t, err, ErrConfirmationNotSent)
+		require.ErrorIs(t, err, errSendConfirmation)
+		require.Empty(t, userRepository.Users, "expected pending subscriber to be removed")
+	})
+}
+
+func TestConfirm(t *testing.T) {
+	t.Parallel()
+
+	pending := port.User{
+		Email:        "test@example.com",
+		Status:       port.UserPending,
+		SubscribedAt: _testNow,
+	}
+
+	links := NewLinks(_testConfig)
+	links.now = func() time.Time { return _testNow }
+	validToken := tokenFromURL(t, links.ConfirmURL(pending))
+	unsubscribeToken := tokenFromURL(t, links.UnsubscribeURL(pending))
+
+	links.now = func() time.Time { return _testNow.Add(-2 * time.Hour) }
+	expiredToken := tokenFromURL(t, links.ConfirmURL(pending))
+
+	tests := []struct {
+		name           string
+		users          []port.User
+		token          string
+		expectedErr    error
+		expectedStatus port.UserStatus
+	}{
+		{
+			name:           "Confirm",
+			users:          []port.User{pending},
+			token:          validToken,
+			expectedErr:    nil,
+			expectedStatus: port.UserConfirmed,
+		},
+		{
+			name: "Confirm again",
+			users: []port.User{
+				{Email: pending.Email, Status: port.UserConfirmed},
+			},
+			token:          validToken,
+			expectedErr:    nil,
+			expectedStatus: port.UserConfirmed,
+		},
+		{
+			name:           "Expired token",
+			users:          []port.User{pending},
+			token:          expiredToken,
+			expectedErr:    ErrExpiredToken,
+			expectedStatus: port.UserPending,
+		},
+		{
+			name:           "Unsubscribe token",
+			users:          []port.User{pending},
+			token:          unsubscribeToken,
+			expectedErr:    ErrInvalidToken,
+			expectedStatus: port.UserPending,
+		},
+		{
+			name:        "Purged subscription",
+			users:       []port.User{},
+			token:       validToken,
+			expectedErr: ErrNotSubscribed,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			userRepository := &StubUserRepository{Users: tt.users}
+			service := newTestService(userRepository, &StubConfirmationSender{})
+
+			err := service.Confirm(context.Background(), tt.token)
+			require.ErrorIs(t, err, tt.expectedErr)
+
+			if len(tt.users) > 0 {
+				require.Equal(t, tt.expectedStatus, userRepository.Users[0].Status)
+			}
+		})
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	t.Parallel()
+
+	subscriber := port.User{Email: "test@example.com", Status: port.UserConfirmed}
+	validToken := tokenFromURL(t, NewLinks(_testConfig).UnsubscribeURL(subscriber))
+
+	otherConfig := _testConfig
+	otherConfig.Secret = "other secret"
+	otherToken := tokenFromURL(t, NewLinks(otherConfig).UnsubscribeURL(subscriber))
+
+	tests := []struct {
+		name        string
+		users       []port.User
+		token       string
+		expectedErr error
+		expectedLen int
+	}{
+		{
+			name:        "Unsubscribe",
+			users:       []port.User{subscriber},
+			token:       validToken,
+			expectedErr: nil,
+			expectedLen: 0,
+		},
+		{
+			name:        "Not subscribed",
+			users:       []port.User{{Email: "other@example.com"}},
+			token:       validToken,
+			expectedErr: ErrNotSubscribed,
+			expectedLen: 1,
+		},
+		{
+			name:        "Token signed with another secret",
+			users:       []port.User{subscriber},
+			token:       otherToken,
+			expectedErr: ErrInvalidToken,
+			expectedLen: 1,
+		},
+		{
+			name:        "Malformed token",
+			users:       []port.User{subscriber},
+			token:       "malformed",
+			expectedErr: ErrInvalidToken,
+			expectedLen: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			userRepository := &StubUserRepository{Users: tt.users}
+			service := newTestService(userRepository, &StubConfirmationSender{})
+
+			err := service.Unsubscribe(context.Background(), tt.token)
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Len(t, userRepository.Users, tt.expectedLen)
+		})
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	t.Parallel()
+
+	confirmed := port.User{Email: "confirmed@example.com", Status: port.UserConfirmed}
+	userRepository := &StubUserRepository{
+		Users: []port.User{
+			{Email: "pending@example.com", Status: port.UserPending},
+			confirmed,
+		},
+	}
+	service := newTestService(userRepository, &StubConfirmationSender{})
+
+	subscribers, err := service.Subscriptions(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []port.User{confirmed}, subscribers)
+}
+
+func TestFindByEmail(t *testing.T) {
+	t.Parallel()
+
+	confirmed := port.User{Email: "confirmed@example.com", Status: port.UserConfirmed}
+	userRepository := &StubUserRepository{
+		Users: []port.User{
+			{Email: "pending@example.com", Status: port.UserPending},
+			confirmed,
+		},
+	}
+	service := newTestService(userRepository, &StubConfirmationSender{})
+
+	subscriber, err := service.FindByEmail(context.Background(), "confirmed@example.com")
+	require.NoError(t, err)
+	require.Equal(t, confirmed, *subscriber)
+
+	_, err = service.FindByEmail(context.Background(), "pending@example.com")
+	require.ErrorIs(t, err, port.ErrCannotFindByEmail)
+
+	_, err = service.FindByEmail(context.Background(), "missing@example.com")
+	require.ErrorIs(t, err, port.ErrCannotFindByEmail)
+}
+
+func TestPreferences(t *testing.T) {
+	t.Parallel()
+
+	subscriber := port.User{Email: "test@example.com", Status: port.UserConfirmed}
+	links := NewLinks(_testConfig)
+	validToken := tokenFromURL(t, links.PreferencesURL(subscriber))
+	unsubscribeToken := tokenFromURL(t, links.UnsubscribeURL(subscriber))
+
+	preferences := port.Preferences{
+		Pairs:     []port.CurrencyPair{{Base: "ETH", Quote: "USD"}},
+		Frequency: port.FrequencyDaily,
+		Timezone:  "UTC",
+	}
+
+	t.Run("Default preferences", func(t *testing.T) {
+		t.Parallel()
+
+		userRepository := &StubUserRepository{Users: []port.User{subscriber}}
+		service := newTestService(userRepository, &StubConfirmationSender{})
+
+		stored, err := service.Preferences(context.Background(), validToken)
+		require.NoError(t, err)
+		require.Equal(t, &port.Preferences{
+			Pairs: []port.CurrencyPair{port.DefaultCurrencyPair},
+		}, stored)
+	})
+
+	t.Run("Update preferences", func(t *testing.T) {
+		t.Parallel()
+
+		userRepository := &StubUserRepository{Users: []port.User{subscriber}}
+		service := newTestService(userRepository, &StubConfirmationSender{})
+
+		err := service.UpdatePreferences(context.Background(), validToken, preferences)
+		require.NoError(t, err)
+		require.Equal(t, preferences, userRepository.Users[0].Preferences)
+
+		stored, err := service.Preferences(context.Background(), validToken)
+		require.NoError(t, err)
+		require.Equal(t, &preferences, stored)
+	})
+
+	t.Run("Unsubscribe token", func(t *testing.T) {
+		t.Parallel()
+
+		userRepository := &StubUserRepository{Users: []port.User{subscriber}}
+		service := newTestService(userRepository, &StubConfirmationSender{})
+
+		err := service.UpdatePreferences(context.Background(), unsubscribeToken, preferences)
+		require.ErrorIs(t, err, ErrInvalidToken)
+		require.Equal(t, port.Preferences{}, userRepository.Users[0].Preferences)
+	})
+
+	t.Run("Not subscribed", func(t *testing.T) {
+		t.Parallel()
+
+		service := newTestService(&StubUserRepository{}, &StubConfirmationSender{})
+
+		_, err := service.Preferences(context.Background(), validToken)
+		require.ErrorIs(t, err, ErrNotSubscribed)
+	})
+}
+
+func TestMarkNotified(t *testing.T) {
+	t.Parallel()
+
+	everyBroadcast := port.User{Email: "every@example.com", Status: port.UserConfirmed}
+	daily := port.User{
+		Email:       "daily@example.com",
+		Status:      port.UserConfirmed,
+		Preferences: port.Preferences{Frequency: port.FrequencyDaily},
+	}
+
+	userRepository := &StubUserRepository{Users: []port.User{everyBroadcast, daily}}
+	service := newTestService(userRepository, &StubConfirmationSender{})
+
+	// The preferences changed after the users were read are kept
+	userRepository.Users[1].Preferences.Timezone = "Europe/Kyiv"
+
+	err := service.MarkNotified(context.Background(), []port.User{everyBroadcast, daily}, _testNow)
+	require.NoError(t, err)
+	require.True(t, userRepository.Users[0].NotifiedAt.IsZero())
+	require.Equal(t, _testNow, userRepository.Users[1].NotifiedAt)
+	require.Equal(t, "Europe/Kyiv", userRepository.Users[1].Preferences.Timezone)
+
+	err = service.MarkNotified(
+		context.Background(),
+		[]port.User{{Email: "gone@example.com", Preferences: daily.Preferences}},
+		_testNow,
+	)
+	require.NoError(t, err)
+
+	userRepository.Err = errUserStorage
+	err = service.MarkNotified(context.Background(), []port.User{daily}, _testNow)
+	require.ErrorIs(t, err, ErrUserRepository)
+}
+
+func TestPurgeUnconfirmed(t *testing.T) {
+	t.Parallel()
+
+	fresh := port.User{
+		Email:        "fresh@example.com",
+		Status:       port.UserPending,
+		SubscribedAt: _testNow.Add(-time.Minute),
+	}
+	confirmed := port.User{
+		Email:        "confirmed@example.com",
+		Status:       port.UserConfirmed,
+		SubscribedAt: _testNow.Add(-48 * time.Hour),
+	}
+	userRepository := &StubUserRepository{
+		Users: []port.User{
+			{
+				Email:        "expired@example.com",
+				Status:       port.UserPending,
+				SubscribedAt: _testNow.Add(-2 * time.Hour),
+			},
+			fresh,
+			confirmed,
+		},
+	}
+	service := newTestService(userRepository, &StubConfirmationSender{})
+
+	purged, err := service.PurgeUnconfirmed(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	require.Equal(t, []port.User{fresh, confirmed}, userRepository.Users)
 }
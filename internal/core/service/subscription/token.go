@@ -0,0 +1,128 @@
+package subscription
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_secretSize       = 32
+	_tokenSeparator   = "."
+	_payloadSeparator = "\n"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+var _encoding = base64.RawURLEncoding
+
+// tokenPurpose prevents using a token issued for one action for another
+type tokenPurpose string
+
+const (
+	_purposeUnsubscribe tokenPurpose = "unsubscribe"
+	_purposeConfirm     tokenPurpose = "confirm"
+	_purposePreferences tokenPurpose = "preferences"
+)
+
+// RandomSecret generates a secret for signing the tokens
+func RandomSecret() (string, error) {
+	secret := make([]byte, _secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return _encoding.EncodeToString(secret), nil
+}
+
+// tokenSigner signs the purpose, the expiration time and the email
+// with HMAC-SHA256, the token is the encoded payload followed by
+// the encoded signature
+type tokenSigner struct {
+	secret []byte
+}
+
+func newTokenSigner(secret string) *tokenSigner {
+	return &tokenSigner{secret: []byte(secret)}
+}
+
+// sign issues the token, the zero expiration time means it never expires
+func (s *tokenSigner) sign(
+	purpose tokenPurpose,
+	email string,
+	expiresAt time.Time,
+) string {
+	var expires int64
+	if !expiresAt.IsZero() {
+		expires = expiresAt.Unix()
+	}
+
+	payload := strings.Join([]string{
+		string(purpose),
+		strconv.FormatInt(expires, 10),
+		email,
+	}, _payloadSeparator)
+
+	encodedPayload := _encoding.EncodeToString([]byte(payload))
+	signature := _encoding.EncodeToString(s.mac(encodedPayload))
+
+	return encodedPayload + _tokenSeparator + signature
+}
+
+// verify returns the email of the token if the signature is valid,
+// the purpose matches and the token hasn't expired by now
+func (s *tokenSigner) verify(
+	purpose tokenPurpose,
+	token string,
+	now time.Time,
+) (string, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, _tokenSeparator)
+	if !found {
+		return "", ErrInvalidToken
+	}
+
+	signature, err := _encoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, s.mac(encodedPayload)) {
+		return "", ErrInvalidToken
+	}
+
+	payload, err := _encoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	fields := strings.SplitN(string(payload), _payloadSeparator, 3)
+	if len(fields) != 3 || fields[0] != string(purpose) {
+		return "", ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if expires != 0 && !now.Before(time.Unix(expires, 0)) {
+		return "", ErrExpiredToken
+	}
+
+	return fields[2], nil
+}
+
+func (s *tokenSigner) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
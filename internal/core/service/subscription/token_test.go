@@ -0,0 +1,95 @@
+package subscription
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenSigner(t *testing.T) {
+	t.Parallel()
+
+	signer := newTokenSigner("secret")
+	now := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		token       func() string
+		purpose     tokenPurpose
+		expectedErr error
+	}{
+		{
+			name: "Valid token",
+			token: func() string {
+				return signer.sign(_purposeConfirm, "test@example.com", now.Add(time.Hour))
+			},
+			purpose:     _purposeConfirm,
+			expectedErr: nil,
+		},
+		{
+			name: "Token without expiration",
+			token: func() string {
+				return signer.sign(_purposeUnsubscribe, "test@example.com", time.Time{})
+			},
+			purpose:     _purposeUnsubscribe,
+			expectedErr: nil,
+		},
+		{
+			name: "Expired token",
+			token: func() string {
+				return signer.sign(_purposeConfirm, "test@example.com", now)
+			},
+			purpose:     _purposeConfirm,
+			expectedErr: ErrExpiredToken,
+		},
+		{
+			name: "Token for another purpose",
+			token: func() string {
+				return signer.sign(_purposeUnsubscribe, "test@example.com", time.Time{})
+			},
+			purpose:     _purposeConfirm,
+			expectedErr: ErrInvalidToken,
+		},
+		{
+			name: "Tampered payload",
+			token: func() string {
+				token := signer.sign(_purposeUnsubscribe, "test@example.com", time.Time{})
+				_, signature, _ := strings.Cut(token, _tokenSeparator)
+				payload := "unsubscribe\n0\nother@example.com"
+
+				return _encoding.EncodeToString([]byte(payload)) + _tokenSeparator + signature
+			},
+			purpose:     _purposeUnsubscribe,
+			expectedErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			email, err := signer.verify(tt.purpose, tt.token(), now)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "test@example.com", email)
+		})
+	}
+}
+
+func TestRandomSecret(t *testing.T) {
+	t.Parallel()
+
+	first, err := RandomSecret()
+	require.NoError(t, err)
+
+	second, err := RandomSecret()
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+}
@@ -1,47 +1,118 @@
 package httpcontroller
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"gses2-app/internal/core/port"
+	"gses2-app/internal/core/service/alert"
+	"gses2-app/internal/core/service/broadcast"
+	"gses2-app/internal/core/service/history"
+	"gses2-app/internal/core/service/mailbox"
+	"gses2-app/internal/core/service/schedule"
 	"gses2-app/internal/core/service/subscription"
 )
 
-type SenderService interface {
-	SendExchangeRate(rate port.Rate, subscribers ...port.User) error
+type OutboxService interface {
+	Batch(ctx context.Context, id string) (*port.Batch, error)
+}
+
+type BroadcastService interface {
+	Broadcast(ctx context.Context, idempotencyKey string) (batchID string, err error)
 }
 
 type RateService interface {
-	ExchangeRate() (rate port.Rate, err error)
+	ExchangeRate(
+		ctx context.Context,
+		pair port.CurrencyPair,
+	) (rate port.Rate, err error)
+}
+
+type HistoryService interface {
+	OHLC(
+		ctx context.Context,
+		pair port.CurrencyPair,
+		from, to time.Time,
+		interval time.Duration,
+	) ([]port.OHLC, error)
 }
 
 type SubscriptionService interface {
-	Subscribe(subscriber *port.User) error
-	Subscriptions() (subscribers []port.User, err error)
+	Subscribe(ctx context.Context, subscriber *port.User) error
+	Confirm(ctx context.Context, token string) error
+	Unsubscribe(ctx context.Context, token string) error
+	Preferences(ctx context.Context, token string) (*port.Preferences, error)
+	UpdatePreferences(ctx context.Context, token string, preferences port.Preferences) error
+	PreferencesEmail(token string) (string, error)
 }
 
+type ScheduleService interface {
+	Schedule(ctx context.Context) (*port.Schedule, error)
+}
+
+type AlertService interface {
+	Create(ctx context.Context, alert port.Alert) (*port.Alert, error)
+	Alerts(ctx context.Context, email string) ([]port.Alert, error)
+	Delete(ctx context.Context, email, id string) error
+}
+
+const (
+	_defaultHistoryPeriod   = 24 * time.Hour
+	_defaultHistoryInterval = time.Hour
+	_idempotencyKeyHeader   = "Idempotency-Key"
+	_sendEmailsPath         = "/api/sendEmails"
+)
+
+var (
+	ErrInvalidTime     = errors.New("invalid time, expected RFC 3339 format")
+	ErrInvalidInterval = errors.New("invalid interval, expected duration e.g. 1h")
+	ErrReservedKey     = errors.New("idempotency key is reserved for the scheduled broadcasts")
+)
+
 type AppController struct {
 	ExchangeRateService      RateService
+	RateHistoryService       HistoryService
 	EmailSubscriptionService SubscriptionService
-	EmailSenderService       SenderService
+	EmailOutboxService       OutboxService
+	RateBroadcastService     BroadcastService
+	BroadcastScheduleService ScheduleService
+	RateAlertService         AlertService
 }
 
 func NewAppController(
 	exchangeRateService RateService,
+	rateHistoryService HistoryService,
 	emailSubscriptionService SubscriptionService,
-	emailSenderService SenderService,
+	emailOutboxService OutboxService,
+	rateBroadcastService BroadcastService,
+	broadcastScheduleService ScheduleService,
+	rateAlertService AlertService,
 ) *AppController {
 	return &AppController{
 		ExchangeRateService:      exchangeRateService,
+		RateHistoryService:       rateHistoryService,
 		EmailSubscriptionService: emailSubscriptionService,
-		EmailSenderService:       emailSenderService,
+		EmailOutboxService:       emailOutboxService,
+		RateBroadcastService:     rateBroadcastService,
+		BroadcastScheduleService: broadcastScheduleService,
+		RateAlertService:         rateAlertService,
 	}
 }
 
 func (ac *AppController) GetRate(w http.ResponseWriter, r *http.Request) {
-	exchangeRate, err := ac.ExchangeRateService.ExchangeRate()
+	pair, err := currencyPairFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exchangeRate, err := ac.ExchangeRateService.ExchangeRate(r.Context(), pair)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -52,15 +123,79 @@ func (ac *AppController) GetRate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetRateHistory returns the OHLC rates of the pair within the time range
+// given by the "from" and "to" query parameters, grouped by "interval".
+// By default the last 24 hours are grouped by one hour
+func (ac *AppController) GetRateHistory(w http.ResponseWriter, r *http.Request) {
+	pair, err := currencyPairFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, to, interval, err := historyRangeFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rates, err := ac.RateHistoryService.OHLC(r.Context(), pair, from, to, interval)
+	if errors.Is(err, history.ErrHistoryStorage) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(rates); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SubscribeEmail subscribes the "email" in the "locale" or the locale
+// preferred by Accept-Language with the delivery preferences of the form,
+// the invalid emails are rejected with the validation error as JSON
 func (ac *AppController) SubscribeEmail(w http.ResponseWriter, r *http.Request) {
-	subscriber := &port.User{Email: r.FormValue("email")}
-	err := ac.EmailSubscriptionService.Subscribe(subscriber)
+	locale, err := localeFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	preferences, err := preferencesFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subscriber := &port.User{
+		Email:       r.FormValue("email"),
+		Locale:      locale,
+		Preferences: preferences,
+	}
+	err = ac.EmailSubscriptionService.Subscribe(r.Context(), subscriber)
+
+	var validationErr *mailbox.ValidationError
+	if errors.As(err, &validationErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(validationErr)
+		return
+	}
 
 	if errors.Is(err, subscription.ErrAlreadySubscribed) {
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
 
+	if errors.Is(err, subscription.ErrConfirmationTooSoon) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -69,28 +204,324 @@ func (ac *AppController) SubscribeEmail(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 }
 
-func (ac *AppController) SendEmails(w http.ResponseWriter, r *http.Request) {
-	exchangeRate, err := ac.ExchangeRateService.ExchangeRate()
+// ConfirmEmail confirms the subscription the "token" was issued for,
+// only the confirmed subscribers get the rate updates
+func (ac *AppController) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	err := ac.EmailSubscriptionService.Confirm(r.Context(), r.FormValue("token"))
+
+	if errors.Is(err, subscription.ErrInvalidToken) ||
+		errors.Is(err, subscription.ErrExpiredToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, subscription.ErrNotSubscribed) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// UnsubscribeEmail removes the subscriber the "token" was issued for,
+// it serves both the API and the one-click unsubscribe links
+func (ac *AppController) UnsubscribeEmail(w http.ResponseWriter, r *http.Request) {
+	err := ac.EmailSubscriptionService.Unsubscribe(r.Context(), r.FormValue("token"))
+
+	if errors.Is(err, subscription.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, subscription.ErrNotSubscribed) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetPreferences responds with the delivery preferences
+// of the subscriber the "token" was issued for
+func (ac *AppController) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	preferences, err := ac.EmailSubscriptionService.Preferences(
+		r.Context(),
+		r.FormValue("token"),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), preferencesErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(preferences); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// UpdatePreferences replaces the delivery preferences of the subscriber
+// the "token" was issued for with the ones of the form
+func (ac *AppController) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	preferences, err := preferencesFromRequest(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	subscribers, err := ac.EmailSubscriptionService.Subscriptions()
+	err = ac.EmailSubscriptionService.UpdatePreferences(
+		r.Context(),
+		r.FormValue("token"),
+		preferences,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), preferencesErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// SendEmails enqueues the emails with the current rates of the followed
+// pairs to the due subscribers and responds with the ID of their batch,
+// the emails are delivered in the background. The requests with the same
+// "Idempotency-Key" header enqueue the emails only once, the keys of the
+// scheduled broadcasts are rejected, so a request can't take their slot
+func (ac *AppController) SendEmails(w http.ResponseWriter, r *http.Request) {
+	idempotencyKey := r.Header.Get(_idempotencyKeyHeader)
+	if strings.HasPrefix(idempotencyKey, schedule.IdempotencyKeyPrefix) {
+		err := fmt.Errorf("%w: %q", ErrReservedKey, idempotencyKey)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	batchID, err := ac.RateBroadcastService.Broadcast(r.Context(), idempotencyKey)
+	if errors.Is(err, broadcast.ErrExchangeRate) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := url.Values{"batch": {batchID}}
+	w.Header().Set("Location", _sendEmailsPath+"?"+query.Encode())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	_ = json.NewEncoder(w).Encode(struct {
+		BatchID string `json:"batchId"`
+	}{BatchID: batchID})
+}
+
+// GetEmailBatch responds with the delivery state of the emails
+// of the batch given by the "batch" query parameter
+func (ac *AppController) GetEmailBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := ac.EmailOutboxService.Batch(r.Context(), r.URL.Query().Get("batch"))
+	if errors.Is(err, port.ErrBatchNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = ac.EmailSenderService.SendExchangeRate(
-		exchangeRate,
-		subscribers...,
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(batch); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetSchedule responds with the next slots of the scheduled
+// broadcasts and their previous runs
+func (ac *AppController) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, err := ac.BroadcastScheduleService.Schedule(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CreateAlert adds the alert of the subscriber the preferences "token" was
+// issued for from the "pair", "kind", "threshold", "window" and "hysteresis"
+// form values and responds with the created alert
+func (ac *AppController) CreateAlert(w http.ResponseWriter, r *http.Request) {
+	email, err := ac.EmailSubscriptionService.PreferencesEmail(r.FormValue("token"))
+	if err != nil {
+		http.Error(w, err.Error(), preferencesErrorStatus(err))
+		return
+	}
+
+	alertRule, err := port.ParseAlert(
+		r.FormValue("pair"),
+		r.FormValue("kind"),
+		r.FormValue("threshold"),
+		r.FormValue("window"),
+		r.FormValue("hysteresis"),
 	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	alertRule.Email = email
 
+	created, err := ac.RateAlertService.Create(r.Context(), alertRule)
+	if err != nil {
+		http.Error(w, err.Error(), alertErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+// GetAlerts responds with the alerts of the subscriber
+// the preferences "token" was issued for
+func (ac *AppController) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	email, err := ac.EmailSubscriptionService.PreferencesEmail(r.FormValue("token"))
+	if err != nil {
+		http.Error(w, err.Error(), preferencesErrorStatus(err))
+		return
+	}
+
+	alerts, err := ac.RateAlertService.Alerts(r.Context(), email)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(alerts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DeleteAlert removes the alert with the "id" of the subscriber
+// the preferences "token" was issued for
+func (ac *AppController) DeleteAlert(w http.ResponseWriter, r *http.Request) {
+	email, err := ac.EmailSubscriptionService.PreferencesEmail(r.FormValue("token"))
+	if err != nil {
+		http.Error(w, err.Error(), preferencesErrorStatus(err))
+		return
+	}
+
+	err = ac.RateAlertService.Delete(r.Context(), email, r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), alertErrorStatus(err))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
+
+// localeFromRequest returns the locale from the form, the invalid
+// Accept-Language header is ignored as the browsers send it on their own
+func localeFromRequest(r *http.Request) (string, error) {
+	if locale := r.FormValue("locale"); locale != "" {
+		return port.ParseLocale(locale)
+	}
+
+	return port.PreferredLocale(r.Header.Get("Accept-Language")), nil
+}
+
+// preferencesFromRequest reads the delivery preferences from the "pairs",
+// "frequency", "timezone" and "quietHours" form values, e.g. "BTC/UAH,ETH/USD",
+// "daily", "Europe/Kyiv" and "22:00-07:00", the omitted ones are the defaults
+func preferencesFromRequest(r *http.Request) (port.Preferences, error) {
+	return port.ParsePreferences(
+		r.FormValue("pairs"),
+		r.FormValue("frequency"),
+		r.FormValue("timezone"),
+		r.FormValue("quietHours"),
+	)
+}
+
+// preferencesErrorStatus returns the status of the error of the preferences
+func preferencesErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, subscription.ErrInvalidToken):
+		return http.StatusBadRequest
+	case errors.Is(err, subscription.ErrNotSubscribed):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// alertErrorStatus returns the status of the error of the alerts
+func alertErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, alert.ErrNotSubscribed), errors.Is(err, port.ErrAlertNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, alert.ErrTooManyAlerts):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// currencyPairFromRequest reads the pair from the "base" and "quote" query
+// parameters, each of them falls back to the default pair when omitted
+func currencyPairFromRequest(r *http.Request) (port.CurrencyPair, error) {
+	base := r.URL.Query().Get("base")
+	if base == "" {
+		base = port.DefaultCurrencyPair.Base
+	}
+
+	quote := r.URL.Query().Get("quote")
+	if quote == "" {
+		quote = port.DefaultCurrencyPair.Quote
+	}
+
+	return port.NewCurrencyPair(base, quote)
+}
+
+func historyRangeFromRequest(r *http.Request) (
+	from, to time.Time,
+	interval time.Duration,
+	err error,
+) {
+	query := r.URL.Query()
+
+	to = time.Now().UTC()
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, interval, ErrInvalidTime
+		}
+	}
+
+	from = to.Add(-_defaultHistoryPeriod)
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, interval, ErrInvalidTime
+		}
+	}
+
+	interval = _defaultHistoryInterval
+	if value := query.Get("interval"); value != "" {
+		if interval, err = time.ParseDuration(value); err != nil {
+			return from, to, interval, ErrInvalidInterval
+		}
+	}
+
+	return from, to, interval, nil
+}
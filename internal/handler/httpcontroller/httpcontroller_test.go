@@ -1,18 +1,34 @@
 package httpcontroller
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"gses2-app/internal/core/port"
+	"gses2-app/internal/core/service/alert"
+	"gses2-app/internal/core/service/broadcast"
+	"gses2-app/internal/core/service/history"
+	"gses2-app/internal/core/service/mailbox"
 	"gses2-app/internal/core/service/subscription"
 )
 
+var _testRate = port.Rate{
+	Amount:    port.MustParseDecimal("1227057.5"),
+	Pair:      port.DefaultCurrencyPair,
+	Provider:  "TestRateProvider",
+	FetchedAt: time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC),
+}
+
+const _testRateJSON = `{"amount":"1227057.5","pair":{"base":"BTC","quote":"UAH"},` +
+	`"provider":"TestRateProvider","fetchedAt":"2023-07-01T12:00:00Z"}`
+
 var (
 	errSubscriptions = errors.New("get subscriptions error")
 	errExchangeRate  = errors.New("exchange rate error")
@@ -22,62 +38,215 @@ var (
 type StubExchangeRateService struct {
 	rate port.Rate
 	err  error
+	pair port.CurrencyPair
 }
 
-func (m *StubExchangeRateService) ExchangeRate() (port.Rate, error) {
+func (m *StubExchangeRateService) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	m.pair = pair
 	return m.rate, m.err
 }
 
+type StubHistoryService struct {
+	ohlc     []port.OHLC
+	err      error
+	from     time.Time
+	to       time.Time
+	interval time.Duration
+}
+
+func (m *StubHistoryService) OHLC(
+	ctx context.Context,
+	pair port.CurrencyPair,
+	from, to time.Time,
+	interval time.Duration,
+) ([]port.OHLC, error) {
+	m.from, m.to, m.interval = from, to, interval
+	return m.ohlc, m.err
+}
+
 type StubEmailSubscriptionService struct {
-	subscribeErr     error
-	subscriptions    []port.User
-	subscriptionsErr error
-	isSubscribedErr  error
+	subscribeErr    error
+	confirmErr      error
+	unsubscribeErr  error
+	preferencesErr  error
+	token           string
+	preferences     *port.Preferences
+	email           string
+	subscriber      *port.User
+	isSubscribedErr error
+}
+
+func (m *StubEmailSubscriptionService) Unsubscribe(
+	ctx context.Context,
+	token string,
+) error {
+	m.token = token
+	return m.unsubscribeErr
+}
+
+func (m *StubEmailSubscriptionService) Confirm(
+	ctx context.Context,
+	token string,
+) error {
+	m.token = token
+	return m.confirmErr
 }
 
-func (m *StubEmailSubscriptionService) Subscribe(subscriber *port.User) error {
+func (m *StubEmailSubscriptionService) Subscribe(
+	ctx context.Context,
+	subscriber *port.User,
+) error {
+	m.subscriber = subscriber
 	return m.subscribeErr
 }
 
-func (m *StubEmailSubscriptionService) Subscriptions() ([]port.User, error) {
-	if m.subscriptionsErr != nil {
-		return nil, m.subscriptionsErr
+func (m *StubEmailSubscriptionService) Preferences(
+	ctx context.Context,
+	token string,
+) (*port.Preferences, error) {
+	m.token = token
+	if m.preferencesErr != nil {
+		return nil, m.preferencesErr
 	}
-	return m.subscriptions, nil
+	return m.preferences, nil
+}
+
+func (m *StubEmailSubscriptionService) UpdatePreferences(
+	ctx context.Context,
+	token string,
+	preferences port.Preferences,
+) error {
+	m.token = token
+	m.preferences = &preferences
+	return m.preferencesErr
+}
+
+func (m *StubEmailSubscriptionService) PreferencesEmail(token string) (string, error) {
+	m.token = token
+	if m.preferencesErr != nil {
+		return "", m.preferencesErr
+	}
+	return m.email, nil
 }
 
 func (m *StubEmailSubscriptionService) IsSubscribed(subscriber port.User) (bool, error) {
 	return true, m.isSubscribedErr
 }
 
-type StubEmailSenderService struct {
-	sendErr error
+type StubEmailOutboxService struct {
+	batch    *port.Batch
+	batchErr error
 }
 
-func (m *StubEmailSenderService) SendExchangeRate(
-	rate port.Rate,
-	subscribers ...port.User,
-) error {
-	return m.sendErr
+func (m *StubEmailOutboxService) Batch(ctx context.Context, id string) (*port.Batch, error) {
+	if m.batchErr != nil {
+		return nil, m.batchErr
+	}
+
+	return m.batch, nil
+}
+
+type StubBroadcastService struct {
+	idempotencyKey string
+	err            error
+}
+
+func (m *StubBroadcastService) Broadcast(
+	ctx context.Context,
+	idempotencyKey string,
+) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+
+	m.idempotencyKey = idempotencyKey
+
+	return "batch-id", nil
+}
+
+type StubScheduleService struct {
+	schedule *port.Schedule
+	err      error
+}
+
+func (m *StubScheduleService) Schedule(ctx context.Context) (*port.Schedule, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return m.schedule, nil
+}
+
+type StubAlertService struct {
+	alert     *port.Alert
+	alerts    []port.Alert
+	email     string
+	deletedID string
+	err       error
+}
+
+func (m *StubAlertService) Create(ctx context.Context, alert port.Alert) (*port.Alert, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	alert.ID = "alert-id"
+	m.alert = &alert
+
+	return m.alert, nil
+}
+
+func (m *StubAlertService) Alerts(ctx context.Context, email string) ([]port.Alert, error) {
+	m.email = email
+	return m.alerts, m.err
+}
+
+func (m *StubAlertService) Delete(ctx context.Context, email, id string) error {
+	m.email = email
+	m.deletedID = id
+	return m.err
 }
 
 func TestGetRate(t *testing.T) {
 	tests := []struct {
 		name           string
+		url            string
 		service        *StubExchangeRateService
 		expectedStatus int
 		expectedBody   string
+		expectedPair   port.CurrencyPair
 	}{
 		{
 			name:           "Exchange rate",
-			service:        &StubExchangeRateService{rate: 1.5},
+			url:            "/rate",
+			service:        &StubExchangeRateService{rate: _testRate},
+			expectedStatus: http.StatusOK,
+			expectedBody:   _testRateJSON,
+			expectedPair:   port.DefaultCurrencyPair,
+		},
+		{
+			name:           "Exchange rate for a currency pair",
+			url:            "/rate?base=eth&quote=usd",
+			service:        &StubExchangeRateService{rate: _testRate},
 			expectedStatus: http.StatusOK,
-			expectedBody:   "1.5",
+			expectedBody:   _testRateJSON,
+			expectedPair:   port.CurrencyPair{Base: "ETH", Quote: "USD"},
+		},
+		{
+			name:           "Invalid currency pair",
+			url:            "/rate?base=e-th&quote=usd",
+			service:        &StubExchangeRateService{rate: _testRate},
+			expectedStatus: http.StatusBadRequest,
 		},
 		{
 			name:           "Exchange rate error",
+			url:            "/rate",
 			service:        &StubExchangeRateService{err: errExchangeRate},
 			expectedStatus: http.StatusBadRequest,
+			expectedPair:   port.DefaultCurrencyPair,
 		},
 	}
 
@@ -85,11 +254,15 @@ func TestGetRate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			controller := NewAppController(
 				tt.service,
+				&StubHistoryService{},
 				&StubEmailSubscriptionService{},
-				&StubEmailSenderService{},
+				&StubEmailOutboxService{},
+				&StubBroadcastService{},
+				&StubScheduleService{},
+				&StubAlertService{},
 			)
 
-			req, err := http.NewRequest(http.MethodGet, "/rate", nil)
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -118,21 +291,144 @@ func TestGetRate(t *testing.T) {
 					tt.expectedStatus,
 				)
 			}
+
+			require.Equal(t, tt.expectedPair, tt.service.pair)
+		})
+	}
+}
+
+func TestGetRateHistory(t *testing.T) {
+	from := time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, time.July, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		url              string
+		service          *StubHistoryService
+		expectedStatus   int
+		expectedInterval time.Duration
+	}{
+		{
+			name:             "Rate history",
+			url:              "/rate/history?from=2023-07-01T00:00:00Z&to=2023-07-02T00:00:00Z&interval=15m",
+			service:          &StubHistoryService{},
+			expectedStatus:   http.StatusOK,
+			expectedInterval: 15 * time.Minute,
+		},
+		{
+			name:           "Invalid time",
+			url:            "/rate/history?from=yesterday",
+			service:        &StubHistoryService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid interval",
+			url:            "/rate/history?interval=hourly",
+			service:        &StubHistoryService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid time range",
+			url:            "/rate/history?from=2023-07-02T00:00:00Z&to=2023-07-01T00:00:00Z",
+			service:        &StubHistoryService{err: history.ErrInvalidTimeRange},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "History storage error",
+			url:            "/rate/history",
+			service:        &StubHistoryService{err: history.ErrHistoryStorage},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewAppController(
+				&StubExchangeRateService{},
+				tt.service,
+				&StubEmailSubscriptionService{},
+				&StubEmailOutboxService{},
+				&StubBroadcastService{},
+				&StubScheduleService{},
+				&StubAlertService{},
+			)
+
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(controller.GetRateHistory)
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				require.Equal(t, from, tt.service.from)
+				require.Equal(t, to, tt.service.to)
+				require.Equal(t, tt.expectedInterval, tt.service.interval)
+			}
 		})
 	}
 }
 
 func TestSubscribeEmail(t *testing.T) {
 	tests := []struct {
-		name           string
-		service        *StubEmailSubscriptionService
-		expectedStatus int
+		name                string
+		service             *StubEmailSubscriptionService
+		form                string
+		acceptLanguage      string
+		expectedStatus      int
+		expectedBody        string
+		expectedLocale      string
+		expectedPreferences port.Preferences
 	}{
 		{
 			name:           "Subscribe email",
 			service:        &StubEmailSubscriptionService{},
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:           "Subscribe with locale",
+			service:        &StubEmailSubscriptionService{},
+			form:           "&locale=uk_ua",
+			acceptLanguage: "en",
+			expectedStatus: http.StatusOK,
+			expectedLocale: "uk-UA",
+		},
+		{
+			name:           "Subscribe with Accept-Language",
+			service:        &StubEmailSubscriptionService{},
+			acceptLanguage: "en;q=0.5, uk",
+			expectedStatus: http.StatusOK,
+			expectedLocale: "uk",
+		},
+		{
+			name:           "Subscribe with preferences",
+			service:        &StubEmailSubscriptionService{},
+			form:           "&pairs=ETH/USD&frequency=daily&timezone=UTC&quietHours=22:00-07:00",
+			expectedStatus: http.StatusOK,
+			expectedPreferences: port.Preferences{
+				Pairs:      []port.CurrencyPair{{Base: "ETH", Quote: "USD"}},
+				Frequency:  port.FrequencyDaily,
+				Timezone:   "UTC",
+				QuietHours: port.QuietHours{Start: 22 * 60, End: 7 * 60},
+			},
+		},
+		{
+			name:           "Invalid preferences",
+			service:        &StubEmailSubscriptionService{},
+			form:           "&quietHours=night",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid locale",
+			service:        &StubEmailSubscriptionService{},
+			form:           "&locale=ukrainian",
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name: "Subscription error",
 			service: &StubEmailSubscriptionService{
@@ -140,20 +436,48 @@ func TestSubscribeEmail(t *testing.T) {
 			},
 			expectedStatus: http.StatusConflict,
 		},
+		{
+			name: "Confirmation sent recently",
+			service: &StubEmailSubscriptionService{
+				subscribeErr: subscription.ErrConfirmationTooSoon,
+			},
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name: "Invalid email",
+			service: &StubEmailSubscriptionService{
+				subscribeErr: &mailbox.ValidationError{
+					Email:  "test@example.com",
+					Reason: mailbox.ReasonDeniedDomain,
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"email":"test@example.com","reason":"domain is denied"}`,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			controller := NewAppController(
 				&StubExchangeRateService{},
+				&StubHistoryService{},
 				tt.service,
-				&StubEmailSenderService{},
+				&StubEmailOutboxService{},
+				&StubBroadcastService{},
+				&StubScheduleService{},
+				&StubAlertService{},
 			)
 
-			req, err := http.NewRequest(http.MethodPost, "/subscribe", strings.NewReader("email=test@example.com"))
+			req, err := http.NewRequest(
+				http.MethodPost,
+				"/subscribe",
+				strings.NewReader("email=test@example.com"+tt.form),
+			)
 			if err != nil {
 				t.Fatal(err)
 			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
 
 			rr := httptest.NewRecorder()
 
@@ -168,55 +492,351 @@ func TestSubscribeEmail(t *testing.T) {
 				rr.Code,
 				tt.expectedStatus,
 			)
+
+			if tt.expectedBody != "" {
+				require.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+
+			if tt.service.subscriber != nil {
+				require.Equal(t, "test@example.com", tt.service.subscriber.Email)
+				require.Equal(t, tt.expectedLocale, tt.service.subscriber.Locale)
+				require.Equal(t, tt.expectedPreferences, tt.service.subscriber.Preferences)
+			}
 		})
 	}
 }
 
-func TestSendEmails(t *testing.T) {
+func TestConfirmEmail(t *testing.T) {
+	tests := []struct {
+		name           string
+		service        *StubEmailSubscriptionService
+		expectedStatus int
+	}{
+		{
+			name:           "Confirm",
+			service:        &StubEmailSubscriptionService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Invalid token",
+			service: &StubEmailSubscriptionService{
+				confirmErr: subscription.ErrInvalidToken,
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Expired token",
+			service: &StubEmailSubscriptionService{
+				confirmErr: subscription.ErrExpiredToken,
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Purged subscription",
+			service: &StubEmailSubscriptionService{
+				confirmErr: subscription.ErrNotSubscribed,
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Repository error",
+			service: &StubEmailSubscriptionService{
+				confirmErr: subscription.ErrUserRepository,
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewAppController(
+				&StubExchangeRateService{},
+				&StubHistoryService{},
+				tt.service,
+				&StubEmailOutboxService{},
+				&StubBroadcastService{},
+				&StubScheduleService{},
+				&StubAlertService{},
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/confirm?token=abc", nil)
+			rr := httptest.NewRecorder()
+			controller.ConfirmEmail(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			require.Equal(t, "abc", tt.service.token)
+		})
+	}
+}
+
+func TestUnsubscribeEmail(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		service        *StubEmailSubscriptionService
+		expectedStatus int
+	}{
+		{
+			name:           "Unsubscribe with link",
+			method:         http.MethodGet,
+			url:            "/api/unsubscribe?token=abc",
+			service:        &StubEmailSubscriptionService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "One-click unsubscribe",
+			method:         http.MethodPost,
+			url:            "/api/unsubscribe?token=abc",
+			service:        &StubEmailSubscriptionService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Delete subscription",
+			method:         http.MethodDelete,
+			url:            "/api/subscribe?token=abc",
+			service:        &StubEmailSubscriptionService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Invalid token",
+			method: http.MethodGet,
+			url:    "/api/unsubscribe?token=abc",
+			service: &StubEmailSubscriptionService{
+				unsubscribeErr: subscription.ErrInvalidToken,
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Not subscribed",
+			method: http.MethodGet,
+			url:    "/api/unsubscribe?token=abc",
+			service: &StubEmailSubscriptionService{
+				unsubscribeErr: subscription.ErrNotSubscribed,
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Repository error",
+			method: http.MethodGet,
+			url:    "/api/unsubscribe?token=abc",
+			service: &StubEmailSubscriptionService{
+				unsubscribeErr: subscription.ErrUserRepository,
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewAppController(
+				&StubExchangeRateService{},
+				&StubHistoryService{},
+				tt.service,
+				&StubEmailOutboxService{},
+				&StubBroadcastService{},
+				&StubScheduleService{},
+				&StubAlertService{},
+			)
+
+			req := httptest.NewRequest(
+				tt.method,
+				tt.url,
+				strings.NewReader("List-Unsubscribe=One-Click"),
+			)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			controller.UnsubscribeEmail(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			require.Equal(t, "abc", tt.service.token)
+		})
+	}
+}
+
+func TestGetPreferences(t *testing.T) {
+	tests := []struct {
+		name           string
+		service        *StubEmailSubscriptionService
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "Preferences",
+			service: &StubEmailSubscriptionService{
+				preferences: &port.Preferences{
+					Pairs:      []port.CurrencyPair{port.DefaultCurrencyPair},
+					Frequency:  port.FrequencyDaily,
+					Timezone:   "Europe/Kyiv",
+					QuietHours: port.QuietHours{Start: 22 * 60, End: 7 * 60},
+				},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"pairs":[{"base":"BTC","quote":"UAH"}],"frequency":"daily",` +
+				`"timezone":"Europe/Kyiv","quietHours":"22:00-07:00"}`,
+		},
+		{
+			name: "Invalid token",
+			service: &StubEmailSubscriptionService{
+				preferencesErr: subscription.ErrInvalidToken,
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Not subscribed",
+			service: &StubEmailSubscriptionService{
+				preferencesErr: subscription.ErrNotSubscribed,
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Repository error",
+			service: &StubEmailSubscriptionService{
+				preferencesErr: subscription.ErrUserRepository,
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewAppController(
+				&StubExchangeRateService{},
+				&StubHistoryService{},
+				tt.service,
+				&StubEmailOutboxService{},
+				&StubBroadcastService{},
+				&StubScheduleService{},
+				&StubAlertService{},
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/preferences?token=abc", nil)
+			rr := httptest.NewRecorder()
+			controller.GetPreferences(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			require.Equal(t, "abc", tt.service.token)
+
+			if tt.expectedBody != "" {
+				require.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestUpdatePreferences(t *testing.T) {
 	tests := []struct {
 		name                string
-		exchangeRateService *StubExchangeRateService
-		subscriptionService *StubEmailSubscriptionService
-		emailSenderService  *StubEmailSenderService
+		service             *StubEmailSubscriptionService
+		form                string
 		expectedStatus      int
+		expectedPreferences *port.Preferences
 	}{
 		{
-			name: "Send emails",
-			exchangeRateService: &StubExchangeRateService{
-				rate: 1.5,
+			name:           "Update preferences",
+			service:        &StubEmailSubscriptionService{},
+			form:           "pairs=BTC/UAH,eth/usd&frequency=weekly&timezone=UTC&quietHours=23:00-06:00",
+			expectedStatus: http.StatusOK,
+			expectedPreferences: &port.Preferences{
+				Pairs: []port.CurrencyPair{
+					port.DefaultCurrencyPair,
+					{Base: "ETH", Quote: "USD"},
+				},
+				Frequency:  port.FrequencyWeekly,
+				Timezone:   "UTC",
+				QuietHours: port.QuietHours{Start: 23 * 60, End: 6 * 60},
 			},
-			subscriptionService: &StubEmailSubscriptionService{
-				subscriptions: convertEmailsToUsers(
-					[]string{"subscriber1@example.com", "subscriber2@example.com"},
-				),
+		},
+		{
+			name:                "Reset preferences",
+			service:             &StubEmailSubscriptionService{},
+			expectedStatus:      http.StatusOK,
+			expectedPreferences: &port.Preferences{},
+		},
+		{
+			name:           "Invalid preferences",
+			service:        &StubEmailSubscriptionService{},
+			form:           "frequency=yearly",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid token",
+			service: &StubEmailSubscriptionService{
+				preferencesErr: subscription.ErrInvalidToken,
 			},
-			emailSenderService: &StubEmailSenderService{},
-			expectedStatus:     http.StatusOK,
+			expectedStatus:      http.StatusBadRequest,
+			expectedPreferences: &port.Preferences{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewAppController(
+				&StubExchangeRateService{},
+				&StubHistoryService{},
+				tt.service,
+				&StubEmailOutboxService{},
+				&StubBroadcastService{},
+				&StubScheduleService{},
+				&StubAlertService{},
+			)
+
+			req := httptest.NewRequest(
+				http.MethodPut,
+				"/api/preferences?token=abc",
+				strings.NewReader(tt.form),
+			)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			controller.UpdatePreferences(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			require.Equal(t, tt.expectedPreferences, tt.service.preferences)
+		})
+	}
+}
+
+func TestSendEmails(t *testing.T) {
+	tests := []struct {
+		name             string
+		broadcastService *StubBroadcastService
+		idempotencyKey   string
+		expectedStatus   int
+		expectedBody     string
+	}{
+		{
+			name:             "Send emails",
+			broadcastService: &StubBroadcastService{},
+			idempotencyKey:   "key",
+			expectedStatus:   http.StatusAccepted,
+			expectedBody:     `{"batchId": "batch-id"}`,
+		},
+		{
+			name:             "Key of the scheduled broadcast",
+			broadcastService: &StubBroadcastService{},
+			idempotencyKey:   "schedule/2023-07-01T09:00:00Z",
+			expectedStatus:   http.StatusBadRequest,
 		},
 		{
 			name: "Exchange rate error",
-			exchangeRateService: &StubExchangeRateService{
-				err: errExchangeRate,
+			broadcastService: &StubBroadcastService{
+				err: errors.Join(errExchangeRate, broadcast.ErrExchangeRate),
 			},
-			subscriptionService: &StubEmailSubscriptionService{},
-			emailSenderService:  &StubEmailSenderService{},
-			expectedStatus:      http.StatusBadRequest,
+			expectedStatus: http.StatusBadRequest,
 		},
 		{
-			name:                "Subscription service error",
-			exchangeRateService: &StubExchangeRateService{},
-			subscriptionService: &StubEmailSubscriptionService{
-				subscriptionsErr: errSubscriptions,
+			name: "Subscription service error",
+			broadcastService: &StubBroadcastService{
+				err: errors.Join(errSubscriptions, broadcast.ErrSubscriptions),
 			},
-			emailSenderService: &StubEmailSenderService{},
-			expectedStatus:     http.StatusInternalServerError,
+			expectedStatus: http.StatusInternalServerError,
 		},
 		{
-			name:                "Email sender service error",
-			exchangeRateService: &StubExchangeRateService{},
-			subscriptionService: &StubEmailSubscriptionService{},
-			emailSenderService: &StubEmailSenderService{
-				sendErr: errSendEmail,
+			name: "Email outbox service error",
+			broadcastService: &StubBroadcastService{
+				err: errSendEmail,
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
@@ -225,15 +845,20 @@ func TestSendEmails(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			controller := NewAppController(
-				tt.exchangeRateService,
-				tt.subscriptionService,
-				tt.emailSenderService,
+				&StubExchangeRateService{},
+				&StubHistoryService{},
+				&StubEmailSubscriptionService{},
+				&StubEmailOutboxService{},
+				tt.broadcastService,
+				&StubScheduleService{},
+				&StubAlertService{},
 			)
 
 			req, err := http.NewRequest(http.MethodPost, "/send", nil)
 			if err != nil {
 				t.Fatal(err)
 			}
+			req.Header.Set("Idempotency-Key", tt.idempotencyKey)
 
 			rr := httptest.NewRecorder()
 
@@ -248,10 +873,405 @@ func TestSendEmails(t *testing.T) {
 				rr.Code,
 				tt.expectedStatus,
 			)
+
+			if tt.expectedBody != "" {
+				require.JSONEq(t, tt.expectedBody, rr.Body.String())
+				require.Equal(t, "/api/sendEmails?batch=batch-id", rr.Header().Get("Location"))
+				require.Equal(t, tt.idempotencyKey, tt.broadcastService.idempotencyKey)
+			}
 		})
 	}
 }
 
+func TestGetEmailBatch(t *testing.T) {
+	tests := []struct {
+		name               string
+		emailOutboxService *StubEmailOutboxService
+		expectedStatus     int
+		expectedBody       string
+	}{
+		{
+			name: "Batch",
+			emailOutboxService: &StubEmailOutboxService{
+				batch: &port.Batch{
+					ID:      "batch-id",
+					Summary: port.BatchSummary{Total: 1, Delivered: 1},
+					Jobs:    []port.Job{},
+				},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"id": "batch-id",
+				"createdAt": "0001-01-01T00:00:00Z",
+				"summary": {"total": 1, "queued": 0, "delivered": 1, "dead": 0},
+				"jobs": []
+			}`,
+		},
+		{
+			name: "Batch not found",
+			emailOutboxService: &StubEmailOutboxService{
+				batchErr: port.ErrBatchNotFound,
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Email outbox service error",
+			emailOutboxService: &StubEmailOutboxService{
+				batchErr: errSendEmail,
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewAppController(
+				&StubExchangeRateService{},
+				&StubHistoryService{},
+				&StubEmailSubscriptionService{},
+				tt.emailOutboxService,
+				&StubBroadcastService{},
+				&StubScheduleService{},
+				&StubAlertService{},
+			)
+
+			req, err := http.NewRequest(http.MethodGet, "/send?batch=batch-id", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(controller.GetEmailBatch)
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedBody != "" {
+				require.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetSchedule(t *testing.T) {
+	slot := time.Date(2023, time.July, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		scheduleService *StubScheduleService
+		expectedStatus  int
+		expectedBody    string
+	}{
+		{
+			name: "Schedule",
+			scheduleService: &StubScheduleService{
+				schedule: &port.Schedule{
+					Enabled:  true,
+					Cron:     "0 9 * * *",
+					Timezone: "UTC",
+					NextRuns: []time.Time{slot.Add(24 * time.Hour)},
+					PreviousRuns: []port.ScheduleRun{
+						{Slot: slot, StartedAt: slot, Attempts: 1, BatchID: "batch-id"},
+					},
+				},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"enabled": true,
+				"cron": "0 9 * * *",
+				"timezone": "UTC",
+				"nextRuns": ["2023-07-02T09:00:00Z"],
+				"previousRuns": [{
+					"slot": "2023-07-01T09:00:00Z",
+					"startedAt": "2023-07-01T09:00:00Z",
+					"attempts": 1,
+					"batchId": "batch-id"
+				}]
+			}`,
+		},
+		{
+			name:            "Disabled schedule",
+			scheduleService: &StubScheduleService{schedule: &port.Schedule{}},
+			expectedStatus:  http.StatusOK,
+			expectedBody:    `{"enabled": false}`,
+		},
+		{
+			name:            "Schedule service error",
+			scheduleService: &StubScheduleService{err: errSendEmail},
+			expectedStatus:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewAppController(
+				&StubExchangeRateService{},
+				&StubHistoryService{},
+				&StubEmailSubscriptionService{},
+				&StubEmailOutboxService{},
+				&StubBroadcastService{},
+				tt.scheduleService,
+				&StubAlertService{},
+			)
+
+			req, err := http.NewRequest(http.MethodGet, "/api/schedule", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(controller.GetSchedule)
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedBody != "" {
+				require.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateAlert(t *testing.T) {
+	tests := []struct {
+		name           string
+		service        *StubAlertService
+		tokenErr       error
+		form           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Level alert",
+			service:        &StubAlertService{},
+			form:           "kind=above&threshold=1500000",
+			expectedStatus: http.StatusCreated,
+			expectedBody: `{"id":"alert-id","email":"test@example.com",` +
+				`"pair":{"base":"BTC","quote":"UAH"},"kind":"above","threshold":"1500000",` +
+				`"hysteresis":0.5,"triggered":false,"triggeredAt":"0001-01-01T00:00:00Z",` +
+				`"createdAt":"0001-01-01T00:00:00Z"}`,
+		},
+		{
+			name:           "Change alert",
+			service:        &StubAlertService{},
+			form:           "pair=ETH/USD&kind=change&threshold=5&window=1h&hysteresis=1",
+			expectedStatus: http.StatusCreated,
+			expectedBody: `{"id":"alert-id","email":"test@example.com",` +
+				`"pair":{"base":"ETH","quote":"USD"},"kind":"change","threshold":"5","window":"1h",` +
+				`"hysteresis":1,"triggered":false,"triggeredAt":"0001-01-01T00:00:00Z",` +
+				`"createdAt":"0001-01-01T00:00:00Z"}`,
+		},
+		{
+			name:           "Invalid alert",
+			service:        &StubAlertService{},
+			form:           "kind=cross&threshold=1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Not subscribed",
+			service:        &StubAlertService{err: alert.ErrNotSubscribed},
+			form:           "kind=below&threshold=1",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Too many alerts",
+			service:        &StubAlertService{err: alert.ErrTooManyAlerts},
+			form:           "kind=below&threshold=1",
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Repository error",
+			service:        &StubAlertService{err: alert.ErrAlertRepository},
+			form:           "kind=below&threshold=1",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "Invalid token",
+			service:        &StubAlertService{},
+			tokenErr:       subscription.ErrInvalidToken,
+			form:           "kind=below&threshold=1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Email of the form is ignored",
+			service:        &StubAlertService{},
+			form:           "email=other@example.com&kind=above&threshold=1",
+			expectedStatus: http.StatusCreated,
+			expectedBody: `{"id":"alert-id","email":"test@example.com",` +
+				`"pair":{"base":"BTC","quote":"UAH"},"kind":"above","threshold":"1",` +
+				`"hysteresis":0.5,"triggered":false,"triggeredAt":"0001-01-01T00:00:00Z",` +
+				`"createdAt":"0001-01-01T00:00:00Z"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := newAlertController(tt.service, tt.tokenErr)
+
+			form := tt.form + "&token=token"
+
+			req := httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			controller.CreateAlert(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedBody != "" {
+				require.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetAlerts(t *testing.T) {
+	tests := []struct {
+		name           string
+		service        *StubAlertService
+		tokenErr       error
+		expectedStatus int
+		expectedBody   string
+		expectedEmail  string
+	}{
+		{
+			name: "Alerts",
+			service: &StubAlertService{alerts: []port.Alert{{
+				ID:         "alert-id",
+				Email:      "test@example.com",
+				Pair:       port.DefaultCurrencyPair,
+				Kind:       port.AlertBelow,
+				Threshold:  port.MustParseDecimal("1000000"),
+				Hysteresis: 0.5,
+				Triggered:  true,
+				TriggeredAt: time.Date(
+					2023, time.July, 1, 12, 0, 0, 0, time.UTC,
+				),
+				CreatedAt: time.Date(2023, time.July, 1, 9, 0, 0, 0, time.UTC),
+			}}},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[{"id":"alert-id","email":"test@example.com",` +
+				`"pair":{"base":"BTC","quote":"UAH"},"kind":"below","threshold":"1000000",` +
+				`"hysteresis":0.5,"triggered":true,"triggeredAt":"2023-07-01T12:00:00Z",` +
+				`"createdAt":"2023-07-01T09:00:00Z"}]`,
+			expectedEmail: "test@example.com",
+		},
+		{
+			name:           "No alerts",
+			service:        &StubAlertService{alerts: []port.Alert{}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+			expectedEmail:  "test@example.com",
+		},
+		{
+			name:           "Repository error",
+			service:        &StubAlertService{err: alert.ErrAlertRepository},
+			expectedStatus: http.StatusInternalServerError,
+			expectedEmail:  "test@example.com",
+		},
+		{
+			name:           "Invalid token",
+			service:        &StubAlertService{},
+			tokenErr:       subscription.ErrInvalidToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := newAlertController(tt.service, tt.tokenErr)
+
+			req := httptest.NewRequest(
+				http.MethodGet,
+				"/api/alerts?email=other@example.com&token=token",
+				nil,
+			)
+			rr := httptest.NewRecorder()
+			controller.GetAlerts(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			require.Equal(t, tt.expectedEmail, tt.service.email)
+
+			if tt.expectedBody != "" {
+				require.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestDeleteAlert(t *testing.T) {
+	tests := []struct {
+		name              string
+		service           *StubAlertService
+		tokenErr          error
+		expectedStatus    int
+		expectedEmail     string
+		expectedDeletedID string
+	}{
+		{
+			name:              "Deleted",
+			service:           &StubAlertService{},
+			expectedStatus:    http.StatusOK,
+			expectedEmail:     "test@example.com",
+			expectedDeletedID: "alert-id",
+		},
+		{
+			name:              "Not found",
+			service:           &StubAlertService{err: port.ErrAlertNotFound},
+			expectedStatus:    http.StatusNotFound,
+			expectedEmail:     "test@example.com",
+			expectedDeletedID: "alert-id",
+		},
+		{
+			name:              "Repository error",
+			service:           &StubAlertService{err: alert.ErrAlertRepository},
+			expectedStatus:    http.StatusInternalServerError,
+			expectedEmail:     "test@example.com",
+			expectedDeletedID: "alert-id",
+		},
+		{
+			name:           "Invalid token",
+			service:        &StubAlertService{},
+			tokenErr:       subscription.ErrInvalidToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := newAlertController(tt.service, tt.tokenErr)
+
+			req := httptest.NewRequest(
+				http.MethodDelete,
+				"/api/alerts?id=alert-id&token=token",
+				nil,
+			)
+			rr := httptest.NewRecorder()
+			controller.DeleteAlert(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			require.Equal(t, tt.expectedEmail, tt.service.email)
+			require.Equal(t, tt.expectedDeletedID, tt.service.deletedID)
+		})
+	}
+}
+
+// newAlertController returns the controller with the alert service, the
+// preferences token is of test@example.com unless there is the token error
+func newAlertController(service AlertService, tokenErr error) *AppController {
+	return NewAppController(
+		&StubExchangeRateService{},
+		&StubHistoryService{},
+		&StubEmailSubscriptionService{email: "test@example.com", preferencesErr: tokenErr},
+		&StubEmailOutboxService{},
+		&StubBroadcastService{},
+		&StubScheduleService{},
+		service,
+	)
+}
+
 func convertEmailsToUsers(emails []string) []port.User {
 	users := make([]port.User, len(emails))
 
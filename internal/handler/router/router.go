@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
@@ -12,20 +13,98 @@ type HTTPConfig struct {
 
 type Controller interface {
 	GetRate(w http.ResponseWriter, r *http.Request)
+	GetRateHistory(w http.ResponseWriter, r *http.Request)
 	SubscribeEmail(w http.ResponseWriter, r *http.Request)
+	ConfirmEmail(w http.ResponseWriter, r *http.Request)
+	UnsubscribeEmail(w http.ResponseWriter, r *http.Request)
+	GetPreferences(w http.ResponseWriter, r *http.Request)
+	UpdatePreferences(w http.ResponseWriter, r *http.Request)
 	SendEmails(w http.ResponseWriter, r *http.Request)
+	GetEmailBatch(w http.ResponseWriter, r *http.Request)
+	GetSchedule(w http.ResponseWriter, r *http.Request)
+	CreateAlert(w http.ResponseWriter, r *http.Request)
+	GetAlerts(w http.ResponseWriter, r *http.Request)
+	DeleteAlert(w http.ResponseWriter, r *http.Request)
 }
 
 type httpRouter struct {
 	controller Controller
+	timeout    time.Duration
 }
 
-func NewHTTPRouter(controller Controller) *httpRouter {
-	return &httpRouter{controller: controller}
+// NewHTTPRouter creates the router, the context of every request gets
+// the deadline after the timeout unless the timeout is zero
+func NewHTTPRouter(controller Controller, timeout time.Duration) *httpRouter {
+	return &httpRouter{controller: controller, timeout: timeout}
 }
 
 func (router *httpRouter) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/api/rate", router.controller.GetRate)
-	mux.HandleFunc("/api/subscribe", router.controller.SubscribeEmail)
-	mux.HandleFunc("/api/sendEmails", router.controller.SendEmails)
+	mux.HandleFunc("/api/rate", router.withTimeout(router.controller.GetRate))
+	mux.HandleFunc("/api/rate/history", router.withTimeout(router.controller.GetRateHistory))
+	mux.HandleFunc("/api/subscribe", router.withTimeout(router.subscription))
+	mux.HandleFunc("/api/confirm", router.withTimeout(router.controller.ConfirmEmail))
+	mux.HandleFunc("/api/unsubscribe", router.withTimeout(router.controller.UnsubscribeEmail))
+	mux.HandleFunc("/api/preferences", router.withTimeout(router.preferences))
+	mux.HandleFunc("/api/sendEmails", router.withTimeout(router.sendEmails))
+	mux.HandleFunc("/api/schedule", router.withTimeout(router.controller.GetSchedule))
+	mux.HandleFunc("/api/alerts", router.withTimeout(router.alerts))
+}
+
+// subscription routes DELETE requests to unsubscribe
+// and the rest of the requests to subscribe
+func (router *httpRouter) subscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		router.controller.UnsubscribeEmail(w, r)
+		return
+	}
+
+	router.controller.SubscribeEmail(w, r)
+}
+
+// preferences routes GET requests to read the preferences
+// and the rest of the requests to update them
+func (router *httpRouter) preferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		router.controller.GetPreferences(w, r)
+		return
+	}
+
+	router.controller.UpdatePreferences(w, r)
+}
+
+// sendEmails routes GET requests to poll the batch
+// and the rest of the requests to send the emails
+func (router *httpRouter) sendEmails(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		router.controller.GetEmailBatch(w, r)
+		return
+	}
+
+	router.controller.SendEmails(w, r)
+}
+
+// alerts routes GET requests to list the alerts, DELETE requests
+// to delete the alert and the rest of the requests to create it
+func (router *httpRouter) alerts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		router.controller.GetAlerts(w, r)
+	case http.MethodDelete:
+		router.controller.DeleteAlert(w, r)
+	default:
+		router.controller.CreateAlert(w, r)
+	}
+}
+
+func (router *httpRouter) withTimeout(handler http.HandlerFunc) http.HandlerFunc {
+	if router.timeout <= 0 {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), router.timeout)
+		defer cancel()
+
+		handler(w, r.WithContext(ctx))
+	}
 }
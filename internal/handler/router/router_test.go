@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -15,36 +16,127 @@ func (m *stubController) GetRate(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("getRate"))
 }
 
+func (m *stubController) GetRateHistory(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("getRateHistory"))
+}
+
 func (m *stubController) SubscribeEmail(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("subscribeEmail"))
 }
 
+func (m *stubController) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("confirmEmail"))
+}
+
+func (m *stubController) UnsubscribeEmail(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("unsubscribeEmail"))
+}
+
+func (m *stubController) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("getPreferences"))
+}
+
+func (m *stubController) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("updatePreferences"))
+}
+
 func (m *stubController) SendEmails(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("sendEmails"))
 }
 
+func (m *stubController) GetEmailBatch(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("getEmailBatch"))
+}
+
+func (m *stubController) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("getSchedule"))
+}
+
+func (m *stubController) CreateAlert(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("createAlert"))
+}
+
+func (m *stubController) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("getAlerts"))
+}
+
+func (m *stubController) DeleteAlert(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("deleteAlert"))
+}
+
+type deadlineController struct {
+	stubController
+}
+
+func (m *deadlineController) GetRate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.Context().Deadline(); ok {
+		w.Write([]byte("deadline"))
+		return
+	}
+	w.Write([]byte("no deadline"))
+}
+
 func TestHttpRouter(t *testing.T) {
 	mux := http.NewServeMux()
 	controller := &stubController{}
-	router := NewHTTPRouter(controller)
+	router := NewHTTPRouter(controller, 0)
 	router.RegisterRoutes(mux)
 
 	server := httptest.NewServer(mux)
 	defer server.Close()
 
 	tests := []struct {
-		name  string
-		route string
-		want  string
+		name   string
+		method string
+		route  string
+		want   string
 	}{
 		{name: "Test rate", route: "/api/rate", want: "getRate"},
+		{name: "Test rate history", route: "/api/rate/history", want: "getRateHistory"},
 		{name: "Test subscribe", route: "/api/subscribe", want: "subscribeEmail"},
-		{name: "Test sendEmails", route: "/api/sendEmails", want: "sendEmails"},
+		{
+			name:   "Test delete subscription",
+			method: http.MethodDelete,
+			route:  "/api/subscribe",
+			want:   "unsubscribeEmail",
+		},
+		{name: "Test confirm", route: "/api/confirm", want: "confirmEmail"},
+		{name: "Test unsubscribe", route: "/api/unsubscribe", want: "unsubscribeEmail"},
+		{name: "Test preferences", route: "/api/preferences", want: "getPreferences"},
+		{
+			name:   "Test update preferences",
+			method: http.MethodPut,
+			route:  "/api/preferences",
+			want:   "updatePreferences",
+		},
+		{
+			name:   "Test sendEmails",
+			method: http.MethodPost,
+			route:  "/api/sendEmails",
+			want:   "sendEmails",
+		},
+		{name: "Test email batch", route: "/api/sendEmails?batch=1", want: "getEmailBatch"},
+		{name: "Test schedule", route: "/api/schedule", want: "getSchedule"},
+		{
+			name:   "Test create alert",
+			method: http.MethodPost,
+			route:  "/api/alerts",
+			want:   "createAlert",
+		},
+		{name: "Test alerts", route: "/api/alerts?token=abc", want: "getAlerts"},
+		{
+			name:   "Test delete alert",
+			method: http.MethodDelete,
+			route:  "/api/alerts?token=abc&id=1",
+			want:   "deleteAlert",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			res, err := http.Get(server.URL + tt.route)
+			req, err := http.NewRequest(tt.method, server.URL+tt.route, nil)
+			require.NoError(t, err)
+			res, err := http.DefaultClient.Do(req)
 			require.NoError(t, err)
 			body, err := io.ReadAll(res.Body)
 			require.NoError(t, err)
@@ -54,3 +146,28 @@ func TestHttpRouter(t *testing.T) {
 		})
 	}
 }
+
+func TestHttpRouterTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    string
+	}{
+		{name: "Request with deadline", timeout: time.Minute, want: "deadline"},
+		{name: "Request without deadline", timeout: 0, want: "no deadline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			router := NewHTTPRouter(&deadlineController{}, tt.timeout)
+			router.RegisterRoutes(mux)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/rate", nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.want, rr.Body.String())
+		})
+	}
+}
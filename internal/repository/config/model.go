@@ -1,23 +1,39 @@
 package config
 
 import (
+	"gses2-app/internal/core/service/alert"
+	"gses2-app/internal/core/service/mailbox"
+	"gses2-app/internal/core/service/outbox"
+	"gses2-app/internal/core/service/rate"
+	"gses2-app/internal/core/service/rate/cache"
+	"gses2-app/internal/core/service/schedule"
+	"gses2-app/internal/core/service/subscription"
 	"gses2-app/internal/handler/router"
 	"gses2-app/internal/repository/logger/rabbit"
 	"gses2-app/internal/repository/rate/rest/binance"
 	"gses2-app/internal/repository/rate/rest/coingecko"
 	"gses2-app/internal/repository/rate/rest/kuna"
+	"gses2-app/internal/repository/sender/email/dkim"
 	"gses2-app/internal/repository/sender/email/send"
 	"gses2-app/internal/repository/sender/smtp"
 	"gses2-app/internal/repository/storage"
 )
 
 type Config struct {
-	SMTP         smtp.SMTPConfig
-	Email        send.EmailConfig
-	Storage      storage.StorageConfig
-	HTTP         router.HTTPConfig
-	KunaAPI      kuna.KunaAPIConfig
-	BinanceAPI   binance.BinanceAPIConfig
-	CoingeckoAPI coingecko.CoingeckoAPIConfig
-	RabbitMQ     rabbit.RabbitMQConfig
+	SMTP            smtp.SMTPConfig
+	Email           send.EmailConfig
+	DKIM            dkim.DKIMConfig
+	Storage         storage.StorageConfig
+	HTTP            router.HTTPConfig
+	KunaAPI         kuna.KunaAPIConfig
+	BinanceAPI      binance.BinanceAPIConfig
+	CoingeckoAPI    coingecko.CoingeckoAPIConfig
+	RabbitMQ        rabbit.RabbitMQConfig
+	Rate            rate.ServiceConfig
+	Cache           cache.CacheConfig
+	Subscription    subscription.SubscriptionConfig
+	EmailValidation mailbox.ValidationConfig
+	Outbox          outbox.OutboxConfig
+	Schedule        schedule.ScheduleConfig
+	Alert           alert.AlertConfig
 }
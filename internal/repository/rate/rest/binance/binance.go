@@ -5,7 +5,7 @@ import (
 	"errors"
 	"io"
 	"net/http"
-	"strconv"
+	"net/url"
 
 	"gses2-app/internal/core/port"
 	"gses2-app/internal/repository/rate/rest"
@@ -23,14 +23,16 @@ const (
 	_firstItemIndex   = 0
 	_minResponseItems = 5
 	_rateIndex        = 4
+	_klineInterval    = "1s"
+	_klineLimit       = "1"
 )
 
 type HTTPClient interface {
-	Get(url string) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
 }
 
 type BinanceAPIConfig struct {
-	URL string `default:"https://api.binance.com/api/v3/klines?symbol=BTCUAH&interval=1s&limit=1"`
+	URL string `default:"https://api.binance.com/api/v3/klines"`
 }
 
 type BinanceProvider struct {
@@ -51,39 +53,46 @@ func NewProvider(
 	)
 }
 
-func (p *BinanceProvider) URL() string {
-	return p.config.URL
+func (p *BinanceProvider) URL(pair port.CurrencyPair) string {
+	return rest.WithQuery(p.config.URL, url.Values{
+		"symbol":   {pair.Base + pair.Quote},
+		"interval": {_klineInterval},
+		"limit":    {_klineLimit},
+	})
 }
 
 func (p *BinanceProvider) Name() string {
 	return _providerName
 }
 
-func (p *BinanceProvider) ExtractRate(resp *http.Response) (port.Rate, error) {
+func (p *BinanceProvider) ExtractRate(
+	resp *http.Response,
+	pair port.CurrencyPair,
+) (port.Decimal, error) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
+		return port.Decimal{}, err
 	}
 
 	var data [][]interface{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return 0, err
+		return port.Decimal{}, err
 	}
 
 	if len(data) == 0 || len(data[_firstItemIndex]) < _minResponseItems {
-		return 0, ErrUnexpectedResponseFormat
+		return port.Decimal{}, ErrUnexpectedResponseFormat
 	}
 
 	exchangeRate, ok := data[_firstItemIndex][_rateIndex].(string)
 	if !ok {
-		return 0, ErrUnexpectedExchangeRateFormat
+		return port.Decimal{}, ErrUnexpectedExchangeRateFormat
 	}
 
-	rateValue, err := strconv.ParseFloat(exchangeRate, 64)
+	rateValue, err := port.ParseDecimal(exchangeRate)
 	if err != nil {
-		return 0, errors.Join(err, ErrUnexpectedExchangeRateFormat)
+		return port.Decimal{}, errors.Join(err, ErrUnexpectedExchangeRateFormat)
 	}
 
-	return port.Rate(rateValue), nil
+	return rateValue, nil
 }
@@ -2,6 +2,7 @@ package binance
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"testing"
@@ -26,7 +27,7 @@ type StubHTTPClient struct {
 	Error    error
 }
 
-func (m *StubHTTPClient) Get(url string) (*http.Response, error) {
+func (m *StubHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return m.Response, m.Error
 }
 
@@ -34,7 +35,7 @@ func TestBinanceProviderExchangeRate(t *testing.T) {
 	tests := []struct {
 		name           string
 		stubHTTPClient *StubHTTPClient
-		expectedRate   port.Rate
+		expectedRate   port.Decimal
 		expectedError  error
 	}{
 		{
@@ -49,7 +50,7 @@ func TestBinanceProviderExchangeRate(t *testing.T) {
 					),
 				},
 			},
-			expectedRate: 123.456,
+			expectedRate: port.MustParseDecimal("123.456"),
 		},
 		{
 			name: "HTTP request failure",
@@ -101,10 +102,16 @@ func TestBinanceProviderExchangeRate(t *testing.T) {
 
 			config := BinanceAPIConfig{}
 			provider := NewProvider(&StubLogger{}, config, tt.stubHTTPClient)
-			rate, err := provider.ExchangeRate()
+			rate, err := provider.ExchangeRate(
+				context.Background(),
+				port.DefaultCurrencyPair,
+			)
 
 			require.ErrorIs(t, err, tt.expectedError)
-			require.Equal(t, tt.expectedRate, rate, "Expected rate %v, got %v", tt.expectedRate, rate)
+			require.Equal(
+				t, tt.expectedRate.String(), rate.Amount.String(),
+				"Expected rate %v, got %v", tt.expectedRate, rate.Amount,
+			)
 		})
 	}
 
@@ -5,17 +5,16 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"gses2-app/internal/core/port"
 	"gses2-app/internal/repository/rate/rest"
 )
 
-// Represents data type for JSON response
-type Response struct {
-	Bitcoin struct {
-		UAH float64 `json:"uah"`
-	} `json:"bitcoin"`
-}
+// Represents data type for JSON response, rates are indexed
+// by the coin ID and then by the quote currency
+type Response map[string]map[string]json.Number
 
 var (
 	ErrHTTPRequestFailure       = errors.New("http request failure")
@@ -28,11 +27,14 @@ const (
 )
 
 type CoingeckoAPIConfig struct {
-	URL string `default:"https://api.coingecko.com/api/v3/simple/price?ids=bitcoin&vs_currencies=uah"`
+	URL string `default:"https://api.coingecko.com/api/v3/simple/price"`
+
+	// Coingecko identifies coins by ID instead of the ticker symbol
+	CoinIDs map[string]string `default:"BTC:bitcoin,ETH:ethereum,USDT:tether"`
 }
 
 type HTTPClient interface {
-	Get(url string) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
 }
 
 type CoingeckoProvider struct {
@@ -53,25 +55,49 @@ func NewProvider(
 	)
 }
 
-func (p *CoingeckoProvider) URL() string {
-	return p.config.URL
+func (p *CoingeckoProvider) URL(pair port.CurrencyPair) string {
+	return rest.WithQuery(p.config.URL, url.Values{
+		"ids":           {p.coinID(pair.Base)},
+		"vs_currencies": {strings.ToLower(pair.Quote)},
+	})
 }
 
 func (p *CoingeckoProvider) Name() string {
 	return _providerName
 }
 
-func (p *CoingeckoProvider) ExtractRate(resp *http.Response) (port.Rate, error) {
+func (p *CoingeckoProvider) ExtractRate(
+	resp *http.Response,
+	pair port.CurrencyPair,
+) (port.Decimal, error) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
+		return port.Decimal{}, err
 	}
 
 	var data Response
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return 0, errors.Join(err, ErrUnexpectedResponseFormat)
+		return port.Decimal{}, errors.Join(err, ErrUnexpectedResponseFormat)
+	}
+
+	exchangeRate, ok := data[p.coinID(pair.Base)][strings.ToLower(pair.Quote)]
+	if !ok {
+		return port.Decimal{}, ErrUnexpectedResponseFormat
+	}
+
+	rateValue, err := port.ParseJSONNumber(exchangeRate)
+	if err != nil {
+		return port.Decimal{}, errors.Join(err, ErrUnexpectedResponseFormat)
+	}
+
+	return rateValue, nil
+}
+
+func (p *CoingeckoProvider) coinID(currency string) string {
+	if id, ok := p.config.CoinIDs[currency]; ok {
+		return id
 	}
 
-	return port.Rate(data.Bitcoin.UAH), nil
+	return strings.ToLower(currency)
 }
@@ -2,6 +2,7 @@ package coingecko
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"testing"
@@ -26,7 +27,7 @@ type StubHTTPClient struct {
 	Error    error
 }
 
-func (m *StubHTTPClient) Get(url string) (*http.Response, error) {
+func (m *StubHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return m.Response, m.Error
 }
 
@@ -34,7 +35,7 @@ func TestBinanceProviderExchangeRate(t *testing.T) {
 	tests := []struct {
 		name           string
 		stubHTTPClient *StubHTTPClient
-		expectedRate   port.Rate
+		expectedRate   port.Decimal
 		expectedError  error
 	}{
 		{
@@ -49,7 +50,7 @@ func TestBinanceProviderExchangeRate(t *testing.T) {
 					),
 				},
 			},
-			expectedRate: 123456,
+			expectedRate: port.MustParseDecimal("123456"),
 		},
 		{
 			name: "HTTP request failure",
@@ -92,6 +93,20 @@ func TestBinanceProviderExchangeRate(t *testing.T) {
 			},
 			expectedError: ErrUnexpectedResponseFormat,
 		},
+		{
+			name: "Currency pair is missing in response",
+			stubHTTPClient: &StubHTTPClient{
+				Response: &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(
+						bytes.NewBufferString(
+							`{"bitcoin":{"usd":123456}}`,
+						),
+					),
+				},
+			},
+			expectedError: ErrUnexpectedResponseFormat,
+		},
 	}
 
 	for _, tt := range tests {
@@ -99,12 +114,52 @@ func TestBinanceProviderExchangeRate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			config := CoingeckoAPIConfig{}
+			config := CoingeckoAPIConfig{
+				CoinIDs: map[string]string{"BTC": "bitcoin"},
+			}
 			provider := NewProvider(&StubLogger{}, config, tt.stubHTTPClient)
-			rate, err := provider.ExchangeRate()
+			rate, err := provider.ExchangeRate(
+				context.Background(),
+				port.DefaultCurrencyPair,
+			)
 
 			require.ErrorIs(t, err, tt.expectedError)
-			require.Equal(t, tt.expectedRate, rate, "Expected rate %v, got %v", tt.expectedRate, rate)
+			require.Equal(
+				t, tt.expectedRate.String(), rate.Amount.String(),
+				"Expected rate %v, got %v", tt.expectedRate, rate.Amount,
+			)
+		})
+	}
+}
+
+func TestCoingeckoProviderURL(t *testing.T) {
+	provider := &CoingeckoProvider{
+		config: CoingeckoAPIConfig{
+			URL:     "https://api.coingecko.com/api/v3/simple/price",
+			CoinIDs: map[string]string{"ETH": "ethereum"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		pair        port.CurrencyPair
+		expectedURL string
+	}{
+		{
+			name:        "Known coin ID",
+			pair:        port.CurrencyPair{Base: "ETH", Quote: "USD"},
+			expectedURL: "https://api.coingecko.com/api/v3/simple/price?ids=ethereum&vs_currencies=usd",
+		},
+		{
+			name:        "Unknown coin ID",
+			pair:        port.CurrencyPair{Base: "DOGE", Quote: "UAH"},
+			expectedURL: "https://api.coingecko.com/api/v3/simple/price?ids=doge&vs_currencies=uah",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expectedURL, provider.URL(tt.pair))
 		})
 	}
 }
@@ -3,8 +3,9 @@ package kuna
 import (
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"gses2-app/internal/core/port"
 	"gses2-app/internal/repository/rate/rest"
@@ -15,6 +16,7 @@ var (
 	ErrUnexpectedStatusCode         = errors.New("unexpected status code")
 	ErrUnexpectedResponseFormat     = errors.New("unexpected response format")
 	ErrUnexpectedExchangeRateFormat = errors.New("unexpected exchange rate format")
+	ErrUnexpectedCurrencyPair       = errors.New("unexpected currency pair")
 )
 
 const (
@@ -22,14 +24,15 @@ const (
 	_firstItemIndex   = 0
 	_minResponseItems = 9
 	_rateIndex        = 7
+	_symbolIndex      = 0
 )
 
 type KunaAPIConfig struct {
-	URL string `default:"https://api.kuna.io/v3/tickers?symbols=btcuah"`
+	URL string `default:"https://api.kuna.io/v3/tickers"`
 }
 
 type HTTPClient interface {
-	Get(url string) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
 }
 
 type KunaProvider struct {
@@ -50,34 +53,52 @@ func NewProvider(
 	)
 }
 
-func (p *KunaProvider) URL() string {
-	return p.config.URL
+func (p *KunaProvider) URL(pair port.CurrencyPair) string {
+	return rest.WithQuery(p.config.URL, url.Values{
+		"symbols": {symbol(pair)},
+	})
 }
 
 func (p *KunaProvider) Name() string {
 	return _providerName
 }
 
-func (p *KunaProvider) ExtractRate(resp *http.Response) (port.Rate, error) {
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
-	}
+func (p *KunaProvider) ExtractRate(
+	resp *http.Response,
+	pair port.CurrencyPair,
+) (port.Decimal, error) {
+	// Numbers are decoded as json.Number to keep all the digits
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
 
 	var data [][]interface{}
-	err = json.Unmarshal(body, &data)
+	err := decoder.Decode(&data)
 	if err != nil {
-		return 0, err
+		return port.Decimal{}, err
 	}
 
 	if len(data) == 0 || len(data[_firstItemIndex]) < _minResponseItems {
-		return 0, ErrUnexpectedResponseFormat
+		return port.Decimal{}, ErrUnexpectedResponseFormat
 	}
 
-	exchangeRate, ok := data[_firstItemIndex][_rateIndex].(float64)
+	responseSymbol, ok := data[_firstItemIndex][_symbolIndex].(string)
+	if ok && responseSymbol != symbol(pair) {
+		return port.Decimal{}, ErrUnexpectedCurrencyPair
+	}
+
+	exchangeRate, ok := data[_firstItemIndex][_rateIndex].(json.Number)
 	if !ok {
-		return 0, ErrUnexpectedExchangeRateFormat
+		return port.Decimal{}, ErrUnexpectedExchangeRateFormat
 	}
 
-	return port.Rate(exchangeRate), nil
+	rateValue, err := port.ParseJSONNumber(exchangeRate)
+	if err != nil {
+		return port.Decimal{}, errors.Join(err, ErrUnexpectedExchangeRateFormat)
+	}
+
+	return rateValue, nil
+}
+
+func symbol(pair port.CurrencyPair) string {
+	return strings.ToLower(pair.Base + pair.Quote)
 }
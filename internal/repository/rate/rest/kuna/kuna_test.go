@@ -2,6 +2,7 @@ package kuna
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"testing"
@@ -26,7 +27,7 @@ type StubHTTPClient struct {
 	Error    error
 }
 
-func (m *StubHTTPClient) Get(url string) (*http.Response, error) {
+func (m *StubHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return m.Response, m.Error
 }
 
@@ -34,7 +35,7 @@ func TestKunaProviderExchangeRate(t *testing.T) {
 	tests := []struct {
 		name           string
 		stubHTTPClient *StubHTTPClient
-		expectedRate   port.Rate
+		expectedRate   port.Decimal
 		expectedError  error
 	}{
 		{
@@ -49,7 +50,7 @@ func TestKunaProviderExchangeRate(t *testing.T) {
 					),
 				},
 			},
-			expectedRate: 1.24,
+			expectedRate: port.MustParseDecimal("1.24"),
 		},
 		{
 			name: "HTTP request failure",
@@ -92,6 +93,20 @@ func TestKunaProviderExchangeRate(t *testing.T) {
 			},
 			expectedError: ErrUnexpectedExchangeRateFormat,
 		},
+		{
+			name: "Unexpected currency pair",
+			stubHTTPClient: &StubHTTPClient{
+				Response: &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(
+						bytes.NewBufferString(
+							`[["ethuah",1,1,1,1,1,1,1.24,1]]`,
+						),
+					),
+				},
+			},
+			expectedError: ErrUnexpectedCurrencyPair,
+		},
 	}
 
 	for _, tt := range tests {
@@ -101,10 +116,16 @@ func TestKunaProviderExchangeRate(t *testing.T) {
 
 			config := KunaAPIConfig{}
 			provider := NewProvider(&StubLogger{}, config, tt.stubHTTPClient)
-			rate, err := provider.ExchangeRate()
+			rate, err := provider.ExchangeRate(
+				context.Background(),
+				port.DefaultCurrencyPair,
+			)
 
 			require.ErrorIs(t, err, tt.expectedError)
-			require.Equal(t, tt.expectedRate, rate, "Expected rate %v, got %v", tt.expectedRate, rate)
+			require.Equal(
+				t, tt.expectedRate.String(), rate.Amount.String(),
+				"Expected rate %v, got %v", tt.expectedRate, rate.Amount,
+			)
 		})
 	}
 
@@ -1,10 +1,13 @@
 package rest
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"gses2-app/internal/core/port"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 var (
@@ -13,19 +16,20 @@ var (
 )
 
 type HTTPClient interface {
-	Get(url string) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
 }
 
 type Provider interface {
-	URL() string
+	URL(pair port.CurrencyPair) string
 	Name() string
-	ExtractRate(resp *http.Response) (port.Rate, error)
+	ExtractRate(resp *http.Response, pair port.CurrencyPair) (port.Decimal, error)
 }
 
 type AbstractProvider struct {
 	logger         port.Logger
 	actualProvider Provider
 	httpClient     HTTPClient
+	now            func() time.Time
 }
 
 func NewProvider(
@@ -37,6 +41,7 @@ func NewProvider(
 		logger:         logger,
 		actualProvider: actualProvider,
 		httpClient:     httpClient,
+		now:            time.Now,
 	}
 }
 
@@ -44,22 +49,49 @@ func (ap *AbstractProvider) Name() string {
 	return ap.actualProvider.Name()
 }
 
-func (ap *AbstractProvider) ExchangeRate() (port.Rate, error) {
-	resp, err := ap.requestAPI()
+func (ap *AbstractProvider) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
+	resp, err := ap.requestAPI(ctx, pair)
 	if err != nil {
-		return 0, err
+		return port.Rate{}, err
 	}
 
-	return ap.extractRateFromResponse(resp)
+	amount, err := ap.extractRateFromResponse(resp, pair)
+	if err != nil {
+		return port.Rate{}, err
+	}
+
+	return port.Rate{
+		Amount:    amount,
+		Pair:      pair,
+		Provider:  ap.actualProvider.Name(),
+		FetchedAt: ap.now().UTC(),
+	}, nil
 }
 
-func (ap *AbstractProvider) requestAPI() (*http.Response, error) {
-	resp, err := ap.httpClient.Get(ap.actualProvider.URL())
+func (ap *AbstractProvider) requestAPI(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		ap.actualProvider.URL(pair),
+		http.NoBody,
+	)
+	if err != nil {
+		return nil, errors.Join(err, ErrHTTPRequestFailure)
+	}
+
+	resp, err := ap.httpClient.Do(req)
 	if err != nil {
-		return nil, ErrHTTPRequestFailure
+		return nil, errors.Join(err, ErrHTTPRequestFailure)
 	}
 
 	if resp.StatusCode != http.StatusOK {
+		closeBody(resp)
 		return nil, fmt.Errorf("%w: %d", ErrUnexpectedStatusCode, resp.StatusCode)
 	}
 
@@ -67,7 +99,33 @@ func (ap *AbstractProvider) requestAPI() (*http.Response, error) {
 	return resp, nil
 }
 
-func (ap *AbstractProvider) extractRateFromResponse(resp *http.Response) (port.Rate, error) {
+func (ap *AbstractProvider) extractRateFromResponse(
+	resp *http.Response,
+	pair port.CurrencyPair,
+) (port.Decimal, error) {
 	defer resp.Body.Close()
-	return ap.actualProvider.ExtractRate(resp)
+	return ap.actualProvider.ExtractRate(resp, pair)
+}
+
+func closeBody(resp *http.Response) {
+	if resp.Body != nil {
+		resp.Body.Close()
+	}
+}
+
+// WithQuery adds query parameters to the API URL, keeping the ones that
+// are already present in it
+func WithQuery(rawURL string, query url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	q := u.Query()
+	for key, values := range query {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
 }
@@ -2,9 +2,12 @@ package rest
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -25,18 +28,22 @@ type StubHTTPClient struct {
 	Error    error
 }
 
-func (m *StubHTTPClient) Get(url string) (*http.Response, error) {
+func (m *StubHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
 	return m.Response, m.Error
 }
 
 type StubProvider struct {
 	Url          string
 	ProviderName string
-	Rate         port.Rate
+	Rate         port.Decimal
 	Error        error
 }
 
-func (s *StubProvider) URL() string {
+func (s *StubProvider) URL(pair port.CurrencyPair) string {
 	return s.Url
 }
 
@@ -44,7 +51,10 @@ func (s *StubProvider) Name() string {
 	return s.ProviderName
 }
 
-func (s *StubProvider) ExtractRate(r *http.Response) (port.Rate, error) {
+func (s *StubProvider) ExtractRate(
+	r *http.Response,
+	pair port.CurrencyPair,
+) (port.Decimal, error) {
 	return s.Rate, s.Error
 }
 
@@ -53,7 +63,7 @@ func TestExchangeRate(t *testing.T) {
 		name           string
 		stubProvider   Provider
 		stubHTTPClient *StubHTTPClient
-		expectedRate   port.Rate
+		expectedRate   port.Decimal
 		expectedError  error
 	}{
 		{
@@ -61,7 +71,7 @@ func TestExchangeRate(t *testing.T) {
 			stubProvider: &StubProvider{
 				Url:          "https://test.url",
 				ProviderName: "Test",
-				Rate:         1.23,
+				Rate:         port.MustParseDecimal("1.23"),
 			},
 			stubHTTPClient: &StubHTTPClient{
 				Response: &http.Response{
@@ -69,7 +79,7 @@ func TestExchangeRate(t *testing.T) {
 					Body:       io.NopCloser(bytes.NewBufferString("Success Response")),
 				},
 			},
-			expectedRate: 1.23,
+			expectedRate: port.MustParseDecimal("1.23"),
 		},
 		{
 			name: "HTTP request failure",
@@ -103,10 +113,88 @@ func TestExchangeRate(t *testing.T) {
 				tt.stubProvider,
 				tt.stubHTTPClient,
 			)
-			rate, err := abstractProvider.ExchangeRate()
+			rate, err := abstractProvider.ExchangeRate(
+				context.Background(),
+				port.DefaultCurrencyPair,
+			)
 
 			require.ErrorIs(t, err, tt.expectedError)
-			require.Equal(t, tt.expectedRate, rate, "Expected rate %v, got %v", tt.expectedRate, rate)
+			require.Equal(
+				t, tt.expectedRate.String(), rate.Amount.String(),
+				"Expected rate %v, got %v", tt.expectedRate, rate.Amount,
+			)
+		})
+	}
+}
+
+func TestExchangeRateCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	abstractProvider := NewProvider(
+		&StubLogger{},
+		&StubProvider{Url: "https://test.url"},
+		&StubHTTPClient{},
+	)
+
+	_, err := abstractProvider.ExchangeRate(ctx, port.DefaultCurrencyPair)
+
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, err, ErrHTTPRequestFailure)
+}
+
+func TestExchangeRateMetadata(t *testing.T) {
+	fetchedAt := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	pair := port.CurrencyPair{Base: "ETH", Quote: "USD"}
+
+	abstractProvider := NewProvider(
+		&StubLogger{},
+		&StubProvider{
+			ProviderName: "Test",
+			Rate:         port.MustParseDecimal("1227057.123456789"),
+		},
+		&StubHTTPClient{
+			Response: &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString("Success Response")),
+			},
+		},
+	)
+	abstractProvider.now = func() time.Time { return fetchedAt }
+
+	rate, err := abstractProvider.ExchangeRate(context.Background(), pair)
+
+	require.NoError(t, err)
+	require.Equal(t, "1227057.123456789", rate.Amount.String())
+	require.Equal(t, pair, rate.Pair)
+	require.Equal(t, "Test", rate.Provider)
+	require.Equal(t, fetchedAt, rate.FetchedAt)
+}
+
+func TestWithQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		rawURL      string
+		query       url.Values
+		expectedURL string
+	}{
+		{
+			name:        "URL without query",
+			rawURL:      "https://test.url/api",
+			query:       url.Values{"symbol": {"BTCUAH"}},
+			expectedURL: "https://test.url/api?symbol=BTCUAH",
+		},
+		{
+			name:        "URL with query",
+			rawURL:      "https://test.url/api?limit=1",
+			query:       url.Values{"symbol": {"BTCUAH"}},
+			expectedURL: "https://test.url/api?limit=1&symbol=BTCUAH",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expectedURL, WithQuery(tt.rawURL, tt.query))
 		})
 	}
 }
@@ -0,0 +1,65 @@
+package email
+
+import (
+	"errors"
+	"net/textproto"
+
+	"gses2-app/internal/core/port"
+	"gses2-app/internal/repository/sender/email/send"
+)
+
+const _permanentFailureCode = 500
+
+// deliveries reports the delivery of the email to the recipients by the
+// error of sending it, the recipients rejected by the server get their
+// own replies and the rest of them share the outcome of the email
+func deliveries(recipients []string, err error) []port.Delivery {
+	rejected := map[string]error{}
+
+	var recipientsErr *send.RecipientsError
+	if errors.As(err, &recipientsErr) {
+		for _, recipient := range recipientsErr.Rejected {
+			rejected[recipient.Recipient] = recipient.Err
+		}
+		err = nil
+	}
+
+	result := make([]port.Delivery, len(recipients))
+	for i, recipient := range recipients {
+		recipientErr, ok := rejected[recipient]
+		if !ok {
+			recipientErr = err
+		}
+
+		result[i] = deliveryOf(recipient, recipientErr)
+	}
+
+	return result
+}
+
+// deliveryOf classifies the error by the SMTP reply code, the permanent
+// failures are rejected and the transient failures are deferred. The
+// errors without a reply, e.g. the connection errors, are deferred too
+func deliveryOf(email string, err error) port.Delivery {
+	if err == nil {
+		return port.Delivery{Email: email, Status: port.DeliveryAccepted}
+	}
+
+	delivery := port.Delivery{
+		Email:   email,
+		Status:  port.DeliveryDeferred,
+		Message: err.Error(),
+	}
+
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		delivery.Code = reply.Code
+		delivery.Message = reply.Msg
+
+		if reply.Code >= _permanentFailureCode {
+			delivery.Status = port.DeliveryRejected
+		}
+	}
+
+	return delivery
+}
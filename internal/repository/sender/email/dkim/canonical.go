@@ -0,0 +1,96 @@
+package dkim
+
+import "strings"
+
+// parseHeader splits the header into the fields,
+// the folded field keeps its continuation lines
+func parseHeader(header string) []string {
+	var fields []string
+
+	for _, line := range strings.Split(header, "\r\n") {
+		isContinuation := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		if isContinuation && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+
+		fields = append(fields, line)
+	}
+
+	return fields
+}
+
+// lastField returns the last field with the name,
+// it's the one the verifier checks first
+func lastField(fields []string, name string) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		fieldName, _, _ := strings.Cut(fields[i], ":")
+		if strings.EqualFold(strings.TrimRight(fieldName, " \t"), name) {
+			return fields[i], true
+		}
+	}
+
+	return "", false
+}
+
+// relaxedHeader canonicalizes the field as RFC 6376 3.4.2 describes:
+// the lowercase name, the unfolded value with the whitespace collapsed
+// and no whitespace around the colon
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+
+	name = strings.ToLower(strings.TrimRight(name, " \t"))
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = collapseWhitespace(strings.TrimLeft(value, " \t"))
+
+	return name + ":" + value + "\r\n"
+}
+
+// relaxedBody canonicalizes the body as RFC 6376 3.4.4 describes:
+// the whitespace collapsed, no trailing whitespace and no empty lines
+// at the end, the empty body stays empty
+func relaxedBody(body []byte) []byte {
+	var (
+		canonical  strings.Builder
+		emptyLines int
+	)
+
+	for _, line := range strings.Split(string(body), "\r\n") {
+		line = collapseWhitespace(line)
+		if line == "" {
+			emptyLines++
+			continue
+		}
+
+		canonical.WriteString(strings.Repeat("\r\n", emptyLines))
+		canonical.WriteString(line)
+		canonical.WriteString("\r\n")
+		emptyLines = 0
+	}
+
+	return []byte(canonical.String())
+}
+
+// collapseWhitespace reduces every run of spaces and tabs
+// to a single space and drops the trailing ones
+func collapseWhitespace(line string) string {
+	var (
+		collapsed  strings.Builder
+		whitespace bool
+	)
+
+	for i := 0; i < len(line); i++ {
+		if line[i] == ' ' || line[i] == '\t' {
+			whitespace = true
+			continue
+		}
+
+		if whitespace {
+			collapsed.WriteByte(' ')
+			whitespace = false
+		}
+		collapsed.WriteByte(line[i])
+	}
+
+	return collapsed.String()
+}
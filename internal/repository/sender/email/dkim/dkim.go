@@ -0,0 +1,267 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The signing algorithms by the type of the private key
+const (
+	AlgorithmRSA     = "rsa-sha256"
+	AlgorithmEd25519 = "ed25519-sha256"
+)
+
+const (
+	_signatureHeader  = "DKIM-Signature"
+	_canonicalization = "relaxed/relaxed"
+	_minRSABits       = 1024
+	_foldWidth        = 72
+	_selfCheckMessage = "DKIM self-check"
+)
+
+// _signedHeaders are the headers signed when the message has them
+var _signedHeaders = []string{
+	"From",
+	"To",
+	"Subject",
+	"Date",
+	"Message-ID",
+	"MIME-Version",
+	"Content-Type",
+	"List-Unsubscribe",
+	"List-Unsubscribe-Post",
+}
+
+var (
+	ErrIncompleteConfig = errors.New("dkim domain, selector and key path must be set together")
+	ErrInvalidKey       = errors.New("invalid dkim private key")
+	ErrWeakKey          = errors.New("dkim rsa key is too short")
+	ErrSelfCheck        = errors.New("dkim signature self-check failed")
+	ErrMalformedMessage = errors.New("message has no header and body")
+	ErrMissingFrom      = errors.New("message has no From header")
+
+	errSignatureMismatch = errors.New("signature doesn't match the public key")
+)
+
+type DKIMConfig struct {
+	// Domain is the signing domain, the domain of the sender or its parent
+	Domain string
+
+	// Selector names the DNS record with the public key,
+	// TXT <selector>._domainkey.<domain>
+	Selector string
+
+	// KeyPath is the PEM RSA or Ed25519 private key,
+	// the messages aren't signed when it's empty
+	KeyPath string
+}
+
+// Enabled reports whether the messages are signed
+func (c DKIMConfig) Enabled() bool {
+	return c != (DKIMConfig{})
+}
+
+// Signer adds the DKIM signature to the messages,
+// the header and body are canonicalized as relaxed
+type Signer struct {
+	domain    string
+	selector  string
+	algorithm string
+	key       crypto.Signer
+	now       func() time.Time
+}
+
+// NewSigner loads the private key and checks that it signs
+// the messages the public key verifies
+func NewSigner(config DKIMConfig) (*Signer, error) {
+	if config.Domain == "" || config.Selector == "" || config.KeyPath == "" {
+		return nil, ErrIncompleteConfig
+	}
+
+	data, err := os.ReadFile(config.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, algorithm, err := ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", config.KeyPath, err)
+	}
+
+	signer := &Signer{
+		domain:    config.Domain,
+		selector:  config.Selector,
+		algorithm: algorithm,
+		key:       key,
+		now:       time.Now,
+	}
+
+	if err = signer.selfCheck(); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// ParseKey returns the PKCS #1 or PKCS #8 private key
+// from the PEM data and the algorithm it signs with
+func ParseKey(data []byte) (crypto.Signer, string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", fmt.Errorf("%w: no PEM block", ErrInvalidKey)
+	}
+
+	var (
+		key any
+		err error
+	)
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, "", fmt.Errorf("%w: unsupported PEM block %q", ErrInvalidKey, block.Type)
+	}
+
+	if err != nil {
+		return nil, "", errors.Join(ErrInvalidKey, err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < _minRSABits {
+			return nil, "", fmt.Errorf("%w: %d bits", ErrWeakKey, key.N.BitLen())
+		}
+		return key, AlgorithmRSA, nil
+	case ed25519.PrivateKey:
+		return key, AlgorithmEd25519, nil
+	}
+
+	return nil, "", fmt.Errorf("%w: unsupported key type %T", ErrInvalidKey, key)
+}
+
+// Sign returns the message with the DKIM-Signature header prepended,
+// the message is the CRLF separated header and body
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	header, body, ok := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, ErrMalformedMessage
+	}
+
+	fields := parseHeader(string(header))
+
+	var (
+		signedNames []string
+		signed      strings.Builder
+	)
+
+	for _, name := range _signedHeaders {
+		field, found := lastField(fields, name)
+		if !found {
+			continue
+		}
+
+		signedNames = append(signedNames, strings.ToLower(name))
+		signed.WriteString(relaxedHeader(field))
+	}
+
+	if len(signedNames) == 0 || signedNames[0] != "from" {
+		return nil, ErrMissingFrom
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	signatureField := fmt.Sprintf(
+		"%s: v=1; a=%s; c=%s; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		_signatureHeader,
+		s.algorithm,
+		_canonicalization,
+		s.domain,
+		s.selector,
+		s.now().Unix(),
+		strings.Join(signedNames, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+
+	// The signature field is signed without the trailing CRLF and b= value
+	signed.WriteString(strings.TrimSuffix(relaxedHeader(signatureField), "\r\n"))
+
+	signature, err := s.sign([]byte(signed.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	var signedMessage bytes.Buffer
+	signedMessage.WriteString(signatureField)
+	signedMessage.WriteString(fold(base64.StdEncoding.EncodeToString(signature)))
+	signedMessage.WriteString("\r\n")
+	signedMessage.Write(message)
+
+	return signedMessage.Bytes(), nil
+}
+
+// sign returns the signature of the SHA-256 hash of the data, the Ed25519
+// key signs the hash itself as RFC 8463 requires
+func (s *Signer) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	var opts crypto.SignerOpts = crypto.SHA256
+	if s.algorithm == AlgorithmEd25519 {
+		opts = crypto.Hash(0)
+	}
+
+	return s.key.Sign(rand.Reader, digest[:], opts)
+}
+
+// selfCheck signs the probe and verifies the signature with the public key
+func (s *Signer) selfCheck() error {
+	signature, err := s.sign([]byte(_selfCheckMessage))
+	if err != nil {
+		return errors.Join(ErrSelfCheck, err)
+	}
+
+	digest := sha256.Sum256([]byte(_selfCheckMessage))
+
+	switch public := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(public, digest[:], signature) {
+			err = errSignatureMismatch
+		}
+	}
+
+	if err != nil {
+		return errors.Join(ErrSelfCheck, err)
+	}
+
+	return nil
+}
+
+// fold splits the base64 value into the continuation lines
+func fold(value string) string {
+	var folded strings.Builder
+
+	for len(value) > _foldWidth {
+		folded.WriteString(value[:_foldWidth])
+		folded.WriteString("\r\n\t")
+		value = value[_foldWidth:]
+	}
+	folded.WriteString(value)
+
+	return folded.String()
+}
@@ -0,0 +1,322 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const _message = "From: Rates <no.reply@example.com>\r\n" +
+	"To: user@test.com\r\n" +
+	"Subject: BTC to UAH\r\n" +
+	"  exchange rate\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=\"utf-8\"\r\n" +
+	"\r\n" +
+	"Rate  1,227,057.00 \t\r\n" +
+	"\r\n" +
+	"Bye\r\n" +
+	"\r\n" +
+	"\r\n"
+
+var _signedValue = regexp.MustCompile(`b=[^;]*$`)
+
+func TestSign(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name              string
+		key               []byte
+		expectedAlgorithm string
+	}{
+		{
+			name:              "RSA PKCS #1 key",
+			key:               pemEncode(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+			expectedAlgorithm: AlgorithmRSA,
+		},
+		{
+			name:              "RSA PKCS #8 key",
+			key:               pemPKCS8(t, rsaKey),
+			expectedAlgorithm: AlgorithmRSA,
+		},
+		{
+			name:              "Ed25519 key",
+			key:               pemPKCS8(t, ed25519Key),
+			expectedAlgorithm: AlgorithmEd25519,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			signer, err := NewSigner(DKIMConfig{
+				Domain:   "example.com",
+				Selector: "rates",
+				KeyPath:  writeKey(t, tt.key),
+			})
+			require.NoError(t, err)
+			signer.now = func() time.Time { return time.Unix(1136214245, 0) }
+
+			signed, err := signer.Sign([]byte(_message))
+			require.NoError(t, err)
+			require.True(t, strings.HasSuffix(string(signed), _message))
+
+			tags := verify(t, signed, signer.key.Public())
+			require.Equal(t, tt.expectedAlgorithm, tags["a"])
+			require.Equal(t, "relaxed/relaxed", tags["c"])
+			require.Equal(t, "example.com", tags["d"])
+			require.Equal(t, "rates", tags["s"])
+			require.Equal(t, "1136214245", tags["t"])
+			require.Equal(t, "from:to:subject:date:mime-version:content-type", tags["h"])
+		})
+	}
+}
+
+func TestSignMalformedMessage(t *testing.T) {
+	t.Parallel()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := NewSigner(DKIMConfig{
+		Domain:   "example.com",
+		Selector: "rates",
+		KeyPath:  writeKey(t, pemPKCS8(t, key)),
+	})
+	require.NoError(t, err)
+
+	_, err = signer.Sign([]byte("From: a@example.com\r\nTo: b@example.com"))
+	require.ErrorIs(t, err, ErrMalformedMessage)
+
+	_, err = signer.Sign([]byte("To: b@example.com\r\n\r\nHi\r\n"))
+	require.ErrorIs(t, err, ErrMissingFrom)
+}
+
+func TestNewSigner(t *testing.T) {
+	t.Parallel()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		config      func(t *testing.T) DKIMConfig
+		expectedErr error
+	}{
+		{
+			name: "Missing selector",
+			config: func(t *testing.T) DKIMConfig {
+				return DKIMConfig{Domain: "example.com", KeyPath: writeKey(t, pemPKCS8(t, key))}
+			},
+			expectedErr: ErrIncompleteConfig,
+		},
+		{
+			name: "Missing key file",
+			config: func(t *testing.T) DKIMConfig {
+				return DKIMConfig{
+					Domain:   "example.com",
+					Selector: "rates",
+					KeyPath:  filepath.Join(t.TempDir(), "missing.pem"),
+				}
+			},
+			expectedErr: os.ErrNotExist,
+		},
+		{
+			name: "Not a PEM file",
+			config: func(t *testing.T) DKIMConfig {
+				return DKIMConfig{
+					Domain:   "example.com",
+					Selector: "rates",
+					KeyPath:  writeKey(t, []byte("not a key")),
+				}
+			},
+			expectedErr: ErrInvalidKey,
+		},
+		{
+			name: "Unsupported PEM block",
+			config: func(t *testing.T) DKIMConfig {
+				return DKIMConfig{
+					Domain:   "example.com",
+					Selector: "rates",
+					KeyPath:  writeKey(t, pemEncode(t, "CERTIFICATE", []byte("certificate"))),
+				}
+			},
+			expectedErr: ErrInvalidKey,
+		},
+		{
+			name: "Corrupted key",
+			config: func(t *testing.T) DKIMConfig {
+				return DKIMConfig{
+					Domain:   "example.com",
+					Selector: "rates",
+					KeyPath:  writeKey(t, pemEncode(t, "PRIVATE KEY", []byte("corrupted"))),
+				}
+			},
+			expectedErr: ErrInvalidKey,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewSigner(tt.config(t))
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestRelaxedBody(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "Empty body",
+			body:     "",
+			expected: "",
+		},
+		{
+			name:     "Only empty lines",
+			body:     "\r\n\r\n",
+			expected: "",
+		},
+		{
+			name:     "Whitespace",
+			body:     " Rate \t 10.50\t\r\n\r\nBye  \r\n\r\n",
+			expected: " Rate 10.50\r\n\r\nBye\r\n",
+		},
+		{
+			name:     "No trailing CRLF",
+			body:     "Bye",
+			expected: "Bye\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, string(relaxedBody([]byte(tt.body))))
+		})
+	}
+}
+
+// TestRelaxedBodyHash checks the body hash of the example in RFC 8463
+func TestRelaxedBodyHash(t *testing.T) {
+	t.Parallel()
+
+	body := "Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n"
+	hash := sha256.Sum256(relaxedBody([]byte(body)))
+
+	require.Equal(
+		t,
+		"2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=",
+		base64.StdEncoding.EncodeToString(hash[:]),
+	)
+}
+
+func TestRelaxedHeader(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		"subject:BTC to UAH exchange rate\r\n",
+		relaxedHeader("SubJect \t:  BTC to UAH\r\n \t exchange  rate \t"),
+	)
+}
+
+// verify checks the DKIM-Signature of the message the way the receiving
+// server does and returns the tags of the signature
+func verify(t *testing.T, message []byte, public crypto.PublicKey) map[string]string {
+	header, body, ok := strings.Cut(string(message), "\r\n\r\n")
+	require.True(t, ok)
+
+	fields := parseHeader(header)
+	signatureField, ok := lastField(fields, _signatureHeader)
+	require.True(t, ok)
+
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(signatureField[len(_signatureHeader)+1:], ";") {
+		name, value, _ := strings.Cut(tag, "=")
+		value = strings.Join(strings.Fields(value), "")
+		tags[strings.TrimSpace(name)] = value
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody([]byte(body)))
+	require.Equal(t, base64.StdEncoding.EncodeToString(bodyHash[:]), tags["bh"])
+
+	var signed strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		field, found := lastField(fields, name)
+		require.True(t, found)
+		signed.WriteString(relaxedHeader(field))
+	}
+	signed.WriteString(_signedValue.ReplaceAllString(
+		strings.TrimSuffix(relaxedHeader(signatureField), "\r\n"),
+		"b=",
+	))
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte(signed.String()))
+
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		require.NoError(t, rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature))
+	case ed25519.PublicKey:
+		require.True(t, ed25519.Verify(public, digest[:], signature))
+	default:
+		t.Fatalf("unexpected public key %T", public)
+	}
+
+	return tags
+}
+
+func pemPKCS8(t *testing.T, key any) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pemEncode(t, "PRIVATE KEY", der)
+}
+
+func pemEncode(t *testing.T, blockType string, der []byte) []byte {
+	t.Helper()
+
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func writeKey(t *testing.T, key []byte) string {
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	require.NoError(t, os.WriteFile(path, key, 0o600))
+
+	return path
+}
@@ -1,59 +1,406 @@
 package email
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"gses2-app/internal/core/port"
+	"gses2-app/internal/repository/sender/email/dkim"
 	"gses2-app/internal/repository/sender/email/send"
 	"gses2-app/internal/repository/sender/smtp"
 )
 
+const (
+	_ratePrecision   = 2
+	_fetchedAtLayout = "2006-01-02 15:04:05 MST"
+	_changeFormat    = "%+.2f"
+)
+
+// The directions of the rate change shown in the emails
+const (
+	ChangeUp   = "up"
+	ChangeDown = "down"
+	ChangeFlat = "flat"
+)
+
+// The delivery modes of the exchange rate emails
+const (
+	DeliveryIndividual = "individual"
+	DeliveryBCC        = "bcc"
+)
+
+var ErrUnknownDelivery = errors.New("unknown delivery mode")
+
 type EmailSenderConfig struct {
 	SMTP  smtp.SMTPConfig
 	Email send.EmailConfig
+	DKIM  dkim.DKIMConfig
+}
+
+type Linker interface {
+	UnsubscribeURL(user port.User) string
+	ConfirmURL(user port.User) string
+	PreferencesURL(user port.User) string
+}
+
+// Connections lends the SMTP connection to send an email,
+// every email is sent over the connection used by no one else
+type Connections interface {
+	Do(ctx context.Context, fn func(client smtp.SMTPConnectionClient) error) error
+}
+
+// RateHistory provides the earlier rates to show the rate change
+type RateHistory interface {
+	Range(pair port.CurrencyPair, from, to time.Time) ([]port.Rate, error)
 }
 
 type Provider struct {
-	config     *EmailSenderConfig
-	connection smtp.SMTPConnectionClient
+	config      *EmailSenderConfig
+	connections Connections
+	linker      Linker
+	history     RateHistory
+	templates   *send.Templates
+	signer      send.Signer
 }
 
+// NewProvider loads the email templates and the DKIM key, the rate
+// change isn't shown when the history is nil
 func NewProvider(
 	config *EmailSenderConfig,
-	dialer smtp.TLSConnectionDialer,
-	factory smtp.SMTPClientFactory,
+	connections Connections,
+	linker Linker,
+	history RateHistory,
 ) (*Provider, error) {
-	client := smtp.NewSMTPClient(config.SMTP, dialer, factory)
-	clientConnection, err := client.Connect()
+	if !isKnownDelivery(config.Email.Delivery) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDelivery, config.Email.Delivery)
+	}
+
+	templates, err := send.LoadTemplates(
+		config.Email.TemplatesDir,
+		defaultLocale(config.Email.DefaultLocale),
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Provider{config: config, connection: clientConnection}, nil
+	signer, err := newSigner(config.DKIM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		config:      config,
+		connections: connections,
+		linker:      linker,
+		history:     history,
+		templates:   templates,
+		signer:      signer,
+	}, nil
 }
 
+// SendExchangeRate sends the rate to the subscribers according to the
+// delivery mode and reports the delivery to every subscriber, a failed
+// subscriber doesn't stop the delivery to the others. The error is
+// returned only when the email can't be composed
 func (p *Provider) SendExchangeRate(
+	ctx context.Context,
+	rate port.Rate,
+	subscribers []port.User,
+) (*port.DeliveryReport, error) {
+	if p.config.Email.Delivery == DeliveryBCC {
+		return p.sendBCC(ctx, rate, subscribers)
+	}
+
+	return p.sendIndividually(ctx, rate, subscribers)
+}
+
+// sendIndividually sends a separate email to every subscriber, so each
+// of them gets a personal greeting and unsubscribe link in their locale
+func (p *Provider) sendIndividually(
+	ctx context.Context,
+	rate port.Rate,
+	subscribers []port.User,
+) (*port.DeliveryReport, error) {
+	report := port.NewDeliveryReport()
+	update := p.newRateUpdate(rate)
+
+	for _, subscriber := range subscribers {
+		templateData := update.templateData(p.templates.Match(subscriber.Locale))
+		templateData.Name = nameOf(subscriber.Email)
+		templateData.Email = subscriber.Email
+		templateData.UnsubscribeURL = p.linker.UnsubscribeURL(subscriber)
+		templateData.PreferencesURL = p.linker.PreferencesURL(subscriber)
+
+		emailMessage, err := send.NewEmailMessage(
+			p.config.Email,
+			p.templates,
+			send.TemplateRate,
+			[]string{subscriber.Email},
+			templateData,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.send(ctx, emailMessage)
+		report.Add(deliveries(emailMessage.Recipients(), err)...)
+	}
+
+	return report, nil
+}
+
+// sendBCC sends a single email to the subscribers in the same locale with
+// all of them in Bcc, the email can't contain the personal greeting and
+// unsubscribe link
+func (p *Provider) sendBCC(
+	ctx context.Context,
 	rate port.Rate,
 	subscribers []port.User,
-) error {
+) (*port.DeliveryReport, error) {
+	report := port.NewDeliveryReport()
+	update := p.newRateUpdate(rate)
+
+	for _, group := range p.groupByLocale(subscribers) {
+		emailMessage, err := send.NewEmailMessage(
+			p.config.Email,
+			p.templates,
+			send.TemplateRate,
+			nil,
+			update.templateData(group.locale),
+		)
+		if err != nil {
+			return nil, err
+		}
+		emailMessage.Bcc = group.emails
+
+		err = p.send(ctx, emailMessage)
+		report.Add(deliveries(emailMessage.Recipients(), err)...)
+	}
+
+	return report, nil
+}
 
-	emailAddresses := convertUsersToEmails(subscribers)
+// localeGroup is the subscribers getting the email in the locale
+type localeGroup struct {
+	locale string
+	emails []string
+}
+
+// groupByLocale groups the subscribers by the locale of their templates
+// in the order the locales first appear in
+func (p *Provider) groupByLocale(subscribers []port.User) []*localeGroup {
+	var groups []*localeGroup
+	byLocale := make(map[string]*localeGroup)
+
+	for _, subscriber := range subscribers {
+		locale := p.templates.Match(subscriber.Locale)
+
+		group, ok := byLocale[locale]
+		if !ok {
+			group = &localeGroup{locale: locale}
+			byLocale[locale] = group
+			groups = append(groups, group)
+		}
 
-	templateData := send.TemplateData{Rate: fmt.Sprintf("%.2f", rate)}
-	emailMessage, err := send.NewEmailMessage(p.config.Email, emailAddresses, templateData)
+		group.emails = append(group.emails, subscriber.Email)
+	}
+
+	return groups
+}
+
+// SendConfirmation sends the email with the link
+// confirming the subscription of the user
+func (p *Provider) SendConfirmation(ctx context.Context, user port.User) error {
+	config := p.config.Email
+	config.Subject = config.ConfirmationSubject
+
+	emailMessage, err := send.NewEmailMessage(
+		config,
+		p.templates,
+		send.TemplateConfirmation,
+		[]string{user.Email},
+		send.TemplateData{
+			Locale:     p.templates.Match(user.Locale),
+			ConfirmURL: p.linker.ConfirmURL(user),
+		},
+	)
 	if err != nil {
 		return err
 	}
 
-	return send.SendEmail(p.connection, emailMessage)
+	return p.send(ctx, emailMessage)
 }
 
-func convertUsersToEmails(users []port.User) []string {
-	emails := make([]string, len(users))
+// SendAlert sends the email about the alert fired by the rate. The change
+// alert shows the move from the rate it fired on instead of the change
+// within the change period
+func (p *Provider) SendAlert(ctx context.Context, user port.User, event port.AlertEvent) error {
+	config := p.config.Email
+	config.Subject = config.AlertSubject
 
-	for i, user := range users {
-		emails[i] = user.Email
+	update := rateUpdate{rate: event.Rate, previous: event.Reference}
+	if event.Reference == nil {
+		update = p.newRateUpdate(event.Rate)
 	}
 
-	return emails
+	templateData := withAlert(
+		update.templateData(p.templates.Match(user.Locale)),
+		event.Alert,
+	)
+	templateData.Name = nameOf(user.Email)
+	templateData.Email = user.Email
+	templateData.UnsubscribeURL = p.linker.UnsubscribeURL(user)
+	templateData.PreferencesURL = p.linker.PreferencesURL(user)
+
+	emailMessage, err := send.NewEmailMessage(
+		config,
+		p.templates,
+		send.TemplateAlert,
+		[]string{user.Email},
+		templateData,
+	)
+	if err != nil {
+		return err
+	}
+
+	return p.send(ctx, emailMessage)
+}
+
+func (p *Provider) send(ctx context.Context, emailMessage *send.EmailMessage) error {
+	return p.connections.Do(ctx, func(client smtp.SMTPConnectionClient) error {
+		return send.SendEmail(ctx, client, emailMessage, p.signer)
+	})
+}
+
+// rateUpdate is the rate sent to the subscribers and the earlier rate
+// it's compared to, previous is nil when there's no such rate
+type rateUpdate struct {
+	rate     port.Rate
+	previous *port.Rate
+}
+
+// newRateUpdate compares the rate with the earliest rate within the change
+// period, the change is omitted when the history has no such rate or
+// can't be read
+func (p *Provider) newRateUpdate(rate port.Rate) rateUpdate {
+	update := rateUpdate{rate: rate}
+
+	if previous, ok := p.previousRate(rate); ok {
+		update.previous = &previous
+	}
+
+	return update
+}
+
+// templateData returns the rate and its change formatted in the locale
+func (u rateUpdate) templateData(locale string) send.TemplateData {
+	format := numberFormatOf(locale)
+
+	data := send.TemplateData{
+		Locale:    locale,
+		Rate:      format.number(u.rate.Amount),
+		Price:     format.price(u.rate.Amount, u.rate.Pair.Quote),
+		Base:      u.rate.Pair.Base,
+		Quote:     u.rate.Pair.Quote,
+		Provider:  u.rate.Provider,
+		FetchedAt: formatFetchedAt(u.rate.FetchedAt),
+	}
+
+	if u.previous == nil {
+		return data
+	}
+
+	data.PreviousRate = format.number(u.previous.Amount)
+	data.PreviousPrice = format.price(u.previous.Amount, u.previous.Pair.Quote)
+	data.PreviousFetchedAt = formatFetchedAt(u.previous.FetchedAt)
+	data.Change = format.percent(
+		(u.rate.Amount.Float64()/u.previous.Amount.Float64() - 1) * 100,
+	)
+	data.ChangeDirection = changeDirection(u.rate.Amount.Cmp(u.previous.Amount))
+
+	return data
+}
+
+// withAlert adds the alert formatted in the locale of the data
+func withAlert(data send.TemplateData, alert port.Alert) send.TemplateData {
+	format := numberFormatOf(data.Locale)
+
+	data.AlertKind = string(alert.Kind)
+	if alert.Kind == port.AlertChange {
+		data.AlertChange = strings.TrimPrefix(format.percent(alert.Threshold.Float64()), "+")
+		data.AlertWindow = alert.Window.String()
+	} else {
+		data.AlertLevel = format.price(alert.Threshold, alert.Pair.Quote)
+	}
+
+	return data
+}
+
+func (p *Provider) previousRate(rate port.Rate) (port.Rate, bool) {
+	if p.history == nil || p.config.Email.ChangePeriod <= 0 {
+		return port.Rate{}, false
+	}
+
+	rates, err := p.history.Range(
+		rate.Pair,
+		rate.FetchedAt.Add(-p.config.Email.ChangePeriod),
+		rate.FetchedAt,
+	)
+	if err != nil || len(rates) == 0 || rates[0].Amount.IsZero() {
+		return port.Rate{}, false
+	}
+
+	return rates[0], true
+}
+
+func changeDirection(cmp int) string {
+	switch {
+	case cmp > 0:
+		return ChangeUp
+	case cmp < 0:
+		return ChangeDown
+	}
+
+	return ChangeFlat
+}
+
+func formatFetchedAt(fetchedAt time.Time) string {
+	return fetchedAt.UTC().Format(_fetchedAtLayout)
+}
+
+// defaultLocale returns the locale of the emails
+// when the default locale isn't configured
+func defaultLocale(locale string) string {
+	if locale == "" {
+		return _defaultLanguage
+	}
+
+	return locale
+}
+
+// newSigner returns nil when DKIM isn't configured
+func newSigner(config dkim.DKIMConfig) (send.Signer, error) {
+	if !config.Enabled() {
+		return nil, nil
+	}
+
+	signer, err := dkim.NewSigner(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+func isKnownDelivery(delivery string) bool {
+	return delivery == "" || delivery == DeliveryIndividual || delivery == DeliveryBCC
+}
+
+// nameOf returns the local part of the email to greet the recipient
+func nameOf(email string) string {
+	name, _, _ := strings.Cut(email, "@")
+	return name
 }
@@ -1,55 +1,115 @@
 package email
 
 import (
+	"context"
 	"errors"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"gses2-app/internal/core/port"
+	"gses2-app/internal/repository/sender/email/dkim"
+	"gses2-app/internal/repository/sender/email/send"
 	"gses2-app/internal/repository/sender/smtp"
 )
 
 var (
 	errDialerError  = errors.New("dialer error")
 	errFactoryError = errors.New("factory error")
+	errConnection   = errors.New("connection error")
+	errMailbox      = &textproto.Error{Code: 550, Msg: "mailbox unavailable"}
+	errMailboxBusy  = &textproto.Error{Code: 450, Msg: "mailbox busy"}
+	errHistory      = errors.New("history error")
 )
 
+type StubRateHistory struct {
+	rates    []port.Rate
+	err      error
+	from, to time.Time
+}
+
+func (h *StubRateHistory) Range(
+	pair port.CurrencyPair,
+	from, to time.Time,
+) ([]port.Rate, error) {
+	h.from, h.to = from, to
+	return h.rates, h.err
+}
+
+type StubLinker struct {
+	linked []string
+}
+
+func (l *StubLinker) UnsubscribeURL(user port.User) string {
+	l.linked = append(l.linked, user.Email)
+	return "https://example.com/api/unsubscribe?token=" + user.Email
+}
+
+func (l *StubLinker) PreferencesURL(user port.User) string {
+	return "https://example.com/api/preferences?token=" + user.Email
+}
+
+func (l *StubLinker) ConfirmURL(user port.User) string {
+	l.linked = append(l.linked, user.Email)
+	return "https://example.com/api/confirm?token=" + user.Email
+}
+
 func TestSendExchangeRate(t *testing.T) {
 	tests := []struct {
-		name         string
-		emails       []string
-		exchangeRate port.Rate
-		dialer       smtp.TLSConnectionDialer
-		factory      smtp.SMTPClientFactory
-		expectedErr  error
+		name               string
+		emails             []string
+		exchangeRate       port.Rate
+		dialer             smtp.ConnectionDialer
+		factory            smtp.SMTPClientFactory
+		expectedDeliveries []port.Delivery
 	}{
 		{
 			name:         "Successful SendExchangeRate",
 			emails:       []string{"test@example.com"},
-			exchangeRate: 10.5,
+			exchangeRate: port.Rate{Amount: port.MustParseDecimal("10.5")},
 			dialer:       &smtp.StubDialer{},
 			factory:      &smtp.StubSMTPClientFactory{Client: &smtp.StubSMTPClient{}},
-			expectedErr:  nil,
+			expectedDeliveries: []port.Delivery{
+				{Email: "test@example.com", Status: port.DeliveryAccepted},
+			},
+		},
+		{
+			name:         "Send to every subscriber separately",
+			emails:       []string{"first@example.com", "second@example.com"},
+			exchangeRate: port.Rate{Amount: port.MustParseDecimal("10.5")},
+			dialer:       &smtp.StubDialer{},
+			factory:      &smtp.StubSMTPClientFactory{Client: &smtp.StubSMTPClient{}},
+			expectedDeliveries: []port.Delivery{
+				{Email: "first@example.com", Status: port.DeliveryAccepted},
+				{Email: "second@example.com", Status: port.DeliveryAccepted},
+			},
 		},
 		{
-			name:         "Failed due to dialer error",
+			name:         "Deferred due to dialer error",
 			emails:       []string{"test@example.com"},
-			exchangeRate: 10.5,
+			exchangeRate: port.Rate{Amount: port.MustParseDecimal("10.5")},
 			dialer:       &smtp.StubDialer{Err: errDialerError},
 			factory:      &smtp.StubSMTPClientFactory{Client: &smtp.StubSMTPClient{}},
-			expectedErr:  errDialerError,
+			expectedDeliveries: []port.Delivery{
+				{Email: "test@example.com", Status: port.DeliveryDeferred, Message: "dialer error"},
+			},
 		},
 		{
-			name:         "Failed due to factory error",
+			name:         "Deferred due to factory error",
 			emails:       []string{"test@example.com"},
-			exchangeRate: 10.5,
+			exchangeRate: port.Rate{Amount: port.MustParseDecimal("10.5")},
 			dialer:       &smtp.StubDialer{},
 			factory: &smtp.StubSMTPClientFactory{
 				Client: &smtp.StubSMTPClient{},
 				Err:    errFactoryError,
 			},
-			expectedErr: errFactoryError,
+			expectedDeliveries: []port.Delivery{
+				{Email: "test@example.com", Status: port.DeliveryDeferred, Message: "factory error"},
+			},
 		},
 	}
 
@@ -59,22 +119,393 @@ func TestSendExchangeRate(t *testing.T) {
 			t.Parallel()
 
 			config := &EmailSenderConfig{}
-			service, err := NewProvider(config, tt.dialer, tt.factory)
-
-			require.Equal(t, tt.expectedErr, err)
-
-			if tt.expectedErr != nil {
-				return
-			}
+			linker := &StubLinker{}
+			service, err := NewProvider(
+				config,
+				newPool(t, tt.dialer, tt.factory),
+				linker,
+				nil,
+			)
+			require.NoError(t, err, "the provider is created without connecting")
 
 			users := convertEmailsToUsers(tt.emails)
-			err = service.SendExchangeRate(tt.exchangeRate, users)
+			report, err := service.SendExchangeRate(context.Background(), tt.exchangeRate, users)
 
 			require.NoError(t, err, "SendExchangeRate() unexpected error = %v", err)
+			require.Equal(t, tt.emails, linker.linked)
+			require.Equal(t, tt.expectedDeliveries, report.Deliveries)
+		})
+	}
+}
+
+func TestSendExchangeRateDelivery(t *testing.T) {
+	emails := []string{"first@example.com", "bad@example.com", "third@example.com"}
+	accepted := func(email string) port.Delivery {
+		return port.Delivery{Email: email, Status: port.DeliveryAccepted}
+	}
+	rejected := port.Delivery{
+		Email:   "bad@example.com",
+		Status:  port.DeliveryRejected,
+		Code:    550,
+		Message: "mailbox unavailable",
+	}
+
+	tests := []struct {
+		name               string
+		delivery           string
+		client             *smtp.StubSMTPClient
+		expectedMails      int
+		expectedLinked     []string
+		expectedDeliveries []port.Delivery
+		expectedSummary    port.DeliverySummary
+	}{
+		{
+			name:           "Individual delivery",
+			delivery:       DeliveryIndividual,
+			client:         &smtp.StubSMTPClient{},
+			expectedMails:  3,
+			expectedLinked: emails,
+			expectedDeliveries: []port.Delivery{
+				accepted("first@example.com"),
+				accepted("bad@example.com"),
+				accepted("third@example.com"),
+			},
+			expectedSummary: port.DeliverySummary{Total: 3, Accepted: 3},
+		},
+		{
+			name:     "Individual delivery continues after rejected recipient",
+			delivery: DeliveryIndividual,
+			client: &smtp.StubSMTPClient{
+				RcptErrs: map[string]error{"bad@example.com": errMailbox},
+			},
+			expectedMails:  3,
+			expectedLinked: emails,
+			expectedDeliveries: []port.Delivery{
+				accepted("first@example.com"),
+				rejected,
+				accepted("third@example.com"),
+			},
+			expectedSummary: port.DeliverySummary{Total: 3, Accepted: 2, Rejected: 1},
+		},
+		{
+			name:     "Individual delivery with transient failure",
+			delivery: DeliveryIndividual,
+			client: &smtp.StubSMTPClient{
+				RcptErrs: map[string]error{"bad@example.com": errMailboxBusy},
+			},
+			expectedMails:  3,
+			expectedLinked: emails,
+			expectedDeliveries: []port.Delivery{
+				accepted("first@example.com"),
+				{
+					Email:   "bad@example.com",
+					Status:  port.DeliveryDeferred,
+					Code:    450,
+					Message: "mailbox busy",
+				},
+				accepted("third@example.com"),
+			},
+			expectedSummary: port.DeliverySummary{Total: 3, Accepted: 2, Deferred: 1},
+		},
+		{
+			name:     "BCC delivery with rejected recipient",
+			delivery: DeliveryBCC,
+			client: &smtp.StubSMTPClient{
+				RcptErrs: map[string]error{"bad@example.com": errMailbox},
+			},
+			expectedMails: 1,
+			expectedDeliveries: []port.Delivery{
+				accepted("first@example.com"),
+				rejected,
+				accepted("third@example.com"),
+			},
+			expectedSummary: port.DeliverySummary{Total: 3, Accepted: 2, Rejected: 1},
+		},
+		{
+			name:          "BCC delivery with connection error",
+			delivery:      DeliveryBCC,
+			client:        &smtp.StubSMTPClient{MailErr: errConnection},
+			expectedMails: 1,
+			expectedDeliveries: []port.Delivery{
+				{Email: "first@example.com", Status: port.DeliveryDeferred, Message: "connection error"},
+				{Email: "bad@example.com", Status: port.DeliveryDeferred, Message: "connection error"},
+				{Email: "third@example.com", Status: port.DeliveryDeferred, Message: "connection error"},
+			},
+			expectedSummary: port.DeliverySummary{Total: 3, Deferred: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := &EmailSenderConfig{Email: send.EmailConfig{Delivery: tt.delivery}}
+			linker := &StubLinker{}
+			provider, err := NewProvider(
+				config,
+				newPool(
+					t,
+					&smtp.StubDialer{},
+					&smtp.StubSMTPClientFactory{Client: tt.client},
+				),
+				linker,
+				nil,
+			)
+			require.NoError(t, err)
+
+			report, err := provider.SendExchangeRate(
+				context.Background(),
+				port.Rate{Amount: port.MustParseDecimal("10.5")},
+				convertEmailsToUsers(emails),
+			)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedMails, tt.client.Mails)
+			require.Equal(t, tt.expectedLinked, linker.linked)
+			require.Equal(t, tt.expectedDeliveries, report.Deliveries)
+			require.Equal(t, tt.expectedSummary, report.Summary)
+		})
+	}
+}
+
+func TestSendExchangeRateByLocale(t *testing.T) {
+	subscribers := []port.User{
+		{Email: "uk@example.com", Locale: "uk"},
+		{Email: "en@example.com", Locale: "en-GB"},
+		{Email: "ukua@example.com", Locale: "uk-UA"},
+		{Email: "none@example.com"},
+	}
+
+	tests := []struct {
+		name          string
+		delivery      string
+		expectedMails int
+		expectedRcpts []string
+	}{
+		{
+			name:          "Individual delivery",
+			delivery:      DeliveryIndividual,
+			expectedMails: 4,
+			expectedRcpts: []string{
+				"uk@example.com",
+				"en@example.com",
+				"ukua@example.com",
+				"none@example.com",
+			},
+		},
+		{
+			name:          "BCC delivery grouped by locale",
+			delivery:      DeliveryBCC,
+			expectedMails: 2,
+			expectedRcpts: []string{
+				"uk@example.com",
+				"ukua@example.com",
+				"en@example.com",
+				"none@example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := &smtp.StubSMTPClient{}
+			config := &EmailSenderConfig{Email: send.EmailConfig{Delivery: tt.delivery}}
+			provider, err := NewProvider(
+				config,
+				newPool(
+					t,
+					&smtp.StubDialer{},
+					&smtp.StubSMTPClientFactory{Client: client},
+				),
+				&StubLinker{},
+				nil,
+			)
+			require.NoError(t, err)
+
+			report, err := provider.SendExchangeRate(
+				context.Background(),
+				port.Rate{Amount: port.MustParseDecimal("10.5")},
+				subscribers,
+			)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedMails, client.Mails)
+			require.Equal(t, tt.expectedRcpts, client.Rcpts)
+			require.Equal(t, port.DeliverySummary{Total: 4, Accepted: 4}, report.Summary)
+		})
+	}
+}
+
+func TestUnknownDefaultLocale(t *testing.T) {
+	t.Parallel()
+
+	config := &EmailSenderConfig{Email: send.EmailConfig{DefaultLocale: "de"}}
+	_, err := NewProvider(config, nil, &StubLinker{}, nil)
+
+	require.ErrorIs(t, err, send.ErrTemplateNotFound)
+}
+
+func TestInvalidDKIMKey(t *testing.T) {
+	t.Parallel()
+
+	keyPath := filepath.Join(t.TempDir(), "dkim.pem")
+	require.NoError(t, os.WriteFile(keyPath, []byte("not a key"), 0o600))
+
+	tests := []struct {
+		name        string
+		config      dkim.DKIMConfig
+		expectedErr error
+	}{
+		{
+			name:        "Incomplete config",
+			config:      dkim.DKIMConfig{Domain: "example.com", KeyPath: keyPath},
+			expectedErr: dkim.ErrIncompleteConfig,
+		},
+		{
+			name:        "Invalid key",
+			config:      dkim.DKIMConfig{Domain: "example.com", Selector: "rates", KeyPath: keyPath},
+			expectedErr: dkim.ErrInvalidKey,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := &EmailSenderConfig{DKIM: tt.config}
+			_, err := NewProvider(config, nil, &StubLinker{}, nil)
+
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestUnknownDelivery(t *testing.T) {
+	t.Parallel()
+
+	config := &EmailSenderConfig{Email: send.EmailConfig{Delivery: "broadcast"}}
+	_, err := NewProvider(
+		config,
+		newPool(
+			t,
+			&smtp.StubDialer{},
+			&smtp.StubSMTPClientFactory{Client: &smtp.StubSMTPClient{}},
+		),
+		&StubLinker{},
+		nil,
+	)
+
+	require.ErrorIs(t, err, ErrUnknownDelivery)
+}
+
+func TestUnknownTemplatesDir(t *testing.T) {
+	t.Parallel()
+
+	config := &EmailSenderConfig{Email: send.EmailConfig{TemplatesDir: "./missing"}}
+	_, err := NewProvider(
+		config,
+		newPool(
+			t,
+			&smtp.StubDialer{},
+			&smtp.StubSMTPClientFactory{Client: &smtp.StubSMTPClient{}},
+		),
+		&StubLinker{},
+		nil,
+	)
+
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestTemplateDataChange(t *testing.T) {
+	fetchedAt := time.Date(2023, time.July, 2, 12, 0, 0, 0, time.UTC)
+	rate := port.Rate{
+		Amount:    port.MustParseDecimal("102.5"),
+		Pair:      port.DefaultCurrencyPair,
+		FetchedAt: fetchedAt,
+	}
+	previous := func(amount string) port.Rate {
+		return port.Rate{
+			Amount:    port.MustParseDecimal(amount),
+			Pair:      port.DefaultCurrencyPair,
+			FetchedAt: fetchedAt.Add(-23 * time.Hour),
+		}
+	}
+
+	tests := []struct {
+		name              string
+		history           *StubRateHistory
+		expectedPrevious  string
+		expectedChange    string
+		expectedDirection string
+	}{
+		{
+			name:              "Rate went up",
+			history:           &StubRateHistory{rates: []port.Rate{previous("100"), previous("101")}},
+			expectedPrevious:  "100.00",
+			expectedChange:    "+2.50%",
+			expectedDirection: ChangeUp,
+		},
+		{
+			name:              "Rate went down",
+			history:           &StubRateHistory{rates: []port.Rate{previous("125")}},
+			expectedPrevious:  "125.00",
+			expectedChange:    "-18.00%",
+			expectedDirection: ChangeDown,
+		},
+		{
+			name:              "Rate didn't change",
+			history:           &StubRateHistory{rates: []port.Rate{previous("102.5")}},
+			expectedPrevious:  "102.50",
+			expectedChange:    "+0.00%",
+			expectedDirection: ChangeFlat,
+		},
+		{
+			name:    "No earlier rate",
+			history: &StubRateHistory{},
+		},
+		{
+			name:    "History error",
+			history: &StubRateHistory{rates: []port.Rate{previous("100")}, err: errHistory},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := &EmailSenderConfig{Email: send.EmailConfig{ChangePeriod: 24 * time.Hour}}
+			provider, err := NewProvider(config, nil, &StubLinker{}, tt.history)
+			require.NoError(t, err)
+
+			data := provider.newRateUpdate(rate).templateData("en")
+
+			require.Equal(t, "102.50", data.Rate)
+			require.Equal(t, tt.expectedPrevious, data.PreviousRate)
+			require.Equal(t, tt.expectedChange, data.Change)
+			require.Equal(t, tt.expectedDirection, data.ChangeDirection)
+			require.Equal(t, fetchedAt.Add(-24*time.Hour), tt.history.from)
+			require.Equal(t, fetchedAt, tt.history.to)
 		})
 	}
 }
 
+func newPool(
+	t *testing.T,
+	dialer smtp.ConnectionDialer,
+	factory smtp.SMTPClientFactory,
+) *smtp.Pool {
+	pool, err := smtp.NewPool(smtp.SMTPConfig{Auth: smtp.AuthNone}, dialer, factory)
+	require.NoError(t, err)
+
+	return pool
+}
+
 func convertEmailsToUsers(emails []string) []port.User {
 	users := make([]port.User, len(emails))
 
@@ -84,3 +515,146 @@ func convertEmailsToUsers(emails []string) []port.User {
 
 	return users
 }
+
+func TestSendConfirmation(t *testing.T) {
+	t.Parallel()
+
+	config := &EmailSenderConfig{}
+	linker := &StubLinker{}
+	service, err := NewProvider(
+		config,
+		newPool(
+			t,
+			&smtp.StubDialer{},
+			&smtp.StubSMTPClientFactory{Client: &smtp.StubSMTPClient{}},
+		),
+		linker,
+		nil,
+	)
+	require.NoError(t, err)
+
+	err = service.SendConfirmation(context.Background(), port.User{Email: "test@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"test@example.com"}, linker.linked)
+}
+
+func TestSendAlert(t *testing.T) {
+	t.Parallel()
+
+	client := &smtp.StubSMTPClient{}
+	linker := &StubLinker{}
+	provider, err := NewProvider(
+		&EmailSenderConfig{},
+		newPool(
+			t,
+			&smtp.StubDialer{},
+			&smtp.StubSMTPClientFactory{Client: client},
+		),
+		linker,
+		nil,
+	)
+	require.NoError(t, err)
+
+	err = provider.SendAlert(
+		context.Background(),
+		port.User{Email: "test@example.com", Locale: "uk"},
+		port.AlertEvent{
+			Alert: port.Alert{Kind: port.AlertAbove, Threshold: port.MustParseDecimal("100")},
+			Rate:  port.Rate{Amount: port.MustParseDecimal("100.5"), Pair: port.DefaultCurrencyPair},
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, client.Mails)
+	require.Equal(t, []string{"test@example.com"}, client.Rcpts)
+	require.Equal(t, []string{"test@example.com"}, linker.linked)
+}
+
+func TestAlertEmail(t *testing.T) {
+	fetchedAt := time.Date(2023, time.July, 2, 12, 0, 0, 0, time.UTC)
+	rate := port.Rate{
+		Amount:    port.MustParseDecimal("105"),
+		Pair:      port.DefaultCurrencyPair,
+		Provider:  "binance",
+		FetchedAt: fetchedAt,
+	}
+	reference := port.Rate{
+		Amount:    port.MustParseDecimal("100"),
+		Pair:      port.DefaultCurrencyPair,
+		FetchedAt: fetchedAt.Add(-30 * time.Minute),
+	}
+
+	tests := []struct {
+		name            string
+		locale          string
+		event           port.AlertEvent
+		expectedSubject string
+		expectedText    string
+	}{
+		{
+			name:   "Above",
+			locale: "en",
+			event: port.AlertEvent{
+				Alert: port.Alert{
+					Pair:      port.DefaultCurrencyPair,
+					Kind:      port.AlertAbove,
+					Threshold: port.MustParseDecimal("104"),
+				},
+				Rate: rate,
+			},
+			expectedSubject: "BTC/UAH rate alert",
+			expectedText: "The BTC to UAH exchange rate has risen to ₴105.00, " +
+				"reaching your alert level of ₴104.00.",
+		},
+		{
+			name:   "Below in Ukrainian",
+			locale: "uk",
+			event: port.AlertEvent{
+				Alert: port.Alert{
+					Pair:      port.DefaultCurrencyPair,
+					Kind:      port.AlertBelow,
+					Threshold: port.MustParseDecimal("106"),
+				},
+				Rate: rate,
+			},
+			expectedSubject: "Сповіщення про курс BTC/UAH",
+			expectedText: "Курс BTC до UAH знизився до 105,00\u00a0₴ " +
+				"і досяг рівня вашого сповіщення 106,00\u00a0₴.",
+		},
+		{
+			name:   "Change",
+			locale: "en",
+			event: port.AlertEvent{
+				Alert: port.Alert{
+					Pair:      port.DefaultCurrencyPair,
+					Kind:      port.AlertChange,
+					Threshold: port.MustParseDecimal("5"),
+					Window:    port.Duration(time.Hour),
+				},
+				Rate:      rate,
+				Reference: &reference,
+				Change:    5,
+			},
+			expectedSubject: "BTC/UAH rate alert",
+			expectedText: "The BTC to UAH exchange rate has moved by 5.00% or more within 1h " +
+				"and is ₴105.00 now. The rate changed by +5.00% since ₴100.00 at 2023-07-02 11:30:00 UTC.",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			provider, err := NewProvider(&EmailSenderConfig{}, nil, &StubLinker{}, nil)
+			require.NoError(t, err)
+
+			update := rateUpdate{rate: tt.event.Rate, previous: tt.event.Reference}
+			data := withAlert(update.templateData(tt.locale), tt.event.Alert)
+
+			content, err := provider.templates.Render(send.TemplateAlert, data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedSubject, content.Subject)
+			require.Contains(t, content.Text, tt.expectedText)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+
+	"gses2-app/internal/core/port"
+)
+
+const (
+	_groupSize       = 3
+	_defaultLanguage = "en"
+	_noBreakSpace    = "\u00a0"
+	_narrowSpace     = "\u202f"
+)
+
+// numberFormat is how the amounts are written in the language,
+// symbolFirst puts the currency symbol before the amount
+type numberFormat struct {
+	decimal     string
+	group       string
+	symbolFirst bool
+}
+
+// _numberFormats are the formats by the language,
+// the other languages are formatted as English
+var _numberFormats = map[string]numberFormat{
+	"en": {decimal: ".", group: ",", symbolFirst: true},
+	"uk": {decimal: ",", group: _noBreakSpace},
+	"pl": {decimal: ",", group: _noBreakSpace},
+	"de": {decimal: ",", group: "."},
+	"fr": {decimal: ",", group: _narrowSpace},
+}
+
+// _currencySymbols are written instead of the currency codes
+var _currencySymbols = map[string]string{
+	"UAH": "₴",
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"PLN": "zł",
+}
+
+func numberFormatOf(locale string) numberFormat {
+	if format, ok := _numberFormats[port.LocaleLanguage(locale)]; ok {
+		return format
+	}
+
+	return _numberFormats[_defaultLanguage]
+}
+
+// number returns the amount rounded to the precision
+// with the digits grouped by thousands
+func (f numberFormat) number(amount port.Decimal) string {
+	fixed := amount.StringFixed(_ratePrecision)
+
+	sign := ""
+	if strings.HasPrefix(fixed, "-") {
+		sign, fixed = "-", fixed[1:]
+	}
+
+	integer, fraction, _ := strings.Cut(fixed, ".")
+
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%_groupSize == 0 {
+			grouped.WriteString(f.group)
+		}
+		grouped.WriteRune(digit)
+	}
+
+	if fraction == "" {
+		return sign + grouped.String()
+	}
+
+	return sign + grouped.String() + f.decimal + fraction
+}
+
+// price returns the amount with the currency symbol,
+// the currency without the symbol is written after the amount
+func (f numberFormat) price(amount port.Decimal, currency string) string {
+	number := f.number(amount)
+
+	symbol, ok := _currencySymbols[currency]
+	if !ok {
+		return number + _noBreakSpace + currency
+	}
+
+	if f.symbolFirst {
+		return symbol + number
+	}
+
+	return number + _noBreakSpace + symbol
+}
+
+// percent returns the signed percentage, e.g. "+2.50%"
+func (f numberFormat) percent(value float64) string {
+	return strings.Replace(fmt.Sprintf(_changeFormat, value), ".", f.decimal, 1) + "%"
+}
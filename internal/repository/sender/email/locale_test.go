@@ -0,0 +1,74 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+func TestNumberFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		locale          string
+		amount          string
+		currency        string
+		expectedNumber  string
+		expectedPrice   string
+		expectedPercent string
+	}{
+		{
+			name:            "English",
+			locale:          "en",
+			amount:          "1227057",
+			currency:        "UAH",
+			expectedNumber:  "1,227,057.00",
+			expectedPrice:   "₴1,227,057.00",
+			expectedPercent: "+2.50%",
+		},
+		{
+			name:            "Ukrainian",
+			locale:          "uk-UA",
+			amount:          "1227057",
+			currency:        "UAH",
+			expectedNumber:  "1\u00a0227\u00a0057,00",
+			expectedPrice:   "1\u00a0227\u00a0057,00\u00a0₴",
+			expectedPercent: "+2,50%",
+		},
+		{
+			name:            "Currency without symbol",
+			locale:          "uk",
+			amount:          "0.125",
+			currency:        "BTC",
+			expectedNumber:  "0,13",
+			expectedPrice:   "0,13\u00a0BTC",
+			expectedPercent: "+2,50%",
+		},
+		{
+			name:            "Unknown language formatted as English",
+			locale:          "ja",
+			amount:          "-1000.5",
+			currency:        "USD",
+			expectedNumber:  "-1,000.50",
+			expectedPrice:   "$-1,000.50",
+			expectedPercent: "+2.50%",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			format := numberFormatOf(tt.locale)
+			amount := port.MustParseDecimal(tt.amount)
+
+			require.Equal(t, tt.expectedNumber, format.number(amount))
+			require.Equal(t, tt.expectedPrice, format.price(amount, tt.currency))
+			require.Equal(t, tt.expectedPercent, format.percent(2.5))
+		})
+	}
+}
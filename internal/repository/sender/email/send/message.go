@@ -2,16 +2,24 @@ package send
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
 	"strings"
-	"text/template"
+	"time"
 )
 
-const _emailTemplate = `From: {{.From}}
-To: {{.To}}
-Subject: {{.Subject}}
-
-{{.Body}}`
+// _undisclosedRecipients is the To header of the emails sent only to Bcc
+const (
+	_undisclosedRecipients = "undisclosed-recipients:;"
+	_charset               = "utf-8"
+	_messageIDBytes        = 16
+)
 
 var (
 	errParseTemplate   = errors.New("parse template error")
@@ -21,65 +29,245 @@ var (
 type EmailConfig struct {
 	From    string `default:"no.reply@currency.info.api"`
 	Subject string `default:"BTC to UAH exchange rate"`
-	Body    string `default:"The BTC to UAH exchange rate is {{.Rate}} UAH per BTC"`
+
+	// TemplatesDir holds the email templates,
+	// the built-in templates are used when it's empty
+	TemplatesDir string
+
+	// DefaultLocale is the locale of the emails to the subscribers
+	// whose locale has no templates
+	DefaultLocale string `default:"en"`
+
+	// ChangePeriod is how far back the rate is compared
+	// to show its change in the email
+	ChangePeriod time.Duration `default:"24h"`
+
+	// Delivery is "individual" to send a personal email to every subscriber
+	// or "bcc" to send a single email with the subscribers hidden in Bcc
+	Delivery string `default:"individual"`
+
+	ConfirmationSubject string `default:"Confirm your subscription"`
+	AlertSubject        string `default:"Exchange rate alert"`
 }
 
 type TemplateData struct {
-	Rate string
+	// Locale is the locale the email is rendered in
+	Locale string
+
+	// Name and Email identify the recipient, they are empty
+	// when the email is sent to several recipients at once
+	Name  string
+	Email string
+
+	// Rate is the amount formatted in the locale, e.g. "1 227 057,00",
+	// and Price is the amount with the currency, e.g. "1 227 057,00 ₴"
+	Rate      string
+	Price     string
+	Base      string
+	Quote     string
+	Provider  string
+	FetchedAt string
+
+	// The rate the current one is compared to, Change is the signed
+	// percentage, e.g. "+2.50%", and ChangeDirection is "up", "down"
+	// or "flat". They are empty when there's no earlier rate
+	PreviousRate      string
+	PreviousPrice     string
+	PreviousFetchedAt string
+	Change            string
+	ChangeDirection   string
+
+	// The alert fired by the rate, AlertKind is "above", "below" or
+	// "change". AlertLevel is the price the level alerts fire at, AlertChange
+	// is the percent the change alerts fire at when the rate moves by it
+	// within AlertWindow, the move itself is the Change of the rate
+	AlertKind   string
+	AlertLevel  string
+	AlertChange string
+	AlertWindow string
+
+	UnsubscribeURL string
+	PreferencesURL string
+	ConfirmURL     string
 }
 
+// EmailMessage is sent to the To and Bcc recipients,
+// only the To recipients are listed in the headers. The message is
+// multipart/alternative when it has the HTML body. Date and MessageID
+// are generated when the message is prepared unless they are set
 type EmailMessage struct {
-	From    string
-	To      []string
-	Subject string
-	Body    string
+	From           string
+	To             []string
+	Bcc            []string
+	Subject        string
+	TextBody       string
+	HTMLBody       string
+	UnsubscribeURL string
+	Date           time.Time
+	MessageID      string
 }
 
+// NewEmailMessage renders the email with the name from the templates
+// in the locale of the data, the subject defined by the templates
+// takes precedence over the configured one
 func NewEmailMessage(
 	config EmailConfig,
+	templates *Templates,
+	name string,
 	to []string,
 	data TemplateData,
 ) (*EmailMessage, error) {
-	tmpl, err := template.New("email").Parse(config.Body)
+	content, err := templates.Render(name, data)
 	if err != nil {
 		return nil, err
 	}
 
-	var body bytes.Buffer
-	err = tmpl.Execute(&body, data)
-	if err != nil {
-		return nil, err
+	subject := content.Subject
+	if subject == "" {
+		subject = config.Subject
 	}
 
 	return &EmailMessage{
-		From:    config.From,
-		To:      to,
-		Subject: config.Subject,
-		Body:    body.String(),
+		From:           config.From,
+		To:             to,
+		Subject:        subject,
+		TextBody:       content.Text,
+		HTMLBody:       content.HTML,
+		UnsubscribeURL: data.UnsubscribeURL,
 	}, nil
 }
 
+// Prepare returns the MIME message, the non-ASCII subject
+// is encoded per RFC 2047 and the bodies are quoted-printable
 func (e *EmailMessage) Prepare() ([]byte, error) {
-	tmpl, err := template.New("email").Parse(_emailTemplate)
+	messageID, err := e.messageID()
 	if err != nil {
-		return nil, errParseTemplate
+		return nil, err
+	}
+
+	date := e.Date
+	if date.IsZero() {
+		date = time.Now()
 	}
 
 	var message bytes.Buffer
-	err = tmpl.Execute(&message, struct {
-		From    string
-		To      string
-		Subject string
-		Body    string
-	}{
-		From:    e.From,
-		To:      strings.Join(e.To, ","),
-		Subject: e.Subject,
-		Body:    e.Body,
-	})
+	writeHeader(&message, "From", e.From)
+	writeHeader(&message, "To", e.toHeader())
+	writeHeader(&message, "Subject", mime.QEncoding.Encode(_charset, e.Subject))
+	writeHeader(&message, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&message, "Message-ID", messageID)
+	writeHeader(&message, "MIME-Version", "1.0")
+
+	// The List-Unsubscribe headers allow one-click unsubscription, see RFC 8058
+	if e.UnsubscribeURL != "" {
+		writeHeader(&message, "List-Unsubscribe", "<"+e.UnsubscribeURL+">")
+		writeHeader(&message, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	if e.HTMLBody == "" {
+		err = writeSinglePart(&message, e.TextBody)
+	} else {
+		err = writeAlternative(&message, e.TextBody, e.HTMLBody)
+	}
 	if err != nil {
-		return nil, errExecuteTemplate
+		return nil, err
 	}
 
 	return message.Bytes(), nil
 }
+
+// Recipients returns the envelope recipients of the email
+func (e *EmailMessage) Recipients() []string {
+	recipients := make([]string, 0, len(e.To)+len(e.Bcc))
+	recipients = append(recipients, e.To...)
+
+	return append(recipients, e.Bcc...)
+}
+
+func (e *EmailMessage) toHeader() string {
+	if len(e.To) == 0 {
+		return _undisclosedRecipients
+	}
+
+	return strings.Join(e.To, ",")
+}
+
+// messageID returns the Message-ID in the domain of the sender
+func (e *EmailMessage) messageID() (string, error) {
+	if e.MessageID != "" {
+		return e.MessageID, nil
+	}
+
+	id := make([]byte, _messageIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	_, domain, found := strings.Cut(e.From, "@")
+	if !found || domain == "" {
+		domain = "localhost"
+	}
+
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">", nil
+}
+
+func writeHeader(message *bytes.Buffer, key, value string) {
+	message.WriteString(key + ": " + value + "\r\n")
+}
+
+func writeSinglePart(message *bytes.Buffer, body string) error {
+	writeHeader(message, "Content-Type", contentType("text/plain"))
+	writeHeader(message, "Content-Transfer-Encoding", "quoted-printable")
+	message.WriteString("\r\n")
+
+	return writeQuotedPrintable(message, body)
+}
+
+// writeAlternative writes the text and HTML bodies,
+// the clients show the last part they support
+func writeAlternative(message *bytes.Buffer, textBody, htmlBody string) error {
+	writer := multipart.NewWriter(message)
+	writeHeader(message, "Content-Type", mime.FormatMediaType(
+		"multipart/alternative",
+		map[string]string{"boundary": writer.Boundary()},
+	))
+	message.WriteString("\r\n")
+
+	parts := []struct {
+		mediaType string
+		body      string
+	}{
+		{mediaType: "text/plain", body: textBody},
+		{mediaType: "text/html", body: htmlBody},
+	}
+
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", contentType(part.mediaType))
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		if err = writeQuotedPrintable(partWriter, part.body); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	writer := quotedprintable.NewWriter(w)
+	if _, err := writer.Write([]byte(body)); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+func contentType(mediaType string) string {
+	return mime.FormatMediaType(mediaType, map[string]string{"charset": _charset})
+}
@@ -1,52 +1,121 @@
 package send
 
 import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewEmailMessage(t *testing.T) {
+	templates, err := LoadTemplates("", "en")
+	require.NoError(t, err)
+
+	config := EmailConfig{From: "test_from@example.com", Subject: "Test Subject"}
+
 	tests := []struct {
-		name         string
-		emailConfig  EmailConfig
-		to           []string
-		templateData TemplateData
-		expected     *EmailMessage
-		hasError     bool
+		name            string
+		template        string
+		templateData    TemplateData
+		expectedSubject string
+		expectedText    []string
+		expectedHTML    []string
+		expectedErr     error
 	}{
 		{
-			name: "Create email message",
-			emailConfig: EmailConfig{
-				From:    "test_from@example.com",
-				Subject: "Test Subject",
-				Body:    "The current exchange rate is {{.Rate}}.",
+			name:     "Create email message",
+			template: TemplateRate,
+			templateData: TemplateData{
+				Price: "₴200.00",
+				Base:  "BTC",
+				Quote: "UAH",
 			},
-			to: []string{"test_to@example.com"},
+			expectedSubject: "Test Subject",
+			expectedText:    []string{"The BTC to UAH exchange rate is ₴200.00 per BTC"},
+			expectedHTML:    []string{"₴200.00", `<html lang="en">`},
+		},
+		{
+			name:     "Create email message in locale",
+			template: TemplateRate,
 			templateData: TemplateData{
-				Rate: "200",
+				Locale: "uk",
+				Name:   "test_to",
+				Price:  "200,00\u00a0₴",
+				Base:   "BTC",
+				Quote:  "UAH",
 			},
-			expected: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to@example.com"},
-				Subject: "Test Subject",
-				Body:    "The current exchange rate is 200.",
+			expectedSubject: "Курс BTC до UAH",
+			expectedText: []string{
+				"Вітаємо, test_to!",
+				"Курс BTC до UAH становить 200,00\u00a0₴ за 1 BTC",
 			},
-			hasError: false,
+			expectedHTML: []string{"200,00\u00a0₴", `<html lang="uk">`},
 		},
 		{
-			name: "Bad template",
-			emailConfig: EmailConfig{
-				From:    "test_from@example.com",
-				Subject: "Test Subject",
-				Body:    "The current exchange rate is {{.Rate",
+			name:     "Create personal email message",
+			template: TemplateRate,
+			templateData: TemplateData{
+				Name:           "test_to",
+				Price:          "₴200.00",
+				UnsubscribeURL: "https://example.com/api/unsubscribe?token=abc",
+				PreferencesURL: "https://example.com/api/preferences?token=abc",
+			},
+			expectedSubject: "Test Subject",
+			expectedText: []string{
+				"Hello, test_to!",
+				"Delivery preferences: https://example.com/api/preferences?token=abc",
+				"Unsubscribe: https://example.com/api/unsubscribe?token=abc",
+			},
+			expectedHTML: []string{
+				"Hello, test_to!",
+				`href="https://example.com/api/preferences?token=abc"`,
+				`href="https://example.com/api/unsubscribe?token=abc"`,
 			},
-			to: []string{"test_to@example.com"},
+		},
+		{
+			name:     "Create email message with rate change",
+			template: TemplateRate,
 			templateData: TemplateData{
-				Rate: "200",
+				Price:           "₴205.00",
+				PreviousPrice:   "₴200.00",
+				Change:          "+2.50%",
+				ChangeDirection: "up",
 			},
-			expected: nil,
-			hasError: true,
+			expectedSubject: "Test Subject",
+			expectedText:    []string{"The rate changed by +2.50% since ₴200.00"},
+			expectedHTML:    []string{"&#9650; &#43;2.50%"},
+		},
+		{
+			name:     "Escape HTML",
+			template: TemplateRate,
+			templateData: TemplateData{
+				Name: "<b>test_to</b>",
+			},
+			expectedSubject: "Test Subject",
+			expectedText:    []string{"Hello, <b>test_to</b>!"},
+			expectedHTML:    []string{"Hello, &lt;b&gt;test_to&lt;/b&gt;!"},
+		},
+		{
+			name:     "Create confirmation message",
+			template: TemplateConfirmation,
+			templateData: TemplateData{
+				ConfirmURL: "https://example.com/api/confirm?token=abc",
+			},
+			expectedSubject: "Test Subject",
+			expectedText:    []string{"https://example.com/api/confirm?token=abc"},
+			expectedHTML:    []string{`href="https://example.com/api/confirm?token=abc"`},
+		},
+		{
+			name:        "Unknown template",
+			template:    "digest",
+			expectedErr: ErrTemplateNotFound,
 		},
 	}
 
@@ -55,120 +124,130 @@ func TestNewEmailMessage(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			emailMessage, err := NewEmailMessage(tt.emailConfig, tt.to, tt.templateData)
-			require.Equal(
-				t,
-				tt.hasError,
-				err != nil,
-				"NewEmailMessage() error = %v, wantErr %v",
-				err,
-				tt.hasError,
+			emailMessage, err := NewEmailMessage(
+				config,
+				templates,
+				tt.template,
+				[]string{"test_to@example.com"},
+				tt.templateData,
 			)
+			require.ErrorIs(t, err, tt.expectedErr)
 
-			if err != nil {
+			if tt.expectedErr != nil {
 				return
 			}
 
-			require.Equal(
-				t,
-				tt.expected.From,
-				emailMessage.From,
-				"From: got %v, want %v",
-				emailMessage.From,
-				tt.expected.From,
-			)
-
-			require.Equal(
-				t,
-				tt.expected.To,
-				emailMessage.To,
-				"To: got %v, want %v",
-				emailMessage.To,
-				tt.expected.To,
-			)
-
-			require.Equal(
-				t,
-				tt.expected.Subject,
-				emailMessage.Subject,
-				"Subject: got %v, want %v",
-				emailMessage.Subject,
-				tt.expected.Subject,
-			)
+			require.Equal(t, "test_from@example.com", emailMessage.From)
+			require.Equal(t, []string{"test_to@example.com"}, emailMessage.To)
+			require.Equal(t, tt.expectedSubject, emailMessage.Subject)
+			require.Equal(t, tt.templateData.UnsubscribeURL, emailMessage.UnsubscribeURL)
 
-			require.Equal(
-				t,
-				tt.expected.Body,
-				emailMessage.Body,
-				"Body: got %v, want %v",
-				emailMessage.Body,
-				tt.expected.Body,
-			)
+			for _, expected := range tt.expectedText {
+				require.Contains(t, emailMessage.TextBody, expected)
+			}
+			for _, expected := range tt.expectedHTML {
+				require.Contains(t, emailMessage.HTMLBody, expected)
+			}
 		})
 	}
 }
 
 func TestPrepare(t *testing.T) {
+	date := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+
 	tests := []struct {
-		name     string
-		message  *EmailMessage
-		expected string
+		name            string
+		message         *EmailMessage
+		expectedHeaders map[string]string
+		expectedParts   map[string]string
 	}{
 		{
-			name: "Prepare single recipient message",
+			name: "Prepare plain text message",
 			message: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to@example.com"},
-				Subject: "Test Subject",
-				Body:    "Test Body",
+				From:      "test_from@example.com",
+				To:        []string{"test_to@example.com"},
+				Subject:   "Test Subject",
+				TextBody:  "Test Body",
+				Date:      date,
+				MessageID: "<id@example.com>",
 			},
-			expected: `From: test_from@example.com
-To: test_to@example.com
-Subject: Test Subject
-
-Test Body`,
+			expectedHeaders: map[string]string{
+				"From":         "test_from@example.com",
+				"To":           "test_to@example.com",
+				"Subject":      "Test Subject",
+				"Date":         "Sat, 01 Jul 2023 12:00:00 +0000",
+				"Message-Id":   "<id@example.com>",
+				"Mime-Version": "1.0",
+			},
+			expectedParts: map[string]string{"text/plain": "Test Body"},
 		},
 		{
 			name: "Prepare multiple recipient message",
 			message: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to1@example.com", "test_to2@example.com"},
-				Subject: "Test Subject",
-				Body:    "Test Body",
+				From:     "test_from@example.com",
+				To:       []string{"test_to1@example.com", "test_to2@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
 			},
-			expected: `From: test_from@example.com
-To: test_to1@example.com,test_to2@example.com
-Subject: Test Subject
-
-Test Body`,
+			expectedHeaders: map[string]string{
+				"To": "test_to1@example.com,test_to2@example.com",
+			},
+			expectedParts: map[string]string{"text/plain": "Test Body"},
 		},
 		{
-			name: "Prepare message with no body",
+			name: "Prepare message with hidden recipients",
 			message: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to@example.com"},
-				Subject: "Test Subject",
-				Body:    "",
+				From:     "test_from@example.com",
+				Bcc:      []string{"test_to1@example.com", "test_to2@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
 			},
-			expected: `From: test_from@example.com
-To: test_to@example.com
-Subject: Test Subject
-
-`,
+			expectedHeaders: map[string]string{
+				"To": "undisclosed-recipients:;",
+			},
+			expectedParts: map[string]string{"text/plain": "Test Body"},
 		},
 		{
-			name: "Prepare message with no subject",
+			name: "Prepare message with Cyrillic subject",
 			message: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to@example.com"},
-				Subject: "",
-				Body:    "Test Body",
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Курс BTC до UAH",
+				TextBody: "Курс: 1 000 000,00 грн",
+			},
+			expectedHeaders: map[string]string{
+				"Subject": "Курс BTC до UAH",
+			},
+			expectedParts: map[string]string{"text/plain": "Курс: 1 000 000,00 грн"},
+		},
+		{
+			name: "Prepare message with unsubscribe link",
+			message: &EmailMessage{
+				From:           "test_from@example.com",
+				To:             []string{"test_to@example.com"},
+				Subject:        "Test Subject",
+				TextBody:       "Test Body",
+				UnsubscribeURL: "https://example.com/api/unsubscribe?token=abc",
+			},
+			expectedHeaders: map[string]string{
+				"List-Unsubscribe":      "<https://example.com/api/unsubscribe?token=abc>",
+				"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+			},
+			expectedParts: map[string]string{"text/plain": "Test Body"},
+		},
+		{
+			name: "Prepare multipart message",
+			message: &EmailMessage{
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
+				HTMLBody: `<p style="color:#1f2933;">Test Body</p>`,
+			},
+			expectedParts: map[string]string{
+				"text/plain": "Test Body",
+				"text/html":  `<p style="color:#1f2933;">Test Body</p>`,
 			},
-			expected: `From: test_from@example.com
-To: test_to@example.com
-Subject: 
-
-Test Body`,
 		},
 	}
 
@@ -178,16 +257,85 @@ Test Body`,
 			t.Parallel()
 
 			prepared, err := tt.message.Prepare()
+			require.NoError(t, err)
 
-			require.NoError(t, err, "Prepared message: want %v but got error %v", tt.expected, err)
-			require.Equal(
-				t,
-				tt.expected,
-				string(prepared),
-				"Prepared message: got \n%v, want \n%v",
-				string(prepared),
-				tt.expected,
-			)
+			message, err := mail.ReadMessage(bytes.NewReader(prepared))
+			require.NoError(t, err)
+
+			for key, expected := range tt.expectedHeaders {
+				require.Equal(t, expected, decodeHeader(t, message.Header.Get(key)), key)
+			}
+
+			_, err = message.Header.Date()
+			require.NoError(t, err)
+			require.True(t, strings.HasSuffix(message.Header.Get("Message-Id"), "@example.com>"))
+
+			require.Equal(t, tt.expectedParts, readParts(t, message))
 		})
 	}
 }
+
+func TestRecipients(t *testing.T) {
+	t.Parallel()
+
+	message := &EmailMessage{
+		To:  []string{"test_to@example.com"},
+		Bcc: []string{"test_bcc@example.com"},
+	}
+
+	require.Equal(
+		t,
+		[]string{"test_to@example.com", "test_bcc@example.com"},
+		message.Recipients(),
+	)
+}
+
+func decodeHeader(t *testing.T, value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	require.NoError(t, err)
+
+	return decoded
+}
+
+// readParts returns the decoded bodies of the message by their media type
+func readParts(t *testing.T, message *mail.Message) map[string]string {
+	mediaType, params := parseMediaType(t, message.Header.Get("Content-Type"))
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return map[string]string{
+			mediaType: readQuotedPrintable(t, message.Body),
+		}
+	}
+
+	require.Equal(t, "multipart/alternative", mediaType)
+
+	parts := make(map[string]string)
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		require.NoError(t, err)
+
+		partType, partParams := parseMediaType(t, part.Header.Get("Content-Type"))
+		require.Equal(t, "utf-8", partParams["charset"])
+		require.Equal(t, "quoted-printable", part.Header.Get("Content-Transfer-Encoding"))
+
+		parts[partType] = readQuotedPrintable(t, part)
+	}
+}
+
+func parseMediaType(t *testing.T, value string) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(value)
+	require.NoError(t, err)
+
+	return mediaType, params
+}
+
+func readQuotedPrintable(t *testing.T, r io.Reader) string {
+	body, err := io.ReadAll(quotedprintable.NewReader(r))
+	require.NoError(t, err)
+
+	return string(body)
+}
@@ -1,37 +1,102 @@
 package send
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
-)
-
-var (
-	errNoRecipients = errors.New("no recipients")
+	"net/textproto"
+	"strings"
 )
 
 type SenderSMTPClient interface {
 	Mail(string) error
 	Rcpt(string) error
 	Data() (io.WriteCloser, error)
+	Reset() error
 	Quit() error
 }
 
+// Signer signs the assembled message, e.g. with the DKIM signature
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// RecipientError is the reply of the server rejecting the recipient
+type RecipientError struct {
+	Recipient string
+	Err       error
+}
+
+func (e RecipientError) Error() string {
+	return fmt.Sprintf("recipient %s: %v", e.Recipient, e.Err)
+}
+
+func (e RecipientError) Unwrap() error {
+	return e.Err
+}
+
+// RecipientsError lists the recipients rejected by the server,
+// the email is still sent to the accepted recipients
+type RecipientsError struct {
+	Rejected []RecipientError
+}
+
+func (e *RecipientsError) Error() string {
+	messages := make([]string, len(e.Rejected))
+	for i, rejected := range e.Rejected {
+		messages[i] = rejected.Error()
+	}
+
+	return "rejected " + strings.Join(messages, "; ")
+}
+
+func (e *RecipientsError) Unwrap() []error {
+	errs := make([]error, len(e.Rejected))
+	for i, rejected := range e.Rejected {
+		errs[i] = rejected
+	}
+
+	return errs
+}
+
 func setMail(client SenderSMTPClient, from string) error {
 	return client.Mail(from)
 }
 
-func setRecipients(client SenderSMTPClient, to []string) error {
-	if len(to) == 0 {
-		return errNoRecipients
-	}
+// setRecipients returns the recipients accepted by the server and the
+// rejected ones, only the errors other than the server reply abort it
+func setRecipients(
+	ctx context.Context,
+	client SenderSMTPClient,
+	to []string,
+) ([]string, []RecipientError, error) {
+	var (
+		accepted []string
+		rejected []RecipientError
+	)
 
 	for _, recipient := range to {
-		if err := client.Rcpt(recipient); err != nil {
-			return err
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
 		}
+
+		err := client.Rcpt(recipient)
+
+		var reply *textproto.Error
+		if errors.As(err, &reply) {
+			rejected = append(rejected, RecipientError{Recipient: recipient, Err: err})
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		accepted = append(accepted, recipient)
 	}
 
-	return nil
+	return accepted, rejected, nil
 }
 
 func writeAndClose(client SenderSMTPClient, message []byte) error {
@@ -48,25 +113,80 @@ func writeAndClose(client SenderSMTPClient, message []byte) error {
 	return writer.Close()
 }
 
-func SendEmail(client SenderSMTPClient, email *EmailMessage) error {
-	err := setMail(client, email.From)
-	if err != nil {
+// SendEmail sends the email through the client, the context is checked
+// before every SMTP command. The recipients rejected by the server are
+// returned as *RecipientsError, the email is sent to the rest of them.
+// The email isn't signed when the signer is nil
+func SendEmail(
+	ctx context.Context,
+	client SenderSMTPClient,
+	email *EmailMessage,
+	signer Signer,
+) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	err = setRecipients(client, email.To)
-	if errors.Is(err, errNoRecipients) {
+	if len(email.Recipients()) == 0 {
 		return nil
 	}
 
+	err := setMail(client, email.From)
+	if err != nil {
+		return abort(client, err)
+	}
+
+	accepted, rejected, err := setRecipients(ctx, client, email.Recipients())
 	if err != nil {
+		return abort(client, err)
+	}
+
+	if len(accepted) == 0 {
+		return abort(client, &RecipientsError{Rejected: rejected})
+	}
+
+	if err = sendData(ctx, client, email, signer); err != nil {
 		return err
 	}
 
+	if len(rejected) > 0 {
+		return &RecipientsError{Rejected: rejected}
+	}
+
+	return nil
+}
+
+func sendData(
+	ctx context.Context,
+	client SenderSMTPClient,
+	email *EmailMessage,
+	signer Signer,
+) error {
 	emailMessage, err := email.Prepare()
 	if err != nil {
-		return err
+		return abort(client, err)
+	}
+
+	if signer != nil {
+		emailMessage, err = signer.Sign(emailMessage)
+		if err != nil {
+			return abort(client, err)
+		}
+	}
+
+	if err = ctx.Err(); err != nil {
+		return abort(client, err)
 	}
 
 	return writeAndClose(client, emailMessage)
 }
+
+// abort resets the mail transaction, so the next email
+// can be sent over the same connection
+func abort(client SenderSMTPClient, err error) error {
+	if resetErr := client.Reset(); resetErr != nil {
+		return errors.Join(err, resetErr)
+	}
+
+	return err
+}
@@ -1,8 +1,11 @@
 package send
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"io"
+	"net/textproto"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -12,6 +15,8 @@ type StubSMTPClient struct {
 	fromCalledWith    string
 	rcptCalledWith    []string
 	rcptShouldReturn  error
+	rejected          map[string]error
+	resetCalled       bool
 	dataCalled        bool
 	quitCalled        bool
 	writeCalledWith   []byte
@@ -26,9 +31,17 @@ func (m *StubSMTPClient) Mail(from string) error {
 
 func (m *StubSMTPClient) Rcpt(to string) error {
 	m.rcptCalledWith = append(m.rcptCalledWith, to)
+	if err, ok := m.rejected[to]; ok {
+		return err
+	}
 	return m.rcptShouldReturn
 }
 
+func (m *StubSMTPClient) Reset() error {
+	m.resetCalled = true
+	return nil
+}
+
 func (m *StubSMTPClient) Data() (wc io.WriteCloser, err error) {
 	m.dataCalled = true
 	if m.writeShouldReturn != nil {
@@ -51,18 +64,40 @@ func (m *StubSMTPClient) Quit() error {
 	return nil
 }
 
+const _stubSignature = "DKIM-Signature: stub\r\n"
+
+type StubSigner struct {
+	err error
+}
+
+func (s *StubSigner) Sign(message []byte) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return append([]byte(_stubSignature), message...), nil
+}
+
 type testCase struct {
 	name             string
+	ctx              context.Context
 	client           *StubSMTPClient
 	email            *EmailMessage
+	signer           Signer
+	expectSigned     bool
 	expectedErr      error
 	expectDataCalled bool
+	expectReset      bool
+	expectedRcpts    []string
+	expectedRejected []string
 }
 
 var (
 	errWrite         = errors.New("write error")
 	errSetMail       = errors.New("set mail error")
 	errSetRecipients = errors.New("set recipients error")
+	errMailbox       = &textproto.Error{Code: 550, Msg: "mailbox unavailable"}
+	errSign          = errors.New("sign error")
 )
 
 func TestSendEmail(t *testing.T) {
@@ -73,24 +108,64 @@ func TestSendEmail(t *testing.T) {
 				writeShouldReturn: nil,
 			},
 			email: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to@example.com"},
-				Subject: "Test Subject",
-				Body:    "Test Body",
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
 			},
 			expectedErr:      nil,
 			expectDataCalled: true,
 		},
+		{
+			name:   "Send signed email",
+			client: &StubSMTPClient{},
+			email: &EmailMessage{
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
+			},
+			signer:           &StubSigner{},
+			expectSigned:     true,
+			expectDataCalled: true,
+		},
+		{
+			name:   "Error on sign",
+			client: &StubSMTPClient{},
+			email: &EmailMessage{
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
+			},
+			signer:           &StubSigner{err: errSign},
+			expectedErr:      errSign,
+			expectDataCalled: false,
+			expectReset:      true,
+		},
+		{
+			name:   "Send email to hidden recipients",
+			client: &StubSMTPClient{},
+			email: &EmailMessage{
+				From:     "test_from@example.com",
+				Bcc:      []string{"test_to1@example.com", "test_to2@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
+			},
+			expectedErr:      nil,
+			expectDataCalled: true,
+			expectedRcpts:    []string{"test_to1@example.com", "test_to2@example.com"},
+		},
 		{
 			name: "Error on write",
 			client: &StubSMTPClient{
 				writeShouldReturn: errWrite,
 			},
 			email: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to@example.com"},
-				Subject: "Test Subject",
-				Body:    "Test Body",
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
 			},
 			expectedErr:      errWrite,
 			expectDataCalled: true,
@@ -101,13 +176,14 @@ func TestSendEmail(t *testing.T) {
 				mailShouldReturn: errSetMail,
 			},
 			email: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to@example.com"},
-				Subject: "Test Subject",
-				Body:    "Test Body",
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
 			},
 			expectedErr:      errSetMail,
 			expectDataCalled: false,
+			expectReset:      true,
 		},
 		{
 			name: "Error on setRecipients",
@@ -115,13 +191,60 @@ func TestSendEmail(t *testing.T) {
 				rcptShouldReturn: errSetRecipients,
 			},
 			email: &EmailMessage{
-				From:    "test_from@example.com",
-				To:      []string{"test_to@example.com"},
-				Subject: "Test Subject",
-				Body:    "Test Body",
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
 			},
 			expectedErr:      errSetRecipients,
 			expectDataCalled: false,
+			expectReset:      true,
+		},
+		{
+			name: "Rejected recipient",
+			client: &StubSMTPClient{
+				rejected: map[string]error{"test_to2@example.com": errMailbox},
+			},
+			email: &EmailMessage{
+				From:     "test_from@example.com",
+				To:       []string{"test_to1@example.com", "test_to2@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
+			},
+			expectedErr:      errMailbox,
+			expectDataCalled: true,
+			expectedRcpts:    []string{"test_to1@example.com", "test_to2@example.com"},
+			expectedRejected: []string{"test_to2@example.com"},
+		},
+		{
+			name: "All recipients rejected",
+			client: &StubSMTPClient{
+				rcptShouldReturn: errMailbox,
+			},
+			email: &EmailMessage{
+				From:     "test_from@example.com",
+				Bcc:      []string{"test_to1@example.com", "test_to2@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
+			},
+			expectedErr:      errMailbox,
+			expectDataCalled: false,
+			expectReset:      true,
+			expectedRcpts:    []string{"test_to1@example.com", "test_to2@example.com"},
+			expectedRejected: []string{"test_to1@example.com", "test_to2@example.com"},
+		},
+		{
+			name:   "Cancelled context",
+			ctx:    cancelledContext(),
+			client: &StubSMTPClient{},
+			email: &EmailMessage{
+				From:     "test_from@example.com",
+				To:       []string{"test_to@example.com"},
+				Subject:  "Test Subject",
+				TextBody: "Test Body",
+			},
+			expectedErr:      context.Canceled,
+			expectDataCalled: false,
 		},
 	}
 
@@ -130,7 +253,12 @@ func TestSendEmail(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			err := SendEmail(tt.client, tt.email)
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			err := SendEmail(ctx, tt.client, tt.email, tt.signer)
 
 			if tt.expectedErr != nil {
 				require.ErrorIs(t, err, tt.expectedErr, "Error: got %v, want %v", err, tt.expectedErr)
@@ -139,6 +267,42 @@ func TestSendEmail(t *testing.T) {
 			}
 
 			require.Equal(t, tt.expectDataCalled, tt.client.dataCalled, "Data called: got %v, want %v", tt.client.dataCalled, tt.expectDataCalled)
+
+			require.Equal(t, tt.expectReset, tt.client.resetCalled, "Reset called: got %v, want %v", tt.client.resetCalled, tt.expectReset)
+
+			if tt.expectDataCalled && tt.expectedErr == nil {
+				require.Equal(
+					t,
+					tt.expectSigned,
+					bytes.HasPrefix(tt.client.writeCalledWith, []byte(_stubSignature)),
+				)
+			}
+
+			if tt.expectedRcpts != nil {
+				require.Equal(t, tt.expectedRcpts, tt.client.rcptCalledWith)
+			}
+
+			if tt.expectedRejected != nil {
+				var recipientsErr *RecipientsError
+				require.ErrorAs(t, err, &recipientsErr)
+				require.Equal(t, tt.expectedRejected, rejectedRecipients(recipientsErr))
+			}
 		})
 	}
 }
+
+func rejectedRecipients(err *RecipientsError) []string {
+	recipients := make([]string, len(err.Rejected))
+	for i, rejected := range err.Rejected {
+		recipients[i] = rejected.Recipient
+	}
+
+	return recipients
+}
+
+func cancelledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return ctx
+}
@@ -0,0 +1,347 @@
+package send
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"gses2-app/internal/core/port"
+)
+
+// The emails rendered from the templates
+const (
+	TemplateRate         = "rate"
+	TemplateConfirmation = "confirmation"
+	TemplateAlert        = "alert"
+)
+
+// _optionalTemplates may be missing from the templates directory,
+// the emails without the templates can't be sent in the locale
+var _optionalTemplates = []string{TemplateAlert}
+
+// Every email is the content template rendered within the layout, the
+// partials are shared by all the emails of the locale. The text templates
+// are required, the HTML ones are optional. The text template may define
+// the subject of the email
+const (
+	_layout    = "layout"
+	_partials  = "partials/*"
+	_subject   = "subject"
+	_textExt   = ".txt.tmpl"
+	_htmlExt   = ".html.tmpl"
+	_templates = "templates"
+)
+
+var ErrTemplateNotFound = errors.New("email template not found")
+
+//go:embed templates
+var _builtinTemplates embed.FS
+
+// Content is the rendered email, the Subject is empty when the template
+// doesn't define it and HTML is empty when there's no HTML template
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Templates renders the emails in the locales
+type Templates struct {
+	bundles       map[string]*bundle
+	locales       []string
+	defaultLocale string
+}
+
+// bundle is the templates of a single locale
+type bundle struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// LoadTemplates parses the templates from the directory, the built-in
+// templates are used when the directory is empty. Every subdirectory named
+// after a locale, e.g. "uk" or "en-GB", holds the templates of the locale.
+// The templates right in the directory are the templates of the default
+// locale unless it has the subdirectory
+func LoadTemplates(dir, defaultLocale string) (*Templates, error) {
+	fsys, err := templatesFS(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultLocale, err = port.ParseLocale(defaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("default locale: %w", err)
+	}
+
+	templates := &Templates{
+		bundles:       make(map[string]*bundle),
+		defaultLocale: defaultLocale,
+	}
+
+	if err = templates.loadLocales(fsys); err != nil {
+		return nil, err
+	}
+
+	_, isDefaultLoaded := templates.bundles[defaultLocale]
+	_, err = fs.Stat(fsys, TemplateRate+_textExt)
+	if !isDefaultLoaded && err == nil {
+		if err = templates.load(fsys, defaultLocale); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := templates.bundles[defaultLocale]; !ok {
+		return nil, fmt.Errorf("%w for default locale %s", ErrTemplateNotFound, defaultLocale)
+	}
+
+	sort.Strings(templates.locales)
+
+	return templates, nil
+}
+
+// Match returns the locale of the templates the email in the locale is
+// rendered with: the same locale, the locale of its language or another
+// locale of the language, e.g. "en-GB" for "en-US". The default locale
+// is returned when there are no templates in the language
+func (t *Templates) Match(locale string) string {
+	if _, ok := t.bundles[locale]; ok {
+		return locale
+	}
+
+	language := port.LocaleLanguage(locale)
+	if _, ok := t.bundles[language]; ok {
+		return language
+	}
+
+	for _, candidate := range t.locales {
+		if port.LocaleLanguage(candidate) == language {
+			return candidate
+		}
+	}
+
+	return t.defaultLocale
+}
+
+// Render returns the email with the name rendered in the locale
+// of the data matched with the locales of the templates
+func (t *Templates) Render(name string, data TemplateData) (Content, error) {
+	data.Locale = t.Match(data.Locale)
+	templates := t.bundles[data.Locale]
+
+	text, ok := templates.text[name]
+	if !ok {
+		return Content{}, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	var content Content
+
+	textBody, err := executeText(text, _layout+_textExt, data)
+	if err != nil {
+		return Content{}, err
+	}
+	content.Text = textBody
+
+	if text.Lookup(_subject) != nil {
+		subject, subjectErr := executeText(text, _subject, data)
+		if subjectErr != nil {
+			return Content{}, subjectErr
+		}
+		// The subject is a single line whatever the template is
+		content.Subject = strings.Join(strings.Fields(subject), " ")
+	}
+
+	html, ok := templates.html[name]
+	if !ok {
+		return content, nil
+	}
+
+	var htmlBody bytes.Buffer
+	if err = html.Execute(&htmlBody, data); err != nil {
+		return Content{}, errors.Join(errExecuteTemplate, err)
+	}
+	content.HTML = htmlBody.String()
+
+	return content, nil
+}
+
+func (t *Templates) loadLocales(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		locale, localeErr := port.ParseLocale(entry.Name())
+		if localeErr != nil {
+			continue
+		}
+
+		localeFS, subErr := fs.Sub(fsys, entry.Name())
+		if subErr != nil {
+			return subErr
+		}
+
+		if err = t.load(localeFS, locale); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Templates) load(fsys fs.FS, locale string) error {
+	templates := &bundle{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+
+	for _, name := range []string{TemplateRate, TemplateConfirmation} {
+		if err := templates.parse(fsys, name); err != nil {
+			return fmt.Errorf("%s %s email: %w", locale, name, err)
+		}
+	}
+
+	for _, name := range _optionalTemplates {
+		if err := templates.parseOptional(fsys, name); err != nil {
+			return fmt.Errorf("%s %s email: %w", locale, name, err)
+		}
+	}
+
+	t.bundles[locale] = templates
+	t.locales = append(t.locales, locale)
+
+	return nil
+}
+
+func (b *bundle) parse(fsys fs.FS, name string) error {
+	text, err := parseText(fsys, name)
+	if err != nil {
+		return err
+	}
+	b.text[name] = text
+
+	html, err := parseHTML(fsys, name)
+	if err != nil {
+		return err
+	}
+	if html != nil {
+		b.html[name] = html
+	}
+
+	return nil
+}
+
+// parseOptional parses the email unless it has no text template
+func (b *bundle) parseOptional(fsys fs.FS, name string) error {
+	_, err := fs.Stat(fsys, name+_textExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.parse(fsys, name)
+}
+
+func executeText(tmpl *texttemplate.Template, name string, data TemplateData) (string, error) {
+	var rendered bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&rendered, name, data); err != nil {
+		return "", errors.Join(errExecuteTemplate, err)
+	}
+
+	return rendered.String(), nil
+}
+
+func templatesFS(dir string) (fs.FS, error) {
+	if dir == "" {
+		return fs.Sub(_builtinTemplates, _templates)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return os.DirFS(dir), nil
+}
+
+func parseText(fsys fs.FS, name string) (*texttemplate.Template, error) {
+	patterns, err := templatePatterns(fsys, name, _textExt)
+	if err != nil {
+		return nil, err
+	}
+	if patterns == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name+_textExt)
+	}
+
+	tmpl, err := texttemplate.New(_layout+_textExt).ParseFS(fsys, patterns...)
+	if err != nil {
+		return nil, errors.Join(errParseTemplate, err)
+	}
+
+	return tmpl, nil
+}
+
+// parseHTML returns nil when the email has no HTML template
+func parseHTML(fsys fs.FS, name string) (*htmltemplate.Template, error) {
+	patterns, err := templatePatterns(fsys, name, _htmlExt)
+	if patterns == nil || err != nil {
+		return nil, err
+	}
+
+	tmpl, err := htmltemplate.New(_layout+_htmlExt).ParseFS(fsys, patterns...)
+	if err != nil {
+		return nil, errors.Join(errParseTemplate, err)
+	}
+
+	return tmpl, nil
+}
+
+// templatePatterns returns the files making up the email with the
+// extension: the layout, the partials and the content. It returns
+// nil when there's no content template
+func templatePatterns(fsys fs.FS, name, ext string) ([]string, error) {
+	_, err := fs.Stat(fsys, name+ext)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fs.Stat(fsys, _layout+ext)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, _layout+ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := []string{_layout + ext, name + ext}
+
+	partials, err := fs.Glob(fsys, _partials+ext)
+	if err != nil {
+		return nil, err
+	}
+	if len(partials) > 0 {
+		patterns = append(patterns, _partials+ext)
+	}
+
+	return patterns, nil
+}
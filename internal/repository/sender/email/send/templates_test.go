@@ -0,0 +1,241 @@
+package send
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var _textOnlyTemplates = map[string]string{
+	"layout.txt.tmpl":        `{{template "content" .}}{{template "signature"}}`,
+	"partials/sign.txt.tmpl": `{{define "signature"}} -- Rates{{end}}`,
+	"rate.txt.tmpl":          `{{define "content"}}Rate {{.Rate}}{{end}}`,
+	"confirmation.txt.tmpl":  `{{define "content"}}Confirm {{.ConfirmURL}}{{end}}`,
+}
+
+func TestLoadTemplates(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        map[string]string
+		expectedErr  error
+		expectedText string
+		expectedHTML string
+	}{
+		{
+			name:         "Text only templates with partials",
+			files:        _textOnlyTemplates,
+			expectedText: "Rate 10.50 -- Rates",
+		},
+		{
+			name: "HTML templates",
+			files: with(_textOnlyTemplates, map[string]string{
+				"layout.html.tmpl": `<body>{{template "content" .}}</body>`,
+				"rate.html.tmpl":   `{{define "content"}}<b>{{.Rate}}</b>{{end}}`,
+			}),
+			expectedText: "Rate 10.50 -- Rates",
+			expectedHTML: "<body><b>10.50</b></body>",
+		},
+		{
+			name: "Missing text template",
+			files: map[string]string{
+				"layout.txt.tmpl": `{{template "content" .}}`,
+				"rate.txt.tmpl":   `{{define "content"}}Rate {{.Rate}}{{end}}`,
+			},
+			expectedErr: ErrTemplateNotFound,
+		},
+		{
+			name: "Missing HTML layout",
+			files: with(_textOnlyTemplates, map[string]string{
+				"rate.html.tmpl": `{{define "content"}}<b>{{.Rate}}</b>{{end}}`,
+			}),
+			expectedErr: ErrTemplateNotFound,
+		},
+		{
+			name: "Bad template",
+			files: with(_textOnlyTemplates, map[string]string{
+				"rate.txt.tmpl": `{{define "content"}}Rate {{.Rate{{end}}`,
+			}),
+			expectedErr: errParseTemplate,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			templates, err := LoadTemplates(writeTemplates(t, tt.files), "en")
+			require.ErrorIs(t, err, tt.expectedErr)
+
+			if tt.expectedErr != nil {
+				return
+			}
+
+			content, err := templates.Render(TemplateRate, TemplateData{Rate: "10.50"})
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedText, content.Text)
+			require.Equal(t, tt.expectedHTML, content.HTML)
+		})
+	}
+}
+
+func TestLoadBuiltinTemplates(t *testing.T) {
+	t.Parallel()
+
+	templates, err := LoadTemplates("", "en")
+	require.NoError(t, err)
+
+	for _, locale := range []string{"en", "uk"} {
+		require.Equal(t, locale, templates.Match(locale))
+
+		for _, name := range []string{TemplateRate, TemplateConfirmation, TemplateAlert} {
+			content, renderErr := templates.Render(name, TemplateData{Locale: locale})
+			require.NoError(t, renderErr)
+			require.NotEmpty(t, content.Text)
+			require.NotEmpty(t, content.HTML)
+		}
+	}
+}
+
+func TestOptionalTemplates(t *testing.T) {
+	t.Parallel()
+
+	templates, err := LoadTemplates(writeTemplates(t, _textOnlyTemplates), "en")
+	require.NoError(t, err)
+
+	_, err = templates.Render(TemplateAlert, TemplateData{})
+	require.ErrorIs(t, err, ErrTemplateNotFound)
+
+	templates, err = LoadTemplates(writeTemplates(t, with(_textOnlyTemplates, map[string]string{
+		"alert.txt.tmpl": `{{define "content"}}Alert {{.AlertKind}} {{.AlertLevel}}{{end}}`,
+	})), "en")
+	require.NoError(t, err)
+
+	content, err := templates.Render(TemplateAlert, TemplateData{AlertKind: "above", AlertLevel: "10.50"})
+	require.NoError(t, err)
+	require.Equal(t, "Alert above 10.50 -- Rates", content.Text)
+}
+
+func TestLocaleTemplates(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"en/layout.txt.tmpl":       `{{template "content" .}}`,
+		"en/rate.txt.tmpl":         `{{define "content"}}Rate {{.Rate}}{{end}}`,
+		"en/confirmation.txt.tmpl": `{{define "content"}}Confirm{{end}}`,
+		"uk/layout.txt.tmpl":       `{{template "content" .}}`,
+		"uk/rate.txt.tmpl": `{{define "subject"}}
+			Курс
+			{{.Base}}
+		{{end}}{{define "content"}}Курс {{.Rate}}{{end}}`,
+		"uk/confirmation.txt.tmpl":    `{{define "content"}}Підтвердіть{{end}}`,
+		"pt-BR/layout.txt.tmpl":       `{{template "content" .}}`,
+		"pt-BR/rate.txt.tmpl":         `{{define "content"}}Taxa {{.Rate}}{{end}}`,
+		"pt-BR/confirmation.txt.tmpl": `{{define "content"}}Confirme{{end}}`,
+	})
+
+	templates, err := LoadTemplates(dir, "en")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		locale          string
+		expectedLocale  string
+		expectedSubject string
+		expectedText    string
+	}{
+		{
+			name:           "Default locale",
+			locale:         "",
+			expectedLocale: "en",
+			expectedText:   "Rate 10,50",
+		},
+		{
+			name:            "Same locale",
+			locale:          "uk",
+			expectedLocale:  "uk",
+			expectedSubject: "Курс BTC",
+			expectedText:    "Курс 10,50",
+		},
+		{
+			name:            "Locale of the language",
+			locale:          "uk-UA",
+			expectedLocale:  "uk",
+			expectedSubject: "Курс BTC",
+			expectedText:    "Курс 10,50",
+		},
+		{
+			name:           "Another locale of the language",
+			locale:         "pt-PT",
+			expectedLocale: "pt-BR",
+			expectedText:   "Taxa 10,50",
+		},
+		{
+			name:           "Unknown language",
+			locale:         "de",
+			expectedLocale: "en",
+			expectedText:   "Rate 10,50",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expectedLocale, templates.Match(tt.locale))
+
+			content, err := templates.Render(
+				TemplateRate,
+				TemplateData{Locale: tt.locale, Rate: "10,50", Base: "BTC"},
+			)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedSubject, content.Subject)
+			require.Equal(t, tt.expectedText, content.Text)
+		})
+	}
+}
+
+func TestMissingDefaultLocaleTemplates(t *testing.T) {
+	t.Parallel()
+
+	dir := writeTemplates(t, map[string]string{
+		"uk/layout.txt.tmpl":       `{{template "content" .}}`,
+		"uk/rate.txt.tmpl":         `{{define "content"}}Курс{{end}}`,
+		"uk/confirmation.txt.tmpl": `{{define "content"}}Підтвердіть{{end}}`,
+	})
+
+	_, err := LoadTemplates(dir, "en")
+	require.ErrorIs(t, err, ErrTemplateNotFound)
+}
+
+func TestLoadTemplatesMissingDir(t *testing.T) {
+	t.Parallel()
+
+	_, err := LoadTemplates(filepath.Join(t.TempDir(), "missing"), "en")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	return dir
+}
+
+func with(files, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(files)+len(overrides))
+	for name, content := range files {
+		merged[name] = content
+	}
+	for name, content := range overrides {
+		merged[name] = content
+	}
+
+	return merged
+}
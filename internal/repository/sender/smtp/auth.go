@@ -0,0 +1,96 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// The authentication mechanisms
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+var (
+	ErrUnknownAuth        = errors.New("unknown smtp auth")
+	ErrMissingCredentials = errors.New("smtp user and password are required")
+	ErrUnencryptedAuth    = errors.New("unencrypted connection")
+	ErrUnexpectedPrompt   = errors.New("unexpected server challenge")
+)
+
+// newAuth returns the configured mechanism, PLAIN by default, the name
+// is case-insensitive. There's no mechanism for none, so only the others
+// need the credentials
+func newAuth(config SMTPConfig) (smtp.Auth, error) {
+	mechanism := strings.ToLower(strings.TrimSpace(config.Auth))
+
+	switch mechanism {
+	case AuthNone:
+		return nil, nil
+	case AuthPlain, AuthLogin, AuthCRAMMD5, "":
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAuth, config.Auth)
+	}
+
+	if config.User == "" || config.Password == "" {
+		return nil, fmt.Errorf("%w: %q", ErrMissingCredentials, config.Auth)
+	}
+
+	switch mechanism {
+	case AuthLogin:
+		return &loginAuth{
+			username: config.User,
+			password: config.Password,
+			host:     config.Host,
+		}, nil
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(config.User, config.Password), nil
+	default:
+		return smtp.PlainAuth("", config.User, config.Password, config.Host), nil
+	}
+}
+
+// loginAuth implements the LOGIN mechanism, like PLAIN it sends the
+// password as is, so it's refused over unencrypted connections
+// except to localhost
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, ErrUnencryptedAuth
+	}
+
+	if server.Name != a.host {
+		return "", nil, fmt.Errorf("wrong host name %q", server.Name)
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	prompt := strings.ToLower(string(fromServer))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnexpectedPrompt, fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
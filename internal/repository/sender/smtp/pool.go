@@ -0,0 +1,246 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// _checkTimeout limits the RSET and NOOP checks of the connections,
+// so a server that stopped responding doesn't hold the pool
+const _checkTimeout = 10 * time.Second
+
+var ErrPoolClosed = errors.New("smtp pool is closed")
+
+// idleConnection is the connection unused since the time
+type idleConnection struct {
+	client SMTPConnectionClient
+	since  time.Time
+}
+
+// Pool keeps up to the configured number of the SMTP connections. The
+// connections are dialed on the first use, so the pool is created even
+// when the server is unreachable, and the broken ones are replaced by
+// new connections the next time they're needed
+type Pool struct {
+	connect   func(ctx context.Context) (SMTPConnectionClient, error)
+	keepAlive time.Duration
+	maxIdle   time.Duration
+	slots     chan struct{}
+	now       func() time.Time
+
+	mu     sync.Mutex
+	idle   []idleConnection
+	closed bool
+}
+
+func NewPool(
+	config SMTPConfig,
+	dialer ConnectionDialer,
+	factory SMTPClientFactory,
+) (*Pool, error) {
+	client, err := NewSMTPClient(config, dialer, factory)
+	if err != nil {
+		return nil, err
+	}
+
+	size := config.PoolSize
+	if size < 1 {
+		size = 1
+	}
+
+	return &Pool{
+		connect:   client.Connect,
+		keepAlive: config.KeepAlive,
+		maxIdle:   config.MaxIdle,
+		slots:     make(chan struct{}, size),
+		now:       time.Now,
+	}, nil
+}
+
+// Do calls the function with a connection, it waits for a free
+// connection when all of them are in use. The idle connection that
+// turns out broken before the function is called, e.g. it was closed
+// by the server, is replaced by a new one. The function is called
+// only once, since the server could have accepted the email
+// even when the function failed. The exchanges with the server
+// are limited by the context deadline
+func (p *Pool) Do(ctx context.Context, fn func(client SMTPConnectionClient) error) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	client, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(client)
+	p.release(client, err)
+
+	return err
+}
+
+// Run sends NOOP over the idle connections every keep-alive interval
+// until the context is done, then the idle connections are closed
+func (p *Pool) Run(ctx context.Context) {
+	defer p.Close()
+
+	if p.keepAlive <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(p.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.keepIdleAlive()
+		}
+	}
+}
+
+// Close closes the idle connections, the connections
+// in use are closed when they are released
+func (p *Pool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, connection := range idle {
+		closeConnection(connection.client)
+	}
+}
+
+// get returns the most recently used idle connection or dials a new one,
+// the idle connection is checked with RSET, which also ends any mail
+// transaction left on it, and the ones idle for longer than the maximum
+// or not responding are closed. The connection is limited by the
+// context deadline
+func (p *Pool) get(ctx context.Context) (SMTPConnectionClient, error) {
+	deadline, _ := ctx.Deadline()
+
+	for {
+		connection, ok, err := p.pop()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return p.connect(ctx)
+		}
+
+		if !p.isExpired(connection) &&
+			p.check(connection.client, deadline, connection.client.Reset) == nil &&
+			connection.client.SetDeadline(deadline) == nil {
+			return connection.client, nil
+		}
+
+		closeConnection(connection.client)
+	}
+}
+
+func (p *Pool) pop() (idleConnection, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return idleConnection{}, false, ErrPoolClosed
+	}
+
+	if len(p.idle) == 0 {
+		return idleConnection{}, false, nil
+	}
+
+	connection := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+
+	return connection, true, nil
+}
+
+// release returns the connection to the pool unless the call failed
+// and the connection doesn't respond to NOOP, such a connection is closed
+func (p *Pool) release(client SMTPConnectionClient, err error) {
+	if err != nil && p.check(client, time.Time{}, client.Noop) != nil {
+		closeConnection(client)
+		return
+	}
+
+	p.putIdle(idleConnection{client: client, since: p.now()})
+}
+
+// putIdle returns the connection to the pool, the connection
+// is closed when the pool is closed or already full
+func (p *Pool) putIdle(connection idleConnection) {
+	p.mu.Lock()
+	if !p.closed && len(p.idle) < cap(p.slots) {
+		p.idle = append(p.idle, connection)
+		connection.client = nil
+	}
+	p.mu.Unlock()
+
+	if connection.client != nil {
+		closeConnection(connection.client)
+	}
+}
+
+// keepIdleAlive takes the idle connections out of the pool,
+// so they aren't used while the NOOP is sent, and puts back
+// the ones that responded and haven't expired
+func (p *Pool) keepIdleAlive() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, connection := range idle {
+		if p.isExpired(connection) ||
+			p.check(connection.client, time.Time{}, connection.client.Noop) != nil {
+			closeConnection(connection.client)
+			continue
+		}
+
+		p.putIdle(connection)
+	}
+}
+
+// check runs the check of the connection limited by _checkTimeout
+// or the deadline when it's earlier, the zero deadline isn't a limit
+func (p *Pool) check(
+	client SMTPConnectionClient,
+	deadline time.Time,
+	check func() error,
+) error {
+	limit := p.now().Add(_checkTimeout)
+	if !deadline.IsZero() && deadline.Before(limit) {
+		limit = deadline
+	}
+
+	if err := client.SetDeadline(limit); err != nil {
+		return err
+	}
+
+	return check()
+}
+
+func (p *Pool) isExpired(connection idleConnection) bool {
+	return p.maxIdle > 0 && p.now().Sub(connection.since) >= p.maxIdle
+}
+
+// closeConnection quits the session, the connection
+// is closed anyway when the server doesn't respond
+func closeConnection(client SMTPConnectionClient) {
+	if err := client.Quit(); err != nil {
+		client.Close()
+	}
+}
@@ -0,0 +1,295 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errSend = errors.New("send error")
+
+// newTestPool returns the pool dialing the clients one after another,
+// the clients dialed so far are counted in dials, they aren't authenticated
+func newTestPool(t *testing.T, config SMTPConfig, clients ...*StubSMTPClient) (*Pool, *int) {
+	config.Auth = AuthNone
+	pool, err := NewPool(config, &StubDialer{}, &StubSMTPClientFactory{})
+	require.NoError(t, err)
+
+	dials := 0
+	pool.connect = func(context.Context) (SMTPConnectionClient, error) {
+		if dials >= len(clients) {
+			return nil, errConnectionFailed
+		}
+		dials++
+
+		return clients[dials-1], nil
+	}
+
+	return pool, &dials
+}
+
+func TestPoolDialsLazily(t *testing.T) {
+	t.Parallel()
+
+	pool, dials := newTestPool(t, SMTPConfig{KeepAlive: time.Minute}, &StubSMTPClient{})
+	require.Equal(t, 0, *dials)
+
+	for i := 0; i < 2; i++ {
+		err := pool.Do(context.Background(), func(client SMTPConnectionClient) error {
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 1, *dials)
+}
+
+func TestPoolDialError(t *testing.T) {
+	t.Parallel()
+
+	pool, _ := newTestPool(t, SMTPConfig{})
+
+	err := pool.Do(context.Background(), func(client SMTPConnectionClient) error {
+		t.Fatal("the function is called without a connection")
+		return nil
+	})
+	require.ErrorIs(t, err, errConnectionFailed)
+}
+
+func TestPoolReconnect(t *testing.T) {
+	tests := []struct {
+		name          string
+		first         *StubSMTPClient
+		calls         []error
+		expectedErr   error
+		expectedDials int
+		expectedCalls int
+	}{
+		{
+			name:          "Idle connection checked with RSET",
+			first:         &StubSMTPClient{},
+			calls:         []error{nil, nil},
+			expectedDials: 1,
+			expectedCalls: 2,
+		},
+		{
+			name:          "Broken idle connection replaced before use",
+			first:         &StubSMTPClient{ResetErr: io.EOF},
+			calls:         []error{nil, nil},
+			expectedDials: 2,
+			expectedCalls: 2,
+		},
+		{
+			name:          "Failure with working connection",
+			first:         &StubSMTPClient{},
+			calls:         []error{nil, errSend},
+			expectedErr:   errSend,
+			expectedDials: 1,
+			expectedCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pool, dials := newTestPool(t, SMTPConfig{}, tt.first, &StubSMTPClient{})
+
+			calls := 0
+			var err error
+			for _, callErr := range tt.calls {
+				callErr := callErr
+				err = pool.Do(context.Background(), func(client SMTPConnectionClient) error {
+					calls++
+					return callErr
+				})
+			}
+
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Equal(t, tt.expectedDials, *dials)
+			require.Equal(t, tt.expectedCalls, calls)
+		})
+	}
+}
+
+func TestPoolReplacesBrokenIdleConnection(t *testing.T) {
+	t.Parallel()
+
+	first := &StubSMTPClient{}
+	second := &StubSMTPClient{}
+	pool, dials := newTestPool(t, SMTPConfig{KeepAlive: time.Minute}, first, second)
+
+	ctx := context.Background()
+	require.NoError(t, pool.Do(ctx, func(client SMTPConnectionClient) error {
+		return nil
+	}))
+
+	// The server closes the idle connection
+	first.ResetErr = io.EOF
+	first.quitErr = io.EOF
+
+	var used []SMTPConnectionClient
+	err := pool.Do(ctx, func(client SMTPConnectionClient) error {
+		used = append(used, client)
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 2, *dials)
+	require.Equal(t, []SMTPConnectionClient{second}, used)
+	require.Equal(t, 1, first.Closes)
+}
+
+func TestPoolDoesntRepeatStartedCall(t *testing.T) {
+	t.Parallel()
+
+	first := &StubSMTPClient{}
+	pool, dials := newTestPool(t, SMTPConfig{KeepAlive: time.Minute}, first, &StubSMTPClient{})
+
+	ctx := context.Background()
+	require.NoError(t, pool.Do(ctx, func(client SMTPConnectionClient) error {
+		return nil
+	}))
+
+	// The connection breaks after the email could have been accepted
+	calls := 0
+	err := pool.Do(ctx, func(client SMTPConnectionClient) error {
+		calls++
+		first.NoopErr = io.EOF
+		first.quitErr = io.EOF
+		return io.EOF
+	})
+
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 1, calls)
+	require.Equal(t, 1, *dials)
+	require.Equal(t, 1, first.Closes)
+}
+
+func TestPoolLimitsIdleConnectionByDeadline(t *testing.T) {
+	t.Parallel()
+
+	// The deadlines of the contexts are ahead of the real time,
+	// so the contexts aren't done while the pool uses them
+	now := time.Now().Add(time.Hour).Round(0)
+	client := &StubSMTPClient{}
+	pool, _ := newTestPool(t, SMTPConfig{}, client)
+	pool.now = func() time.Time { return now }
+
+	call := func(ctx context.Context) {
+		err := pool.Do(ctx, func(SMTPConnectionClient) error { return nil })
+		require.NoError(t, err)
+	}
+
+	late, cancelLate := context.WithDeadline(context.Background(), now.Add(time.Minute))
+	defer cancelLate()
+
+	soon, cancelSoon := context.WithDeadline(context.Background(), now.Add(time.Second))
+	defer cancelSoon()
+
+	// The new connection is limited when it's dialed
+	call(context.Background())
+	call(late)
+	call(soon)
+	call(context.Background())
+
+	require.Equal(t, []time.Time{
+		now.Add(_checkTimeout), now.Add(time.Minute),
+		now.Add(time.Second), now.Add(time.Second),
+		now.Add(_checkTimeout), {},
+	}, client.Deadlines)
+}
+
+func TestPoolLimitsKeepAlive(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	client := &StubSMTPClient{}
+	pool, _ := newTestPool(t, SMTPConfig{}, client)
+	pool.now = func() time.Time { return now }
+
+	err := pool.Do(context.Background(), func(SMTPConnectionClient) error { return nil })
+	require.NoError(t, err)
+
+	pool.keepIdleAlive()
+	require.Equal(t, 1, client.Noops)
+	require.Equal(t, []time.Time{now.Add(_checkTimeout)}, client.Deadlines)
+}
+
+func TestPoolExpiresIdleConnections(t *testing.T) {
+	t.Parallel()
+
+	first := &StubSMTPClient{}
+	pool, dials := newTestPool(t,
+		SMTPConfig{KeepAlive: time.Minute, MaxIdle: 5 * time.Minute},
+		first,
+		&StubSMTPClient{},
+	)
+
+	now := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	pool.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	noop := func(client SMTPConnectionClient) error { return nil }
+
+	require.NoError(t, pool.Do(ctx, noop))
+
+	now = now.Add(2 * time.Minute)
+	pool.keepIdleAlive()
+	require.Equal(t, 1, first.Noops)
+
+	now = now.Add(5 * time.Minute)
+	pool.keepIdleAlive()
+	require.True(t, first.quitCalled)
+
+	require.NoError(t, pool.Do(ctx, noop))
+	require.Equal(t, 2, *dials)
+}
+
+func TestPoolBoundsConnections(t *testing.T) {
+	t.Parallel()
+
+	pool, _ := newTestPool(t, SMTPConfig{PoolSize: 1}, &StubSMTPClient{})
+
+	release := make(chan struct{})
+	busy := make(chan struct{})
+	go func() {
+		_ = pool.Do(context.Background(), func(client SMTPConnectionClient) error {
+			close(busy)
+			<-release
+			return nil
+		})
+	}()
+	<-busy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := pool.Do(ctx, func(client SMTPConnectionClient) error {
+		return nil
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+}
+
+func TestPoolClose(t *testing.T) {
+	t.Parallel()
+
+	client := &StubSMTPClient{}
+	pool, _ := newTestPool(t, SMTPConfig{}, client)
+
+	ctx := context.Background()
+	noop := func(client SMTPConnectionClient) error { return nil }
+
+	require.NoError(t, pool.Do(ctx, noop))
+
+	pool.Close()
+	require.True(t, client.quitCalled)
+	require.ErrorIs(t, pool.Do(ctx, noop), ErrPoolClosed)
+}
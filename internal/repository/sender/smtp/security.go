@@ -0,0 +1,83 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The ways the connection to the SMTP server is secured
+const (
+	SecurityTLS              = "tls"
+	SecurityStartTLS         = "starttls"
+	SecurityStartTLSOptional = "starttls-optional"
+	SecurityPlain            = "plain"
+)
+
+var (
+	ErrUnknownSecurity     = errors.New("unknown smtp security")
+	ErrStartTLSUnsupported = errors.New("smtp server doesn't support STARTTLS")
+	ErrInvalidCA           = errors.New("no CA certificates found")
+	ErrClientCertificate   = errors.New("client certificate and key must be set together")
+)
+
+func isKnownSecurity(security string) bool {
+	switch security {
+	case SecurityTLS, SecurityStartTLS, SecurityStartTLSOptional, SecurityPlain:
+		return true
+	}
+
+	return false
+}
+
+// newTLSConfig returns the config verifying the server with the system
+// CAs and the CAs from the file, and presenting the client certificate
+func newTLSConfig(config SMTPConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.Host,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if config.CAFile != "" {
+		pool, err := loadCAs(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, ErrClientCertificate
+	}
+
+	if config.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCAs(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w in %s", ErrInvalidCA, path)
+	}
+
+	return pool, nil
+}
@@ -1,29 +1,75 @@
 package smtp
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"net/smtp"
 	"strconv"
+	"time"
 )
 
 type SMTPConfig struct {
 	Host     string `required:"true"`
 	Port     int    `default:"465"`
-	User     string `required:"true"`
-	Password string `required:"true"`
+	User     string
+	Password string
+
+	// Security is the way the connection is secured: the implicit TLS,
+	// the required or optional STARTTLS or none
+	Security string `default:"tls"`
+
+	// Auth is the authentication mechanism or none
+	Auth string `default:"plain"`
+
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool `default:"false"`
+
+	// CAFile is the PEM bundle of the CAs trusted in addition to the system ones,
+	// CertFile and KeyFile are the PEM client certificate and its key
+	CAFile   string
+	CertFile string
+	KeyFile  string
+
+	// PoolSize is the maximum number of the open connections
+	PoolSize int `default:"2"`
+
+	// KeepAlive is the interval of the NOOP commands sent over the idle
+	// connections, a connection idle for longer is checked before use
+	KeepAlive time.Duration `default:"30s"`
+
+	// MaxIdle is the time after which the idle connection is closed
+	MaxIdle time.Duration `default:"5m"`
 }
 
-type TLSConnectionDialer interface {
-	Dial(network, addr string, config *tls.Config) (*tls.Conn, error)
+type ConnectionDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	DialTLSContext(
+		ctx context.Context,
+		network, addr string,
+		config *tls.Config,
+	) (net.Conn, error)
 }
 
-type TLSConnectionDialerImpl struct{}
+type ConnectionDialerImpl struct{}
+
+func (d ConnectionDialerImpl) DialContext(
+	ctx context.Context,
+	network, addr string,
+) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr)
+}
 
-func (d TLSConnectionDialerImpl) Dial(network, addr string, config *tls.Config) (conn *tls.Conn, err error) {
-	return tls.Dial(network, addr, config)
+func (d ConnectionDialerImpl) DialTLSContext(
+	ctx context.Context,
+	network, addr string,
+	config *tls.Config,
+) (net.Conn, error) {
+	dialer := tls.Dialer{Config: config}
+	return dialer.DialContext(ctx, network, addr)
 }
 
 type SMTPConnectionClient interface {
@@ -32,6 +78,15 @@ type SMTPConnectionClient interface {
 	Data() (io.WriteCloser, error)
 	Mail(string) error
 	Rcpt(string) error
+	Reset() error
+	Noop() error
+	Close() error
+	Extension(string) (bool, string)
+	StartTLS(config *tls.Config) error
+
+	// SetDeadline limits the exchanges with the server
+	// until the time, the zero time removes the limit
+	SetDeadline(t time.Time) error
 }
 
 type SMTPClientFactory interface {
@@ -44,73 +99,141 @@ func (f SMTPClientFactoryImpl) NewClient(
 	conn net.Conn,
 	host string,
 ) (SMTPConnectionClient, error) {
-	return smtp.NewClient(conn, host)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connectionClient{Client: client, conn: conn}, nil
+}
+
+// connectionClient is the SMTP client with the connection it talks over,
+// the deadline of the connection still applies after STARTTLS
+type connectionClient struct {
+	*smtp.Client
+	conn net.Conn
+}
+
+func (c *connectionClient) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
 }
 
 type SMTPClient struct {
 	host              string
 	port              int
-	user              string
-	password          string
-	dialer            TLSConnectionDialer
+	security          string
+	auth              smtp.Auth
+	tlsConfig         *tls.Config
+	dialer            ConnectionDialer
 	smtpClientFactory SMTPClientFactory
 }
 
+// NewSMTPClient checks the security and authentication
+// settings and loads the certificates from the files
 func NewSMTPClient(
 	config SMTPConfig,
-	dialer TLSConnectionDialer,
+	dialer ConnectionDialer,
 	factory SMTPClientFactory,
-) *SMTPClient {
+) (*SMTPClient, error) {
+	if config.Security == "" {
+		config.Security = SecurityTLS
+	}
+
+	if !isKnownSecurity(config.Security) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSecurity, config.Security)
+	}
+
+	auth, err := newAuth(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &SMTPClient{
 		host:              config.Host,
 		port:              config.Port,
-		user:              config.User,
-		password:          config.Password,
+		security:          config.Security,
+		auth:              auth,
+		tlsConfig:         tlsConfig,
 		dialer:            dialer,
 		smtpClientFactory: factory,
-	}
+	}, nil
 }
 
-func (c *SMTPClient) createTLSConfig() *tls.Config {
-	return &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         c.host,
+func (c *SMTPClient) createConnection(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(c.host, strconv.Itoa(c.port))
+
+	if c.security == SecurityTLS {
+		return c.dialer.DialTLSContext(ctx, "tcp", addr, c.tlsConfig)
 	}
-}
 
-func (c *SMTPClient) createConnection(tlsConfig *tls.Config) (*tls.Conn, error) {
-	conn, err := c.dialer.Dial(
-		"tcp",
-		fmt.Sprintf("%s:%s", c.host, strconv.Itoa(c.port)),
-		tlsConfig,
-	)
-	return conn, err
+	return c.dialer.DialContext(ctx, "tcp", addr)
 }
 
-func (c *SMTPClient) createSMTPClient(conn *tls.Conn) (SMTPConnectionClient, error) {
+func (c *SMTPClient) createSMTPClient(conn net.Conn) (SMTPConnectionClient, error) {
 	client, err := c.smtpClientFactory.NewClient(conn, c.host)
 	return client, err
 }
 
+// startTLS upgrades the connection when STARTTLS is configured,
+// the optional STARTTLS is skipped when the server doesn't support it
+func (c *SMTPClient) startTLS(client SMTPConnectionClient) error {
+	if c.security != SecurityStartTLS && c.security != SecurityStartTLSOptional {
+		return nil
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); !ok {
+		if c.security == SecurityStartTLSOptional {
+			return nil
+		}
+
+		return ErrStartTLSUnsupported
+	}
+
+	return client.StartTLS(c.tlsConfig)
+}
+
 func (c *SMTPClient) authenticate(client SMTPConnectionClient) error {
-	auth := smtp.PlainAuth("", c.user, c.password, c.host)
-	return client.Auth(auth)
+	if c.auth == nil {
+		return nil
+	}
+
+	return client.Auth(c.auth)
 }
 
-func (c *SMTPClient) Connect() (SMTPConnectionClient, error) {
-	tlsConfig := c.createTLSConfig()
-	conn, err := c.createConnection(tlsConfig)
+// Connect dials the server and starts the session, the greeting,
+// STARTTLS and the authentication are limited by the context deadline
+func (c *SMTPClient) Connect(ctx context.Context) (SMTPConnectionClient, error) {
+	conn, err := c.createConnection(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
 	client, err := c.createSMTPClient(conn)
 	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
 		return nil, err
 	}
 
-	err = c.authenticate(client)
-	if err != nil {
+	if err = c.startTLS(client); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if err = c.authenticate(client); err != nil {
+		client.Close()
 		return nil, err
 	}
 
@@ -1,60 +1,127 @@
 package smtp
 
 import (
+	"context"
 	"errors"
+	"net/smtp"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 var (
 	errConnectionFailed = errors.New("failed to create connection")
 	errSMTPClientFailed = errors.New("failed to create SMTP client")
+	errStartTLSFailed   = errors.New("failed to start TLS")
 )
 
+var _testConfig = SMTPConfig{
+	Host:     "smtp.example.com",
+	Port:     587,
+	User:     "user@example.com",
+	Password: "password",
+}
+
+func withSecurity(security, auth string) SMTPConfig {
+	config := _testConfig
+	config.Security = security
+	config.Auth = auth
+
+	return config
+}
+
 func TestConnect(t *testing.T) {
 	tests := []struct {
-		name        string
-		dialer      TLSConnectionDialer
-		factory     SMTPClientFactory
-		config      SMTPConfig
-		expectedErr error
+		name             string
+		dialer           *StubDialer
+		client           *StubSMTPClient
+		factoryErr       error
+		config           SMTPConfig
+		expectedErr      error
+		expectedTLS      bool
+		expectedStartTLS int
+		expectedAuth     bool
 	}{
 		{
-			name:    "Successful Connection",
-			dialer:  &StubDialer{},
-			factory: &StubSMTPClientFactory{Client: &StubSMTPClient{}},
-			config: SMTPConfig{
-				Host:     "smtp.example.com",
-				Port:     587,
-				User:     "user@example.com",
-				Password: "password",
+			name:         "Successful Connection",
+			dialer:       &StubDialer{},
+			client:       &StubSMTPClient{},
+			config:       _testConfig,
+			expectedTLS:  true,
+			expectedAuth: true,
+		},
+		{
+			name:        "Fail to create connection",
+			dialer:      &StubDialer{Err: errConnectionFailed},
+			client:      &StubSMTPClient{},
+			config:      _testConfig,
+			expectedErr: errConnectionFailed,
+			expectedTLS: true,
+		},
+		{
+			name:        "Fail to create SMTP client",
+			dialer:      &StubDialer{},
+			client:      &StubSMTPClient{},
+			factoryErr:  errSMTPClientFailed,
+			config:      _testConfig,
+			expectedErr: errSMTPClientFailed,
+			expectedTLS: true,
+		},
+		{
+			name:   "STARTTLS",
+			dialer: &StubDialer{},
+			client: &StubSMTPClient{
+				Extensions: map[string]bool{"STARTTLS": true},
 			},
-			expectedErr: nil,
+			config:           withSecurity(SecurityStartTLS, AuthLogin),
+			expectedStartTLS: 1,
+			expectedAuth:     true,
 		},
 		{
-			name:    "Fail to create connection",
-			dialer:  &StubDialer{Err: errConnectionFailed},
-			factory: &StubSMTPClientFactory{Client: &StubSMTPClient{}},
-			config: SMTPConfig{
-				Host:     "smtp.example.com",
-				Port:     587,
-				User:     "user@example.com",
-				Password: "password",
+			name:        "STARTTLS unsupported",
+			dialer:      &StubDialer{},
+			client:      &StubSMTPClient{},
+			config:      withSecurity(SecurityStartTLS, AuthPlain),
+			expectedErr: ErrStartTLSUnsupported,
+		},
+		{
+			name:   "STARTTLS failed",
+			dialer: &StubDialer{},
+			client: &StubSMTPClient{
+				Extensions:  map[string]bool{"STARTTLS": true},
+				StartTLSErr: errStartTLSFailed,
 			},
-			expectedErr: errConnectionFailed,
+			config:           withSecurity(SecurityStartTLS, AuthPlain),
+			expectedErr:      errStartTLSFailed,
+			expectedStartTLS: 1,
+		},
+		{
+			name:         "Optional STARTTLS unsupported",
+			dialer:       &StubDialer{},
+			client:       &StubSMTPClient{},
+			config:       withSecurity(SecurityStartTLSOptional, AuthCRAMMD5),
+			expectedAuth: true,
 		},
 		{
-			name:   "Fail to create SMTP client",
+			name:   "Optional STARTTLS",
 			dialer: &StubDialer{},
-			factory: &StubSMTPClientFactory{
-				Client: &StubSMTPClient{}, Err: errSMTPClientFailed,
+			client: &StubSMTPClient{
+				Extensions: map[string]bool{"STARTTLS": true},
 			},
-			config: SMTPConfig{
-				Host:     "smtp.example.com",
-				Port:     587,
-				User:     "user@example.com",
-				Password: "password",
+			config:           withSecurity(SecurityStartTLSOptional, AuthPlain),
+			expectedStartTLS: 1,
+			expectedAuth:     true,
+		},
+		{
+			name:   "Plain without auth",
+			dialer: &StubDialer{},
+			client: &StubSMTPClient{
+				Extensions: map[string]bool{"STARTTLS": true},
 			},
-			expectedErr: errSMTPClientFailed,
+			config: withSecurity(SecurityPlain, AuthNone),
 		},
 	}
 
@@ -63,17 +130,168 @@ func TestConnect(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			client := NewSMTPClient(tt.config, tt.dialer, tt.factory)
-			smtpClient, err := client.Connect()
+			factory := &StubSMTPClientFactory{Client: tt.client, Err: tt.factoryErr}
+			client, err := NewSMTPClient(tt.config, tt.dialer, factory)
+			require.NoError(t, err)
 
-			if err != nil && !errors.Is(err, tt.expectedErr) {
-				t.Fatalf("Connect() error = %v, expectedErr %v", err, tt.expectedErr)
-				return
-			}
+			smtpClient, err := client.Connect(context.Background())
+			require.ErrorIs(t, err, tt.expectedErr)
 
-			if err == nil && smtpClient == nil {
-				t.Errorf("Expected smtp.Client, got nil")
+			if tt.expectedErr == nil {
+				require.NotNil(t, smtpClient)
 			}
+
+			require.Equal(t, tt.expectedTLS, tt.dialer.TLS)
+			require.Equal(t, tt.expectedStartTLS, tt.client.StartTLSs)
+			require.Equal(t, tt.expectedAuth, tt.client.authCalled)
 		})
 	}
 }
+
+func TestConnectDeadline(t *testing.T) {
+	t.Parallel()
+
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	dialer := &StubDialer{}
+	factory := &StubSMTPClientFactory{Client: &StubSMTPClient{}}
+	client, err := NewSMTPClient(withSecurity(SecurityTLS, AuthNone), dialer, factory)
+	require.NoError(t, err)
+
+	_, err = client.Connect(ctx)
+	require.NoError(t, err)
+	require.True(t, deadline.Equal(dialer.Conn.Deadline))
+}
+
+func TestNewSMTPClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	invalidCA := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalidCA, []byte("not a certificate"), 0o600))
+
+	tests := []struct {
+		name        string
+		config      SMTPConfig
+		expectedErr error
+	}{
+		{
+			name:        "Unknown security",
+			config:      withSecurity("ssl", AuthPlain),
+			expectedErr: ErrUnknownSecurity,
+		},
+		{
+			name:        "Unknown auth",
+			config:      withSecurity(SecurityTLS, "xoauth2"),
+			expectedErr: ErrUnknownAuth,
+		},
+		{
+			name: "Unknown auth without credentials",
+			config: func() SMTPConfig {
+				config := withSecurity(SecurityTLS, "xoauth2")
+				config.User, config.Password = "", ""
+				return config
+			}(),
+			expectedErr: ErrUnknownAuth,
+		},
+		{
+			name:   "Auth in upper case",
+			config: withSecurity(SecurityTLS, "CRAM-MD5"),
+		},
+		{
+			name: "No credentials without auth in upper case",
+			config: func() SMTPConfig {
+				config := withSecurity(SecurityTLS, "None")
+				config.User, config.Password = "", ""
+				return config
+			}(),
+		},
+		{
+			name: "Missing credentials",
+			config: func() SMTPConfig {
+				config := withSecurity(SecurityTLS, AuthLogin)
+				config.Password = ""
+				return config
+			}(),
+			expectedErr: ErrMissingCredentials,
+		},
+		{
+			name: "No credentials without auth",
+			config: func() SMTPConfig {
+				config := withSecurity(SecurityTLS, AuthNone)
+				config.User, config.Password = "", ""
+				return config
+			}(),
+		},
+		{
+			name: "Missing CA file",
+			config: func() SMTPConfig {
+				config := _testConfig
+				config.CAFile = filepath.Join(dir, "missing.pem")
+				return config
+			}(),
+			expectedErr: os.ErrNotExist,
+		},
+		{
+			name: "Invalid CA file",
+			config: func() SMTPConfig {
+				config := _testConfig
+				config.CAFile = invalidCA
+				return config
+			}(),
+			expectedErr: ErrInvalidCA,
+		},
+		{
+			name: "Client certificate without key",
+			config: func() SMTPConfig {
+				config := _testConfig
+				config.CertFile = invalidCA
+				return config
+			}(),
+			expectedErr: ErrClientCertificate,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewSMTPClient(tt.config, &StubDialer{}, &StubSMTPClientFactory{})
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestVerifiesServerByDefault(t *testing.T) {
+	tlsConfig, err := newTLSConfig(_testConfig)
+	require.NoError(t, err)
+	require.False(t, tlsConfig.InsecureSkipVerify)
+	require.Equal(t, "smtp.example.com", tlsConfig.ServerName)
+}
+
+func TestLoginAuth(t *testing.T) {
+	auth := &loginAuth{username: "user", password: "secret", host: "smtp.example.com"}
+
+	t.Run("Refused over unencrypted connection", func(t *testing.T) {
+		_, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com"})
+		require.ErrorIs(t, err, ErrUnencryptedAuth)
+	})
+
+	t.Run("Login", func(t *testing.T) {
+		mechanism, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true})
+		require.NoError(t, err)
+		require.Equal(t, "LOGIN", mechanism)
+
+		username, err := auth.Next([]byte("Username:"), true)
+		require.NoError(t, err)
+		require.Equal(t, "user", string(username))
+
+		password, err := auth.Next([]byte("Password:"), true)
+		require.NoError(t, err)
+		require.Equal(t, "secret", string(password))
+
+		_, err = auth.Next([]byte("Token:"), true)
+		require.ErrorIs(t, err, ErrUnexpectedPrompt)
+	})
+}
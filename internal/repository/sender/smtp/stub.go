@@ -1,10 +1,12 @@
 package smtp
 
 import (
+	"context"
 	"crypto/tls"
 	"io"
 	"net"
 	"net/smtp"
+	"time"
 )
 
 type StubWriteCloser struct {
@@ -22,6 +24,10 @@ func (m *StubWriteCloser) Write(data []byte) (int, error) {
 
 type StubSMTPClient struct {
 	authCalled bool
+
+	// AuthMechanism is the mechanism the client authenticated with
+	AuthMechanism smtp.Auth
+
 	quitCalled bool
 	dataCalled bool
 	mailCalled bool
@@ -33,11 +39,37 @@ type StubSMTPClient struct {
 	MailErr error
 	rcptErr error
 
+	// NoopErr is returned by NOOP, the connection is broken
+	NoopErr error
+
+	// ResetErr is returned by RSET, the connection is broken
+	ResetErr error
+
+	// Extensions are the extensions supported by the server
+	Extensions map[string]bool
+
+	// StartTLSErr is returned by STARTTLS
+	StartTLSErr error
+	StartTLSs   int
+
+	// RcptErrs are the errors returned for the recipients
+	RcptErrs map[string]error
+
+	Mails  int
+	Rcpts  []string
+	Resets int
+	Noops  int
+	Closes int
+
+	// Deadlines are the deadlines set in order
+	Deadlines []time.Time
+
 	writer io.WriteCloser
 }
 
 func (m *StubSMTPClient) Auth(a smtp.Auth) error {
 	m.authCalled = true
+	m.AuthMechanism = a
 	return m.authErr
 }
 
@@ -54,20 +86,97 @@ func (m *StubSMTPClient) Data() (io.WriteCloser, error) {
 
 func (m *StubSMTPClient) Mail(from string) error {
 	m.mailCalled = true
+	m.Mails++
 	return m.MailErr
 }
 
+func (m *StubSMTPClient) Reset() error {
+	m.Resets++
+	return m.ResetErr
+}
+
+func (m *StubSMTPClient) Noop() error {
+	m.Noops++
+	return m.NoopErr
+}
+
+func (m *StubSMTPClient) Close() error {
+	m.Closes++
+	return nil
+}
+
+func (m *StubSMTPClient) Extension(ext string) (bool, string) {
+	return m.Extensions[ext], ""
+}
+
+func (m *StubSMTPClient) StartTLS(config *tls.Config) error {
+	m.StartTLSs++
+	return m.StartTLSErr
+}
+
+func (m *StubSMTPClient) SetDeadline(t time.Time) error {
+	m.Deadlines = append(m.Deadlines, t)
+	return nil
+}
+
 func (m *StubSMTPClient) Rcpt(to string) error {
 	m.rcptCalled = true
+	m.Rcpts = append(m.Rcpts, to)
+	if err, ok := m.RcptErrs[to]; ok {
+		return err
+	}
 	return m.rcptErr
 }
 
+// StubConn is the connection dialed by the stub dialer, nothing
+// is sent over it, only its deadline is kept
+type StubConn struct {
+	net.Conn
+	Deadline time.Time
+}
+
+func (c *StubConn) SetDeadline(t time.Time) error {
+	c.Deadline = t
+	return nil
+}
+
+func (c *StubConn) Close() error {
+	return nil
+}
+
 type StubDialer struct {
 	Err error
+
+	// TLS is true when the connection was dialed with the implicit TLS
+	TLS bool
+
+	// Conn is the last dialed connection
+	Conn *StubConn
 }
 
-func (d *StubDialer) Dial(network string, addr string, config *tls.Config) (*tls.Conn, error) {
-	return nil, d.Err
+func (d *StubDialer) DialContext(
+	ctx context.Context,
+	network, addr string,
+) (net.Conn, error) {
+	return d.dial()
+}
+
+func (d *StubDialer) DialTLSContext(
+	ctx context.Context,
+	network, addr string,
+	config *tls.Config,
+) (net.Conn, error) {
+	d.TLS = true
+	return d.dial()
+}
+
+func (d *StubDialer) dial() (net.Conn, error) {
+	if d.Err != nil {
+		return nil, d.Err
+	}
+
+	d.Conn = &StubConn{}
+	return d.Conn, nil
 }
 
 type StubSMTPClientFactory struct {
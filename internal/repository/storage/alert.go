@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"gses2-app/internal/core/port"
+)
+
+// AlertFileStorage keeps the alerts in a JSON file,
+// the file is replaced on every change like the schedule file
+type AlertFileStorage struct {
+	FilePath string
+
+	mu sync.Mutex
+}
+
+func NewAlertFileStorage(filePath string) *AlertFileStorage {
+	return &AlertFileStorage{FilePath: filePath}
+}
+
+func (s *AlertFileStorage) Alerts(ctx context.Context) ([]port.Alert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []port.Alert
+	if err = json.Unmarshal(data, &alerts); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+func (s *AlertFileStorage) SaveAlerts(ctx context.Context, alerts []port.Alert) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(alerts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return replaceFile(s.FilePath, data)
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+func TestAlertFileStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := NewAlertFileStorage(filepath.Join(t.TempDir(), "alerts.json"))
+
+	createdAt := time.Date(2023, time.July, 1, 9, 0, 0, 0, time.UTC)
+	alerts := []port.Alert{
+		{
+			ID:         "level",
+			Email:      "test@example.com",
+			Pair:       port.DefaultCurrencyPair,
+			Kind:       port.AlertAbove,
+			Threshold:  port.MustParseDecimal("1500000.5"),
+			Hysteresis: 0.5,
+			CreatedAt:  createdAt,
+		},
+		{
+			ID:          "change",
+			Email:       "test@example.com",
+			Pair:        port.CurrencyPair{Base: "ETH", Quote: "USD"},
+			Kind:        port.AlertChange,
+			Threshold:   port.MustParseDecimal("5"),
+			Window:      port.Duration(time.Hour),
+			Hysteresis:  1,
+			Triggered:   true,
+			TriggeredAt: createdAt.Add(time.Hour),
+			CreatedAt:   createdAt,
+		},
+	}
+
+	t.Run("Read missing file", func(t *testing.T) {
+		stored, err := storage.Alerts(ctx)
+		require.NoError(t, err)
+		require.Empty(t, stored)
+	})
+
+	t.Run("Save alerts", func(t *testing.T) {
+		require.NoError(t, storage.SaveAlerts(ctx, alerts))
+
+		stored, err := storage.Alerts(ctx)
+		require.NoError(t, err)
+		require.Len(t, stored, len(alerts))
+
+		for i, alert := range stored {
+			require.Equal(t, 0, alerts[i].Threshold.Cmp(alert.Threshold))
+			alert.Threshold = alerts[i].Threshold
+			require.Equal(t, alerts[i], alert)
+		}
+	})
+}
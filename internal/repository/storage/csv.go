@@ -1,69 +1,799 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"encoding/csv"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+
+	"gses2-app/internal/core/port"
 )
 
-var _headers = []string{"email"} // The order of the columns keys
+var ErrReadOnlyStorage = errors.New("storage is read-only")
 
+// StorageConfig is the storages of the app, Driver chooses the storage
+// of the subscribers, the CSV file in Path or the SQLite database
+// in SQLitePath. The rates older than HistoryRetention are dropped
+// from the history, zero keeps all of them
 type StorageConfig struct {
-	Path string `default:"./storage/storage.csv"`
+	Driver           string        `default:"csv"`
+	Path             string        `default:"./storage/storage.csv"`
+	SQLitePath       string        `default:"./storage/storage.db"`
+	HistoryPath      string        `default:"./storage/history.jsonl"`
+	HistoryRetention time.Duration `default:"720h"`
+	OutboxPath       string        `default:"./storage/outbox.jsonl"`
+	SchedulePath     string        `default:"./storage/schedule.json"`
+	AlertsPath       string        `default:"./storage/alerts.json"`
 }
 
+// CSVStorage keeps the records in the CSV file starting with the header
+// row of the schema columns. The files written without the header are
+// read with the columns in the schema order, and the files with the header
+// of the older schema are read by the names of the columns. Such files
+// are rewritten with the current header on the next write.
+//
+// The file is read once and kept in memory with the index of the indexed
+// columns, the writes update both. The file changed by someone else, as
+// seen by its size and modification time, is read again.
+//
+// Every operation holds the mutex of the file, shared by the storages
+// of the same file, and the advisory lock of the file next to it with
+// the .lock extension, so other processes using the storage wait for it.
+// The rows are synced to the disk before the operation returns and the
+// file is only ever rewritten by replacing it, so a crash can only leave
+// the last row torn. Such a row is cut off and logged when the file is read
 type CSVStorage struct {
 	FilePath string
+	Schema   port.Schema
+
+	logger   port.Logger
+	mu       *sync.Mutex
+	cache    *csvCache
+	readOnly bool
+}
+
+// _fileMutexes are the mutexes of the files by the absolute paths
+var _fileMutexes sync.Map
+
+func NewCSVStorage(logger port.Logger, filePath string, schema port.Schema) *CSVStorage {
+	return &CSVStorage{
+		FilePath: filePath,
+		Schema:   schema,
+		logger:   logger,
+		mu:       fileMutex(filePath),
+	}
 }
 
-func NewCSVStorage(filePath string) *CSVStorage {
-	return &CSVStorage{FilePath: filePath}
+// NewReadOnlyCSVStorage returns the storage that never changes the file
+// or creates the lock file, the torn last row is skipped without cutting
+// it off and the writes return ErrReadOnlyStorage
+func NewReadOnlyCSVStorage(logger port.Logger, filePath string, schema port.Schema) *CSVStorage {
+	s := NewCSVStorage(logger, filePath, schema)
+	s.readOnly = true
+
+	return s
 }
 
-func (s *CSVStorage) AllRecords() ([]map[string]string, error) {
-	f, err := os.Open(s.FilePath)
+// fileMutex returns the mutex of the file
+func fileMutex(filePath string) *sync.Mutex {
+	if path, err := filepath.Abs(filePath); err == nil {
+		filePath = path
+	}
+
+	mu, _ := _fileMutexes.LoadOrStore(filePath, &sync.Mutex{})
+
+	return mu.(*sync.Mutex)
+}
+
+// lock takes the mutex and the advisory lock of the file,
+// the returned function releases both. The read-only storage
+// only takes the mutex when there is no lock file yet
+func (s *CSVStorage) lock(ctx context.Context) (func(), error) {
+	s.mu.Lock()
+
+	f, err := s.openLockFile()
+	if s.readOnly && errors.Is(err, os.ErrNotExist) {
+		return s.mu.Unlock, nil
+	}
+
 	if err != nil {
+		s.mu.Unlock()
 		return nil, err
 	}
-	defer f.Close()
 
-	r := csv.NewReader(f)
-	records, err := r.ReadAll()
+	if err = lockFile(ctx, f); err != nil {
+		f.Close()
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		// Closing the file releases the advisory lock
+		f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+// openLockFile opens the lock file, it's created unless the storage is read-only
+func (s *CSVStorage) openLockFile() (*os.File, error) {
+	if s.readOnly {
+		return os.Open(s.FilePath + ".lock")
+	}
+
+	return os.OpenFile(s.FilePath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+}
+
+func (s *CSVStorage) AllRecords(ctx context.Context) ([]map[string]string, error) {
+	return s.Select(ctx, func(map[string]string) bool { return true })
+}
+
+// Find returns the first record with the value under the key,
+// the records are only scanned when the column isn't indexed
+func (s *CSVStorage) Find(
+	ctx context.Context,
+	key, value string,
+) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lock(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
 
-	maps := make([]map[string]string, 0, len(records))
+	cache, err := s.load()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, record := range records {
-		rowMap := make(map[string]string, len(_headers))
-		for i, key := range _headers {
-			rowMap[key] = record[i]
+	positions := cache.find(key, value)
+	if len(positions) == 0 {
+		return nil, port.ErrRecordNotFound
+	}
+
+	return maps.Clone(cache.records[positions[0]]), nil
+}
+
+// Select returns the records kept by the filter in the file order
+func (s *CSVStorage) Select(
+	ctx context.Context,
+	filter port.RecordFilter,
+) ([]map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cache, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]map[string]string, 0, len(cache.records))
+	for _, record := range cache.records {
+		if filter(record) {
+			selected = append(selected, maps.Clone(record))
+		}
+	}
+
+	return selected, nil
+}
+
+// Page returns up to the limit records following the offset in the file order
+func (s *CSVStorage) Page(
+	ctx context.Context,
+	offset, limit int,
+) ([]map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cache, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	page := make([]map[string]string, 0, limit)
+	for i := offset; i < len(cache.records) && len(page) < limit; i++ {
+		page = append(page, maps.Clone(cache.records[i]))
+	}
+
+	return page, nil
+}
+
+// Append adds the record to the end of the file, the file without
+// the current header is rewritten with it
+func (s *CSVStorage) Append(ctx context.Context, record map[string]string) error {
+	return s.append(ctx, "", record)
+}
+
+// Insert appends the record unless there is one
+// with the same value under the key
+func (s *CSVStorage) Insert(
+	ctx context.Context,
+	key string,
+	record map[string]string,
+) error {
+	return s.append(ctx, key, record)
+}
+
+// append adds the record as Append does, the record isn't added when
+// the key is set and there is one with the same value under it
+func (s *CSVStorage) append(ctx context.Context, key string, record map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if s.readOnly {
+		return ErrReadOnlyStorage
+	}
+
+	if err := s.Schema.Validate(record); err != nil {
+		return err
+	}
+
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cache, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if key != "" && len(cache.find(key, record[key])) > 0 {
+		return port.ErrRecordExists
+	}
+
+	record = s.written(record)
+	if !slices.Equal(cache.header, s.Schema.Names()) {
+		return s.rewrite(append(slices.Clone(cache.records), record))
+	}
+
+	if err = s.appendRow(s.row(record)); err != nil {
+		s.cache = nil
+		return err
+	}
+
+	cache.add(record)
+
+	return s.snapshot(cache)
+}
+
+// Update sets the fields of the record in the records with the value
+// under the key, the storage file is replaced as in Remove
+func (s *CSVStorage) Update(
+	ctx context.Context,
+	key, value string,
+	record map[string]string,
+) (int, error) {
+	return s.UpdateAll(ctx, key, []string{value}, record)
+}
+
+// UpdateAll sets the fields of the record in the records with any of the
+// values under the key, the storage file is replaced once as in Remove
+func (s *CSVStorage) UpdateAll(
+	ctx context.Context,
+	key string,
+	values []string,
+	record map[string]string,
+) (int, error) {
+	if err := s.Schema.Validate(record); err != nil {
+		return 0, err
+	}
+
+	return s.rewriteMatching(ctx, key, values, nil, func(matched map[string]string) bool {
+		for field, fieldValue := range record {
+			matched[field] = fieldValue
+		}
+
+		return true
+	})
+}
+
+// Remove deletes the records with the value under the key, the remaining
+// records are written to a temporary file that replaces the storage file
+func (s *CSVStorage) Remove(ctx context.Context, key, value string) (int, error) {
+	return s.RemoveMatching(ctx, key, value, nil)
+}
+
+// RemoveMatching deletes the records with the value under the key kept by
+// the filter, they are checked under the lock the file is rewritten with
+func (s *CSVStorage) RemoveMatching(
+	ctx context.Context,
+	key, value string,
+	filter port.RecordFilter,
+) (int, error) {
+	return s.rewriteMatching(ctx, key, []string{value}, filter, func(map[string]string) bool {
+		return false
+	})
+}
+
+// rewriteMatching passes the records with any of the values under the key
+// kept by the filter to the keep function, which may modify them, and drops
+// the records it doesn't keep. A nil filter keeps all the records. The
+// storage file is rewritten when any record matched
+func (s *CSVStorage) rewriteMatching(
+	ctx context.Context,
+	key string,
+	values []string,
+	filter port.RecordFilter,
+	keep func(record map[string]string) bool,
+) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if s.readOnly {
+		return 0, ErrReadOnlyStorage
+	}
+
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	cache, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+
+	matches := cache.findAll(key, values)
+	if filter != nil {
+		matches = cache.filter(matches, filter)
+	}
+
+	if len(matches) == 0 {
+		return 0, nil
+	}
+
+	if err = s.rewrite(keepMatching(cache.records, matches, keep)); err != nil {
+		return 0, err
+	}
+
+	return len(matches), nil
+}
+
+// keepMatching returns the records with the copies of the records at the
+// positions passed to the keep function, the ones it doesn't keep are
+// dropped. The positions are in order, the cached records aren't changed
+func keepMatching(
+	records []map[string]string,
+	positions []int,
+	keep func(record map[string]string) bool,
+) []map[string]string {
+	kept := make([]map[string]string, 0, len(records))
+	for i, record := range records {
+		if len(positions) > 0 && positions[0] == i {
+			positions = positions[1:]
+
+			record = maps.Clone(record)
+			if !keep(record) {
+				continue
+			}
+		}
+
+		kept = append(kept, record)
+	}
+
+	return kept
+}
+
+// load returns the cached records, the file is read again
+// when it was changed since it was read or written
+func (s *CSVStorage) load() (*csvCache, error) {
+	if cache, err := s.cached(); cache != nil || err != nil {
+		return cache, err
+	}
+
+	rows, err := s.readRows()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := statFile(s.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	header, records := s.parseRecords(rows)
+
+	s.cache = newCSVCache(s.Schema, header, records)
+	s.cache.stat(info)
+
+	return s.cache, nil
+}
+
+// cached returns the cached records unless the file was changed since
+// they were cached, nil is returned when the file has to be read
+func (s *CSVStorage) cached() (*csvCache, error) {
+	info, err := statFile(s.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cache != nil && s.cache.matches(info) {
+		return s.cache, nil
+	}
+
+	return nil, nil
+}
+
+// parseRecords returns the header of the rows when they start with one
+// and the records of the other rows, the columns of the rows are named
+// by the header or by the schema for the files written before it
+func (s *CSVStorage) parseRecords(rows [][]string) ([]string, []map[string]string) {
+	var header []string
+	columns := s.Schema.Names()
+	if len(rows) > 0 && s.isHeader(rows[0]) {
+		header, rows = rows[0], rows[1:]
+		columns = header
+	}
+
+	records := make([]map[string]string, 0, len(rows))
+	for _, row := range rows {
+		record := make(map[string]string, len(columns))
+		for i, column := range columns {
+			if i < len(row) {
+				record[column] = row[i]
+			}
 		}
-		maps = append(maps, rowMap)
+		records = append(records, record)
 	}
 
-	return maps, nil
+	return header, records
 }
 
-func (s *CSVStorage) Append(record map[string]string) error {
-	f, err := os.OpenFile(s.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// snapshot remembers the state of the file written by the storage,
+// so it isn't taken for the change made by someone else
+func (s *CSVStorage) snapshot(cache *csvCache) error {
+	info, err := os.Stat(s.FilePath)
+	if err != nil {
+		s.cache = nil
+		return err
+	}
+
+	cache.stat(info)
+	s.cache = cache
+
+	return nil
+}
+
+// readRows returns the rows of the file, the missing file has no rows.
+// The torn last row is cut off the file, the complete last row without
+// the line break, as written by hand, gets the line break instead, so
+// the next row isn't appended to it
+func (s *CSVStorage) readRows() ([][]string, error) {
+	data, err := os.ReadFile(s.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, size, err := parseRows(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.repair(data, size); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// repair cuts the data after the size off the file, or ends the file
+// with the line break when it has none, the read-only file is kept
+func (s *CSVStorage) repair(data []byte, size int64) error {
+	if s.readOnly {
+		return nil
+	}
+
+	if size < int64(len(data)) {
+		s.logger.Errorf("Cutting torn last row %q off %s", data[size:], s.FilePath)
+		return truncateFile(s.FilePath, size)
+	}
+
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		return appendLineBreak(s.FilePath)
+	}
+
+	return nil
+}
+
+// parseRows returns the rows of the data and the size of the data they
+// take, the torn last row isn't returned and the size doesn't include it
+func parseRows(data []byte) ([][]string, int64, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	// The rows written before the columns were added are shorter
+	r.FieldsPerRecord = -1
+
+	var (
+		rows [][]string
+		size int64
+	)
+
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, size, nil
+		}
+
+		if isTorn(data[size:], rows, row, err) {
+			return rows, size, nil
+		}
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		rows = append(rows, row)
+		size = r.InputOffset()
+	}
+}
+
+// isTorn reports whether the row read from the rest of the data was torn
+// by the crash. Every row is written with the line break, so only the last
+// row without it can be torn, and it is when it can't be parsed or has
+// fewer fields than the first row, which is the header or the oldest row
+func isTorn(rest []byte, rows [][]string, row []string, err error) bool {
+	if bytes.IndexByte(rest, '\n') >= 0 {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	return len(rows) > 0 && len(row) < len(rows[0])
+}
+
+// statFile returns the info of the file, nil for the missing file
+func statFile(filePath string) (os.FileInfo, error) {
+	info, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return info, err
+}
+
+// truncateFile cuts the file to the size and syncs it
+func truncateFile(filePath string, size int64) error {
+	f, err := os.OpenFile(filePath, os.O_WRONLY, 0)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	w := csv.NewWriter(f)
+	if err = f.Truncate(size); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// appendLineBreak ends the file with the line break and syncs it
+func appendLineBreak(filePath string) error {
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write([]byte("\n")); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// isHeader reports whether the row is the header, which is made only
+// of the names of the columns, the first one always being the first
+// column, so no data row can be taken for it
+func (s *CSVStorage) isHeader(row []string) bool {
+	if len(s.Schema) == 0 || len(row) == 0 || row[0] != s.Schema[0].Name {
+		return false
+	}
+
+	for _, name := range row {
+		if _, ok := s.Schema.Column(name); !ok {
+			return false
+		}
+	}
+
+	return true
+}
 
-	// Build a slice of values based on the order of the keys
-	values := make([]string, 0, len(_headers))
-	for _, key := range _headers {
-		values = append(values, record[key])
+// written returns the record as it's read back from the file,
+// with the empty values of the missing columns
+func (s *CSVStorage) written(record map[string]string) map[string]string {
+	written := make(map[string]string, len(s.Schema))
+	for _, column := range s.Schema {
+		written[column.Name] = record[column.Name]
+	}
+
+	return written
+}
+
+// row returns the values of the record in the order of the columns
+func (s *CSVStorage) row(record map[string]string) []string {
+	values := make([]string, 0, len(s.Schema))
+	for _, column := range s.Schema {
+		values = append(values, record[column.Name])
+	}
+
+	return values
+}
+
+func (s *CSVStorage) appendRow(row []string) error {
+	f, err := os.OpenFile(s.FilePath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	if err = w.Write(values); err != nil {
+	w := csv.NewWriter(f)
+	if err = w.Write(row); err != nil {
 		return err
 	}
 	w.Flush()
 
-	return w.Error()
+	if err = w.Error(); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// rewrite writes the header and the records to a temporary file
+// that replaces the storage file, the records are cached as written.
+// Every update and removal rewrites and syncs the whole file, so they
+// take time in the number of the subscribers, unlike the appends
+func (s *CSVStorage) rewrite(records []map[string]string) error {
+	rows := make([][]string, 0, len(records)+1)
+	rows = append(rows, s.Schema.Names())
+	written := make([]map[string]string, 0, len(records))
+	for _, record := range records {
+		rows = append(rows, s.row(record))
+		written = append(written, s.written(record))
+	}
+
+	var data bytes.Buffer
+	if err := csv.NewWriter(&data).WriteAll(rows); err != nil {
+		return err
+	}
+
+	if err := replaceFile(s.FilePath, data.Bytes()); err != nil {
+		return err
+	}
+
+	return s.snapshot(newCSVCache(s.Schema, s.Schema.Names(), written))
+}
+
+// csvCache is the records of the file with the positions
+// of the records by the values of the indexed columns
+type csvCache struct {
+	schema  port.Schema
+	header  []string
+	records []map[string]string
+	index   map[string]map[string][]int
+
+	info os.FileInfo
+}
+
+func newCSVCache(schema port.Schema, header []string, records []map[string]string) *csvCache {
+	cache := &csvCache{
+		schema: schema,
+		header: header,
+		index:  make(map[string]map[string][]int),
+	}
+
+	for _, column := range schema {
+		if column.Index {
+			cache.index[column.Name] = make(map[string][]int)
+		}
+	}
+
+	for _, record := range records {
+		cache.add(record)
+	}
+
+	return cache
+}
+
+// add appends the record and indexes it
+func (c *csvCache) add(record map[string]string) {
+	position := len(c.records)
+	c.records = append(c.records, record)
+
+	for column, positions := range c.index {
+		value := record[column]
+		positions[value] = append(positions[value], position)
+	}
+}
+
+// find returns the positions of the records with the value
+// under the key in order, the index is used when there is one
+func (c *csvCache) find(key, value string) []int {
+	if positions, ok := c.index[key]; ok {
+		return positions[value]
+	}
+
+	var positions []int
+	for i, record := range c.records {
+		if record[key] == value {
+			positions = append(positions, i)
+		}
+	}
+
+	return positions
+}
+
+// findAll returns the positions of the records
+// with any of the values under the key in order
+func (c *csvCache) findAll(key string, values []string) []int {
+	var positions []int
+	for _, value := range values {
+		positions = append(positions, c.find(key, value)...)
+	}
+	slices.Sort(positions)
+
+	return slices.Compact(positions)
+}
+
+// filter returns the positions of the records kept by the filter
+func (c *csvCache) filter(positions []int, filter port.RecordFilter) []int {
+	kept := make([]int, 0, len(positions))
+	for _, position := range positions {
+		if filter(c.records[position]) {
+			kept = append(kept, position)
+		}
+	}
+
+	return kept
+}
+
+// stat remembers the state of the file, the nil info is the missing file
+func (c *csvCache) stat(info os.FileInfo) {
+	c.info = info
+}
+
+// matches reports whether the file is in the state the records were
+// cached in, the replaced file is told by its identity
+func (c *csvCache) matches(info os.FileInfo) bool {
+	if info == nil || c.info == nil {
+		return info == nil && c.info == nil
+	}
+
+	return os.SameFile(c.info, info) &&
+		c.info.Size() == info.Size() &&
+		c.info.ModTime().Equal(info.ModTime())
 }
@@ -1,25 +1,56 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
+	"gses2-app/internal/core/port"
 )
 
+type StubLogger struct {
+	errors atomic.Int32
+}
+
+func (s *StubLogger) Info(...interface{})           {}
+func (s *StubLogger) Infof(string, ...interface{})  {}
+func (s *StubLogger) Debug(...interface{})          {}
+func (s *StubLogger) Debugf(string, ...interface{}) {}
+func (s *StubLogger) Error(...interface{})          { s.errors.Add(1) }
+func (s *StubLogger) Errorf(string, ...interface{}) { s.errors.Add(1) }
+
 func setup(t *testing.T) (*CSVStorage, func()) {
 	tmpfile, err := os.CreateTemp("", "example")
 	if err != nil {
 		t.Fatalf("failed to create temporary file: %v", err)
 	}
 
-	storage := NewCSVStorage(tmpfile.Name())
+	storage := NewCSVStorage(&StubLogger{}, tmpfile.Name(), port.UserSchema)
 
 	return storage, func() {
 		os.Remove(tmpfile.Name())
 	}
 }
 
+// withEmptyColumns returns the record as it's read,
+// with the empty values of the missing columns
+func withEmptyColumns(record map[string]string) map[string]string {
+	for _, key := range port.UserSchema.Names() {
+		if _, ok := record[key]; !ok {
+			record[key] = ""
+		}
+	}
+
+	return record
+}
+
 func TestCSVStorageAppend(t *testing.T) {
 	storage, teardown := setup(t)
 	defer teardown()
@@ -27,7 +58,7 @@ func TestCSVStorageAppend(t *testing.T) {
 	data := map[string]string{"email": "example@test.com"}
 
 	t.Run("Append data to storage", func(t *testing.T) {
-		if err := storage.Append(data); err != nil {
+		if err := storage.Append(context.Background(), data); err != nil {
 			t.Fatalf("failed to append data: %v", err)
 		}
 	})
@@ -37,13 +68,23 @@ func TestCSVStorageAllRecords(t *testing.T) {
 	storage, teardown := setup(t)
 	defer teardown()
 
-	data := map[string]string{"email": "example@test.com"}
-	if err := storage.Append(data); err != nil {
+	data := map[string]string{
+		"email":        "example@test.com",
+		"status":       "pending",
+		"subscribedAt": "2023-07-01T12:00:00Z",
+		"locale":       "uk-UA",
+		"pairs":        "BTC/UAH,ETH/USD",
+		"frequency":    "daily",
+		"timezone":     "Europe/Kyiv",
+		"quietHours":   "22:00-07:00",
+		"notifiedAt":   "2023-07-02T09:00:00Z",
+	}
+	if err := storage.Append(context.Background(), data); err != nil {
 		t.Fatalf("failed to append data: %v", err)
 	}
 
 	t.Run("Read data from storage", func(t *testing.T) {
-		readData, err := storage.AllRecords()
+		readData, err := storage.AllRecords(context.Background())
 		if err != nil {
 			t.Fatalf("failed to read data: %v", err)
 		}
@@ -53,3 +94,656 @@ func TestCSVStorageAllRecords(t *testing.T) {
 		}
 	})
 }
+
+func TestCSVStorageRemove(t *testing.T) {
+	storage, teardown := setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	for _, email := range []string{"first@test.com", "second@test.com"} {
+		if err := storage.Append(ctx, map[string]string{"email": email}); err != nil {
+			t.Fatalf("failed to append data: %v", err)
+		}
+	}
+
+	t.Run("Remove data from storage", func(t *testing.T) {
+		removed, err := storage.Remove(ctx, "email", "first@test.com")
+		if err != nil {
+			t.Fatalf("failed to remove data: %v", err)
+		}
+
+		if removed != 1 {
+			t.Errorf("removed %d records, want 1", removed)
+		}
+
+		readData, err := storage.AllRecords(ctx)
+		if err != nil {
+			t.Fatalf("failed to read data: %v", err)
+		}
+
+		want := []map[string]string{
+			withEmptyColumns(map[string]string{"email": "second@test.com"}),
+		}
+		if diff := cmp.Diff(want, readData); diff != "" {
+			t.Errorf("remaining data does not match (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Remove missing data from storage", func(t *testing.T) {
+		removed, err := storage.Remove(ctx, "email", "missing@test.com")
+		if err != nil {
+			t.Fatalf("failed to remove data: %v", err)
+		}
+
+		if removed != 0 {
+			t.Errorf("removed %d records, want 0", removed)
+		}
+	})
+}
+
+func TestCSVStorageRemoveMatching(t *testing.T) {
+	storage, teardown := setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	records := []map[string]string{
+		{"email": "first@test.com", "status": "pending"},
+		{"email": "second@test.com", "status": "confirmed"},
+	}
+	for _, record := range records {
+		if err := storage.Append(ctx, record); err != nil {
+			t.Fatalf("failed to append data: %v", err)
+		}
+	}
+
+	pending := port.Match("status", "pending")
+	for _, email := range []string{"first@test.com", "second@test.com"} {
+		if _, err := storage.RemoveMatching(ctx, "email", email, pending); err != nil {
+			t.Fatalf("failed to remove data: %v", err)
+		}
+	}
+
+	readData, err := storage.AllRecords(ctx)
+	if err != nil {
+		t.Fatalf("failed to read data: %v", err)
+	}
+
+	want := []map[string]string{withEmptyColumns(records[1])}
+	if diff := cmp.Diff(want, readData); diff != "" {
+		t.Errorf("remaining data does not match (-want +got):\n%s", diff)
+	}
+}
+
+func TestCSVStorageRewriteKeepsMode(t *testing.T) {
+	storage, teardown := setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	for _, email := range []string{"first@test.com", "second@test.com"} {
+		if err := storage.Append(ctx, map[string]string{"email": email}); err != nil {
+			t.Fatalf("failed to append data: %v", err)
+		}
+	}
+
+	if err := os.Chmod(storage.FilePath, 0644); err != nil {
+		t.Fatalf("failed to change mode: %v", err)
+	}
+
+	if _, err := storage.Remove(ctx, "email", "first@test.com"); err != nil {
+		t.Fatalf("failed to remove data: %v", err)
+	}
+
+	info, err := os.Stat(storage.FilePath)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Errorf("file mode is %v, want %v", mode, os.FileMode(0644))
+	}
+}
+
+func TestCSVStorageUpdate(t *testing.T) {
+	storage, teardown := setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	for _, email := range []string{"first@test.com", "second@test.com"} {
+		record := map[string]string{"email": email, "status": "pending"}
+		if err := storage.Append(ctx, record); err != nil {
+			t.Fatalf("failed to append data: %v", err)
+		}
+	}
+
+	updated, err := storage.Update(
+		ctx,
+		"email", "second@test.com",
+		map[string]string{"status": "confirmed"},
+	)
+	if err != nil {
+		t.Fatalf("failed to update data: %v", err)
+	}
+
+	if updated != 1 {
+		t.Errorf("updated %d records, want 1", updated)
+	}
+
+	readData, err := storage.AllRecords(ctx)
+	if err != nil {
+		t.Fatalf("failed to read data: %v", err)
+	}
+
+	want := []map[string]string{
+		withEmptyColumns(map[string]string{"email": "first@test.com", "status": "pending"}),
+		withEmptyColumns(map[string]string{"email": "second@test.com", "status": "confirmed"}),
+	}
+	if diff := cmp.Diff(want, readData); diff != "" {
+		t.Errorf("updated data does not match (-want +got):\n%s", diff)
+	}
+}
+
+func TestCSVStorageUpdateAll(t *testing.T) {
+	storage, teardown := setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	for _, email := range []string{"first@test.com", "second@test.com", "third@test.com"} {
+		record := map[string]string{"email": email, "status": "confirmed"}
+		if err := storage.Append(ctx, record); err != nil {
+			t.Fatalf("failed to append data: %v", err)
+		}
+	}
+
+	updated, err := storage.UpdateAll(
+		ctx,
+		"email", []string{"third@test.com", "first@test.com", "missing@test.com"},
+		map[string]string{"notifiedAt": "2023-07-01T12:00:00Z"},
+	)
+	if err != nil {
+		t.Fatalf("failed to update data: %v", err)
+	}
+
+	if updated != 2 {
+		t.Errorf("updated %d records, want 2", updated)
+	}
+
+	readData, err := storage.AllRecords(ctx)
+	if err != nil {
+		t.Fatalf("failed to read data: %v", err)
+	}
+
+	want := []map[string]string{
+		withEmptyColumns(map[string]string{
+			"email": "first@test.com", "status": "confirmed", "notifiedAt": "2023-07-01T12:00:00Z",
+		}),
+		withEmptyColumns(map[string]string{"email": "second@test.com", "status": "confirmed"}),
+		withEmptyColumns(map[string]string{
+			"email": "third@test.com", "status": "confirmed", "notifiedAt": "2023-07-01T12:00:00Z",
+		}),
+	}
+	if diff := cmp.Diff(want, readData); diff != "" {
+		t.Errorf("updated data does not match (-want +got):\n%s", diff)
+	}
+}
+
+func TestCSVStorageReadsRowsWithoutNewColumns(t *testing.T) {
+	storage, teardown := setup(t)
+	defer teardown()
+
+	if err := os.WriteFile(storage.FilePath, []byte("old@test.com\n"), 0644); err != nil {
+		t.Fatalf("failed to write data: %v", err)
+	}
+
+	readData, err := storage.AllRecords(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read data: %v", err)
+	}
+
+	want := []map[string]string{{"email": "old@test.com"}}
+	if diff := cmp.Diff(want, readData); diff != "" {
+		t.Errorf("read data does not match (-want +got):\n%s", diff)
+	}
+}
+
+func TestCSVStorageWritesHeader(t *testing.T) {
+	storage := NewCSVStorage(&StubLogger{}, filepath.Join(t.TempDir(), "storage.csv"), port.UserSchema)
+
+	ctx := context.Background()
+	for _, email := range []string{"first@test.com", "second@test.com"} {
+		if err := storage.Append(ctx, map[string]string{"email": email}); err != nil {
+			t.Fatalf("failed to append data: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(storage.FilePath)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	want := "email,status,subscribedAt,locale,pairs,frequency,timezone,quietHours,notifiedAt\n" +
+		"first@test.com,,,,,,,,\n" +
+		"second@test.com,,,,,,,,\n"
+	if diff := cmp.Diff(want, string(data)); diff != "" {
+		t.Errorf("file does not match (-want +got):\n%s", diff)
+	}
+}
+
+func TestCSVStorageUpgradesOlderFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []map[string]string
+	}{
+		{
+			name: "Single column file without header",
+			data: "old@test.com\n",
+			want: []map[string]string{{"email": "old@test.com"}},
+		},
+		{
+			name: "File with the header of fewer columns",
+			data: "email,status\nold@test.com,confirmed\n",
+			want: []map[string]string{{"email": "old@test.com", "status": "confirmed"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &StubLogger{}
+			storage := NewCSVStorage(logger, filepath.Join(t.TempDir(), "storage.csv"), port.UserSchema)
+			if err := os.WriteFile(storage.FilePath, []byte(tt.data), 0644); err != nil {
+				t.Fatalf("failed to write data: %v", err)
+			}
+
+			ctx := context.Background()
+			readData, err := storage.AllRecords(ctx)
+			if err != nil {
+				t.Fatalf("failed to read data: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, readData); diff != "" {
+				t.Errorf("read data does not match (-want +got):\n%s", diff)
+			}
+
+			if err = storage.Append(ctx, map[string]string{"email": "new@test.com"}); err != nil {
+				t.Fatalf("failed to append data: %v", err)
+			}
+
+			readData, err = storage.AllRecords(ctx)
+			if err != nil {
+				t.Fatalf("failed to read data: %v", err)
+			}
+
+			want := []map[string]string{
+				withEmptyColumns(tt.want[0]),
+				withEmptyColumns(map[string]string{"email": "new@test.com"}),
+			}
+			if diff := cmp.Diff(want, readData); diff != "" {
+				t.Errorf("upgraded data does not match (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCSVStorageFindAndSelect(t *testing.T) {
+	storage := NewCSVStorage(&StubLogger{}, filepath.Join(t.TempDir(), "storage.csv"), port.UserSchema)
+
+	ctx := context.Background()
+	records := []map[string]string{
+		{"email": "first@test.com", "status": "pending"},
+		{"email": "second@test.com", "status": "confirmed"},
+		{"email": "third@test.com", "status": "confirmed"},
+	}
+	for _, record := range records {
+		if err := storage.Append(ctx, record); err != nil {
+			t.Fatalf("failed to append data: %v", err)
+		}
+	}
+
+	found, err := storage.Find(ctx, "email", "second@test.com")
+	if err != nil {
+		t.Fatalf("failed to find data: %v", err)
+	}
+
+	if diff := cmp.Diff(withEmptyColumns(records[1]), found); diff != "" {
+		t.Errorf("found data does not match (-want +got):\n%s", diff)
+	}
+
+	if _, err = storage.Find(ctx, "email", "missing@test.com"); !errors.Is(err, port.ErrRecordNotFound) {
+		t.Errorf("found missing data, got error %v", err)
+	}
+
+	selected, err := storage.Select(ctx, port.Match("status", "confirmed"))
+	if err != nil {
+		t.Fatalf("failed to select data: %v", err)
+	}
+
+	want := []map[string]string{withEmptyColumns(records[1]), withEmptyColumns(records[2])}
+	if diff := cmp.Diff(want, selected); diff != "" {
+		t.Errorf("selected data does not match (-want +got):\n%s", diff)
+	}
+}
+
+func TestCSVStorageValidatesColumns(t *testing.T) {
+	storage := NewCSVStorage(&StubLogger{}, filepath.Join(t.TempDir(), "storage.csv"), port.UserSchema)
+
+	ctx := context.Background()
+	if err := storage.Append(ctx, map[string]string{"email": "first@test.com"}); err != nil {
+		t.Fatalf("failed to append data: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		record map[string]string
+		want   error
+	}{
+		{
+			name:   "Unknown column",
+			record: map[string]string{"email": "second@test.com", "phone": "+380"},
+			want:   port.ErrUnknownColumn,
+		},
+		{
+			name:   "Invalid time",
+			record: map[string]string{"email": "second@test.com", "subscribedAt": "yesterday"},
+			want:   port.ErrInvalidValue,
+		},
+	}
+
+	for _, tt := range tests {
+		if err := storage.Append(ctx, tt.record); !errors.Is(err, tt.want) {
+			t.Errorf("%s: appended with error %v, want %v", tt.name, err, tt.want)
+		}
+
+		if _, err := storage.Update(ctx, "email", "first@test.com", tt.record); !errors.Is(err, tt.want) {
+			t.Errorf("%s: updated with error %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCSVStorageCache(t *testing.T) {
+	storage := NewCSVStorage(&StubLogger{}, filepath.Join(t.TempDir(), "storage.csv"), port.UserSchema)
+
+	ctx := context.Background()
+	if err := storage.Append(ctx, map[string]string{"email": "first@test.com"}); err != nil {
+		t.Fatalf("failed to append data: %v", err)
+	}
+
+	info, err := os.Stat(storage.FilePath)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+
+	t.Run("Unchanged file is not read again", func(t *testing.T) {
+		// The row of the same size written with the same
+		// modification time can't be told from the cached one
+		data, err := os.ReadFile(storage.FilePath)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+
+		data = bytes.Replace(data, []byte("first@test.com"), []byte("first@test.org"), 1)
+		if err = os.WriteFile(storage.FilePath, data, 0644); err != nil {
+			t.Fatalf("failed to write data: %v", err)
+		}
+		if err = os.Chtimes(storage.FilePath, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatalf("failed to change times: %v", err)
+		}
+
+		if _, err = storage.Find(ctx, "email", "first@test.com"); err != nil {
+			t.Errorf("failed to find cached data: %v", err)
+		}
+	})
+
+	t.Run("Changed file is read again", func(t *testing.T) {
+		data := []byte("email\nfirst@test.com\nexternal@test.com\n")
+		if err = os.WriteFile(storage.FilePath, data, 0644); err != nil {
+			t.Fatalf("failed to write data: %v", err)
+		}
+
+		found, err := storage.Find(ctx, "email", "external@test.com")
+		if err != nil {
+			t.Fatalf("failed to find data: %v", err)
+		}
+
+		if diff := cmp.Diff(map[string]string{"email": "external@test.com"}, found); diff != "" {
+			t.Errorf("found data does not match (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Removed file has no records", func(t *testing.T) {
+		if err = os.Remove(storage.FilePath); err != nil {
+			t.Fatalf("failed to remove file: %v", err)
+		}
+
+		records, err := storage.AllRecords(ctx)
+		if err != nil {
+			t.Fatalf("failed to read data: %v", err)
+		}
+
+		if len(records) != 0 {
+			t.Errorf("read %d records, want 0", len(records))
+		}
+	})
+}
+
+func TestCSVStorageConcurrentInsert(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "storage.csv")
+
+	// The storages of the same file are used like by the separate requests
+	storages := []*CSVStorage{
+		NewCSVStorage(&StubLogger{}, filePath, port.UserSchema),
+		NewCSVStorage(&StubLogger{}, filePath, port.UserSchema),
+	}
+
+	const inserts = 20
+
+	var (
+		wg       sync.WaitGroup
+		inserted atomic.Int32
+	)
+
+	ctx := context.Background()
+	for i := 0; i < inserts; i++ {
+		wg.Add(1)
+		go func(storage *CSVStorage) {
+			defer wg.Done()
+
+			err := storage.Insert(ctx, "email", map[string]string{"email": "same@test.com"})
+			if err == nil {
+				inserted.Add(1)
+				return
+			}
+
+			if !errors.Is(err, port.ErrRecordExists) {
+				t.Errorf("failed to insert data: %v", err)
+			}
+		}(storages[i%len(storages)])
+	}
+	wg.Wait()
+
+	if inserted.Load() != 1 {
+		t.Errorf("inserted %d records, want 1", inserted.Load())
+	}
+
+	records, err := NewCSVStorage(&StubLogger{}, filePath, port.UserSchema).AllRecords(ctx)
+	if err != nil {
+		t.Fatalf("failed to read data: %v", err)
+	}
+
+	if len(records) != 1 {
+		t.Errorf("stored %d records, want 1", len(records))
+	}
+}
+
+func TestCSVStorageRepairsTornRow(t *testing.T) {
+	header := "email,status,subscribedAt,locale,pairs,frequency,timezone,quietHours,notifiedAt\n"
+
+	tests := []struct {
+		name     string
+		data     string
+		wantData string
+		want     []map[string]string
+		wantCut  bool
+		wantErr  bool
+	}{
+		{
+			name:     "Torn last row",
+			data:     header + "first@test.com,,,,,,,,\nsecond@te",
+			wantData: header + "first@test.com,,,,,,,,\n",
+			want:     []map[string]string{withEmptyColumns(map[string]string{"email": "first@test.com"})},
+			wantCut:  true,
+		},
+		{
+			name:     "Torn quoted value",
+			data:     header + "first@test.com,,,,,,,,\nsecond@test.com,,,,\"BTC/UAH,ETH",
+			wantData: header + "first@test.com,,,,,,,,\n",
+			want:     []map[string]string{withEmptyColumns(map[string]string{"email": "first@test.com"})},
+			wantCut:  true,
+		},
+		{
+			name:     "Valid last row without line break",
+			data:     header + "first@test.com,,,,,,,,\nsecond@test.com,,,,,,,,",
+			wantData: header + "first@test.com,,,,,,,,\nsecond@test.com,,,,,,,,\n",
+			want: []map[string]string{
+				withEmptyColumns(map[string]string{"email": "first@test.com"}),
+				withEmptyColumns(map[string]string{"email": "second@test.com"}),
+			},
+		},
+		{
+			name:     "Only row without line break",
+			data:     "first@test.com",
+			wantData: "first@test.com\n",
+			want:     []map[string]string{{"email": "first@test.com"}},
+		},
+		{
+			name:    "Broken row in the middle",
+			data:    header + "first@test.com,,,,\"BTC/UAH\"x,,,,\nsecond@test.com,,,,,,,,\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &StubLogger{}
+			storage := NewCSVStorage(logger, filepath.Join(t.TempDir(), "storage.csv"), port.UserSchema)
+			if err := os.WriteFile(storage.FilePath, []byte(tt.data), 0644); err != nil {
+				t.Fatalf("failed to write data: %v", err)
+			}
+
+			readData, err := storage.AllRecords(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("read broken data without error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to read data: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, readData); diff != "" {
+				t.Errorf("read data does not match (-want +got):\n%s", diff)
+			}
+
+			data, err := os.ReadFile(storage.FilePath)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.wantData, string(data)); diff != "" {
+				t.Errorf("repaired file does not match (-want +got):\n%s", diff)
+			}
+
+			if cut := logger.errors.Load() > 0; cut != tt.wantCut {
+				t.Errorf("logged the cut row %v, want %v", cut, tt.wantCut)
+			}
+		})
+	}
+}
+
+func TestCSVStoragePage(t *testing.T) {
+	storage, teardown := setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	for _, email := range []string{"first@test.com", "second@test.com", "third@test.com"} {
+		if err := storage.Append(ctx, map[string]string{"email": email}); err != nil {
+			t.Fatalf("failed to append data: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   []string
+	}{
+		{name: "First page", offset: 0, limit: 2, want: []string{"first@test.com", "second@test.com"}},
+		{name: "Last page", offset: 2, limit: 2, want: []string{"third@test.com"}},
+		{name: "After the records", offset: 3, limit: 2, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := storage.Page(ctx, tt.offset, tt.limit)
+			if err != nil {
+				t.Fatalf("failed to read page: %v", err)
+			}
+
+			emails := []string{}
+			for _, record := range page {
+				emails = append(emails, record["email"])
+			}
+
+			if diff := cmp.Diff(tt.want, emails); diff != "" {
+				t.Errorf("page does not match (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadOnlyCSVStorage(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "storage.csv")
+	header := "email,status,subscribedAt,locale,pairs,frequency,timezone,quietHours,notifiedAt\n"
+	data := header + "first@test.com,,,,,,,,\nsecond@te"
+	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write data: %v", err)
+	}
+
+	storage := NewReadOnlyCSVStorage(&StubLogger{}, filePath, port.UserSchema)
+	ctx := context.Background()
+
+	readData, err := storage.AllRecords(ctx)
+	if err != nil {
+		t.Fatalf("failed to read data: %v", err)
+	}
+
+	want := []map[string]string{withEmptyColumns(map[string]string{"email": "first@test.com"})}
+	if diff := cmp.Diff(want, readData); diff != "" {
+		t.Errorf("read data does not match (-want +got):\n%s", diff)
+	}
+
+	if err = storage.Append(ctx, map[string]string{"email": "third@test.com"}); !errors.Is(err, ErrReadOnlyStorage) {
+		t.Errorf("appended to read-only storage, got error %v", err)
+	}
+
+	if _, err = storage.Remove(ctx, "email", "first@test.com"); !errors.Is(err, ErrReadOnlyStorage) {
+		t.Errorf("removed from read-only storage, got error %v", err)
+	}
+
+	written, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	if string(written) != data {
+		t.Errorf("read-only file changed to %q", written)
+	}
+
+	if _, err = os.Stat(filePath + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("read-only storage created the lock file")
+	}
+}
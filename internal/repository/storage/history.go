@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+// _historyCompactInterval is the interval between the compactions,
+// the first rate added after the start is preceded by one
+const _historyCompactInterval = time.Hour
+
+// HistoryFileStorage keeps the rate history in a file, one JSON encoded
+// rate per line. Every rate is synced to the disk, so a crash can only
+// leave the last one torn, such a rate is skipped by the reads and cut
+// off by the next compaction. The rates older than the retention are
+// dropped by the compaction through a temporary file that replaces the
+// history, so the file read by every query stays bounded
+type HistoryFileStorage struct {
+	FilePath  string
+	Retention time.Duration
+
+	logger      port.Logger
+	mu          sync.Mutex
+	compactedAt time.Time
+	now         func() time.Time
+}
+
+func NewHistoryFileStorage(
+	logger port.Logger,
+	filePath string,
+	retention time.Duration,
+) *HistoryFileStorage {
+	return &HistoryFileStorage{
+		FilePath:  filePath,
+		Retention: retention,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Add appends the rate to the history and syncs it, the history
+// is compacted first when the compaction interval has passed
+func (s *HistoryFileStorage) Add(rate port.Rate) error {
+	line, err := json.Marshal(rate)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.compact()
+
+	f, err := os.OpenFile(s.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// Range returns the rates of the pair fetched within [from, to)
+func (s *HistoryFileStorage) Range(
+	pair port.CurrencyPair,
+	from, to time.Time,
+) ([]port.Rate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rates []port.Rate
+	err := s.scan(func(rate port.Rate) error {
+		isInRange := !rate.FetchedAt.Before(from) && rate.FetchedAt.Before(to)
+		if rate.Pair == pair && isInRange {
+			rates = append(rates, rate)
+		}
+
+		return nil
+	})
+
+	return rates, err
+}
+
+// scan passes the rates of the history to the function in order, the
+// torn last rate is logged and skipped while the other invalid ones fail
+func (s *HistoryFileStorage) scan(fn func(rate port.Rate) error) error {
+	f, err := os.Open(s.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		isLast := errors.Is(readErr, io.EOF)
+		if readErr != nil && !isLast {
+			return readErr
+		}
+
+		if err = s.scanLine(line, isLast, fn); err != nil {
+			return err
+		}
+
+		if isLast {
+			return nil
+		}
+	}
+}
+
+// scanLine passes the rate of the line to the function, the blank
+// lines and the last line that can't be parsed, as it's torn, are skipped
+func (s *HistoryFileStorage) scanLine(
+	line []byte,
+	isLast bool,
+	fn func(rate port.Rate) error,
+) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+
+	var rate port.Rate
+	err := json.Unmarshal(line, &rate)
+	if err != nil && isLast {
+		s.logger.Errorf("Skipping torn last rate %q of %s", line, s.FilePath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return fn(rate)
+}
+
+// compact rewrites the history without the rates older than the
+// retention and the torn last rate once per compaction interval.
+// The history is valid without the compaction, so its failure is
+// only logged
+func (s *HistoryFileStorage) compact() {
+	now := s.now()
+	if now.Sub(s.compactedAt) < _historyCompactInterval {
+		return
+	}
+	s.compactedAt = now
+
+	if err := s.save(now.Add(-s.Retention)); err != nil {
+		s.logger.Errorf("Failed to compact %s: %s", s.FilePath, err)
+	}
+}
+
+// save replaces the history with the rates fetched since the time, the
+// zero retention keeps all of them. The file isn't replaced when nothing
+// was dropped from it
+func (s *HistoryFileStorage) save(since time.Time) error {
+	info, err := os.Stat(s.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	err = s.scan(func(rate port.Rate) error {
+		if s.Retention > 0 && rate.FetchedAt.Before(since) {
+			return nil
+		}
+
+		return encoder.Encode(rate)
+	})
+	if err != nil {
+		return err
+	}
+
+	if int64(data.Len()) == info.Size() {
+		return nil
+	}
+
+	return replaceFile(s.FilePath, data.Bytes())
+}
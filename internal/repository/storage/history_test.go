@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+func TestHistoryFileStorage(t *testing.T) {
+	storage := NewHistoryFileStorage(
+		&StubLogger{},
+		filepath.Join(t.TempDir(), "history.jsonl"),
+		0,
+	)
+
+	start := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	ethUSD := port.CurrencyPair{Base: "ETH", Quote: "USD"}
+
+	rates := []port.Rate{
+		{
+			Amount:    port.MustParseDecimal("1227057.123456789"),
+			Pair:      port.DefaultCurrencyPair,
+			Provider:  "KunaRateProvider",
+			FetchedAt: start,
+		},
+		{
+			Amount:    port.MustParseDecimal("1890.5"),
+			Pair:      ethUSD,
+			Provider:  "BinanceRateProvider",
+			FetchedAt: start.Add(time.Minute),
+		},
+		{
+			Amount:    port.MustParseDecimal("1227100"),
+			Pair:      port.DefaultCurrencyPair,
+			Provider:  "KunaRateProvider",
+			FetchedAt: start.Add(time.Hour),
+		},
+	}
+
+	t.Run("Read missing history", func(t *testing.T) {
+		history, err := storage.Range(port.DefaultCurrencyPair, start, start.Add(time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, history)
+	})
+
+	for _, rate := range rates {
+		require.NoError(t, storage.Add(rate))
+	}
+
+	t.Run("Read history of a pair within the range", func(t *testing.T) {
+		history, err := storage.Range(port.DefaultCurrencyPair, start, start.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Equal(t, "1227057.123456789", history[0].Amount.String())
+		require.Equal(t, "KunaRateProvider", history[0].Provider)
+		require.True(t, start.Equal(history[0].FetchedAt))
+	})
+
+	t.Run("Corrupted history", func(t *testing.T) {
+		f, err := os.OpenFile(storage.FilePath, os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = f.WriteString("not a rate\n")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		_, err = storage.Range(ethUSD, start, start.Add(time.Hour))
+		require.Error(t, err)
+	})
+}
+
+func TestHistoryFileStorageTornLastRate(t *testing.T) {
+	logger := &StubLogger{}
+	storage := NewHistoryFileStorage(
+		logger,
+		filepath.Join(t.TempDir(), "history.jsonl"),
+		0,
+	)
+
+	start := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	rate := port.Rate{
+		Amount:    port.MustParseDecimal("1227057.5"),
+		Pair:      port.DefaultCurrencyPair,
+		Provider:  "KunaRateProvider",
+		FetchedAt: start,
+	}
+	require.NoError(t, storage.Add(rate))
+
+	// The crash interrupted the append of the next rate
+	f, err := os.OpenFile(storage.FilePath, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"amount":"12271`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	history, err := storage.Range(port.DefaultCurrencyPair, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, "1227057.5", history[0].Amount.String())
+	require.Equal(t, int32(1), logger.errors.Load())
+}
+
+func TestHistoryFileStorageRetention(t *testing.T) {
+	storage := NewHistoryFileStorage(
+		&StubLogger{},
+		filepath.Join(t.TempDir(), "history.jsonl"),
+		24*time.Hour,
+	)
+
+	start := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	storage.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, storage.Add(port.Rate{
+			Amount:    port.MustParseDecimal("1227057.5"),
+			Pair:      port.DefaultCurrencyPair,
+			Provider:  "KunaRateProvider",
+			FetchedAt: start.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	// The first two rates are past the retention on the next compaction
+	now = start.Add(25*time.Hour + time.Minute)
+	require.NoError(t, storage.Add(port.Rate{
+		Amount:    port.MustParseDecimal("1227100"),
+		Pair:      port.DefaultCurrencyPair,
+		Provider:  "KunaRateProvider",
+		FetchedAt: now,
+	}))
+
+	history, err := storage.Range(port.DefaultCurrencyPair, start, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.True(t, start.Add(2*time.Hour).Equal(history[0].FetchedAt))
+	require.True(t, now.Equal(history[1].FetchedAt))
+}
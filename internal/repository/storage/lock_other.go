@@ -0,0 +1,19 @@
+//go:build !unix
+
+package storage
+
+import (
+	"context"
+	"os"
+)
+
+// lockFile does nothing where the advisory locks aren't supported,
+// the storages of the file in the process are still serialized
+func lockFile(context.Context, *os.File) error {
+	return nil
+}
+
+// syncDir does nothing where the directories can't be synced
+func syncDir(string) error {
+	return nil
+}
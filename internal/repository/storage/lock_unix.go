@@ -0,0 +1,44 @@
+//go:build unix
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// _lockRetryInterval is the interval between the attempts
+// to take the advisory lock held by another process
+const _lockRetryInterval = 10 * time.Millisecond
+
+// lockFile takes the exclusive advisory lock of the file, waiting
+// for other processes to release it until the context is done
+func lockFile(ctx context.Context, f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(_lockRetryInterval):
+		}
+	}
+}
+
+// syncDir syncs the directory, so the file renamed in it
+// is kept after a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,47 @@
+//go:build unix
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+func TestCSVStorageLockWaitsUntilContextDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.csv")
+	storage := NewCSVStorage(&StubLogger{}, path, port.UserSchema)
+
+	// Another process holds the lock
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("failed to open lock file: %v", err)
+	}
+	defer f.Close()
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatalf("failed to lock file: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = storage.Append(ctx, map[string]string{"email": "example@test.com"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got: %v", context.DeadlineExceeded, err)
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatalf("failed to unlock file: %v", err)
+	}
+
+	if err = storage.Append(context.Background(), map[string]string{"email": "example@test.com"}); err != nil {
+		t.Fatalf("failed to append data: %v", err)
+	}
+}
@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gses2-app/internal/core/port"
+)
+
+var ErrInvalidOutboxRecord = errors.New("invalid outbox record, expected batch or job")
+
+// OutboxFileStorage keeps the outbox in a file, an append-only log of
+// JSON encoded records, one per line. A batch is written as its record
+// followed by the records of its jobs, and every change of a job appends
+// the new record of the job, the last one wins. The log is loaded on the
+// first use and compacted to the latest records through a temporary file
+// that replaces it, when it has grown to twice their number and when the
+// finished batches are dropped after the retention period. Every record
+// is synced to the disk, so a crash can only leave the last one torn,
+// such a record is cut off when the log is loaded
+type OutboxFileStorage struct {
+	FilePath  string
+	Retention time.Duration
+
+	logger  port.Logger
+	mu      sync.Mutex
+	loaded  bool
+	batches []port.Batch
+	// jobs are the positions of the jobs in their batches by the keys
+	jobs    map[string]int
+	records int
+	now     func() time.Time
+}
+
+// outboxRecord is the line of the log, either a batch without its jobs or a job
+type outboxRecord struct {
+	Batch *port.Batch `json:"batch,omitempty"`
+	Job   *port.Job   `json:"job,omitempty"`
+}
+
+func NewOutboxFileStorage(
+	logger port.Logger,
+	filePath string,
+	retention time.Duration,
+) *OutboxFileStorage {
+	return &OutboxFileStorage{
+		FilePath:  filePath,
+		Retention: retention,
+		logger:    logger,
+		jobs:      make(map[string]int),
+		now:       time.Now,
+	}
+}
+
+func (s *OutboxFileStorage) AddBatch(
+	ctx context.Context,
+	batch port.Batch,
+) (port.Batch, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return port.Batch{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return port.Batch{}, false, err
+	}
+
+	if i := s.find(batch.ID); i >= 0 {
+		return copyBatch(s.batches[i]), false, nil
+	}
+
+	if err := s.appendRecords(batchRecords(batch)...); err != nil {
+		return port.Batch{}, false, err
+	}
+	s.addBatch(copyBatch(batch))
+	s.compact()
+
+	return batch, true, nil
+}
+
+func (s *OutboxFileStorage) Batch(ctx context.Context, id string) (port.Batch, error) {
+	if err := ctx.Err(); err != nil {
+		return port.Batch{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return port.Batch{}, err
+	}
+
+	i := s.find(id)
+	if i < 0 {
+		return port.Batch{}, port.ErrBatchNotFound
+	}
+
+	return copyBatch(s.batches[i]), nil
+}
+
+// Due returns the queued jobs whose next attempt is due by now,
+// the jobs are sorted by the time of the next attempt
+func (s *OutboxFileStorage) Due(ctx context.Context, now time.Time) ([]port.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	var due []port.Job
+	for _, batch := range s.batches {
+		for _, job := range batch.Jobs {
+			if job.Status == port.JobQueued && !job.NextAttemptAt.After(now) {
+				due = append(due, job)
+			}
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	return due, nil
+}
+
+// UpdateJob replaces the job with the same key in its batch,
+// only the record of the job is appended to the log
+func (s *OutboxFileStorage) UpdateJob(ctx context.Context, job port.Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	i := s.find(job.BatchID)
+	if i < 0 {
+		return port.ErrBatchNotFound
+	}
+
+	if err := s.appendRecords(outboxRecord{Job: &job}); err != nil {
+		return err
+	}
+	s.setJob(i, job)
+	s.compact()
+
+	return nil
+}
+
+// addBatch adds the batch with the jobs to the batches
+func (s *OutboxFileStorage) addBatch(batch port.Batch) {
+	s.batches = append(s.batches, batch)
+	for j, job := range batch.Jobs {
+		s.jobs[job.Key] = j
+	}
+}
+
+// setJob replaces the job with the same key in the batch at the position,
+// the job is added when the batch doesn't have it yet. The batches are
+// only given out as copies, so the job is replaced in place
+func (s *OutboxFileStorage) setJob(i int, job port.Job) {
+	jobs := s.batches[i].Jobs
+	if j, ok := s.jobs[job.Key]; ok && j < len(jobs) && jobs[j].Key == job.Key {
+		jobs[j] = job
+		return
+	}
+
+	s.jobs[job.Key] = len(jobs)
+	s.batches[i].Jobs = append(jobs, job)
+}
+
+func (s *OutboxFileStorage) find(id string) int {
+	for i, batch := range s.batches {
+		if batch.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// retained returns the batches without the finished ones
+// created before the retention period
+func (s *OutboxFileStorage) retained() []port.Batch {
+	expiredBefore := s.now().Add(-s.Retention)
+
+	kept := make([]port.Batch, 0, len(s.batches))
+	for _, batch := range s.batches {
+		if s.Retention > 0 && batch.IsFinished() && batch.CreatedAt.Before(expiredBefore) {
+			continue
+		}
+		kept = append(kept, batch)
+	}
+
+	return kept
+}
+
+// load replays the log and compacts it. The log with the torn last record
+// or without the line break after the last record is rewritten before
+// anything is appended to it
+func (s *OutboxFileStorage) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		s.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.batches, s.jobs, s.records = nil, make(map[string]int), 0
+
+	size, err := s.replay(data)
+	if err != nil {
+		return err
+	}
+
+	if size < len(data) {
+		s.logger.Errorf("Cutting torn last record %q off %s", data[size:], s.FilePath)
+	}
+
+	if size < len(data) || !bytes.HasSuffix(data, []byte("\n")) {
+		if err = s.save(s.retained()); err != nil {
+			return err
+		}
+	}
+
+	s.loaded = true
+	s.compact()
+
+	return nil
+}
+
+// replay applies the records of the data to the batches and returns the
+// size of the data they take. The last record without the line break is
+// torn unless it can be parsed, it isn't applied and the size doesn't
+// include it
+func (s *OutboxFileStorage) replay(data []byte) (int, error) {
+	size := 0
+	for size < len(data) {
+		line, _, found := bytes.Cut(data[size:], []byte("\n"))
+
+		record, err := parseOutboxRecord(line)
+		if !found && err != nil {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		s.apply(record)
+
+		size += len(line) + 1
+	}
+
+	return len(data), nil
+}
+
+func parseOutboxRecord(line []byte) (outboxRecord, error) {
+	var record outboxRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return record, err
+	}
+
+	if (record.Batch == nil) == (record.Job == nil) {
+		return record, ErrInvalidOutboxRecord
+	}
+
+	return record, nil
+}
+
+// apply adds the batch of the record or replaces its job,
+// the jobs of the batches that aren't kept anymore are skipped
+func (s *OutboxFileStorage) apply(record outboxRecord) {
+	s.records++
+
+	if record.Batch != nil {
+		s.addBatch(*record.Batch)
+		return
+	}
+
+	if i := s.find(record.Job.BatchID); i >= 0 {
+		s.setJob(i, *record.Job)
+	}
+}
+
+// compact drops the expired batches and rewrites the log with the latest
+// records when any batch was dropped or the log has grown to twice their
+// number, so the size of the log is proportional to the jobs it keeps.
+// The log is valid without the compaction, so its failure is only logged
+func (s *OutboxFileStorage) compact() {
+	batches := s.retained()
+	if len(batches) == len(s.batches) && s.records <= 2*countRecords(batches) {
+		return
+	}
+
+	if err := s.save(batches); err != nil {
+		s.logger.Errorf("Failed to compact %s: %s", s.FilePath, err)
+	}
+}
+
+// appendRecords writes the records to the end of the log and syncs it
+func (s *OutboxFileStorage) appendRecords(records ...outboxRecord) error {
+	f, err := os.OpenFile(s.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = writeRecords(f, records); err != nil {
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	s.records += len(records)
+
+	return nil
+}
+
+// save writes the records of the batches to a temporary file that replaces
+// the log, the batches are kept in memory only when they are written
+func (s *OutboxFileStorage) save(batches []port.Batch) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.FilePath), filepath.Base(s.FilePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	var records []outboxRecord
+	for _, batch := range batches {
+		records = append(records, batchRecords(batch)...)
+	}
+
+	if err = writeRecords(tmp, records); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), s.FilePath); err != nil {
+		return err
+	}
+
+	s.batches, s.jobs, s.records = nil, make(map[string]int), len(records)
+	for _, batch := range batches {
+		s.addBatch(batch)
+	}
+
+	return nil
+}
+
+func writeRecords(w io.Writer, records []outboxRecord) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return buffered.Flush()
+}
+
+// batchRecords returns the record of the batch without its jobs
+// followed by the records of the jobs
+func batchRecords(batch port.Batch) []outboxRecord {
+	records := make([]outboxRecord, 0, len(batch.Jobs)+1)
+
+	header := batch
+	header.Jobs = nil
+	records = append(records, outboxRecord{Batch: &header})
+
+	for i := range batch.Jobs {
+		records = append(records, outboxRecord{Job: &batch.Jobs[i]})
+	}
+
+	return records
+}
+
+// countRecords returns the number of the records the batches are written as
+func countRecords(batches []port.Batch) int {
+	count := len(batches)
+	for _, batch := range batches {
+		count += len(batch.Jobs)
+	}
+
+	return count
+}
+
+func copyBatch(batch port.Batch) port.Batch {
+	jobs := make([]port.Job, len(batch.Jobs))
+	copy(jobs, batch.Jobs)
+	batch.Jobs = jobs
+
+	return batch
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+func TestOutboxFileStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	storage := NewOutboxFileStorage(&StubLogger{}, path, 0)
+
+	start := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+
+	batch := port.Batch{
+		ID:        "batch",
+		CreatedAt: start,
+		Jobs: []port.Job{
+			{
+				Key:           "batch/later@example.com",
+				BatchID:       "batch",
+				Email:         "later@example.com",
+				Status:        port.JobQueued,
+				NextAttemptAt: start.Add(time.Hour),
+			},
+			{
+				Key:           "batch/first@example.com",
+				BatchID:       "batch",
+				Email:         "first@example.com",
+				Status:        port.JobQueued,
+				NextAttemptAt: start,
+			},
+		},
+	}
+
+	t.Run("Read missing batch", func(t *testing.T) {
+		_, err := storage.Batch(ctx, "batch")
+		require.ErrorIs(t, err, port.ErrBatchNotFound)
+	})
+
+	t.Run("Add batch", func(t *testing.T) {
+		stored, added, err := storage.AddBatch(ctx, batch)
+		require.NoError(t, err)
+		require.True(t, added)
+		require.Equal(t, batch, stored)
+	})
+
+	t.Run("Add batch with the same ID", func(t *testing.T) {
+		stored, added, err := storage.AddBatch(ctx, port.Batch{ID: "batch"})
+		require.NoError(t, err)
+		require.False(t, added)
+		require.Len(t, stored.Jobs, 2)
+	})
+
+	t.Run("Due jobs", func(t *testing.T) {
+		due, err := storage.Due(ctx, start)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		require.Equal(t, "first@example.com", due[0].Email)
+
+		due, err = storage.Due(ctx, start.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, due, 2)
+		require.Equal(t, "first@example.com", due[0].Email)
+	})
+
+	t.Run("Update job", func(t *testing.T) {
+		job := batch.Jobs[1]
+		job.Status = port.JobDelivered
+		job.Attempts = 1
+
+		require.NoError(t, storage.UpdateJob(ctx, job))
+
+		due, err := storage.Due(ctx, start.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		require.Equal(t, "later@example.com", due[0].Email)
+	})
+
+	t.Run("Update job of missing batch", func(t *testing.T) {
+		err := storage.UpdateJob(ctx, port.Job{BatchID: "missing"})
+		require.ErrorIs(t, err, port.ErrBatchNotFound)
+	})
+
+	t.Run("Reload batches from file", func(t *testing.T) {
+		stored, err := NewOutboxFileStorage(&StubLogger{}, path, 0).Batch(ctx, "batch")
+		require.NoError(t, err)
+		require.Equal(t, port.JobDelivered, stored.Jobs[1].Status)
+		require.Equal(t, 1, stored.Jobs[1].Attempts)
+	})
+}
+
+func TestOutboxFileStorageRetention(t *testing.T) {
+	ctx := context.Background()
+	storage := NewOutboxFileStorage(&StubLogger{}, filepath.Join(t.TempDir(), "outbox.jsonl"), time.Hour)
+
+	now := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	storage.now = func() time.Time { return now }
+
+	batches := []port.Batch{
+		{
+			ID:        "finished",
+			CreatedAt: now.Add(-2 * time.Hour),
+			Jobs:      []port.Job{{Key: "finished/a", Status: port.JobDelivered}},
+		},
+		{
+			ID:        "queued",
+			CreatedAt: now.Add(-2 * time.Hour),
+			Jobs:      []port.Job{{Key: "queued/a", Status: port.JobQueued}},
+		},
+		{
+			ID:        "recent",
+			CreatedAt: now.Add(-time.Minute),
+			Jobs:      []port.Job{{Key: "recent/a", Status: port.JobDead}},
+		},
+		{ID: "new", CreatedAt: now},
+	}
+
+	for _, batch := range batches {
+		_, _, err := storage.AddBatch(ctx, batch)
+		require.NoError(t, err)
+	}
+
+	_, err := storage.Batch(ctx, "finished")
+	require.ErrorIs(t, err, port.ErrBatchNotFound)
+
+	for _, id := range []string{"queued", "recent", "new"} {
+		_, err = storage.Batch(ctx, id)
+		require.NoError(t, err)
+	}
+}
+
+func TestOutboxFileStorageLog(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	storage := NewOutboxFileStorage(&StubLogger{}, path, 0)
+
+	batch := port.Batch{ID: "batch"}
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		batch.Jobs = append(batch.Jobs, port.Job{
+			Key:     "batch/" + email,
+			BatchID: "batch",
+			Email:   email,
+			Status:  port.JobQueued,
+		})
+	}
+
+	_, _, err := storage.AddBatch(ctx, batch)
+	require.NoError(t, err)
+	require.Equal(t, 4, countLines(t, path))
+
+	t.Run("Update appends the job", func(t *testing.T) {
+		job := batch.Jobs[0]
+		job.Attempts = 1
+		require.NoError(t, storage.UpdateJob(ctx, job))
+
+		require.Equal(t, 5, countLines(t, path))
+	})
+
+	t.Run("Log is compacted", func(t *testing.T) {
+		job := batch.Jobs[1]
+		for attempt := 1; attempt <= 10; attempt++ {
+			job.Attempts = attempt
+			require.NoError(t, storage.UpdateJob(ctx, job))
+		}
+
+		require.LessOrEqual(t, countLines(t, path), 8)
+
+		stored, err := NewOutboxFileStorage(&StubLogger{}, path, 0).Batch(ctx, "batch")
+		require.NoError(t, err)
+		require.Len(t, stored.Jobs, 3)
+		require.Equal(t, 1, stored.Jobs[0].Attempts)
+		require.Equal(t, 10, stored.Jobs[1].Attempts)
+		require.Equal(t, 0, stored.Jobs[2].Attempts)
+	})
+}
+
+func TestOutboxFileStorageRepairsTornRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	job := port.Job{Key: "batch/a", BatchID: "batch", Status: port.JobQueued}
+	_, _, err := NewOutboxFileStorage(&StubLogger{}, path, 0).
+		AddBatch(ctx, port.Batch{ID: "batch", Jobs: []port.Job{job}})
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"job":{"key":"batch/a","batchId":"bat`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	logger := &StubLogger{}
+	storage := NewOutboxFileStorage(logger, path, 0)
+
+	job.Attempts = 1
+	require.NoError(t, storage.UpdateJob(ctx, job))
+	require.Equal(t, int32(1), logger.errors.Load())
+
+	stored, err := NewOutboxFileStorage(&StubLogger{}, path, 0).Batch(ctx, "batch")
+	require.NoError(t, err)
+	require.Equal(t, 1, stored.Jobs[0].Attempts)
+}
+
+func TestOutboxFileStorageRetentionOnLoad(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	now := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	job := port.Job{Key: "finished/a", BatchID: "finished", Status: port.JobQueued}
+
+	_, _, err := NewOutboxFileStorage(&StubLogger{}, path, 0).AddBatch(ctx, port.Batch{
+		ID:        "finished",
+		CreatedAt: now.Add(-2 * time.Hour),
+		Jobs:      []port.Job{job},
+	})
+	require.NoError(t, err)
+
+	storage := NewOutboxFileStorage(&StubLogger{}, path, time.Hour)
+	storage.now = func() time.Time { return now }
+
+	job.Status = port.JobDelivered
+	require.NoError(t, storage.UpdateJob(ctx, job))
+
+	_, err = storage.Batch(ctx, "finished")
+	require.ErrorIs(t, err, port.ErrBatchNotFound)
+	require.Equal(t, 0, countLines(t, path))
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return bytes.Count(data, []byte("\n"))
+}
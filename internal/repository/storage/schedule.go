@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gses2-app/internal/core/port"
+)
+
+// ScheduleFileStorage keeps the runs of the schedule in a JSON file,
+// the file is replaced on every change, so a crash leaves either
+// the old or the new file
+type ScheduleFileStorage struct {
+	FilePath string
+
+	mu sync.Mutex
+}
+
+func NewScheduleFileStorage(filePath string) *ScheduleFileStorage {
+	return &ScheduleFileStorage{FilePath: filePath}
+}
+
+func (s *ScheduleFileStorage) Runs(ctx context.Context) ([]port.ScheduleRun, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []port.ScheduleRun
+	if err = json.Unmarshal(data, &runs); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+func (s *ScheduleFileStorage) SaveRuns(ctx context.Context, runs []port.ScheduleRun) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return replaceFile(s.FilePath, data)
+}
+
+// replaceFile writes the data to a temporary file that replaces the file,
+// the file keeps its mode and the rename is synced to the directory
+func replaceFile(path string, data []byte) error {
+	mode, err := fileMode(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = writeTemp(tmp, data, mode); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// fileMode returns the permissions of the file, 0644 for the missing one
+func fileMode(path string) (os.FileMode, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0644, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Mode().Perm(), nil
+}
+
+// writeTemp writes the data to the temporary file with the mode and syncs it,
+// the temporary files are created with 0600
+func writeTemp(tmp *os.File, data []byte, mode os.FileMode) error {
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+
+	return tmp.Sync()
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+func TestScheduleFileStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	storage := NewScheduleFileStorage(filepath.Join(dir, "schedule.json"))
+
+	slot := time.Date(2023, time.July, 1, 9, 0, 0, 0, time.UTC)
+	runs := []port.ScheduleRun{
+		{Slot: slot, StartedAt: slot.Add(time.Second), Attempts: 1, BatchID: "batch"},
+		{Slot: slot.Add(time.Hour), StartedAt: slot.Add(time.Hour), Attempts: 2, Error: "rate error"},
+	}
+
+	t.Run("Read missing file", func(t *testing.T) {
+		stored, err := storage.Runs(ctx)
+		require.NoError(t, err)
+		require.Empty(t, stored)
+	})
+
+	t.Run("Save runs", func(t *testing.T) {
+		require.NoError(t, storage.SaveRuns(ctx, runs))
+
+		stored, err := storage.Runs(ctx)
+		require.NoError(t, err)
+		require.Equal(t, runs, stored)
+	})
+
+	t.Run("Replace runs", func(t *testing.T) {
+		require.NoError(t, storage.SaveRuns(ctx, runs[1:]))
+
+		stored, err := storage.Runs(ctx)
+		require.NoError(t, err)
+		require.Equal(t, runs[1:], stored)
+	})
+
+	t.Run("No temporary files left", func(t *testing.T) {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+}
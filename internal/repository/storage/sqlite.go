@@ -0,0 +1,613 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"gses2-app/internal/core/port"
+)
+
+const (
+	// DriverCSV keeps the subscribers in the CSV file
+	DriverCSV = "csv"
+
+	// DriverSQLite keeps the subscribers in the SQLite database
+	DriverSQLite = "sqlite"
+
+	_sqliteBusyTimeout = 5 * time.Second
+
+	// _sqliteMaxValues is the number of the values matched by one statement
+	_sqliteMaxValues = 500
+)
+
+var ErrUnknownDriver = errors.New("unknown storage driver, expected csv or sqlite")
+
+// NewUserStorage returns the storage of the subscribers
+// chosen by the driver of the config
+func NewUserStorage(
+	ctx context.Context,
+	logger port.Logger,
+	config StorageConfig,
+) (port.Storage, error) {
+	switch strings.ToLower(config.Driver) {
+	case DriverCSV:
+		return NewCSVStorage(logger, config.Path, port.UserSchema), nil
+	case DriverSQLite:
+		return NewSQLiteStorage(ctx, config.SQLitePath)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, config.Driver)
+	}
+}
+
+// NewReadOnlyUserStorage returns the storage of the subscribers chosen
+// by the driver of the config, which doesn't change or create any files
+func NewReadOnlyUserStorage(
+	ctx context.Context,
+	logger port.Logger,
+	config StorageConfig,
+) (port.Storage, error) {
+	switch strings.ToLower(config.Driver) {
+	case DriverCSV:
+		return NewReadOnlyCSVStorage(logger, config.Path, port.UserSchema), nil
+	case DriverSQLite:
+		return NewReadOnlySQLiteStorage(ctx, config.SQLitePath)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, config.Driver)
+	}
+}
+
+// _migrations are the versions of the database schema, the version is
+// the position of the migration starting from 1. The applied migrations
+// are never changed, the changes of the schema are added to the end
+var _migrations = [][]string{
+	{
+		`CREATE TABLE subscribers (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			email        TEXT NOT NULL UNIQUE,
+			status       TEXT NOT NULL DEFAULT '',
+			subscribedAt TEXT NOT NULL DEFAULT '',
+			locale       TEXT NOT NULL DEFAULT '',
+			pairs        TEXT NOT NULL DEFAULT '',
+			frequency    TEXT NOT NULL DEFAULT '',
+			timezone     TEXT NOT NULL DEFAULT '',
+			quietHours   TEXT NOT NULL DEFAULT '',
+			notifiedAt   TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX subscribers_status ON subscribers (status, subscribedAt)`,
+	},
+}
+
+// SQLiteStorage keeps the subscribers in the SQLite database, one row
+// of the subscribers table per record with the columns of the user
+// schema. The database schema is migrated to the latest version when
+// the storage is opened, the email is unique
+type SQLiteStorage struct {
+	db     *sql.DB
+	schema port.Schema
+}
+
+// NewSQLiteStorage opens the database in the file and migrates it
+func NewSQLiteStorage(ctx context.Context, filePath string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(filePath, url.Values{"_pragma": {
+		fmt.Sprintf("busy_timeout(%d)", _sqliteBusyTimeout.Milliseconds()),
+		"journal_mode(WAL)",
+	}}))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteStorage{db: db, schema: port.UserSchema}
+	if err = s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewReadOnlySQLiteStorage opens the existing database in the file
+// read-only, it isn't migrated, so neither the file nor the schema
+// is created, and the writes fail. Reading the database without the
+// WAL file would create it, such a database has every change in the
+// file, so it's read as immutable
+func NewReadOnlySQLiteStorage(ctx context.Context, filePath string) (*SQLiteStorage, error) {
+	query := url.Values{
+		"mode": {"ro"},
+		"_pragma": {
+			fmt.Sprintf("busy_timeout(%d)", _sqliteBusyTimeout.Milliseconds()),
+		},
+	}
+	if _, err := os.Stat(filePath + "-wal"); errors.Is(err, os.ErrNotExist) {
+		query.Set("immutable", "1")
+	}
+
+	db, err := sql.Open("sqlite", sqliteDSN(filePath, query))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStorage{db: db, schema: port.UserSchema}, nil
+}
+
+func sqliteDSN(filePath string, query url.Values) string {
+	dsn := url.URL{Scheme: "file", Opaque: filePath, RawQuery: query.Encode()}
+
+	return dsn.String()
+}
+
+// Close closes the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// Version returns the version of the database schema
+func (s *SQLiteStorage) Version(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).
+		Scan(&version)
+
+	return version, err
+}
+
+// migrate applies the migrations newer than the version of the database,
+// each one in its own transaction
+func (s *SQLiteStorage) migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		appliedAt  TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	version, err := s.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	for ; version < len(_migrations); version++ {
+		if err = s.applyMigration(ctx, version+1, _migrations[version]); err != nil {
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) applyMigration(ctx context.Context, version int, statements []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO schema_migrations (version, appliedAt) VALUES (?, ?)`,
+		version, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) AllRecords(ctx context.Context) ([]map[string]string, error) {
+	return s.Select(ctx, func(map[string]string) bool { return true })
+}
+
+// Find returns the first record with the value under the key
+func (s *SQLiteStorage) Find(
+	ctx context.Context,
+	key, value string,
+) (map[string]string, error) {
+	column, err := s.column(key)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := s.query(
+		ctx,
+		`SELECT `+s.columns()+` FROM subscribers WHERE `+column+` = ? ORDER BY id LIMIT 1`,
+		value,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, port.ErrRecordNotFound
+	}
+
+	return records[0], nil
+}
+
+// Select returns the records kept by the filter in the order they were added
+func (s *SQLiteStorage) Select(
+	ctx context.Context,
+	filter port.RecordFilter,
+) ([]map[string]string, error) {
+	records, err := s.query(ctx, `SELECT `+s.columns()+` FROM subscribers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]map[string]string, 0, len(records))
+	for _, record := range records {
+		if filter(record) {
+			selected = append(selected, record)
+		}
+	}
+
+	return selected, nil
+}
+
+// Page returns up to the limit records following the offset in the order they were added
+func (s *SQLiteStorage) Page(
+	ctx context.Context,
+	offset, limit int,
+) ([]map[string]string, error) {
+	return s.query(
+		ctx,
+		`SELECT `+s.columns()+` FROM subscribers ORDER BY id LIMIT ? OFFSET ?`,
+		limit, offset,
+	)
+}
+
+// Append adds the record, ErrRecordExists is returned
+// when there is the record with the same email
+func (s *SQLiteStorage) Append(ctx context.Context, record map[string]string) error {
+	if err := s.schema.Validate(record); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO subscribers (`+s.columns()+`) VALUES (`+s.placeholders()+`)`,
+		s.values(record)...,
+	)
+
+	return uniqueError(err)
+}
+
+// Insert adds the record unless there is one with the same value
+// under the key, the check and the insert are one statement
+func (s *SQLiteStorage) Insert(
+	ctx context.Context,
+	key string,
+	record map[string]string,
+) error {
+	if err := s.schema.Validate(record); err != nil {
+		return err
+	}
+
+	column, err := s.column(key)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO subscribers (`+s.columns()+`) SELECT `+s.placeholders()+
+			` WHERE NOT EXISTS (SELECT 1 FROM subscribers WHERE `+column+` = ?)`,
+		append(s.values(record), record[key])...,
+	)
+	if err != nil {
+		return uniqueError(err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if inserted == 0 {
+		return port.ErrRecordExists
+	}
+
+	return nil
+}
+
+// Update sets the fields of the record in the records
+// with the value under the key
+func (s *SQLiteStorage) Update(
+	ctx context.Context,
+	key, value string,
+	record map[string]string,
+) (int, error) {
+	return s.UpdateAll(ctx, key, []string{value}, record)
+}
+
+// UpdateAll sets the fields of the record in the records with any of the
+// values under the key in one transaction, the values are matched in
+// chunks to keep the number of the query parameters bounded
+func (s *SQLiteStorage) UpdateAll(
+	ctx context.Context,
+	key string,
+	values []string,
+	record map[string]string,
+) (int, error) {
+	if err := s.schema.Validate(record); err != nil {
+		return 0, err
+	}
+
+	column, err := s.column(key)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	updated := 0
+	for len(values) > 0 {
+		chunk := values
+		if len(chunk) > _sqliteMaxValues {
+			chunk = chunk[:_sqliteMaxValues]
+		}
+		values = values[len(chunk):]
+
+		chunkUpdated, updateErr := s.update(ctx, tx, column, chunk, record)
+		if updateErr != nil {
+			return 0, updateErr
+		}
+		updated += chunkUpdated
+	}
+
+	return updated, tx.Commit()
+}
+
+// update sets the fields of the record in the records with any of the
+// values under the column, the empty record only counts the records
+func (s *SQLiteStorage) update(
+	ctx context.Context,
+	tx *sql.Tx,
+	column string,
+	values []string,
+	record map[string]string,
+) (int, error) {
+	where := column + ` IN (` + placeholders(len(values)) + `)`
+
+	args := make([]any, 0, len(record)+len(values))
+	assignments := make([]string, 0, len(record))
+	for _, c := range s.schema {
+		if fieldValue, ok := record[c.Name]; ok {
+			assignments = append(assignments, quoteIdentifier(c.Name)+` = ?`)
+			args = append(args, fieldValue)
+		}
+	}
+	for _, value := range values {
+		args = append(args, value)
+	}
+
+	if len(assignments) == 0 {
+		var count int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM subscribers WHERE `+where, args...).
+			Scan(&count)
+
+		return count, err
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE subscribers SET `+strings.Join(assignments, ", ")+` WHERE `+where,
+		args...,
+	)
+	if err != nil {
+		return 0, uniqueError(err)
+	}
+
+	return rowsAffected(result)
+}
+
+// Remove deletes the records with the value under the key
+func (s *SQLiteStorage) Remove(ctx context.Context, key, value string) (int, error) {
+	column, err := s.column(key)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM subscribers WHERE `+column+` = ?`, value)
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsAffected(result)
+}
+
+// RemoveMatching deletes the records with the value under the key kept by
+// the filter in one transaction. A record is deleted only while all its
+// columns are still the ones the filter was given
+func (s *SQLiteStorage) RemoveMatching(
+	ctx context.Context,
+	key, value string,
+	filter port.RecordFilter,
+) (int, error) {
+	column, err := s.column(key)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	records, err := s.queryTx(
+		ctx,
+		tx,
+		`SELECT `+s.columns()+` FROM subscribers WHERE `+column+` = ?`,
+		value,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, record := range records {
+		if !filter(record) {
+			continue
+		}
+
+		recordRemoved, removeErr := s.removeRecord(ctx, tx, record)
+		if removeErr != nil {
+			return 0, removeErr
+		}
+		removed += recordRemoved
+	}
+
+	return removed, tx.Commit()
+}
+
+// removeRecord deletes the records equal to the record in every column
+func (s *SQLiteStorage) removeRecord(
+	ctx context.Context,
+	tx *sql.Tx,
+	record map[string]string,
+) (int, error) {
+	where := make([]string, len(s.schema))
+	for i, c := range s.schema {
+		where[i] = quoteIdentifier(c.Name) + ` = ?`
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM subscribers WHERE `+strings.Join(where, " AND "),
+		s.values(record)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsAffected(result)
+}
+
+func (s *SQLiteStorage) query(ctx context.Context, query string, args ...any) ([]map[string]string, error) {
+	return s.queryTx(ctx, s.db, query, args...)
+}
+
+// queryer runs the queries either in or out of a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (s *SQLiteStorage) queryTx(
+	ctx context.Context,
+	q queryer,
+	query string,
+	args ...any,
+) ([]map[string]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []map[string]string
+	for rows.Next() {
+		values := make([]string, len(s.schema))
+		dest := make([]any, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		record := make(map[string]string, len(s.schema))
+		for i, column := range s.schema {
+			record[column.Name] = values[i]
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// column returns the quoted name of the column, only the columns
+// of the schema are accepted as they are a part of the query
+func (s *SQLiteStorage) column(name string) (string, error) {
+	if _, ok := s.schema.Column(name); !ok {
+		return "", fmt.Errorf("%w: %s", port.ErrUnknownColumn, name)
+	}
+
+	return quoteIdentifier(name), nil
+}
+
+// columns returns the quoted names of the columns in the schema order
+func (s *SQLiteStorage) columns() string {
+	names := make([]string, len(s.schema))
+	for i, column := range s.schema {
+		names[i] = quoteIdentifier(column.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func (s *SQLiteStorage) placeholders() string {
+	return placeholders(len(s.schema))
+}
+
+// values returns the values of the record in the schema order,
+// the missing ones are empty
+func (s *SQLiteStorage) values(record map[string]string) []any {
+	values := make([]any, len(s.schema))
+	for i, column := range s.schema {
+		values[i] = record[column.Name]
+	}
+
+	return values
+}
+
+func placeholders(count int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", count), ", ")
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func rowsAffected(result sql.Result) (int, error) {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), nil
+}
+
+// uniqueError returns ErrRecordExists for the violated unique constraint
+func uniqueError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return errors.Join(err, port.ErrRecordExists)
+	}
+
+	return err
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+)
+
+func newTestSQLiteStorage(t *testing.T, filePath string) *SQLiteStorage {
+	storage, err := NewSQLiteStorage(context.Background(), filePath)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+
+	return storage
+}
+
+func TestSQLiteStorageMigrations(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "storage.db")
+	ctx := context.Background()
+
+	storage := newTestSQLiteStorage(t, filePath)
+	version, err := storage.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, len(_migrations), version)
+
+	require.NoError(t, storage.Append(ctx, map[string]string{"email": "first@test.com"}))
+	require.NoError(t, storage.Close())
+
+	reopened := newTestSQLiteStorage(t, filePath)
+	version, err = reopened.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, len(_migrations), version)
+
+	records, err := reopened.AllRecords(ctx)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	storage := newTestSQLiteStorage(t, filepath.Join(t.TempDir(), "storage.db"))
+	ctx := context.Background()
+
+	records := []map[string]string{
+		{"email": "first@test.com", "status": "pending", "subscribedAt": "2023-07-01T12:00:00Z"},
+		{"email": "second@test.com", "status": "confirmed", "pairs": "BTC/UAH,ETH/USD"},
+		{"email": "third@test.com", "status": "confirmed"},
+	}
+	for _, record := range records {
+		require.NoError(t, storage.Append(ctx, record))
+	}
+
+	t.Run("Find", func(t *testing.T) {
+		found, err := storage.Find(ctx, "email", "second@test.com")
+		require.NoError(t, err)
+		require.Equal(t, withEmptyColumns(records[1]), found)
+
+		_, err = storage.Find(ctx, "email", "missing@test.com")
+		require.ErrorIs(t, err, port.ErrRecordNotFound)
+
+		_, err = storage.Find(ctx, "email = email OR 1", "")
+		require.ErrorIs(t, err, port.ErrUnknownColumn)
+	})
+
+	t.Run("Select", func(t *testing.T) {
+		selected, err := storage.Select(ctx, port.Match("status", "confirmed"))
+		require.NoError(t, err)
+		require.Equal(t, []map[string]string{
+			withEmptyColumns(records[1]),
+			withEmptyColumns(records[2]),
+		}, selected)
+	})
+
+	t.Run("Email is unique", func(t *testing.T) {
+		err := storage.Append(ctx, map[string]string{"email": "first@test.com"})
+		require.ErrorIs(t, err, port.ErrRecordExists)
+
+		err = storage.Insert(ctx, "email", map[string]string{"email": "first@test.com"})
+		require.ErrorIs(t, err, port.ErrRecordExists)
+
+		_, err = storage.Update(ctx, "email", "third@test.com", map[string]string{"email": "first@test.com"})
+		require.ErrorIs(t, err, port.ErrRecordExists)
+	})
+
+	t.Run("Insert by other key", func(t *testing.T) {
+		err := storage.Insert(ctx, "status", map[string]string{"email": "fourth@test.com", "status": "pending"})
+		require.ErrorIs(t, err, port.ErrRecordExists)
+	})
+
+	t.Run("Invalid values", func(t *testing.T) {
+		err := storage.Append(ctx, map[string]string{"email": "fourth@test.com", "notifiedAt": "now"})
+		require.ErrorIs(t, err, port.ErrInvalidValue)
+	})
+}
+
+func TestSQLiteStorageUpdateAndRemove(t *testing.T) {
+	storage := newTestSQLiteStorage(t, filepath.Join(t.TempDir(), "storage.db"))
+	ctx := context.Background()
+
+	for _, email := range []string{"first@test.com", "second@test.com"} {
+		require.NoError(t, storage.Insert(ctx, "email", map[string]string{"email": email, "status": "pending"}))
+	}
+
+	updated, err := storage.Update(
+		ctx,
+		"email", "second@test.com",
+		map[string]string{"status": "confirmed"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, updated)
+
+	updated, err = storage.Update(ctx, "email", "missing@test.com", map[string]string{"status": "confirmed"})
+	require.NoError(t, err)
+	require.Zero(t, updated)
+
+	removed, err := storage.Remove(ctx, "email", "first@test.com")
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	records, err := storage.AllRecords(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []map[string]string{
+		withEmptyColumns(map[string]string{"email": "second@test.com", "status": "confirmed"}),
+	}, records)
+}
+
+func TestSQLiteStorageRemoveMatching(t *testing.T) {
+	storage := newTestSQLiteStorage(t, filepath.Join(t.TempDir(), "storage.db"))
+	ctx := context.Background()
+
+	records := []map[string]string{
+		{"email": "first@test.com", "status": "pending"},
+		{"email": "second@test.com", "status": "confirmed"},
+	}
+	for _, record := range records {
+		require.NoError(t, storage.Insert(ctx, "email", record))
+	}
+
+	pending := port.Match("status", "pending")
+
+	removed, err := storage.RemoveMatching(ctx, "email", "first@test.com", pending)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	removed, err = storage.RemoveMatching(ctx, "email", "second@test.com", pending)
+	require.NoError(t, err)
+	require.Zero(t, removed)
+
+	all, err := storage.AllRecords(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []map[string]string{withEmptyColumns(records[1])}, all)
+}
+
+func TestSQLiteStorageUpdateAll(t *testing.T) {
+	storage := newTestSQLiteStorage(t, filepath.Join(t.TempDir(), "storage.db"))
+	ctx := context.Background()
+
+	// More emails than one statement matches
+	emails := make([]string, _sqliteMaxValues+2)
+	for i := range emails {
+		emails[i] = fmt.Sprintf("user%d@test.com", i)
+		require.NoError(t, storage.Insert(ctx, "email", map[string]string{"email": emails[i]}))
+	}
+
+	updated, err := storage.UpdateAll(
+		ctx,
+		"email", append(emails[1:], "missing@test.com"),
+		map[string]string{"notifiedAt": "2023-07-01T12:00:00Z"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, len(emails)-1, updated)
+
+	notified, err := storage.Select(ctx, port.Match("notifiedAt", "2023-07-01T12:00:00Z"))
+	require.NoError(t, err)
+	require.Len(t, notified, len(emails)-1)
+
+	first, err := storage.Find(ctx, "email", emails[0])
+	require.NoError(t, err)
+	require.Empty(t, first["notifiedAt"])
+}
+
+func TestSQLiteStoragePage(t *testing.T) {
+	storage := newTestSQLiteStorage(t, filepath.Join(t.TempDir(), "storage.db"))
+	ctx := context.Background()
+
+	for _, email := range []string{"first@test.com", "second@test.com", "third@test.com"} {
+		require.NoError(t, storage.Append(ctx, map[string]string{"email": email}))
+	}
+
+	page, err := storage.Page(ctx, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, "second@test.com", page[0]["email"])
+
+	page, err = storage.Page(ctx, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, "third@test.com", page[0]["email"])
+
+	page, err = storage.Page(ctx, 3, 2)
+	require.NoError(t, err)
+	require.Empty(t, page)
+}
+
+func TestReadOnlySQLiteStorage(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "storage.db")
+	ctx := context.Background()
+
+	_, err := NewReadOnlySQLiteStorage(ctx, filePath)
+	require.Error(t, err)
+	require.NoFileExists(t, filePath)
+
+	storage, err := NewSQLiteStorage(ctx, filePath)
+	require.NoError(t, err)
+	require.NoError(t, storage.Append(ctx, map[string]string{"email": "first@test.com"}))
+	require.NoError(t, storage.Close())
+
+	readOnly, err := NewReadOnlySQLiteStorage(ctx, filePath)
+	require.NoError(t, err)
+	defer readOnly.Close()
+
+	record, err := readOnly.Find(ctx, "email", "first@test.com")
+	require.NoError(t, err)
+	require.Equal(t, "first@test.com", record["email"])
+
+	require.Error(t, readOnly.Append(ctx, map[string]string{"email": "second@test.com"}))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestNewUserStorage(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	config := StorageConfig{
+		Path:       filepath.Join(dir, "storage.csv"),
+		SQLitePath: filepath.Join(dir, "storage.db"),
+	}
+
+	config.Driver = "csv"
+	csvStorage, err := NewUserStorage(ctx, &StubLogger{}, config)
+	require.NoError(t, err)
+	require.IsType(t, &CSVStorage{}, csvStorage)
+
+	config.Driver = "SQLite"
+	sqliteStorage, err := NewUserStorage(ctx, &StubLogger{}, config)
+	require.NoError(t, err)
+	require.IsType(t, &SQLiteStorage{}, sqliteStorage)
+	require.NoError(t, sqliteStorage.(*SQLiteStorage).Close())
+
+	config.Driver = "postgres"
+	_, err = NewUserStorage(ctx, &StubLogger{}, config)
+	require.ErrorIs(t, err, ErrUnknownDriver)
+}
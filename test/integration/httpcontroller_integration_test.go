@@ -6,13 +6,24 @@ package integration
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
 
 	"gses2-app/internal/core/port"
+	"gses2-app/internal/core/service/broadcast"
+	"gses2-app/internal/core/service/history"
+	"gses2-app/internal/core/service/mailbox"
+	"gses2-app/internal/core/service/outbox"
 	"gses2-app/internal/core/service/rate"
 	"gses2-app/internal/core/service/sender"
 	"gses2-app/internal/core/service/subscription"
@@ -21,6 +32,7 @@ import (
 	"gses2-app/internal/repository/config"
 	"gses2-app/internal/repository/sender/email"
 	"gses2-app/internal/repository/sender/smtp"
+	"gses2-app/internal/repository/storage"
 )
 
 const _configPrefix = "GSES2_APP"
@@ -39,8 +51,28 @@ type StubSenderProvider struct {
 }
 
 func (tp *StubSenderProvider) SendExchangeRate(
+	ctx context.Context,
 	rate port.Rate,
 	subscribers []port.User,
+) (*port.DeliveryReport, error) {
+	if tp.Err != nil {
+		return nil, tp.Err
+	}
+
+	return port.NewDeliveryReport(), nil
+}
+
+func (tp *StubSenderProvider) SendConfirmation(
+	ctx context.Context,
+	user port.User,
+) error {
+	return tp.Err
+}
+
+func (tp *StubSenderProvider) SendAlert(
+	ctx context.Context,
+	user port.User,
+	event port.AlertEvent,
 ) error {
 	return tp.Err
 }
@@ -64,7 +96,10 @@ type StubRateProvider struct {
 	ProviderName string
 }
 
-func (m *StubRateProvider) ExchangeRate() (port.Rate, error) {
+func (m *StubRateProvider) ExchangeRate(
+	ctx context.Context,
+	pair port.CurrencyPair,
+) (port.Rate, error) {
 	return m.Rate, m.Error
 }
 
@@ -72,44 +107,147 @@ func (m *StubRateProvider) Name() string {
 	return m.ProviderName
 }
 
+type StubHistoryRepository struct {
+	Rates []port.Rate
+	Err   error
+}
+
+func (s *StubHistoryRepository) Add(rate port.Rate) error {
+	s.Rates = append(s.Rates, rate)
+	return s.Err
+}
+
+func (s *StubHistoryRepository) Range(
+	pair port.CurrencyPair,
+	from, to time.Time,
+) ([]port.Rate, error) {
+	return s.Rates, s.Err
+}
+
 type StubUserRepository struct {
 	Users []port.User
 	Err   error
 }
 
-func (s *StubUserRepository) Add(user *port.User) error {
+func (s *StubUserRepository) Add(ctx context.Context, user *port.User) error {
+	for _, u := range s.Users {
+		if u.Email == user.Email {
+			return port.ErrAlreadyAdded
+		}
+	}
+
 	s.Users = append(s.Users, *user)
 	return s.Err
 }
 
-func (s *StubUserRepository) FindByEmail(email string) (*port.User, error) {
-	return &s.Users[0], s.Err
+func (s *StubUserRepository) MarkNotified(
+	ctx context.Context,
+	emails []string,
+	at time.Time,
+) error {
+	for i := range s.Users {
+		if slices.Contains(emails, s.Users[i].Email) {
+			s.Users[i].NotifiedAt = at
+		}
+	}
+
+	return s.Err
+}
+
+func (s *StubUserRepository) Remove(ctx context.Context, user *port.User) error {
+	for i, u := range s.Users {
+		if u.Email == user.Email {
+			s.Users = append(s.Users[:i], s.Users[i+1:]...)
+			return s.Err
+		}
+	}
+
+	return port.ErrCannotFindByEmail
+}
+
+func (s *StubUserRepository) Update(ctx context.Context, user *port.User) error {
+	for i := range s.Users {
+		if s.Users[i].Email == user.Email {
+			s.Users[i] = *user
+			return s.Err
+		}
+	}
+
+	return port.ErrCannotFindByEmail
+}
+
+func (s *StubUserRepository) RemoveUnconfirmed(
+	ctx context.Context,
+	email string,
+	subscribedBefore time.Time,
+) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	for i, u := range s.Users {
+		if u.Email == email && !u.IsConfirmed() && u.SubscribedAt.Before(subscribedBefore) {
+			s.Users = append(s.Users[:i], s.Users[i+1:]...)
+			return nil
+		}
+	}
+
+	return port.ErrCannotFindByEmail
+}
+
+func (s *StubUserRepository) FindByEmail(
+	ctx context.Context,
+	email string,
+) (*port.User, error) {
+	for _, user := range s.Users {
+		if user.Email == email {
+			found := user
+			return &found, s.Err
+		}
+	}
+
+	return nil, port.ErrCannotFindByEmail
 }
 
-func (s *StubUserRepository) All() ([]port.User, error) {
+func (s *StubUserRepository) All(ctx context.Context) ([]port.User, error) {
 	return s.Users, s.Err
 }
 
 var (
 	errRateProviderAnavailable = errors.New("rate provider unavailable")
 	errSendMessage             = errors.New("failed to send a message")
+	errHistoryStorage          = errors.New("failed to read history")
 )
 
 func TestAppControllerIntegration(t *testing.T) {
 	config := initConfig(t)
 
-	defaultEmailSenderService := sender.NewService(
-		&StubSenderProvider{},
+	outboxPath := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	defaultEmailOutboxService := newOutboxService(
+		config,
+		outboxPath,
+		sender.NewService(&StubSenderProvider{}),
 	)
 
 	defaultRateService := rate.NewService(
 		&StubLogger{},
-		&StubRateProvider{Rate: 42},
+		rate.ServiceConfig{},
+		nil,
+		&StubRateProvider{
+			Rate: port.Rate{Amount: port.MustParseDecimal("42")},
+		},
 	)
 
-	defaultSubscriptionService := subscription.NewService(
-		&StubUserRepository{},
-	)
+	defaultHistoryService := history.NewService(&StubHistoryRepository{})
+
+	defaultSubscriptionService := newSubscriptionService(t, config, &StubUserRepository{})
+
+	links := subscription.NewLinks(config.Subscription)
+	subscriber := port.User{Email: "test@test.com", Status: port.UserConfirmed}
+	pending := port.User{Email: "test@test.com", Status: port.UserPending}
+	unsubscribeURL := requestURI(t, links.UnsubscribeURL(subscriber))
+	confirmURL := requestURI(t, links.ConfirmURL(pending))
 
 	tests := []struct {
 		name                string
@@ -117,9 +255,10 @@ func TestAppControllerIntegration(t *testing.T) {
 		requestURL          string
 		requestBody         io.Reader
 		expectedStatus      int
-		senderService       *sender.Service
+		outboxService       *outbox.Service
 		subscriptionService *subscription.Service
 		rateService         *rate.Service
+		historyService      *history.Service
 	}{
 		{
 			name:                "GetRate OK",
@@ -128,16 +267,49 @@ func TestAppControllerIntegration(t *testing.T) {
 			requestBody:         nil,
 			expectedStatus:      http.StatusOK,
 			subscriptionService: defaultSubscriptionService,
-			senderService:       defaultEmailSenderService,
+			outboxService:       defaultEmailOutboxService,
+			rateService:         defaultRateService,
+		},
+		{
+			name:                "GetRateHistory OK",
+			requestMethod:       http.MethodGet,
+			requestURL:          "/api/rate/history?interval=1h",
+			requestBody:         nil,
+			expectedStatus:      http.StatusOK,
+			subscriptionService: defaultSubscriptionService,
+			outboxService:       defaultEmailOutboxService,
 			rateService:         defaultRateService,
 		},
+		{
+			name:                "GetRateHistory InternalServerError Storage Error",
+			requestMethod:       http.MethodGet,
+			requestURL:          "/api/rate/history",
+			requestBody:         nil,
+			expectedStatus:      http.StatusInternalServerError,
+			subscriptionService: defaultSubscriptionService,
+			outboxService:       defaultEmailOutboxService,
+			rateService:         defaultRateService,
+			historyService: history.NewService(
+				&StubHistoryRepository{Err: errHistoryStorage},
+			),
+		},
 		{
 			name:                "SubscribeEmail OK",
 			requestMethod:       http.MethodPost,
 			requestURL:          "/api/subscribe",
 			requestBody:         bytes.NewBufferString("email=test@test.com"),
 			expectedStatus:      http.StatusOK,
-			senderService:       defaultEmailSenderService,
+			outboxService:       defaultEmailOutboxService,
+			subscriptionService: defaultSubscriptionService,
+			rateService:         defaultRateService,
+		},
+		{
+			name:                "SubscribeEmail BadRequest Invalid Email",
+			requestMethod:       http.MethodPost,
+			requestURL:          "/api/subscribe",
+			requestBody:         bytes.NewBufferString("email=not-an-email"),
+			expectedStatus:      http.StatusBadRequest,
+			outboxService:       defaultEmailOutboxService,
 			subscriptionService: defaultSubscriptionService,
 			rateService:         defaultRateService,
 		},
@@ -147,20 +319,86 @@ func TestAppControllerIntegration(t *testing.T) {
 			requestURL:     "/api/subscribe",
 			requestBody:    bytes.NewBufferString("email=test@test.com"),
 			expectedStatus: http.StatusConflict,
-			subscriptionService: subscription.NewService(
-				&StubUserRepository{Err: port.ErrAlreadyAdded},
-			),
-			senderService: defaultEmailSenderService,
+			subscriptionService: newSubscriptionService(t, config, &StubUserRepository{
+				Users: []port.User{{Email: "test@test.com", Status: port.UserConfirmed}},
+			}),
+			outboxService: defaultEmailOutboxService,
 			rateService:   defaultRateService,
 		},
 		{
-			name:                "SendEmails OK",
+			name:           "SubscribeEmail TooManyRequests",
+			requestMethod:  http.MethodPost,
+			requestURL:     "/api/subscribe",
+			requestBody:    bytes.NewBufferString("email=test@test.com"),
+			expectedStatus: http.StatusTooManyRequests,
+			subscriptionService: newSubscriptionService(t, config, &StubUserRepository{
+				Users: []port.User{{
+					Email:        "test@test.com",
+					Status:       port.UserPending,
+					SubscribedAt: time.Now(),
+				}},
+			}),
+			outboxService: defaultEmailOutboxService,
+			rateService:   defaultRateService,
+		},
+		{
+			name:                "ConfirmEmail OK",
+			requestMethod:       http.MethodGet,
+			requestURL:          confirmURL,
+			requestBody:         nil,
+			expectedStatus:      http.StatusOK,
+			outboxService:       defaultEmailOutboxService,
+			subscriptionService: newSubscriptionService(t, config, &StubUserRepository{Users: []port.User{pending}}),
+			rateService:         defaultRateService,
+		},
+		{
+			name:                "ConfirmEmail BadRequest Invalid Token",
+			requestMethod:       http.MethodGet,
+			requestURL:          "/api/confirm?token=invalid",
+			requestBody:         nil,
+			expectedStatus:      http.StatusBadRequest,
+			outboxService:       defaultEmailOutboxService,
+			subscriptionService: defaultSubscriptionService,
+			rateService:         defaultRateService,
+		},
+		{
+			name:                "UnsubscribeEmail OK",
+			requestMethod:       http.MethodGet,
+			requestURL:          unsubscribeURL,
+			requestBody:         nil,
+			expectedStatus:      http.StatusOK,
+			outboxService:       defaultEmailOutboxService,
+			subscriptionService: newSubscriptionService(t, config, &StubUserRepository{Users: []port.User{subscriber}}),
+			rateService:         defaultRateService,
+		},
+		{
+			name:                "UnsubscribeEmail NotFound Not Subscribed",
+			requestMethod:       http.MethodDelete,
+			requestURL:          strings.Replace(unsubscribeURL, "/api/unsubscribe", "/api/subscribe", 1),
+			requestBody:         nil,
+			expectedStatus:      http.StatusNotFound,
+			outboxService:       defaultEmailOutboxService,
+			subscriptionService: newSubscriptionService(t, config, &StubUserRepository{}),
+			rateService:         defaultRateService,
+		},
+		{
+			name:                "UnsubscribeEmail BadRequest Invalid Token",
+			requestMethod:       http.MethodGet,
+			requestURL:          "/api/unsubscribe?token=invalid",
+			requestBody:         nil,
+			expectedStatus:      http.StatusBadRequest,
+			outboxService:       defaultEmailOutboxService,
+			subscriptionService: defaultSubscriptionService,
+			rateService:         defaultRateService,
+		},
+		{
+			name:                "SendEmails Accepted",
 			requestMethod:       http.MethodPost,
 			requestURL:          "/api/sendEmails",
 			requestBody:         nil,
-			expectedStatus:      http.StatusOK,
+			expectedStatus:      http.StatusAccepted,
 			subscriptionService: defaultSubscriptionService,
-			senderService:       defaultEmailSenderService,
+			outboxService:       defaultEmailOutboxService,
 			rateService:         defaultRateService,
 		},
 		{
@@ -169,44 +407,72 @@ func TestAppControllerIntegration(t *testing.T) {
 			requestURL:          "/api/sendEmails",
 			requestBody:         nil,
 			expectedStatus:      http.StatusBadRequest,
-			subscriptionService: defaultSubscriptionService,
-			senderService:       defaultEmailSenderService,
+			subscriptionService: newSubscriptionService(t, config, &StubUserRepository{Users: []port.User{subscriber}}),
+			outboxService:       defaultEmailOutboxService,
 			rateService: rate.NewService(
 				&StubLogger{},
+				rate.ServiceConfig{},
+				nil,
 				&StubRateProvider{
 					Error: errRateProviderAnavailable,
 				},
 			),
 		},
 		{
-			name:           "SendEmails InternalServerError Subscribtions Error",
-			requestMethod:  http.MethodPost,
-			requestURL:     "/api/sendEmails",
-			requestBody:    nil,
-			expectedStatus: http.StatusInternalServerError,
-			subscriptionService: subscription.NewService(
-				&StubUserRepository{Err: port.ErrCannotLoadUsers},
-			),
-			senderService: defaultEmailSenderService,
-			rateService:   defaultRateService,
+			name:                "SendEmails InternalServerError Subscribtions Error",
+			requestMethod:       http.MethodPost,
+			requestURL:          "/api/sendEmails",
+			requestBody:         nil,
+			expectedStatus:      http.StatusInternalServerError,
+			subscriptionService: newSubscriptionService(t, config, &StubUserRepository{Err: port.ErrCannotLoadUsers}),
+			outboxService:       defaultEmailOutboxService,
+			rateService:         defaultRateService,
 		},
 		{
-			name:                "SendEmails InternalServerError Send Error",
+			name:                "SendEmails InternalServerError Outbox Error",
 			requestMethod:       http.MethodPost,
 			requestURL:          "/api/sendEmails",
 			requestBody:         nil,
 			expectedStatus:      http.StatusInternalServerError,
 			subscriptionService: defaultSubscriptionService,
-			senderService: initEmailSenderService(
-				t,
+			outboxService: newOutboxService(
 				config,
-				&smtp.StubDialer{},
-				&smtp.StubSMTPClientFactory{
-					Client: &smtp.StubSMTPClient{MailErr: errSendMessage},
-				},
+				filepath.Join(outboxPath, "missing", "outbox.jsonl"),
+				sender.NewService(&StubSenderProvider{}),
 			),
 			rateService: defaultRateService,
 		},
+		{
+			name:                "SendEmails Accepted Deferred Delivery",
+			requestMethod:       http.MethodPost,
+			requestURL:          "/api/sendEmails",
+			requestBody:         nil,
+			expectedStatus:      http.StatusAccepted,
+			subscriptionService: newSubscriptionService(t, config, &StubUserRepository{Users: []port.User{subscriber}}),
+			outboxService: newOutboxService(
+				config,
+				outboxPath,
+				initEmailSenderService(
+					t,
+					config,
+					&smtp.StubDialer{},
+					&smtp.StubSMTPClientFactory{
+						Client: &smtp.StubSMTPClient{MailErr: errSendMessage},
+					},
+				),
+			),
+			rateService: defaultRateService,
+		},
+		{
+			name:                "GetEmailBatch NotFound",
+			requestMethod:       http.MethodGet,
+			requestURL:          "/api/sendEmails?batch=unknown",
+			requestBody:         nil,
+			expectedStatus:      http.StatusNotFound,
+			subscriptionService: defaultSubscriptionService,
+			outboxService:       defaultEmailOutboxService,
+			rateService:         defaultRateService,
+		},
 	}
 
 	for _, tt := range tests {
@@ -218,10 +484,24 @@ func TestAppControllerIntegration(t *testing.T) {
 				t.Fatal(err)
 			}
 
+			historyService := tt.historyService
+			if historyService == nil {
+				historyService = defaultHistoryService
+			}
+
 			appController := httpcontroller.NewAppController(
 				tt.rateService,
+				historyService,
 				tt.subscriptionService,
-				tt.senderService,
+				tt.outboxService,
+				broadcast.NewService(
+					&StubLogger{},
+					tt.rateService,
+					tt.subscriptionService,
+					tt.outboxService,
+				),
+				nil,
+				nil,
 			)
 
 			if tt.requestMethod == http.MethodPost {
@@ -230,7 +510,7 @@ func TestAppControllerIntegration(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 
-			router := router.NewHTTPRouter(appController)
+			router := router.NewHTTPRouter(appController, config.HTTP.Timeout)
 			mux := http.NewServeMux()
 			router.RegisterRoutes(mux)
 
@@ -243,6 +523,48 @@ func TestAppControllerIntegration(t *testing.T) {
 	}
 }
 
+func newSubscriptionService(
+	t *testing.T,
+	config *config.Config,
+	userRepository subscription.UserRepository,
+) *subscription.Service {
+	validator, err := mailbox.NewValidator(config.EmailValidation)
+	if err != nil {
+		t.Fatalf("Failed to create email validator: %v", err)
+	}
+
+	return subscription.NewService(
+		&StubLogger{},
+		config.Subscription,
+		userRepository,
+		validator,
+		sender.NewService(&StubSenderProvider{}),
+		subscription.NewLinks(config.Subscription),
+	)
+}
+
+func newOutboxService(
+	config *config.Config,
+	path string,
+	senderService outbox.Sender,
+) *outbox.Service {
+	return outbox.NewService(
+		&StubLogger{},
+		config.Outbox,
+		storage.NewOutboxFileStorage(&StubLogger{}, path, config.Outbox.Retention),
+		senderService,
+	)
+}
+
+func requestURI(t *testing.T, rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+
+	return parsed.RequestURI()
+}
+
 func initConfig(t *testing.T) *config.Config {
 	envVariables := map[string]string{
 		"GSES2_APP_SMTP_HOST":             "test.server.com",
@@ -251,7 +573,6 @@ func initConfig(t *testing.T) *config.Config {
 		"GSES2_APP_SMTP_PASSWORD":         "testpassword",
 		"GSES2_APP_EMAIL_FROM":            "no.reply@test.info.api",
 		"GSES2_APP_EMAIL_SUBJECT":         "BTC to UAH exchange rate",
-		"GSES2_APP_EMAIL_BODY":            "The BTC to UAH rate is {{.Rate}}",
 		"GSES2_APP_STORAGE_PATH":          "./storage/storage.csv",
 		"GSES2_APP_HTTP_PORT":             "8080",
 		"GSES2_APP_HTTP_TIMEOUT":          "10s",
@@ -274,16 +595,22 @@ func initConfig(t *testing.T) *config.Config {
 func initEmailSenderService(
 	t *testing.T,
 	config *config.Config,
-	dialer smtp.TLSConnectionDialer,
+	dialer smtp.ConnectionDialer,
 	factory smtp.SMTPClientFactory,
 ) *sender.Service {
+	pool, err := smtp.NewPool(config.SMTP, dialer, factory)
+	if err != nil {
+		t.Fatalf("error creating smtp pool: %v", err)
+	}
+
 	provider, err := email.NewProvider(
 		&email.EmailSenderConfig{
 			SMTP:  config.SMTP,
 			Email: config.Email,
 		},
-		dialer,
-		factory,
+		pool,
+		subscription.NewLinks(config.Subscription),
+		nil,
 	)
 
 	if err != nil {
@@ -0,0 +1,121 @@
+// Migration integration contains integration tests for moving the subscribers
+// between the CSV and the SQLite storages with the migration service
+
+package integration
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gses2-app/internal/core/port"
+	"gses2-app/internal/core/service/migration"
+	"gses2-app/internal/repository/storage"
+)
+
+func TestMigrationRoundTripIntegration(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// The list written before the header and the columns were added
+	csvPath := filepath.Join(dir, "storage.csv")
+	legacy := "first@test.com\nsecond@test.com\nfirst@test.com\n"
+	require.NoError(t, os.WriteFile(csvPath, []byte(legacy), 0644))
+
+	csvStorage := storage.NewCSVStorage(&StubLogger{}, csvPath, port.UserSchema)
+	sqliteStorage, err := storage.NewSQLiteStorage(ctx, filepath.Join(dir, "storage.db"))
+	require.NoError(t, err)
+	defer sqliteStorage.Close()
+
+	service := migration.NewService(&StubLogger{}, port.UserSchema, port.UserKey)
+
+	dryRun, err := service.Migrate(ctx, csvStorage, sqliteStorage, migration.Options{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, dryRun.Changes, 2)
+
+	records, err := sqliteStorage.AllRecords(ctx)
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	forward, err := service.Migrate(ctx, csvStorage, sqliteStorage, migration.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 1, forward.Duplicates)
+	require.Equal(t, 2, forward.DestinationCount)
+	require.Equal(t, dryRun.SourceChecksum, forward.DestinationChecksum)
+
+	// The subscriber changed after the migration is moved back
+	_, err = sqliteStorage.Update(ctx, port.UserKey, "second@test.com", map[string]string{
+		"status":       "confirmed",
+		"subscribedAt": "2023-07-01T12:00:00Z",
+		"pairs":        "BTC/UAH,ETH/USD",
+	})
+	require.NoError(t, err)
+
+	backPath := filepath.Join(dir, "back.csv")
+	back, err := service.Migrate(
+		ctx,
+		sqliteStorage,
+		storage.NewCSVStorage(&StubLogger{}, backPath, port.UserSchema),
+		migration.Options{},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, back.DestinationCount)
+	require.Equal(t, back.SourceChecksum, back.DestinationChecksum)
+
+	user, err := port.NewUserRepository(storage.NewCSVStorage(&StubLogger{}, backPath, port.UserSchema)).
+		FindByEmail(ctx, "second@test.com")
+	require.NoError(t, err)
+	require.True(t, user.IsConfirmed())
+	require.Len(t, user.Preferences.Pairs, 2)
+}
+
+func TestMigrationDryRunIntegration(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	csvPath := filepath.Join(dir, "storage.csv")
+	require.NoError(t, os.WriteFile(csvPath, []byte("first@test.com\nsecond@test.com\n"), 0644))
+
+	sqlitePath := filepath.Join(dir, "storage.db")
+	sqliteStorage, err := storage.NewSQLiteStorage(ctx, sqlitePath)
+	require.NoError(t, err)
+	require.NoError(t, sqliteStorage.Append(ctx, map[string]string{"email": "first@test.com"}))
+	require.NoError(t, sqliteStorage.Close())
+
+	before := fileNames(t, dir)
+
+	destination, err := storage.NewReadOnlyUserStorage(ctx, &StubLogger{}, storage.StorageConfig{
+		Driver:     storage.DriverSQLite,
+		SQLitePath: sqlitePath,
+	})
+	require.NoError(t, err)
+	defer destination.(*storage.SQLiteStorage).Close()
+
+	service := migration.NewService(&StubLogger{}, port.UserSchema, port.UserKey)
+	report, err := service.Migrate(
+		ctx,
+		storage.NewReadOnlyCSVStorage(&StubLogger{}, csvPath, port.UserSchema),
+		destination,
+		migration.Options{DryRun: true},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Unchanged)
+	require.Equal(t, []migration.Change{{Kind: migration.ChangeAdd, Key: "second@test.com"}}, report.Changes)
+
+	require.Equal(t, before, fileNames(t, dir))
+}
+
+func fileNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
@@ -5,13 +5,18 @@
 package integration
 
 import (
+	"context"
 	"errors"
+	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
 	"gses2-app/internal/core/port"
+	"gses2-app/internal/core/service/mailbox"
 	"gses2-app/internal/core/service/subscription"
 	"gses2-app/internal/repository/storage"
 )
@@ -24,6 +29,21 @@ type SubscriptionTest struct {
 	ExpectedResult []port.User
 }
 
+// StubConfirmationSender keeps the confirmation tokens
+// instead of sending them by email
+type StubConfirmationSender struct {
+	links  *subscription.Links
+	tokens map[string]string
+}
+
+func (s *StubConfirmationSender) SendConfirmation(
+	ctx context.Context,
+	user port.User,
+) error {
+	s.tokens[user.Email] = tokenFromURL(s.links.ConfirmURL(user))
+	return nil
+}
+
 func TestSubscriptionServiceIntegration(t *testing.T) {
 	tmpFile, err := os.CreateTemp("", "example")
 	if err != nil {
@@ -31,31 +51,83 @@ func TestSubscriptionServiceIntegration(t *testing.T) {
 	}
 	defer os.Remove(tmpFile.Name())
 
-	storageCSV := storage.NewCSVStorage(tmpFile.Name())
+	storageCSV := storage.NewCSVStorage(&StubLogger{}, tmpFile.Name(), port.UserSchema)
 	userRepository := port.NewUserRepository(storageCSV)
-	service := subscription.NewService(userRepository)
+	config := subscription.SubscriptionConfig{
+		Secret:          "secret",
+		ConfirmationTTL: time.Hour,
+	}
+	links := subscription.NewLinks(config)
+	confirmations := &StubConfirmationSender{links: links, tokens: map[string]string{}}
+	validator, err := mailbox.NewValidator(mailbox.ValidationConfig{})
+	if err != nil {
+		t.Fatalf("failed to create email validator: %v", err)
+	}
+	service := subscription.NewService(
+		&StubLogger{},
+		config,
+		userRepository,
+		validator,
+		confirmations,
+		links,
+	)
+
+	confirm := func(service *subscription.Service, subscribers []port.User) error {
+		for _, subscriber := range subscribers {
+			token := confirmations.tokens[subscriber.Email]
+			if err := service.Confirm(context.Background(), token); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
 	tests := []SubscriptionTest{
 		{
 			Name:        "Subscribe a new email",
 			Subscribers: []port.User{{Email: "test1@example.com"}},
 			Action: func(service *subscription.Service, subscribers []port.User) error {
-				return service.Subscribe(&subscribers[0])
+				return service.Subscribe(context.Background(), &subscribers[0])
+			},
+			ExpectedResult: []port.User{},
+		},
+		{
+			Name:        "Confirm a new email",
+			Subscribers: []port.User{{Email: "test1@example.com"}},
+			Action:      confirm,
+			ExpectedResult: []port.User{
+				{Email: "test1@example.com"},
 			},
 		},
 		{
 			Name:        "Subscribe an already subscribed email",
 			Subscribers: []port.User{{Email: "test1@example.com"}},
 			Action: func(service *subscription.Service, subscribers []port.User) error {
-				return service.Subscribe(&subscribers[0])
+				return service.Subscribe(context.Background(), &subscribers[0])
 			},
 			ExpectedError: subscription.ErrAlreadySubscribed,
 		},
+		{
+			Name:        "Subscribe a case variant of a subscribed email",
+			Subscribers: []port.User{{Email: " test1@EXAMPLE.com "}},
+			Action: func(service *subscription.Service, subscribers []port.User) error {
+				return service.Subscribe(context.Background(), &subscribers[0])
+			},
+			ExpectedError: subscription.ErrAlreadySubscribed,
+		},
+		{
+			Name:        "Subscribe an invalid email",
+			Subscribers: []port.User{{Email: "test1@"}},
+			Action: func(service *subscription.Service, subscribers []port.User) error {
+				return service.Subscribe(context.Background(), &subscribers[0])
+			},
+			ExpectedError: mailbox.ErrInvalidEmail,
+		},
 		{
 			Name:        "Get all subscriptions",
 			Subscribers: []port.User{},
 			Action: func(service *subscription.Service, subscribers []port.User) error {
-				_, err := service.Subscriptions()
+				_, err := service.Subscriptions(context.Background())
 				return err
 			},
 			ExpectedResult: []port.User{{Email: "test1@example.com"}},
@@ -68,11 +140,11 @@ func TestSubscriptionServiceIntegration(t *testing.T) {
 			},
 			Action: func(service *subscription.Service, subscribers []port.User) error {
 				for _, subscriber := range subscribers {
-					if err := service.Subscribe(&subscriber); err != nil {
+					if err := service.Subscribe(context.Background(), &subscriber); err != nil {
 						return err
 					}
 				}
-				return nil
+				return confirm(service, subscribers)
 			},
 			ExpectedResult: []port.User{
 				{Email: "test1@example.com"},
@@ -88,12 +160,12 @@ func TestSubscriptionServiceIntegration(t *testing.T) {
 			},
 			Action: func(service *subscription.Service, subscribers []port.User) error {
 				for _, subscriber := range subscribers {
-					err := service.Subscribe(&subscriber)
+					err := service.Subscribe(context.Background(), &subscriber)
 					if err != nil && !errors.Is(err, subscription.ErrAlreadySubscribed) {
 						return err
 					}
 				}
-				return nil
+				return confirm(service, subscribers)
 			},
 			ExpectedResult: []port.User{
 				{Email: "test1@example.com"},
@@ -102,6 +174,32 @@ func TestSubscriptionServiceIntegration(t *testing.T) {
 				{Email: "test4@example.com"},
 			},
 		},
+		{
+			Name:        "Unsubscribe an email",
+			Subscribers: []port.User{{Email: "test2@example.com"}},
+			Action: func(service *subscription.Service, subscribers []port.User) error {
+				return service.Unsubscribe(
+					context.Background(),
+					tokenFromURL(links.UnsubscribeURL(subscribers[0])),
+				)
+			},
+			ExpectedResult: []port.User{
+				{Email: "test1@example.com"},
+				{Email: "test3@example.com"},
+				{Email: "test4@example.com"},
+			},
+		},
+		{
+			Name:        "Unsubscribe an already unsubscribed email",
+			Subscribers: []port.User{{Email: "test2@example.com"}},
+			Action: func(service *subscription.Service, subscribers []port.User) error {
+				return service.Unsubscribe(
+					context.Background(),
+					tokenFromURL(links.UnsubscribeURL(subscribers[0])),
+				)
+			},
+			ExpectedError: subscription.ErrNotSubscribed,
+		},
 	}
 
 	for _, tt := range tests {
@@ -111,6 +209,97 @@ func TestSubscriptionServiceIntegration(t *testing.T) {
 	}
 }
 
+// ConfirmingUserRepository confirms the pending users right after loading
+// them, as if they confirmed while the purge was running
+type ConfirmingUserRepository struct {
+	*port.UserRepository
+}
+
+func (r *ConfirmingUserRepository) All(ctx context.Context) ([]port.User, error) {
+	users, err := r.UserRepository.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		confirmed := user
+		confirmed.Status = port.UserConfirmed
+		if err = r.Update(ctx, &confirmed); err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+func TestPurgeKeepsUserConfirmedMeanwhileIntegration(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	sqliteStorage, err := storage.NewSQLiteStorage(ctx, filepath.Join(dir, "storage.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite storage: %v", err)
+	}
+	defer sqliteStorage.Close()
+
+	storages := map[string]port.Storage{
+		"CSV":    storage.NewCSVStorage(&StubLogger{}, filepath.Join(dir, "storage.csv"), port.UserSchema),
+		"SQLite": sqliteStorage,
+	}
+
+	for name, userStorage := range storages {
+		userStorage := userStorage
+		t.Run(name, func(t *testing.T) {
+			userRepository := port.NewUserRepository(userStorage)
+			user := &port.User{
+				Email:        "test1@example.com",
+				Status:       port.UserPending,
+				SubscribedAt: time.Now().Add(-2 * time.Hour),
+			}
+			if err := userRepository.Add(ctx, user); err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+
+			config := subscription.SubscriptionConfig{Secret: "secret", ConfirmationTTL: time.Hour}
+			service := subscription.NewService(
+				&StubLogger{},
+				config,
+				&ConfirmingUserRepository{UserRepository: userRepository},
+				nil,
+				nil,
+				subscription.NewLinks(config),
+			)
+
+			purged, err := service.PurgeUnconfirmed(ctx)
+			if err != nil {
+				t.Fatalf("failed to purge: %v", err)
+			}
+
+			if purged != 0 {
+				t.Errorf("purged %d users, want 0", purged)
+			}
+
+			stored, err := userRepository.FindByEmail(ctx, user.Email)
+			if err != nil {
+				t.Fatalf("confirmed user was removed: %v", err)
+			}
+
+			if !stored.IsConfirmed() {
+				t.Errorf("user status is %q, want confirmed", stored.Status)
+			}
+		})
+	}
+}
+
+func tokenFromURL(rawURL string) string {
+	link, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return link.Query().Get("token")
+}
+
 func runTest(t *testing.T, test SubscriptionTest, service *subscription.Service) {
 	err := test.Action(service, test.Subscribers)
 	checkError(t, err, test.ExpectedError)
@@ -132,12 +321,17 @@ func checkExpectedResult(
 		return
 	}
 
-	subscriptions, err := service.Subscriptions()
+	subscriptions, err := service.Subscriptions(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to get all subscriptions: %v", err)
 	}
 
-	if !cmp.Equal(subscriptions, expectedResult) {
+	emails := make([]port.User, len(subscriptions))
+	for i, subscriber := range subscriptions {
+		emails[i] = port.User{Email: subscriber.Email}
+	}
+
+	if !cmp.Equal(emails, expectedResult) {
 		t.Errorf("Unexpected subscriptions. Got: %v, Expected: %v", subscriptions, expectedResult)
 	}
 }